@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ChaikaReports/internal/archive"
+	"ChaikaReports/internal/models"
+	"ChaikaReports/internal/repository"
+)
+
+// exportTrips Writes trips of the selected routes started in [from, to) to an archive file
+func exportTrips(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	routes := fs.String("routes", "", "comma separated route IDs, all routes when empty")
+	from := fs.String("from", "", "first trip start date, YYYY-MM-DD or RFC3339 (required)")
+	to := fs.String("to", "", "end of the range, exclusive, YYYY-MM-DD or RFC3339 (required)")
+	file := fs.String("file", "", "archive file to write, e.g. trips.jsonl.gz (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("export: -file is required")
+	}
+
+	sel := archive.Selection{}
+	var err error
+	if sel.From, err = parseDate("from", *from); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if sel.To, err = parseDate("to", *to); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	for _, routeID := range strings.Split(*routes, ",") {
+		if routeID = strings.TrimSpace(routeID); routeID != "" {
+			sel.RouteIDs = append(sel.RouteIDs, routeID)
+		}
+	}
+
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	stats, err := archive.Export(ctx, a.repo, f, sel)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return printStats(a, stats)
+}
+
+// importTrips Replays an archive file into the keyspace
+func importTrips(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "archive file to read (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("import: -file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stats, err := archive.Import(ctx, aggregatingSink{a.repo}, f)
+	if err != nil {
+		return fmt.Errorf("import: %w (%d reports imported before the failure)", err, stats.Reports)
+	}
+	return printStats(a, stats)
+}
+
+// aggregatingSink stores imported reports without queueing their trips for synchronization and refreshes
+// the revenue aggregates of every imported report's trip
+type aggregatingSink struct {
+	repo repository.SalesRepository
+}
+
+func (s aggregatingSink) InsertData(ctx context.Context, report *models.CarriageReport) error {
+	if err := s.repo.ImportData(ctx, report); err != nil {
+		return err
+	}
+	return s.repo.RefreshTripAggregates(ctx, &report.TripID)
+}
+
+// rebuild Recomputes derived tables from operations
+func rebuild(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 || args[0] != "aggregates" {
+		return fmt.Errorf("rebuild: expected aggregates")
+	}
+
+	n, err := a.repo.RebuildAggregates(ctx)
+	if err != nil {
+		return fmt.Errorf("rebuild: %w (%d trips rebuilt before the failure)", err, n)
+	}
+	return a.out.message(fmt.Sprintf("rebuilt aggregates of %d trips", n))
+}
+
+// backfill Fills index tables for data stored before they existed
+func backfill(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("backfill: expected route-trips or trip-employees")
+	}
+
+	var n int
+	var err error
+	switch args[0] {
+	case "route-trips":
+		n, err = a.repo.BackfillRouteTrips(ctx)
+	case "trip-employees":
+		n, err = a.repo.BackfillTripEmployees(ctx)
+	default:
+		return fmt.Errorf("backfill: unknown table %q, expected route-trips or trip-employees", args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("backfill: %w (%d trips indexed before the failure)", err, n)
+	}
+	return a.out.message(fmt.Sprintf("indexed %d trips", n))
+}
+
+func printStats(a *app, stats archive.Stats) error {
+	rows := [][]string{
+		{"trips", strconv.Itoa(stats.Trips)},
+		{"reports", strconv.Itoa(stats.Reports)},
+		{"operations", strconv.Itoa(stats.Operations)},
+	}
+	return a.out.print(stats, []string{"METRIC", "VALUE"}, rows)
+}
+
+// parseDate Parses a YYYY-MM-DD date as UTC midnight, or an RFC3339 time
+func parseDate(name, value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return parseTime(name, value)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ChaikaReports/internal/models"
+	"ChaikaReports/internal/repository"
+	"ChaikaReports/internal/service"
+)
+
+// app is what commands run against
+type app struct {
+	svc  service.SalesService
+	repo repository.SalesRepository
+	out  *printer
+}
+
+type command func(ctx context.Context, a *app, args []string) error
+
+var commands = map[string]command{
+	"trips":        listTrips,
+	"trip":         dumpTrip,
+	"set-quantity": setQuantity,
+	"unsynced":     unsynced,
+	"summary":      summary,
+	"export":       exportTrips,
+	"import":       importTrips,
+	"backfill":     backfill,
+	"rebuild":      rebuild,
+}
+
+// listTrips Lists the trips an employee worked in a year
+func listTrips(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("trips", flag.ContinueOnError)
+	employeeID := fs.String("employee", "", "employee ID (required)")
+	year := fs.String("year", strconv.Itoa(time.Now().Year()), "year of the trips")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *employeeID == "" {
+		return fmt.Errorf("trips: -employee is required")
+	}
+
+	trips, err := a.svc.GetEmployeeTrips(ctx, *employeeID, *year)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(trips))
+	for _, t := range trips {
+		rows = append(rows, []string{t.TripID.RouteID, formatTime(t.TripID.StartTime), formatTime(t.EndTime)})
+	}
+	return a.out.print(trips, []string{"ROUTE", "START", "END"}, rows)
+}
+
+// dumpTrip Prints every carriage report of a trip as JSON regardless of the output mode
+func dumpTrip(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("trip", flag.ContinueOnError)
+	tripID := tripFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tid, err := tripID()
+	if err != nil {
+		return fmt.Errorf("trip: %w", err)
+	}
+
+	trip, err := a.svc.GetTrip(ctx, tid)
+	if err != nil {
+		return err
+	}
+	return a.out.json(trip)
+}
+
+// setQuantity Corrects the quantity of a single item in a cart
+func setQuantity(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("set-quantity", flag.ContinueOnError)
+	tripID := tripFlags(fs)
+	employeeID := fs.String("employee", "", "employee ID of the cart (required)")
+	operationTime := fs.String("time", "", "operation time of the cart, RFC3339 (required)")
+	productID := fs.Int("product", 0, "product ID (required)")
+	quantity := fs.Int("quantity", -1, "new quantity (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tid, err := tripID()
+	if err != nil {
+		return fmt.Errorf("set-quantity: %w", err)
+	}
+	if *employeeID == "" || *productID == 0 {
+		return fmt.Errorf("set-quantity: -employee and -product are required")
+	}
+	opTime, err := parseTime("time", *operationTime)
+	if err != nil {
+		return fmt.Errorf("set-quantity: %w", err)
+	}
+	if *quantity < 0 || *quantity > 32767 {
+		return fmt.Errorf("set-quantity: -quantity must be between 0 and 32767")
+	}
+
+	cartID := &models.CartID{EmployeeID: *employeeID, OperationTime: opTime}
+	newQuantity := int16(*quantity)
+	if err := a.svc.UpdateItemQuantity(ctx, tid, cartID, productID, &newQuantity); err != nil {
+		return err
+	}
+	return a.out.message("updated")
+}
+
+// unsynced Lists, requeues or clears entries of the unsynced trip queue
+func unsynced(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unsynced: expected list, requeue or clear")
+	}
+
+	switch args[0] {
+	case "list":
+		trips, err := a.svc.GetUnsyncedTrips(ctx)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(trips))
+		for _, t := range trips {
+			rows = append(rows, []string{t.RouteID, t.Year, formatTime(t.StartTime)})
+		}
+		return a.out.print(trips, []string{"ROUTE", "YEAR", "START"}, rows)
+	case "requeue", "clear":
+		fs := flag.NewFlagSet("unsynced "+args[0], flag.ContinueOnError)
+		tripID := tripFlags(fs)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		tid, err := tripID()
+		if err != nil {
+			return fmt.Errorf("unsynced %s: %w", args[0], err)
+		}
+
+		if args[0] == "requeue" {
+			if err := a.svc.RequeueTrip(ctx, tid); err != nil {
+				return err
+			}
+			return a.out.message("requeued")
+		}
+		if err := a.svc.DeleteSyncedTrip(ctx, tid.RouteID, tid.StartTime); err != nil {
+			return err
+		}
+		return a.out.message("cleared")
+	default:
+		return fmt.Errorf("unsynced: unknown action %q", args[0])
+	}
+}
+
+// summary Prints sales and refund totals of a trip
+func summary(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("summary", flag.ContinueOnError)
+	tripID := tripFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tid, err := tripID()
+	if err != nil {
+		return fmt.Errorf("summary: %w", err)
+	}
+
+	s, err := a.svc.GetTripSummary(ctx, tid)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{
+		{"carriages", strconv.Itoa(s.Carriages)},
+		{"employees", strconv.Itoa(s.Employees)},
+		{"carts", strconv.Itoa(s.Carts)},
+		{"sales", strconv.Itoa(s.Sales)},
+		{"refunds", strconv.Itoa(s.Refunds)},
+		{"items sold", strconv.Itoa(s.ItemsSold)},
+		{"items refunded", strconv.Itoa(s.ItemsRefunded)},
+		{"sales total", formatKopecks(s.SalesTotal)},
+		{"refunds total", formatKopecks(s.RefundsTotal)},
+		{"net total", formatKopecks(s.NetTotal)},
+	}
+	return a.out.print(s, []string{"METRIC", "VALUE"}, rows)
+}
+
+// tripFlags Registers -route and -start on fs, the returned func builds the TripID after parsing
+func tripFlags(fs *flag.FlagSet) func() (*models.TripID, error) {
+	routeID := fs.String("route", "", "route ID (required)")
+	start := fs.String("start", "", "trip start time, RFC3339 (required)")
+	return func() (*models.TripID, error) {
+		if *routeID == "" {
+			return nil, fmt.Errorf("-route is required")
+		}
+		startTime, err := parseTime("start", *start)
+		if err != nil {
+			return nil, err
+		}
+		return &models.TripID{
+			RouteID:   *routeID,
+			Year:      strconv.Itoa(startTime.Year()),
+			StartTime: startTime,
+		}, nil
+	}
+}
+
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("-%s is required", name)
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t.UTC(), nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatKopecks Formats an amount in kopecks as rubles
+func formatKopecks(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
@@ -0,0 +1,116 @@
+package main
+
+// chaikactl runs operational actions against the reports keyspace through the service layer.
+//
+// Usage:
+//
+//	chaikactl [-config config.yml] [-test] [-o table|json] <command> [flags]
+//
+// Commands:
+//
+//	trips         -employee E -year YYYY                    list trips of an employee
+//	trip          -route R -start T                         dump a trip as JSON
+//	set-quantity  -route R -start T -employee E -time T -product P -quantity Q
+//	                                                        correct the quantity of an item in a cart
+//	unsynced      list | requeue -route R -start T | clear -route R -start T
+//	                                                        inspect or change the unsynced trip queue
+//	summary       -route R -start T                         summarize sales and refunds of a trip
+//	export        -from D -to D -file F [-routes R1,R2]     write trips to a compressed archive
+//	import        -file F                                   replay an archive into the keyspace
+//	backfill      route-trips                               index trips stored before route_trips existed
+//	backfill      trip-employees                            index trip employees stored before trip_employees existed
+//	rebuild       aggregates                                recompute revenue aggregates from operations
+//
+// Times are RFC3339, e.g. 2024-05-01T08:30:00Z.
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"ChaikaReports/internal/config"
+	"ChaikaReports/internal/repository/cassandra"
+	"ChaikaReports/internal/service"
+
+	"github.com/go-kit/log"
+)
+
+func main() {
+	defaultConfig := os.Getenv("CONFIG_PATH")
+	if defaultConfig == "" {
+		defaultConfig = "config.yml"
+	}
+	configPath := flag.String("config", defaultConfig, "path to the config file")
+	useTest := flag.Bool("test", false, "use the cassandra-test keyspace instead of cassandra")
+	output := flag.String("o", outputTable, "output format: table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(*configPath, *useTest, *output, flag.Args()); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "chaikactl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\n", os.Args[0])
+	_, _ = fmt.Fprintln(out, "Commands: trips, trip, set-quantity, unsynced, summary, export, import, backfill, rebuild")
+	_, _ = fmt.Fprintln(out, "Run '<command> -h' for command flags.")
+	_, _ = fmt.Fprintln(out)
+	flag.PrintDefaults()
+}
+
+func run(configPath string, useTest bool, output string, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return fmt.Errorf("missing command")
+	}
+	printer, err := newPrinter(os.Stdout, output)
+	if err != nil {
+		return err
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	storage := cfg.Cassandra
+	if useTest {
+		storage = cfg.CassandraTest
+	}
+
+	// Logs go to stderr so JSON output on stdout stays machine readable
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	session, err := cassandra.InitCassandra(logger, storage)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cassandra: %w", err)
+	}
+	defer cassandra.CloseCassandra(session)
+
+	repo := cassandra.NewSalesRepository(session, logger)
+	a := &app{svc: newService(repo, cfg), repo: repo, out: printer}
+	return cmd(context.Background(), a, args[1:])
+}
+
+// newService Builds the service with the settings of the server from cfg. There is no aggregate refresher,
+// the process exits after one command, so aggregates are refreshed before a write returns
+func newService(repo *cassandra.SalesRepository, cfg *config.Config) service.SalesService {
+	maxClockSkew := service.DefaultMaxClockSkew
+	if cfg.Ingest.MaxClockSkew != nil {
+		maxClockSkew = *cfg.Ingest.MaxClockSkew
+	}
+	return service.NewSalesService(repo,
+		service.WithClockSkewPolicy(maxClockSkew, cfg.Ingest.NormalizeClockSkew),
+		service.WithCursorSigner(service.NewCursorSigner([]byte(cfg.Paging.CursorSecret), cfg.Paging.CursorTTL)),
+	)
+}
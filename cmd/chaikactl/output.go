@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printer writes command results either as an aligned table or as indented JSON
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	if format != outputTable && format != outputJSON {
+		return nil, fmt.Errorf("unknown output format %q, expected %s or %s", format, outputTable, outputJSON)
+	}
+	return &printer{w: w, format: format}, nil
+}
+
+// print Writes v as JSON, or header and rows as a table
+func (p *printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.format == outputJSON {
+		return p.json(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (p *printer) message(msg string) error {
+	if p.format == outputJSON {
+		return p.json(map[string]string{"message": msg})
+	}
+	_, err := fmt.Fprintln(p.w, msg)
+	return err
+}
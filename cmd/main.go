@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
@@ -27,14 +28,20 @@ import (
 	"time"
 
 	_ "ChaikaReports/cmd/docs"
+	"ChaikaReports/internal/anomaly"
 	"ChaikaReports/internal/config"
 	grpcHandler "ChaikaReports/internal/handler/grpc"
 	httpHandler "ChaikaReports/internal/handler/http"
+	"ChaikaReports/internal/health"
+	"ChaikaReports/internal/models"
 	"ChaikaReports/internal/repository/cassandra"
+	"ChaikaReports/internal/retention"
 	"ChaikaReports/internal/service"
 
 	"github.com/go-kit/log"
 	"google.golang.org/grpc"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
@@ -54,31 +61,94 @@ func main() {
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
 
 	// ——— Cassandra session ———
-	session, err := cassandra.InitCassandra(
-		logger,
-		cfg.Cassandra.Keyspace,
-		cfg.Cassandra.Hosts,
-		cfg.Cassandra.User,
-		cfg.Cassandra.Password,
-		cfg.Cassandra.Timeout,
-		cfg.Cassandra.RetryDelay,
-		cfg.Cassandra.RetryAttempts,
-	)
+	session, err := cassandra.InitCassandra(logger, cfg.Cassandra)
 	if err != nil {
 		_ = logger.Log("error", "Failed to initialize Cassandra", "err", err)
 		return
 	}
 	defer cassandra.CloseCassandra(session)
 
+	// Fail fast when the keyspace does not match the statements of the repository
+	if err := cassandra.PrepareStatements(context.Background(), session); err != nil {
+		_ = logger.Log("error", "Failed to prepare Cassandra statements", "err", err)
+		return
+	}
+	statementStats := cassandra.NewStatementStats()
+	expvar.Publish("cassandra_statements", expvar.Func(func() interface{} { return statementStats.Snapshot() }))
+	session = cassandra.NewInstrumentedSession(session, statementStats)
+
 	// ——— Wire up repo, service, handlers ———
+	if cfg.Paging.CursorSecret == "" {
+		_ = logger.Log("msg", "paging.cursor_secret is not set, paging cursors will not survive a restart")
+	}
 	repo := cassandra.NewSalesRepository(session, logger)
-	svc := service.NewSalesService(repo)
+	refresher := service.NewAggregateRefresher(repo, log.With(logger, "component", "aggregates"))
+	maxClockSkew := service.DefaultMaxClockSkew
+	if cfg.Ingest.MaxClockSkew != nil {
+		maxClockSkew = *cfg.Ingest.MaxClockSkew
+	}
+	svc := service.NewSalesService(repo,
+		service.WithClockSkewPolicy(maxClockSkew, cfg.Ingest.NormalizeClockSkew),
+		service.WithCursorSigner(service.NewCursorSigner([]byte(cfg.Paging.CursorSecret), cfg.Paging.CursorTTL)),
+		service.WithAggregateRefresher(refresher),
+	)
+	if cfg.Cache.Enabled {
+		svc = service.NewCachingSalesService(svc, service.NewLRUCache(cfg.Cache.Size, cfg.Cache.TTL))
+	}
 	httpSrvHandler := httpHandler.NewHTTPHandler(svc, logger)
 
+	// ——— Retention job ———
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go refresher.Run(jobCtx)
+	if cfg.Retention.Enabled {
+		job := retention.NewJob(repo, log.With(logger, "component", "retention"),
+			cfg.Retention.ArchiveDir, cfg.Retention.HotYears, cfg.Retention.StartYear,
+			retention.WithDeleteHook(func(tripID *models.TripID, employeeIDs []string) {
+				service.InvalidateTrip(svc, tripID, employeeIDs)
+			}))
+		_ = logger.Log("msg", "starting retention job", "cutoff", job.Cutoff(), "interval", cfg.Retention.Interval)
+		go job.Run(jobCtx, cfg.Retention.Interval)
+	}
+
+	// ——— Anomaly analyzer ———
+	if cfg.Anomaly.Enabled {
+		anomalyLogger := log.With(logger, "component", "anomaly")
+		analyzer := anomaly.NewAnalyzer(repo, anomaly.NewLogEmitter(anomalyLogger), anomalyLogger, anomaly.Thresholds{
+			RefundRatio:       cfg.Anomaly.RefundRatio,
+			OutlierFactor:     cfg.Anomaly.OutlierFactor,
+			MinTripCarts:      cfg.Anomaly.MinTripCarts,
+			TripTimeTolerance: cfg.Anomaly.TripTimeTolerance,
+			MaxCorrections:    cfg.Anomaly.MaxCorrections,
+		}, cfg.Anomaly.LookbackDays)
+		_ = logger.Log("msg", "starting anomaly analyzer", "lookback_days", cfg.Anomaly.LookbackDays, "interval", cfg.Anomaly.Interval)
+		go analyzer.Run(jobCtx, cfg.Anomaly.Interval)
+	}
+
+	// ——— Health checks ———
+	checker := health.NewChecker(func(ctx context.Context) error { return cassandra.Ping(ctx, session) },
+		log.With(logger, "component", "health"), cfg.Health.Interval, cfg.Health.Timeout)
+
 	// ——— HTTP server ———
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(checker))
+	mux.Handle("/", httpSrvHandler)
 	srv := &http.Server{
 		Addr:    fmt.Sprintf("%s:%s", cfg.HTTPServer.Host, cfg.HTTPServer.Port),
-		Handler: httpSrvHandler,
+		Handler: mux,
+	}
+
+	// ——— Admin server ———
+	// Metrics expose internals such as statement texts, so they are never served on the public listener
+	var adminSrv *http.Server
+	if cfg.AdminServer.Enabled {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/debug/vars", expvar.Handler())
+		adminSrv = &http.Server{
+			Addr:    fmt.Sprintf("%s:%s", cfg.AdminServer.Host, cfg.AdminServer.Port),
+			Handler: adminMux,
+		}
 	}
 
 	// ——— gRPC server ———
@@ -92,8 +162,20 @@ func main() {
 	router := grpcHandler.NewRouter(svc, logger)
 	grpcHandler.RegisterGRPCServer(grpcSrv, router)
 
+	// grpc.health.v1 follows readiness for the whole server and every registered service
+	grpcHealthSrv := grpcHealth.NewServer()
+	services := make([]string, 0, len(grpcSrv.GetServiceInfo()))
+	for name := range grpcSrv.GetServiceInfo() {
+		services = append(services, name)
+	}
+	healthpb.RegisterHealthServer(grpcSrv, grpcHealthSrv)
+	updateGRPCHealth := health.GRPCStatusUpdater(grpcHealthSrv, services...)
+	updateGRPCHealth(false)
+	checker.OnChange(updateGRPCHealth)
+	go checker.Run(jobCtx)
+
 	// ——— Start both servers concurrently & handle graceful shutdown ———
-	done := make(chan error, 2)
+	done := make(chan error, 3)
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -110,6 +192,15 @@ func main() {
 		}
 	}()
 
+	if adminSrv != nil {
+		go func() {
+			_ = logger.Log("msg", "starting admin server", "addr", adminSrv.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				done <- fmt.Errorf("admin server error: %w", err)
+			}
+		}()
+	}
+
 	go func() {
 		_ = logger.Log("msg", "starting gRPC server", "addr", grpcAddr)
 		if err := grpcSrv.Serve(lis); err != nil {
@@ -121,6 +212,13 @@ func main() {
 	err = <-done
 	_ = logger.Log("msg", "shutting down servers", "reason", err)
 
+	// Report not ready first so load balancers stop sending new requests before the servers close
+	checker.Shutdown()
+	grpcHealthSrv.Shutdown()
+	if cfg.Health.ShutdownDelay > 0 {
+		time.Sleep(cfg.Health.ShutdownDelay)
+	}
+
 	// Graceful HTTP shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.Timeout*time.Second)
 	defer cancel()
@@ -130,5 +228,19 @@ func main() {
 
 	// Graceful gRPC shutdown
 	grpcSrv.GracefulStop()
+
+	if adminSrv != nil {
+		if shutdownErr := adminSrv.Shutdown(ctx); shutdownErr != nil {
+			_ = logger.Log("error", "Admin server shutdown failed", "err", shutdownErr)
+		}
+	}
+
+	// Refresh the aggregates of the last writes before the session closes
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.HTTPServer.Timeout*time.Second)
+	defer cancelDrain()
+	refresher.Drain(drainCtx)
+	if pending := refresher.Pending(); pending > 0 {
+		_ = logger.Log("msg", "trip aggregates left stale, run `chaikactl rebuild aggregates`", "trips", pending)
+	}
 	_ = logger.Log("msg", "servers stopped")
 }
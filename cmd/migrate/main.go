@@ -0,0 +1,139 @@
+package main
+
+// migrate applies the embedded CQL migrations to the configured keyspace.
+//
+// Usage:
+//
+//	migrate [-config config.yml] [-test] [-create-keyspace] up
+//	migrate [-config config.yml] [-test] down [n]
+//	migrate [-config config.yml] [-test] status
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"ChaikaReports/internal/config"
+	"ChaikaReports/internal/repository/cassandra"
+
+	"github.com/go-kit/log"
+)
+
+func main() {
+	defaultConfig := os.Getenv("CONFIG_PATH")
+	if defaultConfig == "" {
+		defaultConfig = "config.yml"
+	}
+	configPath := flag.String("config", defaultConfig, "path to the config file")
+	useTest := flag.Bool("test", false, "migrate the cassandra-test keyspace instead of cassandra")
+	createKeyspace := flag.Bool("create-keyspace", false, "create the keyspace before migrating if it does not exist")
+	replication := flag.String("replication", "{'class': 'SimpleStrategy', 'replication_factor': 1}",
+		"replication settings used with -create-keyspace")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up | down [n] | status\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(*configPath, *useTest, *createKeyspace, *replication, flag.Args()); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath string, useTest, createKeyspace bool, replication string, args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	storage := cfg.Cassandra
+	if useTest {
+		storage = cfg.CassandraTest
+	}
+
+	logger := log.NewLogfmtLogger(log.StdlibWriter{})
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "keyspace", storage.Keyspace)
+
+	if createKeyspace {
+		if err := ensureKeyspace(logger, storage, replication); err != nil {
+			return err
+		}
+	}
+
+	session, err := cassandra.InitCassandra(logger, storage)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cassandra: %w", err)
+	}
+	defer cassandra.CloseCassandra(session)
+
+	migrator, err := cassandra.NewMigrator(session, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migration(s)\n", len(done))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		done, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migration(s)\n", len(done))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.UTC().Format("2006-01-02T15:04:05Z07:00")
+			}
+			_, _ = fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return nil
+}
+
+// ensureKeyspace Connects without a keyspace and creates the target one if it is missing
+func ensureKeyspace(logger log.Logger, storage config.StorageConfig, replication string) error {
+	noKeyspace := storage
+	noKeyspace.Keyspace = ""
+	session, err := cassandra.InitCassandra(logger, noKeyspace)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cassandra: %w", err)
+	}
+	defer cassandra.CloseCassandra(session)
+
+	stmt := fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", storage.Keyspace, replication)
+	if err := session.Query(stmt).Exec(); err != nil {
+		return fmt.Errorf("failed to create keyspace %s: %w", storage.Keyspace, err)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package anomaly flags suspicious sales patterns for fraud review.
+//
+// The analyzer reads the trips started in the last LookbackDays UTC days and the corrections made
+// on those days, and flags:
+//   - employees whose refunds are a large share of their operations in a trip
+//   - carts whose total is far above the median cart total of their trip
+//   - carts operated before their trip started or after it ended
+//   - employees with many items of their carts corrected on one day
+//
+// Every flag has an ID derived from what was flagged, so a later run finding the same pattern
+// replaces the stored flag instead of adding one. Only flags not stored yet are emitted as events;
+// a flag is emitted before it is stored, so a failed run emits it again rather than never.
+// Flags are not withdrawn when the data behind them changes.
+package anomaly
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// dayLayout is the format of Anomaly.Day
+const dayLayout = "2006-01-02"
+
+// Repository is the part of the sales repository used by the analyzer
+type Repository interface {
+	GetRoutes(ctx context.Context) ([]string, error)
+	GetRouteTrips(ctx context.Context, routeID string, from, to time.Time) ([]models.TripID, error)
+	GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error)
+	GetCorrections(ctx context.Context, day time.Time) ([]models.Correction, error)
+	GetAnomalies(ctx context.Context, from, to time.Time) ([]models.Anomaly, error)
+	InsertAnomaly(ctx context.Context, anomaly *models.Anomaly) error
+}
+
+// Emitter receives every newly flagged anomaly
+type Emitter interface {
+	Emit(ctx context.Context, anomaly models.Anomaly) error
+}
+
+// logEmitter emits anomalies as structured log events
+type logEmitter struct {
+	log log.Logger
+}
+
+// NewLogEmitter Creates an Emitter writing one "anomaly_flagged" event per anomaly to logger
+func NewLogEmitter(logger log.Logger) Emitter {
+	return &logEmitter{log: logger}
+}
+
+func (e *logEmitter) Emit(_ context.Context, a models.Anomaly) error {
+	return e.log.Log(
+		"event", "anomaly_flagged",
+		"id", a.ID,
+		"day", a.Day,
+		"kind", a.Kind,
+		"route_id", a.TripID.RouteID,
+		"start_time", a.TripID.StartTime,
+		"employee_id", a.EmployeeID,
+		"operation_time", a.OperationTime,
+		"value", a.Value,
+		"threshold", a.Threshold,
+	)
+}
+
+// Thresholds decide what is flagged
+type Thresholds struct {
+	// RefundRatio is the refunds total over the sales and refunds total of an employee in a trip above which they are flagged
+	RefundRatio float64
+	// OutlierFactor is how many times the median cart total of its trip a cart total must exceed to be flagged
+	OutlierFactor float64
+	// MinTripCarts is the number of carts a trip needs before its carts are compared with its median
+	MinTripCarts int
+	// TripTimeTolerance is how far an operation time may be outside its trip before it is flagged
+	TripTimeTolerance time.Duration
+	// MaxCorrections is the number of items of the carts of an employee that may be corrected on one day
+	MaxCorrections int
+}
+
+// Result summarizes an analyzer run
+type Result struct {
+	Trips     int `json:"trips"`
+	Anomalies int `json:"anomalies"`
+	New       int `json:"new"`
+}
+
+// Analyzer flags anomalies in recent trips and corrections
+type Analyzer struct {
+	repo         Repository
+	emitter      Emitter
+	log          log.Logger
+	thresholds   Thresholds
+	lookbackDays int
+	now          func() time.Time
+}
+
+// NewAnalyzer Creates an analyzer checking the trips started and corrections made in the last lookbackDays UTC days,
+// today included
+func NewAnalyzer(repo Repository, emitter Emitter, logger log.Logger, thresholds Thresholds, lookbackDays int) *Analyzer {
+	return &Analyzer{
+		repo:         repo,
+		emitter:      emitter,
+		log:          logger,
+		thresholds:   thresholds,
+		lookbackDays: lookbackDays,
+		now:          time.Now,
+	}
+}
+
+// Run Runs the analyzer immediately and then every interval until ctx is done
+func (a *Analyzer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := a.RunOnce(ctx)
+		if err != nil {
+			_ = a.log.Log("msg", "anomaly run failed", "err", err, "new_anomalies", res.New)
+		} else {
+			_ = a.log.Log("msg", "anomaly run finished", "trips", res.Trips, "anomalies", res.Anomalies, "new_anomalies", res.New)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce Checks every trip and correction of the lookback window and stores what it flags
+func (a *Analyzer) RunOnce(ctx context.Context) (Result, error) {
+	var res Result
+	today := a.now().UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, 1-a.lookbackDays)
+
+	stored, err := a.repo.GetAnomalies(ctx, from, today)
+	if err != nil {
+		return res, fmt.Errorf("failed to read stored anomalies: %w", err)
+	}
+	known := make(map[string]models.Anomaly, len(stored))
+	for _, anomaly := range stored {
+		known[anomalyKey(anomaly)] = anomaly
+	}
+	flag := func(anomalies []models.Anomaly) error {
+		for _, anomaly := range anomalies {
+			if err := a.store(ctx, anomaly, known, &res); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	routes, err := a.repo.GetRoutes(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed to list routes: %w", err)
+	}
+	sort.Strings(routes)
+	for _, routeID := range routes {
+		tripIDs, err := a.repo.GetRouteTrips(ctx, routeID, from, today.AddDate(0, 0, 1))
+		if err != nil {
+			return res, fmt.Errorf("failed to list trips of route %s: %w", routeID, err)
+		}
+		for i := range tripIDs {
+			trip, err := a.repo.GetTrip(ctx, &tripIDs[i])
+			if err != nil {
+				return res, fmt.Errorf("failed to read trip %s %v: %w", routeID, tripIDs[i].StartTime, err)
+			}
+			res.Trips++
+			if err := flag(a.checkTrip(tripIDs[i], trip)); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		corrections, err := a.repo.GetCorrections(ctx, day)
+		if err != nil {
+			return res, fmt.Errorf("failed to read corrections of %s: %w", day.Format(dayLayout), err)
+		}
+		if err := flag(a.checkCorrections(day, corrections)); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// store Stores an anomaly, emitting it first when it was not stored before. A flag found again keeps
+// the time it was first detected and is updated with the current value
+func (a *Analyzer) store(ctx context.Context, anomaly models.Anomaly, known map[string]models.Anomaly, res *Result) error {
+	res.Anomalies++
+	if prev, ok := known[anomalyKey(anomaly)]; ok {
+		if prev.Value == anomaly.Value && prev.Threshold == anomaly.Threshold {
+			return nil
+		}
+		anomaly.DetectedAt = prev.DetectedAt
+	} else {
+		anomaly.DetectedAt = a.now().UTC()
+		if err := a.emitter.Emit(ctx, anomaly); err != nil {
+			return fmt.Errorf("failed to emit anomaly %s %s: %w", anomaly.Kind, anomaly.ID, err)
+		}
+		res.New++
+	}
+	if err := a.repo.InsertAnomaly(ctx, &anomaly); err != nil {
+		return fmt.Errorf("failed to store anomaly %s %s: %w", anomaly.Kind, anomaly.ID, err)
+	}
+	known[anomalyKey(anomaly)] = anomaly
+	return nil
+}
+
+func anomalyKey(a models.Anomaly) string {
+	return a.Day + "|" + a.Kind + "|" + a.ID
+}
+
+// tripAnomaly Returns an anomaly of a trip, its ID names the trip, the employee and the cart when there is one
+func tripAnomaly(kind string, tripID models.TripID, employeeID string, operationTime time.Time, value, threshold float64) models.Anomaly {
+	id := tripID.RouteID + "|" + strconv.FormatInt(tripID.StartTime.UnixMilli(), 10) + "|" + employeeID
+	if !operationTime.IsZero() {
+		id += "|" + strconv.FormatInt(operationTime.UnixMilli(), 10)
+	}
+	return models.Anomaly{
+		ID:            id,
+		Day:           tripID.StartTime.UTC().Format(dayLayout),
+		Kind:          kind,
+		TripID:        tripID,
+		EmployeeID:    employeeID,
+		OperationTime: operationTime,
+		Value:         value,
+		Threshold:     threshold,
+	}
+}
+
+// checkTrip Returns the refund ratio, outlier cart and outside trip anomalies of a trip
+func (a *Analyzer) checkTrip(tripID models.TripID, trip models.Trip) []models.Anomaly {
+	trip.Sort()
+	var anomalies []models.Anomaly
+
+	type amounts struct{ sales, refunds int64 }
+	byEmployee := make(map[string]*amounts)
+	var totals []int64
+	for _, report := range trip.Carriage {
+		for _, cart := range report.Carts {
+			total := cartTotal(cart)
+			totals = append(totals, total)
+			employee, ok := byEmployee[cart.CartID.EmployeeID]
+			if !ok {
+				employee = &amounts{}
+				byEmployee[cart.CartID.EmployeeID] = employee
+			}
+			switch cart.OperationType {
+			case models.OperationTypeSale:
+				employee.sales += total
+			case models.OperationTypeRefund:
+				employee.refunds += total
+			}
+
+			if outside := outsideTrip(tripID.StartTime, report.EndTime, cart.CartID.OperationTime); outside > a.thresholds.TripTimeTolerance {
+				anomalies = append(anomalies, tripAnomaly(models.AnomalyOutsideTrip, tripID, cart.CartID.EmployeeID, cart.CartID.OperationTime,
+					outside.Minutes(), a.thresholds.TripTimeTolerance.Minutes()))
+			}
+		}
+	}
+
+	if len(totals) >= a.thresholds.MinTripCarts {
+		if median := medianOf(totals); median > 0 {
+			for _, report := range trip.Carriage {
+				for _, cart := range report.Carts {
+					if factor := float64(cartTotal(cart)) / float64(median); factor > a.thresholds.OutlierFactor {
+						anomalies = append(anomalies, tripAnomaly(models.AnomalyOutlierCart, tripID, cart.CartID.EmployeeID, cart.CartID.OperationTime,
+							factor, a.thresholds.OutlierFactor))
+					}
+				}
+			}
+		}
+	}
+
+	employeeIDs := make([]string, 0, len(byEmployee))
+	for employeeID := range byEmployee {
+		employeeIDs = append(employeeIDs, employeeID)
+	}
+	sort.Strings(employeeIDs)
+	for _, employeeID := range employeeIDs {
+		employee := byEmployee[employeeID]
+		if employee.refunds == 0 {
+			continue
+		}
+		if ratio := float64(employee.refunds) / float64(employee.sales+employee.refunds); ratio > a.thresholds.RefundRatio {
+			anomalies = append(anomalies, tripAnomaly(models.AnomalyRefundRatio, tripID, employeeID, time.Time{}, ratio, a.thresholds.RefundRatio))
+		}
+	}
+	return anomalies
+}
+
+// checkCorrections Returns an anomaly for every employee with more than MaxCorrections items corrected on day
+func (a *Analyzer) checkCorrections(day time.Time, corrections []models.Correction) []models.Anomaly {
+	counts := make(map[string]int)
+	for _, c := range corrections {
+		counts[c.CartID.EmployeeID]++
+	}
+	employeeIDs := make([]string, 0, len(counts))
+	for employeeID, count := range counts {
+		if count > a.thresholds.MaxCorrections {
+			employeeIDs = append(employeeIDs, employeeID)
+		}
+	}
+	sort.Strings(employeeIDs)
+
+	anomalies := make([]models.Anomaly, 0, len(employeeIDs))
+	for _, employeeID := range employeeIDs {
+		anomalies = append(anomalies, models.Anomaly{
+			ID:         employeeID,
+			Day:        day.Format(dayLayout),
+			Kind:       models.AnomalyCorrections,
+			EmployeeID: employeeID,
+			Value:      float64(counts[employeeID]),
+			Threshold:  float64(a.thresholds.MaxCorrections),
+		})
+	}
+	return anomalies
+}
+
+func cartTotal(cart models.Cart) int64 {
+	var total int64
+	for _, item := range cart.Items {
+		total += int64(item.Quantity) * item.Price
+	}
+	return total
+}
+
+// outsideTrip Returns how far t is before start or after end, a zero end leaves the trip open
+func outsideTrip(start, end, t time.Time) time.Duration {
+	if t.Before(start) {
+		return start.Sub(t)
+	}
+	if !end.IsZero() && t.After(end) {
+		return t.Sub(end)
+	}
+	return 0
+}
+
+// medianOf Returns the median of values, the lower one of the middle two for an even count
+func medianOf(values []int64) int64 {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)-1)/2]
+}
@@ -0,0 +1,239 @@
+package anomaly
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var today = time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+
+// fakeRepository keeps trips, corrections and anomalies in memory
+type fakeRepository struct {
+	trips       []models.Trip // every trip has at least one carriage
+	corrections map[string][]models.Correction
+	anomalies   []models.Anomaly
+	inserts     int
+}
+
+func (f *fakeRepository) GetRoutes(_ context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var routes []string
+	for _, trip := range f.trips {
+		if routeID := trip.Carriage[0].TripID.RouteID; routeID != "" {
+			if _, ok := seen[routeID]; !ok {
+				seen[routeID] = struct{}{}
+				routes = append(routes, routeID)
+			}
+		}
+	}
+	return routes, nil
+}
+
+func (f *fakeRepository) GetRouteTrips(_ context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	var res []models.TripID
+	for _, trip := range f.trips {
+		id := trip.Carriage[0].TripID
+		if id.RouteID == routeID && !id.StartTime.Before(from) && id.StartTime.Before(to) {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeRepository) GetTrip(_ context.Context, tripID *models.TripID) (models.Trip, error) {
+	for _, trip := range f.trips {
+		if trip.Carriage[0].TripID == *tripID {
+			return trip, nil
+		}
+	}
+	return models.Trip{}, nil
+}
+
+func (f *fakeRepository) GetCorrections(_ context.Context, day time.Time) ([]models.Correction, error) {
+	return f.corrections[day.Format(dayLayout)], nil
+}
+
+func (f *fakeRepository) GetAnomalies(_ context.Context, from, to time.Time) ([]models.Anomaly, error) {
+	var res []models.Anomaly
+	for _, a := range f.anomalies {
+		if a.Day >= from.Format(dayLayout) && a.Day <= to.Format(dayLayout) {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeRepository) InsertAnomaly(_ context.Context, anomaly *models.Anomaly) error {
+	f.inserts++
+	for i, a := range f.anomalies {
+		if anomalyKey(a) == anomalyKey(*anomaly) {
+			f.anomalies[i] = *anomaly
+			return nil
+		}
+	}
+	f.anomalies = append(f.anomalies, *anomaly)
+	return nil
+}
+
+// recordingEmitter keeps the emitted anomalies, failing with err when it is set
+type recordingEmitter struct {
+	emitted []models.Anomaly
+	err     error
+}
+
+func (e *recordingEmitter) Emit(_ context.Context, anomaly models.Anomaly) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.emitted = append(e.emitted, anomaly)
+	return nil
+}
+
+var thresholds = Thresholds{
+	RefundRatio:       0.5,
+	OutlierFactor:     5,
+	MinTripCarts:      4,
+	TripTimeTolerance: 10 * time.Minute,
+	MaxCorrections:    2,
+}
+
+func newTestAnalyzer(repo Repository, emitter Emitter) *Analyzer {
+	analyzer := NewAnalyzer(repo, emitter, log.NewNopLogger(), thresholds, 2)
+	analyzer.now = func() time.Time { return today.Add(15 * time.Hour) }
+	return analyzer
+}
+
+func testTrip(routeID string, start time.Time, carts ...models.Cart) models.Trip {
+	return models.Trip{Carriage: []models.CarriageReport{{
+		TripID:     models.TripID{RouteID: routeID, Year: start.Format("2006"), StartTime: start},
+		EndTime:    start.Add(4 * time.Hour),
+		CarriageID: 1,
+		Carts:      carts,
+	}}}
+}
+
+func testCart(employeeID string, at time.Time, opType int8, total int64) models.Cart {
+	return models.Cart{
+		CartID:        models.CartID{EmployeeID: employeeID, OperationTime: at},
+		OperationType: opType,
+		Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: total}},
+	}
+}
+
+func TestCheckTrip(t *testing.T) {
+	start := today.Add(8 * time.Hour)
+	trip := testTrip("r1", start,
+		testCart("e1", start.Add(time.Hour), models.OperationTypeSale, 100),
+		testCart("e1", start.Add(2*time.Hour), models.OperationTypeSale, 120),
+		testCart("e2", start.Add(time.Hour), models.OperationTypeSale, 1000),
+		testCart("e2", start.Add(3*time.Hour), models.OperationTypeRefund, 90),
+		testCart("e3", start.Add(-5*time.Minute), models.OperationTypeSale, 90),
+		testCart("e3", start.Add(4*time.Hour+30*time.Minute), models.OperationTypeRefund, 300),
+	)
+	tripID := trip.Carriage[0].TripID
+	late := start.Add(4*time.Hour + 30*time.Minute)
+
+	anomalies := newTestAnalyzer(&fakeRepository{}, &recordingEmitter{}).checkTrip(tripID, trip)
+
+	assert.Equal(t, []models.Anomaly{
+		// 30 minutes after the end, the 5 minutes before the start are tolerated
+		{ID: "r1|1714982400000|e3|1714998600000", Day: "2024-05-06", Kind: models.AnomalyOutsideTrip, TripID: tripID,
+			EmployeeID: "e3", OperationTime: late, Value: 30, Threshold: 10},
+		// The median cart total is 100
+		{ID: "r1|1714982400000|e2|1714986000000", Day: "2024-05-06", Kind: models.AnomalyOutlierCart, TripID: tripID,
+			EmployeeID: "e2", OperationTime: start.Add(time.Hour), Value: 10, Threshold: 5},
+		// e3 refunded 300 of 390, e2 only 90 of 1090
+		{ID: "r1|1714982400000|e3", Day: "2024-05-06", Kind: models.AnomalyRefundRatio, TripID: tripID,
+			EmployeeID: "e3", Value: 300.0 / 390, Threshold: 0.5},
+	}, anomalies)
+}
+
+func TestCheckTrip_FewCarts(t *testing.T) {
+	start := today.Add(8 * time.Hour)
+	trip := testTrip("r1", start,
+		testCart("e1", start.Add(time.Hour), models.OperationTypeSale, 100),
+		testCart("e1", start.Add(2*time.Hour), models.OperationTypeSale, 100),
+		testCart("e1", start.Add(3*time.Hour), models.OperationTypeSale, 5000),
+	)
+
+	anomalies := newTestAnalyzer(&fakeRepository{}, &recordingEmitter{}).checkTrip(trip.Carriage[0].TripID, trip)
+	assert.Empty(t, anomalies, "outliers need MinTripCarts carts")
+}
+
+func TestCheckCorrections(t *testing.T) {
+	correction := func(employeeID string, productID int) models.Correction {
+		return models.Correction{CartID: models.CartID{EmployeeID: employeeID}, ProductID: productID}
+	}
+	corrections := []models.Correction{
+		correction("e1", 1), correction("e1", 2), correction("e1", 3),
+		correction("e2", 1), correction("e2", 2),
+	}
+
+	anomalies := newTestAnalyzer(&fakeRepository{}, &recordingEmitter{}).checkCorrections(today, corrections)
+	assert.Equal(t, []models.Anomaly{
+		{ID: "e1", Day: "2024-05-06", Kind: models.AnomalyCorrections, EmployeeID: "e1", Value: 3, Threshold: 2},
+	}, anomalies)
+}
+
+func TestRunOnce(t *testing.T) {
+	yesterday := today.AddDate(0, 0, -1).Add(20 * time.Hour)
+	repo := &fakeRepository{
+		trips: []models.Trip{
+			testTrip("r1", yesterday, testCart("e1", yesterday.Add(time.Hour), models.OperationTypeRefund, 100)),
+			testTrip("r2", today.Add(8*time.Hour), testCart("e2", today.Add(13*time.Hour), models.OperationTypeSale, 100)),
+			// Started before the lookback window
+			testTrip("r1", yesterday.AddDate(0, 0, -1), testCart("e3", yesterday.AddDate(0, 0, -1), models.OperationTypeRefund, 100)),
+		},
+		corrections: map[string][]models.Correction{
+			"2024-05-06": {
+				{CartID: models.CartID{EmployeeID: "e4"}, ProductID: 1},
+				{CartID: models.CartID{EmployeeID: "e4"}, ProductID: 2},
+				{CartID: models.CartID{EmployeeID: "e4"}, ProductID: 3},
+			},
+		},
+	}
+	emitter := &recordingEmitter{}
+	analyzer := newTestAnalyzer(repo, emitter)
+
+	res, err := analyzer.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Result{Trips: 2, Anomalies: 3, New: 3}, res)
+	kinds := make(map[string]string)
+	for _, a := range emitter.emitted {
+		kinds[a.EmployeeID] = a.Kind
+		assert.Equal(t, today.Add(15*time.Hour), a.DetectedAt)
+	}
+	assert.Equal(t, map[string]string{"e1": models.AnomalyRefundRatio, "e2": models.AnomalyOutsideTrip, "e4": models.AnomalyCorrections}, kinds)
+	assert.Len(t, repo.anomalies, 3)
+
+	// A later run stores nothing new and emits nothing, a changed value is updated and keeps its detection time
+	repo.corrections["2024-05-06"] = append(repo.corrections["2024-05-06"], models.Correction{CartID: models.CartID{EmployeeID: "e4"}, ProductID: 4})
+	analyzer.now = func() time.Time { return today.Add(16 * time.Hour) }
+	res, err = analyzer.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Result{Trips: 2, Anomalies: 3, New: 0}, res)
+	assert.Len(t, emitter.emitted, 3)
+	assert.Equal(t, 4, repo.inserts)
+	require.Len(t, repo.anomalies, 3)
+	corrections := repo.anomalies[2]
+	assert.Equal(t, models.AnomalyCorrections, corrections.Kind)
+	assert.Equal(t, float64(4), corrections.Value)
+	assert.Equal(t, today.Add(15*time.Hour), corrections.DetectedAt)
+}
+
+func TestRunOnce_EmitErrorStoresNothing(t *testing.T) {
+	start := today.Add(8 * time.Hour)
+	repo := &fakeRepository{trips: []models.Trip{testTrip("r1", start, testCart("e1", start, models.OperationTypeRefund, 100))}}
+	emitter := &recordingEmitter{err: errors.New("unavailable")}
+
+	_, err := newTestAnalyzer(repo, emitter).RunOnce(context.Background())
+	assert.ErrorContains(t, err, "failed to emit anomaly refund_ratio")
+	assert.Empty(t, repo.anomalies, "an anomaly is only stored once it was emitted")
+}
@@ -0,0 +1,112 @@
+// Package archive exports trips to portable archive files and imports them back.
+//
+// An archive is a gzip-compressed stream of JSON lines. The first line is a Header
+// naming the format and its version, every following line is one carriage report
+// of a trip. The record types are independent of the domain models so archives stay
+// readable when the models change; a new layout gets a new Version.
+package archive
+
+import (
+	"ChaikaReports/internal/models"
+	"time"
+)
+
+// Format identifies chaika trip archives
+const Format = "chaika-trips"
+
+// Version is the record layout written by Export; Import accepts versions up to it
+const Version = 1
+
+// Header is the first line of an archive
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// reportRecord is one carriage report of a trip
+type reportRecord struct {
+	RouteID    string       `json:"route_id"`
+	Year       string       `json:"year"`
+	StartTime  time.Time    `json:"start_time"`
+	EndTime    time.Time    `json:"end_time"`
+	CarriageID int8         `json:"carriage_id"`
+	Carts      []cartRecord `json:"carts"`
+}
+
+type cartRecord struct {
+	EmployeeID    string       `json:"employee_id"`
+	OperationTime time.Time    `json:"operation_time"`
+	OperationType int8         `json:"operation_type"`
+	Items         []itemRecord `json:"items"`
+}
+
+type itemRecord struct {
+	ProductID int   `json:"product_id"`
+	Quantity  int16 `json:"quantity"`
+	Price     int64 `json:"price"`
+}
+
+// Stats counts what was exported or imported
+type Stats struct {
+	Trips      int `json:"trips"`
+	Reports    int `json:"reports"`
+	Operations int `json:"operations"`
+}
+
+func newReportRecord(report *models.CarriageReport) reportRecord {
+	rec := reportRecord{
+		RouteID:    report.TripID.RouteID,
+		Year:       report.TripID.Year,
+		StartTime:  report.TripID.StartTime.UTC(),
+		EndTime:    report.EndTime.UTC(),
+		CarriageID: report.CarriageID,
+		Carts:      make([]cartRecord, 0, len(report.Carts)),
+	}
+	for _, cart := range report.Carts {
+		c := cartRecord{
+			EmployeeID:    cart.CartID.EmployeeID,
+			OperationTime: cart.CartID.OperationTime.UTC(),
+			OperationType: cart.OperationType,
+			Items:         make([]itemRecord, 0, len(cart.Items)),
+		}
+		for _, item := range cart.Items {
+			c.Items = append(c.Items, itemRecord{ProductID: item.ProductID, Quantity: item.Quantity, Price: item.Price})
+		}
+		rec.Carts = append(rec.Carts, c)
+	}
+	return rec
+}
+
+func (rec reportRecord) toModel() *models.CarriageReport {
+	report := &models.CarriageReport{
+		TripID: models.TripID{
+			RouteID:   rec.RouteID,
+			Year:      rec.Year,
+			StartTime: rec.StartTime,
+		},
+		EndTime:    rec.EndTime,
+		CarriageID: rec.CarriageID,
+		Carts:      make([]models.Cart, 0, len(rec.Carts)),
+	}
+	for _, c := range rec.Carts {
+		cart := models.Cart{
+			CartID:        models.CartID{EmployeeID: c.EmployeeID, OperationTime: c.OperationTime},
+			OperationType: c.OperationType,
+			Items:         make([]models.Item, 0, len(c.Items)),
+		}
+		for _, item := range c.Items {
+			cart.Items = append(cart.Items, models.Item{ProductID: item.ProductID, Quantity: item.Quantity, Price: item.Price})
+		}
+		report.Carts = append(report.Carts, cart)
+	}
+	return report
+}
+
+func (rec reportRecord) operations() int {
+	var n int
+	for _, c := range rec.Carts {
+		n += len(c.Items)
+	}
+	return n
+}
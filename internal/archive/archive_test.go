@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"ChaikaReports/internal/models"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore is an in-memory Source and Sink keyed by trip
+type memoryStore struct {
+	trips map[models.TripID]models.Trip
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{trips: make(map[models.TripID]models.Trip)}
+}
+
+func (m *memoryStore) GetRoutes(_ context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var routes []string
+	for id := range m.trips {
+		if _, ok := seen[id.RouteID]; !ok {
+			seen[id.RouteID] = struct{}{}
+			routes = append(routes, id.RouteID)
+		}
+	}
+	return routes, nil
+}
+
+func (m *memoryStore) GetRouteTrips(_ context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	var trips []models.TripID
+	for id := range m.trips {
+		if id.RouteID == routeID && !id.StartTime.Before(from) && id.StartTime.Before(to) {
+			trips = append(trips, id)
+		}
+	}
+	return trips, nil
+}
+
+func (m *memoryStore) GetTrip(_ context.Context, tripID *models.TripID) (models.Trip, error) {
+	return m.trips[*tripID], nil
+}
+
+func (m *memoryStore) InsertData(_ context.Context, report *models.CarriageReport) error {
+	trip := m.trips[report.TripID]
+	trip.Carriage = append(trip.Carriage, *report)
+	m.trips[report.TripID] = trip
+	return nil
+}
+
+func testReport(routeID string, start time.Time, carriageID int8) models.CarriageReport {
+	return models.CarriageReport{
+		TripID:     models.TripID{RouteID: routeID, Year: "2024", StartTime: start},
+		EndTime:    start.Add(3 * time.Hour),
+		CarriageID: carriageID,
+		Carts: []models.Cart{
+			{
+				CartID:        models.CartID{EmployeeID: "emp1", OperationTime: start.Add(time.Minute)},
+				OperationType: models.OperationTypeSale,
+				Items:         []models.Item{{ProductID: 1, Quantity: 2, Price: 150}, {ProductID: 2, Quantity: 1, Price: 90}},
+			},
+			{
+				CartID:        models.CartID{EmployeeID: "emp1", OperationTime: start.Add(time.Hour)},
+				OperationType: models.OperationTypeRefund,
+				Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 150}},
+			},
+		},
+	}
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	jan := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 10, 8, 0, 0, 0, time.UTC)
+
+	src := newMemoryStore()
+	for _, r := range []models.CarriageReport{
+		testReport("r1", jan, 2),
+		testReport("r1", jan, 1),
+		testReport("r2", jan, 1),
+		testReport("r1", feb, 1), // outside the range
+	} {
+		require.NoError(t, src.InsertData(ctx, &r))
+	}
+
+	var buf bytes.Buffer
+	stats, err := Export(ctx, src, &buf, Selection{
+		From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Trips: 2, Reports: 3, Operations: 9}, stats)
+
+	dst := newMemoryStore()
+	imported, err := Import(ctx, dst, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, stats, imported)
+
+	r1 := models.TripID{RouteID: "r1", Year: "2024", StartTime: jan}
+	require.Len(t, dst.trips[r1].Carriage, 2)
+	assert.Equal(t, int8(1), dst.trips[r1].Carriage[0].CarriageID)
+	assert.Equal(t, testReport("r1", jan, 1), dst.trips[r1].Carriage[0])
+	assert.NotContains(t, dst.trips, models.TripID{RouteID: "r1", Year: "2024", StartTime: feb})
+}
+
+func TestExport_SelectedRoutes(t *testing.T) {
+	ctx := context.Background()
+	jan := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+
+	src := newMemoryStore()
+	for _, r := range []models.CarriageReport{testReport("r1", jan, 1), testReport("r2", jan, 1)} {
+		require.NoError(t, src.InsertData(ctx, &r))
+	}
+
+	var buf bytes.Buffer
+	stats, err := Export(ctx, src, &buf, Selection{RouteIDs: []string{"r2"}, From: jan, To: jan.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Trips)
+}
+
+func TestExport_InvalidRange(t *testing.T) {
+	jan := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+	_, err := Export(context.Background(), newMemoryStore(), &bytes.Buffer{}, Selection{From: jan, To: jan})
+	assert.Error(t, err)
+}
+
+func TestImport_RejectsUnknownArchives(t *testing.T) {
+	gz := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write([]byte(s))
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "not gzip", data: []byte(`{"format":"chaika-trips","version":1}`)},
+		{name: "wrong format", data: gz(`{"format":"other","version":1}` + "\n")},
+		{name: "future version", data: gz(`{"format":"chaika-trips","version":99}` + "\n")},
+		{name: "corrupt record", data: gz(`{"format":"chaika-trips","version":1}` + "\n{\"route_id\":")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(context.Background(), newMemoryStore(), bytes.NewReader(tt.data))
+			assert.Error(t, err)
+		})
+	}
+}
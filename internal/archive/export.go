@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"ChaikaReports/internal/models"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Source is the read side of the repository used by Export
+type Source interface {
+	GetRoutes(ctx context.Context) ([]string, error)
+	GetRouteTrips(ctx context.Context, routeID string, from, to time.Time) ([]models.TripID, error)
+	GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error)
+}
+
+// Selection picks the trips to export: trips of RouteIDs (all routes when empty)
+// whose start time is in [From, To)
+type Selection struct {
+	RouteIDs []string
+	From     time.Time
+	To       time.Time
+}
+
+// Export Writes the selected trips to w as a compressed archive
+func Export(ctx context.Context, src Source, w io.Writer, sel Selection) (Stats, error) {
+	if !sel.From.Before(sel.To) {
+		return Stats{}, fmt.Errorf("invalid range: from %v is not before to %v", sel.From, sel.To)
+	}
+
+	routes := sel.RouteIDs
+	if len(routes) == 0 {
+		var err error
+		if routes, err = src.GetRoutes(ctx); err != nil {
+			return Stats{}, fmt.Errorf("failed to list routes: %w", err)
+		}
+		sort.Strings(routes)
+	}
+
+	aw, err := NewWriter(w)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, routeID := range routes {
+		trips, err := src.GetRouteTrips(ctx, routeID, sel.From, sel.To)
+		if err != nil {
+			return aw.Stats(), fmt.Errorf("failed to list trips of route %s: %w", routeID, err)
+		}
+		for i := range trips {
+			if err := ctx.Err(); err != nil {
+				return aw.Stats(), err
+			}
+			trip, err := src.GetTrip(ctx, &trips[i])
+			if err != nil {
+				return aw.Stats(), fmt.Errorf("failed to read trip %s %v: %w", routeID, trips[i].StartTime, err)
+			}
+			if err := aw.WriteTrip(trip); err != nil {
+				return aw.Stats(), err
+			}
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		return aw.Stats(), err
+	}
+	return aw.Stats(), nil
+}
+
+// Writer writes trips to an archive
+type Writer struct {
+	zw    *gzip.Writer
+	enc   *json.Encoder
+	stats Stats
+}
+
+// NewWriter Starts an archive on w by writing its header; Close must be called to flush it
+func NewWriter(w io.Writer) (*Writer, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(Header{Format: Format, Version: Version, CreatedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+	return &Writer{zw: zw, enc: enc}, nil
+}
+
+// WriteTrip Encodes the carriage reports of a trip in the order of models.Trip.Sort,
+// so archives of the same data are identical. Trips without reports are skipped
+func (aw *Writer) WriteTrip(trip models.Trip) error {
+	trip.Sort()
+	reports := trip.Carriage
+
+	for i := range reports {
+		rec := newReportRecord(&reports[i])
+		if err := aw.enc.Encode(rec); err != nil {
+			return err
+		}
+		aw.stats.Reports++
+		aw.stats.Operations += rec.operations()
+	}
+	if len(reports) > 0 {
+		aw.stats.Trips++
+	}
+	return nil
+}
+
+// Stats Returns what has been written so far
+func (aw *Writer) Stats() Stats {
+	return aw.stats
+}
+
+// Close Flushes the compressed stream, it does not close the underlying writer
+func (aw *Writer) Close() error {
+	return aw.zw.Close()
+}
@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"ChaikaReports/internal/models"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Sink is the write side of the repository used by Import
+type Sink interface {
+	InsertData(ctx context.Context, carriageReport *models.CarriageReport) error
+}
+
+// Import Replays every carriage report of an archive through sink.InsertData.
+// Inserts are upserts, so importing the same archive twice leaves the same data
+func Import(ctx context.Context, sink Sink, r io.Reader) (Stats, error) {
+	return Read(ctx, r, func(report *models.CarriageReport) error {
+		if err := sink.InsertData(ctx, report); err != nil {
+			return fmt.Errorf("failed to insert trip %s %v carriage %d: %w",
+				report.TripID.RouteID, report.TripID.StartTime, report.CarriageID, err)
+		}
+		return nil
+	})
+}
+
+// Read Decodes an archive and calls fn for every carriage report in it, stopping at the first error
+func Read(ctx context.Context, r io.Reader, fn func(report *models.CarriageReport) error) (Stats, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Stats{}, fmt.Errorf("not a trip archive: %w", err)
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return Stats{}, fmt.Errorf("failed to read archive header: %w", err)
+	}
+	if header.Format != Format {
+		return Stats{}, fmt.Errorf("unexpected archive format %q", header.Format)
+	}
+	if header.Version < 1 || header.Version > Version {
+		return Stats{}, fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+
+	var stats Stats
+	var lastTrip models.TripID
+	for line := 2; ; line++ {
+		var rec reportRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return stats, nil
+			}
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		if err := fn(rec.toModel()); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		// Reports of a trip are written consecutively by Writer
+		if rec.RouteID != lastTrip.RouteID || !rec.StartTime.Equal(lastTrip.StartTime) {
+			stats.Trips++
+			lastTrip = models.TripID{RouteID: rec.RouteID, StartTime: rec.StartTime}
+		}
+		stats.Reports++
+		stats.Operations += rec.operations()
+	}
+}
@@ -17,6 +17,43 @@ type StorageConfig struct {
 	Timeout       time.Duration `mapstructure:"timeout" validate:"required"`
 	RetryDelay    time.Duration `mapstructure:"retry_delay" validate:"required"`
 	RetryAttempts int           `mapstructure:"retry_attempts" validate:"required"`
+
+	// LocalDC enables token-aware routing to the replicas of this datacenter; empty routes token-aware across all hosts
+	LocalDC string `mapstructure:"local_dc"`
+	// ReadConsistency and WriteConsistency default to QUORUM; ANY and EACH_QUORUM are not served for reads
+	ReadConsistency  string `mapstructure:"read_consistency" validate:"omitempty,oneof=ONE TWO THREE QUORUM ALL LOCAL_QUORUM LOCAL_ONE"`
+	WriteConsistency string `mapstructure:"write_consistency" validate:"omitempty,oneof=ANY ONE TWO THREE QUORUM ALL LOCAL_QUORUM EACH_QUORUM LOCAL_ONE"`
+	// SerialConsistency is the Paxos phase consistency of conditional (IF) statements, defaults to SERIAL
+	SerialConsistency string `mapstructure:"serial_consistency" validate:"omitempty,oneof=SERIAL LOCAL_SERIAL"`
+	// NumConns is the number of connections per host, the driver default when 0
+	NumConns             int                        `mapstructure:"num_conns" validate:"gte=0"`
+	TLS                  TLSConfig                  `mapstructure:"tls"`
+	QueryRetry           QueryRetryConfig           `mapstructure:"query_retry"`
+	SpeculativeExecution SpeculativeExecutionConfig `mapstructure:"speculative_execution"`
+}
+
+type TLSConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	CAFile   string `mapstructure:"ca_file" validate:"omitempty,file"`
+	CertFile string `mapstructure:"cert_file" validate:"required_with=KeyFile,omitempty,file"`
+	KeyFile  string `mapstructure:"key_file" validate:"required_with=CertFile,omitempty,file"`
+	// ServerName overrides the host name checked against the server certificate
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// QueryRetryConfig retries failed queries with exponential backoff, queries are not retried when NumRetries is 0
+type QueryRetryConfig struct {
+	NumRetries int           `mapstructure:"num_retries" validate:"gte=0"`
+	MinBackoff time.Duration `mapstructure:"min_backoff" validate:"required_with=NumRetries,gte=0"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff" validate:"required_with=NumRetries,gtefield=MinBackoff"`
+}
+
+// SpeculativeExecutionConfig sends reads to another replica when the first one has not answered after Delay
+type SpeculativeExecutionConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Attempts int           `mapstructure:"attempts" validate:"required_if=Enabled true,gte=0"`
+	Delay    time.Duration `mapstructure:"delay" validate:"required_if=Enabled true,gte=0"`
 }
 
 type HTTPServerConfig struct {
@@ -25,6 +62,14 @@ type HTTPServerConfig struct {
 	Timeout time.Duration `mapstructure:"timeout" validate:"required"`
 }
 
+// AdminServerConfig serves runtime metrics (/debug/vars) on a listener of its own, which should only be
+// reachable from the monitoring network; metrics are not served at all when it is disabled
+type AdminServerConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host" validate:"required_if=Enabled true"`
+	Port    string `mapstructure:"port" validate:"required_if=Enabled true"`
+}
+
 type GRPCServerConfig struct {
 	Host     string        `mapstructure:"host" validate:"required"`
 	Port     string        `mapstructure:"port" validate:"required"`
@@ -32,11 +77,92 @@ type GRPCServerConfig struct {
 	Protocol string        `mapstructure:"protocol" validate:"required"`
 }
 
+// IngestConfig controls how carriage reports are accepted; an unset max_clock_skew uses the service default,
+// while 0 flags every report whose terminal clock differs from the server clock
+type IngestConfig struct {
+	MaxClockSkew       *time.Duration `mapstructure:"max_clock_skew" validate:"omitempty,gte=0"`
+	NormalizeClockSkew bool           `mapstructure:"normalize_clock_skew"`
+}
+
+type RetentionConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	HotYears   int           `mapstructure:"hot_years" validate:"required_if=Enabled true,gte=0"`
+	StartYear  int           `mapstructure:"start_year" validate:"required_if=Enabled true,gte=0"`
+	Interval   time.Duration `mapstructure:"interval" validate:"required_if=Enabled true,gte=0"`
+	ArchiveDir string        `mapstructure:"archive_dir" validate:"required_if=Enabled true"`
+}
+
+// AnomalyConfig controls the anomaly analyzer, see the anomaly package for what each threshold flags
+type AnomalyConfig struct {
+	Enabled           bool          `mapstructure:"enabled"`
+	Interval          time.Duration `mapstructure:"interval" validate:"required_if=Enabled true,gte=0"`
+	LookbackDays      int           `mapstructure:"lookback_days" validate:"required_if=Enabled true,gte=0"`
+	RefundRatio       float64       `mapstructure:"refund_ratio" validate:"required_if=Enabled true,gte=0,lte=1"`
+	OutlierFactor     float64       `mapstructure:"outlier_factor" validate:"required_if=Enabled true,gte=0"`
+	MinTripCarts      int           `mapstructure:"min_trip_carts" validate:"gte=0"`
+	TripTimeTolerance time.Duration `mapstructure:"trip_time_tolerance" validate:"gte=0"`
+	MaxCorrections    int           `mapstructure:"max_corrections" validate:"required_if=Enabled true,gte=0"`
+}
+
+type CacheConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Size    int           `mapstructure:"size" validate:"required_if=Enabled true,gte=0"`
+	TTL     time.Duration `mapstructure:"ttl" validate:"required_if=Enabled true,gte=0"`
+}
+
+// HealthConfig controls the readiness probe, zero durations use the defaults of the health package
+type HealthConfig struct {
+	Interval      time.Duration `mapstructure:"interval" validate:"gte=0"`
+	Timeout       time.Duration `mapstructure:"timeout" validate:"gte=0"`
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay" validate:"gte=0"`
+}
+
+// PagingConfig controls the signed paging cursors; an empty secret is replaced by a random one per process,
+// so instances behind a load balancer must share a configured secret
+type PagingConfig struct {
+	CursorSecret string        `mapstructure:"cursor_secret" validate:"omitempty,min=16"`
+	CursorTTL    time.Duration `mapstructure:"cursor_ttl" validate:"gte=0"`
+}
+
 type Config struct {
-	Cassandra     StorageConfig    `mapstructure:"cassandra"`
-	CassandraTest StorageConfig    `mapstructure:"cassandra-test"`
-	HTTPServer    HTTPServerConfig `mapstructure:"http-server"`
-	GRPCServer    GRPCServerConfig `mapstructure:"grpc-server"`
+	Cassandra     StorageConfig     `mapstructure:"cassandra"`
+	CassandraTest StorageConfig     `mapstructure:"cassandra-test"`
+	HTTPServer    HTTPServerConfig  `mapstructure:"http-server"`
+	GRPCServer    GRPCServerConfig  `mapstructure:"grpc-server"`
+	AdminServer   AdminServerConfig `mapstructure:"admin-server"`
+	Ingest        IngestConfig      `mapstructure:"ingest"`
+	Retention     RetentionConfig   `mapstructure:"retention"`
+	Anomaly       AnomalyConfig     `mapstructure:"anomaly"`
+	Cache         CacheConfig       `mapstructure:"cache"`
+	Health        HealthConfig      `mapstructure:"health"`
+	Paging        PagingConfig      `mapstructure:"paging"`
+}
+
+// redacted is printed in place of configured secrets
+const redacted = "[REDACTED]"
+
+// String Formats the config with the storage passwords, TLS key files and cursor secret masked, so it can be logged
+func (c Config) String() string {
+	// plain has the fields of Config without its String method
+	type plain Config
+	c.Cassandra = c.Cassandra.redacted()
+	c.CassandraTest = c.CassandraTest.redacted()
+	c.Paging.CursorSecret = redact(c.Paging.CursorSecret)
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+func (s StorageConfig) redacted() StorageConfig {
+	s.Password = redact(s.Password)
+	s.TLS.KeyFile = redact(s.TLS.KeyFile)
+	return s
+}
+
+// redact Masks a set secret, an unset one stays empty so the output shows it is missing
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -62,5 +188,28 @@ func LoadConfig(configPath string) (*Config, error) {
 
 func validateConfig(cfg *Config) error {
 	validate := validator.New()
-	return validate.Struct(cfg)
+	if err := validate.Struct(cfg); err != nil {
+		return err
+	}
+	if admin := cfg.AdminServer; admin.Enabled && (admin.Port == cfg.HTTPServer.Port || admin.Port == cfg.GRPCServer.Port) {
+		return fmt.Errorf("admin-server: port %s is already used by the http or grpc server", admin.Port)
+	}
+	if err := validateStorage("cassandra", cfg.Cassandra); err != nil {
+		return err
+	}
+	return validateStorage("cassandra-test", cfg.CassandraTest)
+}
+
+// validateStorage Checks the storage settings the struct tags cannot express
+func validateStorage(name string, storage StorageConfig) error {
+	for _, level := range []string{storage.ReadConsistency, storage.WriteConsistency, storage.SerialConsistency} {
+		if strings.HasPrefix(level, "LOCAL_") && storage.LocalDC == "" {
+			return fmt.Errorf("%s: consistency %s needs local_dc", name, level)
+		}
+	}
+	tls := storage.TLS
+	if !tls.Enabled && (tls.CAFile != "" || tls.CertFile != "" || tls.ServerName != "") {
+		return fmt.Errorf("%s: tls settings are given but tls is not enabled", name)
+	}
+	return nil
 }
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validConfig() Config {
+	storage := StorageConfig{
+		Hosts:         []string{"localhost"},
+		Keyspace:      "chaika",
+		User:          "cassandra",
+		Password:      "cassandra",
+		Timeout:       5 * time.Second,
+		RetryDelay:    time.Second,
+		RetryAttempts: 3,
+	}
+	return Config{
+		Cassandra:     storage,
+		CassandraTest: storage,
+		HTTPServer:    HTTPServerConfig{Port: "8080", Host: "0.0.0.0", Timeout: time.Second},
+		GRPCServer:    GRPCServerConfig{Host: "0.0.0.0", Port: "9090", Timeout: time.Second, Protocol: "tcp"},
+	}
+}
+
+func TestValidateConfig_Storage(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("pem"), 0o600))
+
+	tests := []struct {
+		name    string
+		modify  func(s *StorageConfig)
+		wantErr string
+	}{
+		{
+			name:   "Defaults",
+			modify: func(s *StorageConfig) {},
+		},
+		{
+			name: "Local consistency with datacenter and TLS",
+			modify: func(s *StorageConfig) {
+				s.LocalDC = "dc1"
+				s.ReadConsistency = "LOCAL_ONE"
+				s.WriteConsistency = "LOCAL_QUORUM"
+				s.SerialConsistency = "LOCAL_SERIAL"
+				s.TLS = TLSConfig{Enabled: true, CAFile: caFile}
+				s.SpeculativeExecution = SpeculativeExecutionConfig{Enabled: true, Attempts: 2, Delay: 50 * time.Millisecond}
+			},
+		},
+		{
+			name:    "Unknown consistency",
+			modify:  func(s *StorageConfig) { s.ReadConsistency = "MOST" },
+			wantErr: "ReadConsistency",
+		},
+		{
+			name:    "Write-only read consistency",
+			modify:  func(s *StorageConfig) { s.ReadConsistency = "EACH_QUORUM" },
+			wantErr: "ReadConsistency",
+		},
+		{
+			name:    "Read consistency any",
+			modify:  func(s *StorageConfig) { s.ReadConsistency = "ANY" },
+			wantErr: "ReadConsistency",
+		},
+		{
+			name:    "Non-serial serial consistency",
+			modify:  func(s *StorageConfig) { s.SerialConsistency = "QUORUM" },
+			wantErr: "SerialConsistency",
+		},
+		{
+			name:    "Local serial consistency without datacenter",
+			modify:  func(s *StorageConfig) { s.SerialConsistency = "LOCAL_SERIAL" },
+			wantErr: "needs local_dc",
+		},
+		{
+			name:    "Local consistency without datacenter",
+			modify:  func(s *StorageConfig) { s.WriteConsistency = "LOCAL_QUORUM" },
+			wantErr: "needs local_dc",
+		},
+		{
+			name:    "Missing CA file",
+			modify:  func(s *StorageConfig) { s.TLS = TLSConfig{Enabled: true, CAFile: caFile + ".missing"} },
+			wantErr: "CAFile",
+		},
+		{
+			name:    "Certificate without key",
+			modify:  func(s *StorageConfig) { s.TLS = TLSConfig{Enabled: true, CertFile: caFile} },
+			wantErr: "KeyFile",
+		},
+		{
+			name:    "TLS files with TLS disabled",
+			modify:  func(s *StorageConfig) { s.TLS = TLSConfig{CAFile: caFile} },
+			wantErr: "tls is not enabled",
+		},
+		{
+			name: "Backoff range",
+			modify: func(s *StorageConfig) {
+				s.QueryRetry = QueryRetryConfig{NumRetries: 3, MinBackoff: time.Second, MaxBackoff: time.Millisecond}
+			},
+			wantErr: "MaxBackoff",
+		},
+		{
+			name: "Speculative execution without delay",
+			modify: func(s *StorageConfig) {
+				s.SpeculativeExecution = SpeculativeExecutionConfig{Enabled: true, Attempts: 2}
+			},
+			wantErr: "Delay",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg.Cassandra)
+			err := validateConfig(&cfg)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_MaxClockSkew(t *testing.T) {
+	cfg := validConfig()
+	assert.NoError(t, validateConfig(&cfg), "unset uses the service default")
+
+	zero := time.Duration(0)
+	cfg.Ingest.MaxClockSkew = &zero
+	assert.NoError(t, validateConfig(&cfg), "zero flags any skew")
+
+	negative := -time.Minute
+	cfg.Ingest.MaxClockSkew = &negative
+	assert.ErrorContains(t, validateConfig(&cfg), "MaxClockSkew")
+}
+
+func TestValidateConfig_AdminServer(t *testing.T) {
+	cfg := validConfig()
+	assert.NoError(t, validateConfig(&cfg), "disabled by default")
+
+	cfg.AdminServer = AdminServerConfig{Enabled: true, Host: "127.0.0.1"}
+	assert.ErrorContains(t, validateConfig(&cfg), "Port")
+
+	cfg.AdminServer.Port = cfg.HTTPServer.Port
+	assert.ErrorContains(t, validateConfig(&cfg), "already used")
+	cfg.AdminServer.Port = cfg.GRPCServer.Port
+	assert.ErrorContains(t, validateConfig(&cfg), "already used")
+
+	cfg.AdminServer.Port = "9100"
+	assert.NoError(t, validateConfig(&cfg))
+}
+
+func TestConfig_StringRedactsSecrets(t *testing.T) {
+	cfg := validConfig()
+	cfg.Cassandra.Password = "storage-secret"
+	cfg.Cassandra.TLS = TLSConfig{Enabled: true, CertFile: "/etc/chaika/client.pem", KeyFile: "/etc/chaika/client-key.pem"}
+	cfg.CassandraTest.Password = ""
+	cfg.Paging.CursorSecret = "cursor-secret-0123456789"
+
+	out := fmt.Sprintf("Loaded config: %+v", &cfg)
+	assert.NotContains(t, out, "storage-secret")
+	assert.NotContains(t, out, "client-key.pem")
+	assert.NotContains(t, out, "cursor-secret")
+	assert.Contains(t, out, "Password:[REDACTED]")
+	assert.Contains(t, out, "CursorSecret:[REDACTED]")
+	assert.Contains(t, out, "CertFile:/etc/chaika/client.pem")
+	assert.Contains(t, out, "User:cassandra")
+
+	// The config itself is left untouched
+	assert.Equal(t, "storage-secret", cfg.Cassandra.Password)
+	assert.Equal(t, "cursor-secret-0123456789", cfg.Paging.CursorSecret)
+}
@@ -2,15 +2,28 @@ package decoder
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"ChaikaReports/internal/models"
 	pb "github.com/Chaika-Team/chaika-proto/gen/rprts"
 )
 
-func DecodeGetTripRequest(_ context.Context, req *pb.GetTripRequest) *models.TripID {
+func DecodeGetTripRequest(_ context.Context, req *pb.GetTripRequest) (*models.TripID, error) {
+	if err := req.GetStartTime().CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid start_time: %w", err)
+	}
 	return &models.TripID{
-		RouteID:   req.RouteId,
-		Year:      req.Year,
-		StartTime: req.StartTime.AsTime(),
+		RouteID:   req.GetRouteId(),
+		Year:      req.GetYear(),
+		StartTime: req.GetStartTime().AsTime(),
+	}, nil
+}
+
+// DecodeDeleteSyncedTripRequest Returns the route and start time of the synced trip
+func DecodeDeleteSyncedTripRequest(_ context.Context, req *pb.DeleteSyncedTripRequest) (string, time.Time, error) {
+	if err := req.GetStartTime().CheckValid(); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid start_time: %w", err)
 	}
+	return req.GetRouteId(), req.GetStartTime().AsTime(), nil
 }
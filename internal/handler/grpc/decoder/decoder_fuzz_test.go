@@ -0,0 +1,43 @@
+package decoder
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/Chaika-Team/chaika-proto/gen/rprts"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func FuzzDecodeTripRequests(f *testing.F) {
+	start := time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)
+	f.Add("route_test", "2025", start.Unix(), int32(0), true)
+	f.Add("route_test", "2025", start.Unix(), int32(999999999), true)
+	f.Add("", "", int64(0), int32(-1), true)
+	f.Add("route_test", "2025", int64(253402300800), int32(0), true) // 10000-01-01
+	f.Add("route_test", "2025", int64(0), int32(0), false)
+
+	f.Fuzz(func(t *testing.T, routeID, year string, seconds int64, nanos int32, hasStart bool) {
+		var startTime *timestamppb.Timestamp
+		if hasStart {
+			startTime = &timestamppb.Timestamp{Seconds: seconds, Nanos: nanos}
+		}
+		valid := startTime.CheckValid() == nil
+
+		tripID, err := DecodeGetTripRequest(context.Background(), &pb.GetTripRequest{RouteId: routeID, Year: year, StartTime: startTime})
+		if valid != (err == nil) || (err == nil) == (tripID == nil) {
+			t.Fatalf("start %v decoded to %+v, error %v", startTime, tripID, err)
+		}
+		if err == nil && (tripID.RouteID != routeID || tripID.Year != year || !tripID.StartTime.Equal(time.Unix(seconds, int64(nanos)))) {
+			t.Fatalf("request decoded to %+v", tripID)
+		}
+
+		gotRoute, gotStart, err := DecodeDeleteSyncedTripRequest(context.Background(), &pb.DeleteSyncedTripRequest{RouteId: routeID, StartTime: startTime})
+		if valid != (err == nil) {
+			t.Fatalf("start %v decoded with error %v", startTime, err)
+		}
+		if err == nil && (gotRoute != routeID || !gotStart.Equal(time.Unix(seconds, int64(nanos)))) {
+			t.Fatalf("request decoded to %q, %s", gotRoute, gotStart)
+		}
+	})
+}
@@ -31,7 +31,10 @@ func RegisterGRPCServer(s *grpc.Server, router *Router) {
 
 func (r *Router) GetTrip(ctx context.Context, req *pb.GetTripRequest) (*pb.GetTripReply, error) {
 	// 1) decode
-	tid := decoder.DecodeGetTripRequest(ctx, req)
+	tid, err := decoder.DecodeGetTripRequest(ctx, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	// 2) business
 	trip, err := r.svc.GetTrip(ctx, tid)
@@ -47,8 +50,11 @@ func (r *Router) GetTrip(ctx context.Context, req *pb.GetTripRequest) (*pb.GetTr
 func (r *Router) DeleteSyncedTrip(
 	ctx context.Context, req *pb.DeleteSyncedTripRequest) (*pb.AckReply, error) {
 
-	if err := r.svc.DeleteSyncedTrip(ctx,
-		req.RouteId, req.StartTime.AsTime()); err != nil {
+	routeID, startTime, err := decoder.DecodeDeleteSyncedTripRequest(ctx, req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := r.svc.DeleteSyncedTrip(ctx, routeID, startTime); err != nil {
 		return nil, status.Errorf(codes.Internal, err.Error())
 	}
 	return &pb.AckReply{Message: "deleted"}, nil
@@ -3,24 +3,101 @@ package decoder
 import (
 	"ChaikaReports/internal/handler/http/schemas"
 	"ChaikaReports/internal/models"
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
 
 const invalidRequestBodyErrorMessage = "invalid request body"
 
+const (
+	// maxBulkLines caps the number of carriage reports accepted in a single bulk upload
+	maxBulkLines = 1000
+	// maxBulkLineBytes caps the size of a single NDJSON line
+	maxBulkLineBytes = 1 << 20
+)
+
+// decodeJSONBody Decodes a body holding a single JSON value into v, trailing data is rejected
+// the same way json.Unmarshal rejects it for a bulk line
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
 // DecodeInsertSalesRequest decodes the HTTP request into the domain model
 func DecodeInsertSalesRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req schemas.InsertSalesRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(r, &req); err != nil {
+		return nil, errors.New(invalidRequestBodyErrorMessage)
+	}
+
+	carriage, err := carriageReportFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return carriage, nil
+}
+
+// DecodeInsertSalesBulkRequest decodes a newline-delimited JSON body of InsertSalesRequest objects.
+// Lines that fail to decode are kept with their error so the endpoint can reject them individually.
+func DecodeInsertSalesBulkRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineBytes)
+
+	var req schemas.InsertSalesBulkRequest
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if len(req.Lines) == maxBulkLines {
+			return nil, fmt.Errorf("too many lines in bulk request (max %d)", maxBulkLines)
+		}
+
+		line := schemas.InsertSalesBulkLine{Line: lineNo}
+		var sale schemas.InsertSalesRequest
+		if err := json.Unmarshal(raw, &sale); err != nil {
+			line.Error = invalidRequestBodyErrorMessage
+		} else if carriage, err := carriageReportFromRequest(sale); err != nil {
+			line.Error = err.Error()
+		} else {
+			line.Report = carriage
+		}
+		req.Lines = append(req.Lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("bulk line %d exceeds %d bytes", lineNo+1, maxBulkLineBytes)
+		}
 		return nil, errors.New(invalidRequestBodyErrorMessage)
 	}
+	if len(req.Lines) == 0 {
+		return nil, errors.New("empty bulk request")
+	}
 
+	return req, nil
+}
+
+// carriageReportFromRequest validates an InsertSalesRequest and converts it to the domain model
+func carriageReportFromRequest(req schemas.InsertSalesRequest) (*models.CarriageReport, error) {
 	// Convert schemas.InsertSalesRequest to models.CarriageReport
 	carriageStartTime, err := time.Parse(time.RFC3339, req.TripID.StartTime)
 	if err != nil {
@@ -74,6 +151,7 @@ func DecodeInsertSalesRequest(_ context.Context, r *http.Request) (interface{},
 	carriage := &models.CarriageReport{
 		TripID: models.TripID{
 			RouteID:   req.TripID.RouteID,
+			Year:      req.TripID.Year,
 			StartTime: carriageStartTime,
 		},
 		EndTime:    carriageEndTime,
@@ -81,9 +159,25 @@ func DecodeInsertSalesRequest(_ context.Context, r *http.Request) (interface{},
 		Carts:      carts,
 	}
 
+	if req.DeviceTime != "" {
+		deviceTime, err := time.Parse(time.RFC3339, req.DeviceTime)
+		if err != nil {
+			return nil, errors.New("invalid device_time format")
+		}
+		carriage.ClockSkew = &models.ClockSkew{DeviceTime: deviceTime}
+	}
+
 	return carriage, nil
 }
 
+func DecodeGetReceiptRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	reportID := mux.Vars(r)["report_id"]
+	if reportID == "" {
+		return nil, errors.New("missing required path parameter: report_id")
+	}
+	return schemas.GetReceiptRequest{ReportID: reportID}, nil
+}
+
 func DecodeGetEmployeeCartsInTripRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	query := r.URL.Query()
 	routeID := query.Get("route_id")
@@ -102,10 +196,29 @@ func DecodeGetEmployeeCartsInTripRequest(_ context.Context, r *http.Request) (in
 			StartTime: startTime,
 		},
 		EmployeeID: employeeID,
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+	order, err := sortOrderFromQuery(query)
+	if err != nil {
+		return nil, err
 	}
+	req.Order = order
 	return req, nil
 }
 
+// sortOrderFromQuery Reads the order query parameter, defaulting to desc
+func sortOrderFromQuery(query url.Values) (string, error) {
+	switch order := query.Get("order"); order {
+	case "":
+		return string(models.SortDesc), nil
+	case string(models.SortAsc), string(models.SortDesc):
+		return order, nil
+	default:
+		return "", errors.New("invalid order (must be asc or desc)")
+	}
+}
+
 func DecodeGetEmployeeCartsInTripPagedRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	query := r.URL.Query()
 	routeID := query.Get("route_id")
@@ -136,7 +249,14 @@ func DecodeGetEmployeeCartsInTripPagedRequest(_ context.Context, r *http.Request
 		EmployeeID: employeeID,
 		Limit:      limit,
 		Cursor:     cursor,
+		From:       query.Get("from"),
+		To:         query.Get("to"),
 	}
+	order, err := sortOrderFromQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	req.Order = order
 	return req, nil
 }
 
@@ -176,9 +296,198 @@ func DecodeGetEmployeeTripsRequest(_ context.Context, r *http.Request) (interfac
 	return req, nil
 }
 
+func DecodeGetArchivedTripsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return schemas.GetArchivedTripsRequest{RouteID: r.URL.Query().Get("route_id")}, nil
+}
+
+func DecodeGetTripRevenueRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	routeID := query.Get("route_id")
+	year := query.Get("year")
+	startTime := query.Get("start_time")
+
+	if routeID == "" || year == "" || startTime == "" {
+		return nil, errors.New("missing required query parameters: route_id, year or start_time")
+	}
+
+	return schemas.GetTripRevenueRequest{
+		TripID: schemas.TripID{
+			RouteID:   routeID,
+			Year:      year,
+			StartTime: startTime,
+		},
+	}, nil
+}
+
+func DecodeGetDailyRevenueRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := schemas.GetDailyRevenueRequest{
+		RouteID:    query.Get("route_id"),
+		EmployeeID: query.Get("employee_id"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+
+	scopes := 0
+	if req.RouteID != "" {
+		scopes++
+	}
+	if req.EmployeeID != "" {
+		scopes++
+	}
+	if p := query.Get("product_id"); p != "" {
+		productID, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.New("invalid product_id (must be an integer)")
+		}
+		req.ProductID = &productID
+		scopes++
+	}
+	if scopes != 1 {
+		return nil, errors.New("exactly one of route_id, employee_id or product_id is required")
+	}
+	if req.From == "" || req.To == "" {
+		return nil, errors.New("missing required query parameters: from or to")
+	}
+	return req, nil
+}
+
+func DecodeGetEmployeeAnalyticsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := schemas.GetEmployeeAnalyticsRequest{
+		RouteID:    query.Get("route_id"),
+		EmployeeID: query.Get("employee_id"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+	if req.RouteID == "" && req.EmployeeID == "" {
+		return nil, errors.New("route_id or employee_id is required")
+	}
+	if req.From == "" || req.To == "" {
+		return nil, errors.New("missing required query parameters: from or to")
+	}
+	return req, nil
+}
+
+func DecodeGetProductConsumptionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := schemas.GetProductConsumptionRequest{
+		RouteID: query.Get("route_id"),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+		Format:  query.Get("format"),
+	}
+	if req.RouteID == "" || req.From == "" || req.To == "" {
+		return nil, errors.New("missing required query parameters: route_id, from or to")
+	}
+	if b := query.Get("by_carriage"); b != "" {
+		byCarriage, err := strconv.ParseBool(b)
+		if err != nil {
+			return nil, errors.New("invalid by_carriage (must be true or false)")
+		}
+		req.ByCarriage = byCarriage
+	}
+	switch req.Format {
+	case "":
+		req.Format = "json"
+	case "json", "csv":
+	default:
+		return nil, errors.New("invalid format (must be json or csv)")
+	}
+	return req, nil
+}
+
+func DecodeGetAnomaliesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := schemas.GetAnomaliesRequest{
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+		Kind:       query.Get("kind"),
+		RouteID:    query.Get("route_id"),
+		EmployeeID: query.Get("employee_id"),
+	}
+	if req.From == "" || req.To == "" {
+		return nil, errors.New("missing required query parameters: from or to")
+	}
+	switch req.Kind {
+	case "", models.AnomalyRefundRatio, models.AnomalyOutlierCart, models.AnomalyOutsideTrip, models.AnomalyCorrections:
+	default:
+		return nil, errors.New("invalid kind (must be refund_ratio, outlier_cart, outside_trip or corrections)")
+	}
+	return req, nil
+}
+
+func DecodeSearchOperationsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := schemas.SearchOperationsRequest{
+		RouteID:    query.Get("route_id"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+		EmployeeID: query.Get("employee_id"),
+		Limit:      50,
+		Cursor:     query.Get("cursor"),
+	}
+	if req.From == "" || req.To == "" {
+		return nil, errors.New("missing required query parameters: from or to")
+	}
+
+	if c := query.Get("carriage_id"); c != "" {
+		carriageID, err := strconv.ParseInt(c, 10, 8)
+		if err != nil || carriageID < 0 {
+			return nil, errors.New("invalid carriage_id (must be an integer from 0 to 127)")
+		}
+		id := int8(carriageID)
+		req.CarriageID = &id
+	}
+	switch t := query.Get("operation_type"); t {
+	case "":
+	case strconv.Itoa(int(models.OperationTypeSale)):
+		req.OperationType = models.OperationTypeSale
+	case strconv.Itoa(int(models.OperationTypeRefund)):
+		req.OperationType = models.OperationTypeRefund
+	default:
+		return nil, errors.New("invalid operation_type (must be 1 for sales or 2 for refunds)")
+	}
+	if p := query.Get("product_id"); p != "" {
+		productID, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.New("invalid product_id (must be an integer)")
+		}
+		req.ProductID = &productID
+	}
+	var err error
+	if req.MinAmount, err = amountFromQuery(query, "min_amount"); err != nil {
+		return nil, err
+	}
+	if req.MaxAmount, err = amountFromQuery(query, "max_amount"); err != nil {
+		return nil, err
+	}
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return nil, errors.New("invalid limit (must be a positive integer)")
+		}
+		req.Limit = n
+	}
+	return req, nil
+}
+
+// amountFromQuery Reads an optional amount in kopecks from the query parameter param
+func amountFromQuery(query url.Values, param string) (*int64, error) {
+	a := query.Get(param)
+	if a == "" {
+		return nil, nil
+	}
+	amount, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s (must be an integer number of kopecks)", param)
+	}
+	return &amount, nil
+}
+
 func DecodeUpdateItemQuantityRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req schemas.UpdateItemQuantityRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(r, &req); err != nil {
 		return nil, errors.New(invalidRequestBodyErrorMessage)
 	}
 	return req, nil
@@ -186,7 +495,7 @@ func DecodeUpdateItemQuantityRequest(_ context.Context, r *http.Request) (interf
 
 func DecodeDeleteItemFromCartRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req schemas.DeleteItemFromCartRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := decodeJSONBody(r, &req); err != nil {
 		return nil, errors.New(invalidRequestBodyErrorMessage)
 	}
 	return req, nil
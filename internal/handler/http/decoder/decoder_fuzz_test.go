@@ -0,0 +1,257 @@
+package decoder
+
+import (
+	"ChaikaReports/internal/handler/http/schemas"
+	"ChaikaReports/internal/models"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Seeds taken from the endpoint tests in test/http_test
+const (
+	seedInsertSales = `{"trip_id":{"route_id":"route_test","start_time":"2023-01-15T10:00:01Z"},` +
+		`"end_time":"2023-01-15T11:00:01Z","carriage_id":10,` +
+		`"carts":[{"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},` +
+		`"operation_type":1,"items":[{"product_id":1,"quantity":10,"price":100},{"product_id":2,"quantity":5,"price":200}]}]}`
+	seedInsertSalesDeviceTime = `{"trip_id":{"route_id":"route_test","start_time":"2023-01-15T10:00:01Z"},` +
+		`"end_time":"2023-01-15T11:00:01Z","carriage_id":10,"device_time":"2023-01-15T13:00:00+03:00",` +
+		`"carts":[{"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},` +
+		`"operation_type":2,"items":[{"product_id":1,"quantity":-1,"price":100}]}]}`
+	seedItemCorrection = `{"trip_id":{"route_id":"route_test","year":"2023","start_time":"2023-01-15T10:00:01Z"},` +
+		`"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},"product_id":1,"new_quantity":5}`
+	seedTripQuery = "route_id=route_test&year=2025&start_time=2025-08-20T08:00:00Z"
+)
+
+// bodyRequest Builds a request whose body is body
+func bodyRequest(body string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	return r
+}
+
+// queryRequest Builds a request with the raw query string query
+func queryRequest(query string) *http.Request {
+	return &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/", RawQuery: query}}
+}
+
+// checkDecoded Fails unless exactly one of req and err is set and err has a message
+func checkDecoded(t *testing.T, req interface{}, err error) bool {
+	t.Helper()
+	if err != nil {
+		if req != nil {
+			t.Fatalf("decoder returned both %#v and error %q", req, err)
+		}
+		if err.Error() == "" {
+			t.Fatal("decoder returned an empty error message")
+		}
+		return false
+	}
+	if req == nil {
+		t.Fatal("decoder returned neither a request nor an error")
+	}
+	return true
+}
+
+// checkCarriageReport Fails when a decoded report breaks an invariant the service relies on
+func checkCarriageReport(t *testing.T, report *models.CarriageReport) {
+	t.Helper()
+	if report.TripID.Year != strconv.Itoa(report.TripID.StartTime.Year()) {
+		t.Fatalf("year %q does not match start time %s", report.TripID.Year, report.TripID.StartTime)
+	}
+	if report.TripID.RouteID == "" || report.CarriageID == 0 || report.EndTime.IsZero() {
+		t.Fatalf("required field missing from %+v", report)
+	}
+	for _, cart := range report.Carts {
+		if cart.CartID.EmployeeID == "" || cart.CartID.OperationTime.IsZero() || cart.OperationType == 0 {
+			t.Fatalf("required cart field missing from %+v", cart)
+		}
+		for _, item := range cart.Items {
+			if item.Quantity == 0 {
+				t.Fatalf("invalid item %+v", item)
+			}
+		}
+	}
+}
+
+func FuzzDecodeInsertSalesRequest(f *testing.F) {
+	f.Add(seedInsertSales)
+	f.Add(seedInsertSalesDeviceTime)
+	f.Add(`{"trip_id":{"route_id":"route_test" "start_time":"2023-01-15T10:00:01Z"}}`)
+	f.Add(`{"trip_id":{"route_id":"route_test","start_time":"2023-01-15"},"carts":[]}`)
+	f.Add(seedInsertSales + `{}`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req, err := DecodeInsertSalesRequest(context.Background(), bodyRequest(body))
+		if checkDecoded(t, req, err) {
+			checkCarriageReport(t, req.(*models.CarriageReport))
+		}
+
+		// A single line body must be judged the same way as a bulk line
+		trimmed := strings.TrimSpace(body)
+		if trimmed == "" || strings.ContainsAny(trimmed, "\r\n") || len(trimmed) > maxBulkLineBytes {
+			return
+		}
+		bulk, bulkErr := DecodeInsertSalesBulkRequest(context.Background(), bodyRequest(body))
+		if bulkErr != nil {
+			t.Fatalf("bulk decoder rejected a single line: %v", bulkErr)
+		}
+		line := bulk.(schemas.InsertSalesBulkRequest).Lines[0]
+		if err == nil && line.Error != "" || err != nil && line.Error != err.Error() {
+			t.Fatalf("single decoder returned error %v, bulk line error %q", err, line.Error)
+		}
+	})
+}
+
+func FuzzDecodeInsertSalesBulkRequest(f *testing.F) {
+	f.Add(seedInsertSales + "\n{not json}\n\n" + seedInsertSalesDeviceTime + "\n")
+	f.Add("\n\n")
+	f.Add(seedInsertSales)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req, err := DecodeInsertSalesBulkRequest(context.Background(), bodyRequest(body))
+		if !checkDecoded(t, req, err) {
+			return
+		}
+		bulk := req.(schemas.InsertSalesBulkRequest)
+		if len(bulk.Lines) == 0 || len(bulk.Lines) > maxBulkLines {
+			t.Fatalf("decoded %d lines", len(bulk.Lines))
+		}
+		previous := 0
+		for _, line := range bulk.Lines {
+			if line.Line <= previous {
+				t.Fatalf("line %d follows line %d", line.Line, previous)
+			}
+			previous = line.Line
+			if (line.Report == nil) == (line.Error == "") {
+				t.Fatalf("line %d has report %v and error %q", line.Line, line.Report, line.Error)
+			}
+			if line.Report != nil {
+				checkCarriageReport(t, line.Report)
+			}
+		}
+	})
+}
+
+func FuzzDecodeGetReceiptRequest(f *testing.F) {
+	f.Add("report-1")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, reportID string) {
+		r := mux.SetURLVars(queryRequest(""), map[string]string{"report_id": reportID})
+		req, err := DecodeGetReceiptRequest(context.Background(), r)
+		if checkDecoded(t, req, err) && req.(schemas.GetReceiptRequest).ReportID != reportID {
+			t.Fatalf("report id %q decoded as %+v", reportID, req)
+		}
+	})
+}
+
+// FuzzDecodeQueryRequests Fuzzes every decoder reading its request from the query string
+func FuzzDecodeQueryRequests(f *testing.F) {
+	f.Add(seedTripQuery)
+	f.Add(seedTripQuery + "&employee_id=emp1&limit=2&cursor=eyJ0IjoiMjAyNS0wOC0yMFQxMDowMDowMFoifQ==")
+	f.Add(seedTripQuery + "&employee_id=emp1&limit=-1")
+	f.Add(seedTripQuery + "&employee_id=emp1&order=asc&from=2025-08-20T09:00:00Z&to=2025-08-20T10:00:00%2B03:00")
+	f.Add(seedTripQuery + "&employee_id=emp1&order=ASC")
+	f.Add("employee_id=emp1&year=2025")
+	f.Add("route_id=r1&from=2024-01-01&to=2024-01-31")
+	f.Add("product_id=12&from=2024-01-01&to=2024-01-31")
+	f.Add("route_id=r1&from=2024-01-01&to=2024-01-07&carriage_id=3&operation_type=2&product_id=42&min_amount=100&max_amount=-1&limit=5")
+	f.Add("from=2024-01-01&to=2024-01-07&kind=outlier_cart")
+	f.Add("route_id=r1&from=2024-01-01&to=2024-01-07&by_carriage=true&format=csv")
+	f.Add("route_id=%zz&year=;")
+
+	decoders := map[string]func(context.Context, *http.Request) (interface{}, error){
+		"GetEmployeeCartsInTrip":      DecodeGetEmployeeCartsInTripRequest,
+		"GetEmployeeCartsInTripPaged": DecodeGetEmployeeCartsInTripPagedRequest,
+		"GetEmployeeIDsByTrip":        DecodeGetEmployeeIDsByTripRequest,
+		"GetEmployeeTrips":            DecodeGetEmployeeTripsRequest,
+		"GetArchivedTrips":            DecodeGetArchivedTripsRequest,
+		"GetTripRevenue":              DecodeGetTripRevenueRequest,
+		"GetDailyRevenue":             DecodeGetDailyRevenueRequest,
+		"SearchOperations":            DecodeSearchOperationsRequest,
+		"GetEmployeeAnalytics":        DecodeGetEmployeeAnalyticsRequest,
+		"GetAnomalies":                DecodeGetAnomaliesRequest,
+		"GetProductConsumption":       DecodeGetProductConsumptionRequest,
+	}
+
+	f.Fuzz(func(t *testing.T, query string) {
+		for name, decode := range decoders {
+			req, err := decode(context.Background(), queryRequest(query))
+			if !checkDecoded(t, req, err) {
+				continue
+			}
+			switch req := req.(type) {
+			case schemas.GetEmployeeCartsInTripRequest:
+				checkTripID(t, name, req.TripID)
+				checkOrder(t, name, req.Order)
+			case schemas.GetEmployeeCartsInTripPagedRequest:
+				checkTripID(t, name, req.TripID)
+				checkOrder(t, name, req.Order)
+				if req.Limit <= 0 {
+					t.Fatalf("%s: decoded limit %d", name, req.Limit)
+				}
+			case schemas.GetEmployeeIDsByTripRequest:
+				checkTripID(t, name, req.TripID)
+			case schemas.GetTripRevenueRequest:
+				checkTripID(t, name, req.TripID)
+			case schemas.GetEmployeeTripsRequest:
+				if req.EmployeeID == "" || req.Year == "" {
+					t.Fatalf("%s: required field missing from %+v", name, req)
+				}
+			case schemas.GetDailyRevenueRequest:
+				if req.From == "" || req.To == "" {
+					t.Fatalf("%s: required field missing from %+v", name, req)
+				}
+			case schemas.GetEmployeeAnalyticsRequest:
+				if req.RouteID == "" && req.EmployeeID == "" || req.From == "" || req.To == "" {
+					t.Fatalf("%s: required field missing from %+v", name, req)
+				}
+			case schemas.GetAnomaliesRequest:
+				if req.From == "" || req.To == "" {
+					t.Fatalf("%s: required field missing from %+v", name, req)
+				}
+			case schemas.GetProductConsumptionRequest:
+				if req.RouteID == "" || req.From == "" || req.To == "" || req.Format != "json" && req.Format != "csv" {
+					t.Fatalf("%s: invalid request %+v", name, req)
+				}
+			case schemas.SearchOperationsRequest:
+				if req.From == "" || req.To == "" || req.Limit <= 0 || req.CarriageID != nil && *req.CarriageID < 0 {
+					t.Fatalf("%s: invalid request %+v", name, req)
+				}
+			}
+		}
+	})
+}
+
+func checkTripID(t *testing.T, name string, tripID schemas.TripID) {
+	t.Helper()
+	if tripID.RouteID == "" || tripID.Year == "" || tripID.StartTime == "" {
+		t.Fatalf("%s: required field missing from %+v", name, tripID)
+	}
+}
+
+func checkOrder(t *testing.T, name string, order string) {
+	t.Helper()
+	if order != string(models.SortAsc) && order != string(models.SortDesc) {
+		t.Fatalf("%s: decoded order %q", name, order)
+	}
+}
+
+// FuzzDecodeItemCorrectionRequests Fuzzes the decoders of the cart item corrections
+func FuzzDecodeItemCorrectionRequests(f *testing.F) {
+	f.Add(seedItemCorrection)
+	f.Add(`{"trip_id":{"route_id":"route_test"},"product_id":"1"}`)
+	f.Add(`{"new_quantity":70000}`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		req, err := DecodeUpdateItemQuantityRequest(context.Background(), bodyRequest(body))
+		checkDecoded(t, req, err)
+		req, err = DecodeDeleteItemFromCartRequest(context.Background(), bodyRequest(body))
+		checkDecoded(t, req, err)
+	})
+}
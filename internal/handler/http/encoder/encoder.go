@@ -2,11 +2,16 @@ package encoder
 
 import (
 	"ChaikaReports/internal/handler/http/schemas"
+	"ChaikaReports/internal/models"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-kit/log"
+	"mime"
 	"net/http"
+	"strconv"
 )
 
 // EncodeResponse encodes the domain response into an HTTP response
@@ -16,6 +21,15 @@ func EncodeResponse(_ context.Context, w http.ResponseWriter, response interface
 	case schemas.InsertSalesResponse:
 		w.WriteHeader(http.StatusOK)
 		return json.NewEncoder(w).Encode(res)
+	case schemas.ReplaceSalesResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetReceiptResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.InsertSalesBulkResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
 	case schemas.GetEmployeeCartsInTripResponse:
 		w.WriteHeader(http.StatusOK)
 		return json.NewEncoder(w).Encode(res)
@@ -28,6 +42,30 @@ func EncodeResponse(_ context.Context, w http.ResponseWriter, response interface
 	case schemas.GetEmployeeTripsResponse:
 		w.WriteHeader(http.StatusOK)
 		return json.NewEncoder(w).Encode(res)
+	case schemas.GetArchivedTripsResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetTripRevenueResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetDailyRevenueResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.SearchOperationsResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetEmployeeAnalyticsResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetProductConsumptionResponse:
+		if res.Format == "csv" {
+			return encodeProductConsumptionCSV(w, res)
+		}
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
+	case schemas.GetAnomaliesResponse:
+		w.WriteHeader(http.StatusOK)
+		return json.NewEncoder(w).Encode(res)
 	case schemas.UpdateItemQuantityResponse:
 		w.WriteHeader(http.StatusOK)
 		return json.NewEncoder(w).Encode(res)
@@ -39,6 +77,39 @@ func EncodeResponse(_ context.Context, w http.ResponseWriter, response interface
 	}
 }
 
+// encodeProductConsumptionCSV writes the products of a consumption report as a CSV attachment
+func encodeProductConsumptionCSV(w http.ResponseWriter, res schemas.GetProductConsumptionResponse) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": fmt.Sprintf("consumption_%s_%s_%s.csv", res.RouteID, res.From, res.To)}))
+	w.WriteHeader(http.StatusOK)
+
+	header := []string{"day", "product_id", "rank", "sold", "refunded", "quantity", "net_total"}
+	if res.ByCarriage {
+		header = append(header[:1], append([]string{"carriage_id"}, header[1:]...)...)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, p := range res.Products {
+		row := []string{p.Day, strconv.Itoa(p.ProductID), strconv.Itoa(p.Rank), strconv.Itoa(p.Sold),
+			strconv.Itoa(p.Refunded), strconv.Itoa(p.Quantity), strconv.FormatInt(p.NetTotal, 10)}
+		if res.ByCarriage {
+			carriageID := ""
+			if p.CarriageID != nil {
+				carriageID = strconv.Itoa(int(*p.CarriageID))
+			}
+			row = append(row[:1], append([]string{carriageID}, row[1:]...)...)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
 // EncodeError encodes errors into an HTTP error response
 func EncodeError(logger log.Logger) func(_ context.Context, err error, w http.ResponseWriter) {
 	return func(_ context.Context, err error, w http.ResponseWriter) {
@@ -47,6 +118,12 @@ func EncodeError(logger log.Logger) func(_ context.Context, err error, w http.Re
 		var msg string
 
 		switch {
+		case errors.Is(err, models.ErrNotFound):
+			code = http.StatusNotFound
+			msg = err.Error()
+		case errors.Is(err, models.ErrInvalidCursor):
+			code = http.StatusBadRequest
+			msg = err.Error()
 		case isValidationError(err):
 			code = http.StatusBadRequest
 			msg = err.Error()
@@ -64,6 +141,18 @@ func EncodeError(logger log.Logger) func(_ context.Context, err error, w http.Re
 	}
 }
 
+// ServiceErrorMessage Returns the message reported to a client for an error returned by the service outside of
+// a request decoder, such as a failed line of a bulk upload. Not found and invalid cursor errors are reported as
+// EncodeError reports them, anything else is logged and replaced by a generic message since it may carry
+// repository internals
+func ServiceErrorMessage(logger log.Logger, err error) string {
+	if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrInvalidCursor) {
+		return err.Error()
+	}
+	_ = logger.Log("error", fmt.Sprintf("Internal server error: %v", err))
+	return http.StatusText(http.StatusInternalServerError)
+}
+
 // Helper function to determine if the error is a validation error
 func isValidationError(err error) bool {
 	// Implement logic to determine if err is a validation error
@@ -1,12 +1,14 @@
 package http
 
 import (
+	"ChaikaReports/internal/handler/http/encoder"
 	"ChaikaReports/internal/handler/http/schemas"
 	"ChaikaReports/internal/models"
 	"ChaikaReports/internal/service"
 	"context"
 	"errors"
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
 	"time"
 )
 
@@ -14,6 +16,14 @@ const (
 	invalidStartTimeErrorMessage     = "invalid start_time format; must be RFC3339"
 	invalidOperationTimeErrorMessage = "invalid operation_time format; must be RFC3339"
 	invalidRequestTypeErrorMessage   = "invalid request type"
+	invalidDateErrorMessage          = "invalid from or to format; must be YYYY-MM-DD"
+	invalidWindowErrorMessage        = "invalid from or to format; must be RFC3339"
+)
+
+// Statuses reported per line by the bulk insert endpoint
+const (
+	bulkLineAccepted = "accepted"
+	bulkLineRejected = "rejected"
 )
 
 // MakeInsertSalesEndpoint creates the insert sales endpoint.
@@ -24,7 +34,7 @@ const (
 // @Accept       json
 // @Produce      json
 // @Param        request  body      schemas.InsertSalesRequest  true  "Insert Sales Request"
-// @Success      200      {object}  schemas.InsertSalesResponse "Data inserted successfully"
+// @Success      200      {object}  schemas.InsertSalesResponse "Data inserted successfully, with the receipt"
 // @Failure      400      {object}  schemas.ErrorResponse       "Bad request"
 // @Failure      500      {object}  schemas.ErrorResponse       "Internal server error"
 // @Router       /sale [post]
@@ -35,21 +45,154 @@ func MakeInsertSalesEndpoint(svc service.SalesService) endpoint.Endpoint {
 			return nil, errors.New(invalidRequestTypeErrorMessage)
 		}
 
-		err := svc.InsertData(ctx, carriage)
+		receipt, err := svc.InsertData(ctx, carriage)
 		if err != nil {
 			return nil, err
 		}
 
-		return schemas.InsertSalesResponse{
+		response := schemas.InsertSalesResponse{
 			Message: "Data inserted successfully",
+			Receipt: mapDomainReceiptToSchemaReceipt(receipt),
+		}
+		if cs := carriage.ClockSkew; cs != nil {
+			skewMs := cs.Skew.Milliseconds()
+			response.ClockSkewMs = &skewMs
+			response.ClockSkewFlagged = cs.Flagged
+			response.ClockCorrected = cs.Corrected
+		}
+		return response, nil
+	}
+}
+
+// MakeReplaceSalesEndpoint creates the replace carriage report endpoint.
+//
+// @Summary      Replace Carriage Report
+// @Description  Replaces the stored carriage report of a trip: new carts are inserted, changed items are overwritten and items missing from the request are deleted, all in one atomic batch. Requests listing an item twice, items stored for another carriage or more changes than fit in one batch are rejected.
+// @Tags         Sales
+// @Accept       json
+// @Produce      json
+// @Param        request  body      schemas.InsertSalesRequest   true  "Carriage report replacing the stored one"
+// @Success      200      {object}  schemas.ReplaceSalesResponse "Summary of changed rows"
+// @Failure      400      {object}  schemas.ErrorResponse        "Bad request"
+// @Failure      500      {object}  schemas.ErrorResponse        "Internal server error"
+// @Router       /sale [put]
+func MakeReplaceSalesEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		carriage, ok := request.(*models.CarriageReport)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		receipt, diff, err := svc.ReplaceCarriageReport(ctx, carriage)
+		if err != nil {
+			return nil, err
+		}
+
+		return schemas.ReplaceSalesResponse{
+			Message:   "Carriage report replaced successfully",
+			Receipt:   mapDomainReceiptToSchemaReceipt(receipt),
+			Inserted:  diff.Inserted,
+			Updated:   diff.Updated,
+			Deleted:   diff.Deleted,
+			Unchanged: diff.Unchanged,
 		}, nil
 	}
 }
 
+// MakeGetReceiptEndpoint creates the receipt lookup endpoint.
+//
+// @Summary      Get Report Receipt
+// @Description  Returns the receipt issued when a carriage report was accepted, so terminals can verify what the server stored.
+// @Tags         Sales
+// @Produce      json
+// @Param        report_id  path      string  true  "Report ID from the insert response"
+// @Success      200        {object}  schemas.GetReceiptResponse
+// @Failure      400        {object}  schemas.ErrorResponse
+// @Failure      404        {object}  schemas.ErrorResponse
+// @Router       /sale/{report_id} [get]
+func MakeGetReceiptEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetReceiptRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		receipt, err := svc.GetReceipt(ctx, req.ReportID)
+		if err != nil {
+			return nil, err
+		}
+
+		return schemas.GetReceiptResponse{
+			Receipt: mapDomainReceiptToSchemaReceipt(receipt),
+		}, nil
+	}
+}
+
+// MakeInsertSalesBulkEndpoint creates the bulk insert sales endpoint.
+//
+// @Summary      Bulk Insert Sales Data
+// @Description  Inserts newline-delimited JSON carriage reports and reports the outcome of every line, so partially failed uploads only need to resend rejected lines.
+// @Tags         Sales
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Param        request  body      schemas.InsertSalesRequest     true  "One Insert Sales Request per line"
+// @Success      200      {object}  schemas.InsertSalesBulkResponse "Per-line results"
+// @Failure      400      {object}  schemas.ErrorResponse          "Bad request"
+// @Failure      500      {object}  schemas.ErrorResponse          "Internal server error"
+// @Router       /sale/bulk [post]
+func MakeInsertSalesBulkEndpoint(svc service.SalesService, logger log.Logger) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.InsertSalesBulkRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		// Only lines that decoded successfully are sent to the service
+		reports := make([]*models.CarriageReport, 0, len(req.Lines))
+		for _, line := range req.Lines {
+			if line.Report != nil {
+				reports = append(reports, line.Report)
+			}
+		}
+		results := svc.InsertDataBulk(ctx, reports)
+
+		response := schemas.InsertSalesBulkResponse{
+			Results: make([]schemas.InsertSalesBulkResult, 0, len(req.Lines)),
+		}
+		next := 0
+		for _, line := range req.Lines {
+			result := schemas.InsertSalesBulkResult{Line: line.Line}
+			if line.Report == nil {
+				result.Status = bulkLineRejected
+				result.Error = line.Error
+			} else {
+				if err := results[next].Err; err != nil {
+					result.Status = bulkLineRejected
+					result.Error = encoder.ServiceErrorMessage(logger, err)
+				} else {
+					receipt := mapDomainReceiptToSchemaReceipt(results[next].Receipt)
+					result.Status = bulkLineAccepted
+					result.Receipt = &receipt
+				}
+				next++
+			}
+
+			if result.Status == bulkLineAccepted {
+				response.Accepted++
+			} else {
+				response.Rejected++
+			}
+			response.Results = append(response.Results, result)
+		}
+
+		return response, nil
+	}
+}
+
 // MakeGetEmployeeCartsInTripEndpoint handles getting carts for an employee in a trip
 //
 // @Summary      Get Employee Carts in Trip
-// @Description  Returns all carts handled by a specific employee during a specific trip.
+// @Description  Returns all carts handled by a specific employee during a specific trip, ordered by operation time.
 // @Tags         Sales
 // @Accept       json
 // @Produce      json
@@ -57,6 +200,9 @@ func MakeInsertSalesEndpoint(svc service.SalesService) endpoint.Endpoint {
 // @Param        year         query     string  true  "Year"
 // @Param        start_time   query     string  true  "Trip Start Time in RFC3339 format"
 // @Param        employee_id  query     string  true  "Employee ID"
+// @Param        order        query     string  false "Sort order by operation time, asc or desc (default desc)"
+// @Param        from         query     string  false "Earliest operation time, inclusive (RFC3339)"
+// @Param        to           query     string  false "Latest operation time, exclusive (RFC3339)"
 // @Success      200          {object}  schemas.GetEmployeeCartsInTripResponse
 // @Failure      400          {object}  schemas.ErrorResponse
 // @Failure      500          {object}  schemas.ErrorResponse
@@ -82,8 +228,13 @@ func MakeGetEmployeeCartsInTripEndpoint(svc service.SalesService) endpoint.Endpo
 			StartTime: startTime,
 		}
 
+		filter, err := cartFilterFromRequest(req.Order, req.From, req.To)
+		if err != nil {
+			return nil, err
+		}
+
 		// Call the service. EmployeeID remains a string.
-		carts, err := svc.GetEmployeeCartsInTrip(ctx, &tripID, &req.EmployeeID)
+		carts, err := svc.GetEmployeeCartsInTrip(ctx, &tripID, &req.EmployeeID, filter)
 		if err != nil {
 			return nil, err
 		}
@@ -104,7 +255,7 @@ func MakeGetEmployeeCartsInTripEndpoint(svc service.SalesService) endpoint.Endpo
 // MakeGetEmployeeCartsInTripPagedEndpoint handles paginated (cart-safe) retrieval
 //
 // @Summary      Get Employee Carts in Trip (paged, cart-safe)
-// @Description  Returns complete carts, paginated by carts with an opaque cursor.
+// @Description  Returns complete carts ordered by operation time, paginated by carts with opaque next and previous cursors.
 // @Tags         Sales
 // @Accept       json
 // @Produce      json
@@ -113,7 +264,10 @@ func MakeGetEmployeeCartsInTripEndpoint(svc service.SalesService) endpoint.Endpo
 // @Param        start_time   query     string  true  "Trip Start Time (RFC3339)"
 // @Param        employee_id  query     string  true  "Employee ID"
 // @Param        limit        query     int     false "Number of complete carts to return (default 10)"
-// @Param        cursor       query     string  false "Opaque cursor from previous response; empty to start"
+// @Param        cursor       query     string  false "Signed next_cursor or prev_cursor from a previous response, valid for the same trip, employee, limit, order and window until it expires; empty to start"
+// @Param        order        query     string  false "Sort order by operation time, asc or desc (default desc)"
+// @Param        from         query     string  false "Earliest operation time, inclusive (RFC3339)"
+// @Param        to           query     string  false "Latest operation time, exclusive (RFC3339)"
 // @Success      200          {object}  schemas.GetEmployeeCartsInTripPagedResponse
 // @Failure      400          {object}  schemas.ErrorResponse
 // @Failure      500          {object}  schemas.ErrorResponse
@@ -136,23 +290,52 @@ func MakeGetEmployeeCartsInTripPagedEndpoint(svc service.SalesService) endpoint.
 			StartTime: startTime,
 		}
 
-		carts, nextCursor, err := svc.GetEmployeeCartsInTripPaged(ctx, &tripID, req.EmployeeID, req.Limit, req.Cursor)
+		filter, err := cartFilterFromRequest(req.Order, req.From, req.To)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := svc.GetEmployeeCartsInTripPaged(ctx, &tripID, req.EmployeeID, filter, req.Limit, req.Cursor)
 		if err != nil {
 			return nil, err
 		}
 
 		var schemaCarts []schemas.Cart
-		for _, c := range carts {
+		for _, c := range page.Carts {
 			schemaCarts = append(schemaCarts, mapDomainCartToSchemaCart(c))
 		}
 
 		return schemas.GetEmployeeCartsInTripPagedResponse{
 			Carts:      schemaCarts,
-			NextCursor: nextCursor,
+			NextCursor: page.NextCursor,
+			PrevCursor: page.PrevCursor,
 		}, nil
 	}
 }
 
+// cartFilterFromRequest Builds the cart filter from the order and the optional RFC3339 window bounds
+func cartFilterFromRequest(order, from, to string) (models.CartFilter, error) {
+	filter := models.CartFilter{Order: models.SortOrder(order)}
+	if filter.Order == "" {
+		filter.Order = models.SortDesc
+	}
+	var err error
+	if from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return models.CartFilter{}, errors.New(invalidWindowErrorMessage)
+		}
+	}
+	if to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return models.CartFilter{}, errors.New(invalidWindowErrorMessage)
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return models.CartFilter{}, errors.New("invalid window; from must be before to")
+	}
+	return filter, nil
+}
+
 // MakeGetEmployeeIDsByTripEndpoint handles getting employee IDs by trip
 //
 // @Summary      Get Employee IDs by Trip
@@ -250,6 +433,323 @@ func MakeGetEmployeeTripsEndpoint(svc service.SalesService) endpoint.Endpoint {
 	}
 }
 
+// MakeGetArchivedTripsEndpoint handles listing trips moved to archive files
+//
+// @Summary      Get Archived Trips
+// @Description  Returns the catalog of trips the retention job moved from the database to archive files.
+// @Tags         Sales
+// @Produce      json
+// @Param        route_id  query     string  false  "Route ID, all routes when omitted"
+// @Success      200       {object}  schemas.GetArchivedTripsResponse
+// @Failure      400       {object}  schemas.ErrorResponse
+// @Router       /trip/archived [get]
+func MakeGetArchivedTripsEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetArchivedTripsRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		archived, err := svc.GetArchivedTrips(ctx, req.RouteID)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.GetArchivedTripsResponse{ArchivedTrips: make([]schemas.ArchivedTrip, 0, len(archived))}
+		for _, a := range archived {
+			response.ArchivedTrips = append(response.ArchivedTrips, mapDomainArchivedTripToSchemaArchivedTrip(a))
+		}
+		return response, nil
+	}
+}
+
+// MakeGetTripRevenueEndpoint handles getting the revenue aggregate of a trip
+//
+// @Summary      Get Trip Revenue
+// @Description  Returns the pre-aggregated sales and refunds of a trip, amounts are in kopecks.
+// @Tags         Revenue
+// @Produce      json
+// @Param        route_id    query     string  true  "Route ID"
+// @Param        year        query     string  true  "Year"
+// @Param        start_time  query     string  true  "Trip Start Time in RFC3339 format"
+// @Success      200         {object}  schemas.GetTripRevenueResponse
+// @Failure      400         {object}  schemas.ErrorResponse
+// @Failure      404         {object}  schemas.ErrorResponse
+// @Router       /trip/revenue [get]
+func MakeGetTripRevenueEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetTripRevenueRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		startTime, err := time.Parse(time.RFC3339, req.TripID.StartTime)
+		if err != nil {
+			return nil, errors.New(invalidStartTimeErrorMessage)
+		}
+		tripID := models.TripID{
+			RouteID:   req.TripID.RouteID,
+			Year:      req.TripID.Year,
+			StartTime: startTime,
+		}
+
+		revenue, err := svc.GetTripRevenue(ctx, &tripID)
+		if err != nil {
+			return nil, err
+		}
+
+		return schemas.GetTripRevenueResponse{
+			TripID:    req.TripID,
+			Revenue:   mapDomainRevenueToSchemaRevenue(revenue.Revenue),
+			UpdatedAt: revenue.UpdatedAt.Format(time.RFC3339),
+		}, nil
+	}
+}
+
+// MakeGetDailyRevenueEndpoint handles getting the daily revenue of a route, employee or product
+//
+// @Summary      Get Daily Revenue
+// @Description  Returns the pre-aggregated revenue of every UTC day in the range that has operations. Exactly one of route_id, employee_id and product_id must be given.
+// @Tags         Revenue
+// @Produce      json
+// @Param        route_id     query     string  false  "Route ID"
+// @Param        employee_id  query     string  false  "Employee ID"
+// @Param        product_id   query     int     false  "Product ID"
+// @Param        from         query     string  true   "First day, YYYY-MM-DD"
+// @Param        to           query     string  true   "Last day, YYYY-MM-DD"
+// @Success      200          {object}  schemas.GetDailyRevenueResponse
+// @Failure      400          {object}  schemas.ErrorResponse
+// @Router       /revenue/daily [get]
+func MakeGetDailyRevenueEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetDailyRevenueRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		from, err := time.Parse(time.DateOnly, req.From)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+		to, err := time.Parse(time.DateOnly, req.To)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+
+		scope := models.RevenueScope{RouteID: req.RouteID, EmployeeID: req.EmployeeID, ProductID: req.ProductID}
+		days, err := svc.GetDailyRevenue(ctx, scope, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.GetDailyRevenueResponse{Days: make([]schemas.DailyRevenue, 0, len(days))}
+		for _, d := range days {
+			response.Days = append(response.Days, schemas.DailyRevenue{Day: d.Day, Revenue: mapDomainRevenueToSchemaRevenue(d.Revenue)})
+		}
+		return response, nil
+	}
+}
+
+// MakeSearchOperationsEndpoint handles searching the operations of trips
+//
+// @Summary      Search Operations
+// @Description  Returns the product lines of the trips started in the day range that match every given filter, ordered by route, trip start, carriage, operation time, employee and product. A page may hold fewer operations than the limit while next_cursor is set.
+// @Tags         Sales
+// @Produce      json
+// @Param        route_id        query     string  false  "Route ID, every route when empty"
+// @Param        from            query     string  true   "First trip start day, YYYY-MM-DD"
+// @Param        to              query     string  true   "Last trip start day, YYYY-MM-DD, at most 31 days after from"
+// @Param        carriage_id     query     int     false  "Carriage ID"
+// @Param        employee_id     query     string  false  "Employee ID"
+// @Param        operation_type  query     int     false  "1 for sales, 2 for refunds"
+// @Param        product_id      query     int     false  "Product ID"
+// @Param        min_amount      query     int     false  "Minimum quantity times price in kopecks, inclusive"
+// @Param        max_amount      query     int     false  "Maximum quantity times price in kopecks, inclusive"
+// @Param        limit           query     int     false  "Number of operations to return (default 50, at most 500)"
+// @Param        cursor          query     string  false  "Signed next_cursor from a previous response with the same filters and limit"
+// @Success      200             {object}  schemas.SearchOperationsResponse
+// @Failure      400             {object}  schemas.ErrorResponse
+// @Router       /operation/search [get]
+func MakeSearchOperationsEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.SearchOperationsRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		from, err := time.Parse(time.DateOnly, req.From)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+		to, err := time.Parse(time.DateOnly, req.To)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+
+		filter := models.OperationFilter{
+			RouteID:       req.RouteID,
+			From:          from,
+			To:            to,
+			CarriageID:    req.CarriageID,
+			EmployeeID:    req.EmployeeID,
+			OperationType: req.OperationType,
+			ProductID:     req.ProductID,
+			MinAmount:     req.MinAmount,
+			MaxAmount:     req.MaxAmount,
+		}
+		page, err := svc.SearchOperations(ctx, filter, req.Limit, req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.SearchOperationsResponse{
+			Operations: make([]schemas.Operation, 0, len(page.Operations)),
+			NextCursor: page.NextCursor,
+		}
+		for _, op := range page.Operations {
+			response.Operations = append(response.Operations, mapDomainOperationToSchemaOperation(op))
+		}
+		return response, nil
+	}
+}
+
+// MakeGetEmployeeAnalyticsEndpoint handles getting the performance of employees over a range of trips
+//
+// @Summary      Get Employee Analytics
+// @Description  Aggregates the carts of the trips started in the day range per employee: trips worked, carts, revenue, refund ratio, average sale cart value and the top 5 products by net total. With route_id every employee of the route is returned ranked by net total, narrowed to employee_id when it is also given; with only employee_id the trips of that employee on every route are aggregated and no rank is set.
+// @Tags         Revenue
+// @Produce      json
+// @Param        route_id     query     string  false  "Route ID"
+// @Param        employee_id  query     string  false  "Employee ID"
+// @Param        from         query     string  true   "First trip start day, YYYY-MM-DD"
+// @Param        to           query     string  true   "Last trip start day, YYYY-MM-DD, at most 93 days after from"
+// @Success      200          {object}  schemas.GetEmployeeAnalyticsResponse
+// @Failure      400          {object}  schemas.ErrorResponse
+// @Router       /analytics/employee [get]
+func MakeGetEmployeeAnalyticsEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetEmployeeAnalyticsRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		from, err := time.Parse(time.DateOnly, req.From)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+		to, err := time.Parse(time.DateOnly, req.To)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+
+		employees, err := svc.GetEmployeeAnalytics(ctx, req.RouteID, req.EmployeeID, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.GetEmployeeAnalyticsResponse{Employees: make([]schemas.EmployeeStats, 0, len(employees))}
+		for _, e := range employees {
+			response.Employees = append(response.Employees, mapDomainEmployeeStatsToSchemaEmployeeStats(e))
+		}
+		return response, nil
+	}
+}
+
+// MakeGetProductConsumptionEndpoint handles getting the products consumed on the trips of a route
+//
+// @Summary      Get Product Consumption
+// @Description  Sums the items sold and refunded per product on the trips of the route started on each UTC day of the range, optionally per carriage, to plan restocking. Products are ranked by net quantity (sold minus refunded) within their day or carriage, ties by net total. With format=csv the rows are returned as a CSV attachment with the columns day, carriage_id (only with by_carriage), product_id, rank, sold, refunded, quantity and net_total.
+// @Tags         Revenue
+// @Produce      json
+// @Produce      text/csv
+// @Param        route_id     query     string  true   "Route ID"
+// @Param        from         query     string  true   "First trip start day, YYYY-MM-DD"
+// @Param        to           query     string  true   "Last trip start day, YYYY-MM-DD, at most 93 days after from"
+// @Param        by_carriage  query     bool    false  "Break the products down per carriage"
+// @Param        format       query     string  false  "json (default) or csv"
+// @Success      200          {object}  schemas.GetProductConsumptionResponse
+// @Failure      400          {object}  schemas.ErrorResponse
+// @Router       /analytics/product [get]
+func MakeGetProductConsumptionEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetProductConsumptionRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		from, err := time.Parse(time.DateOnly, req.From)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+		to, err := time.Parse(time.DateOnly, req.To)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+
+		products, err := svc.GetProductConsumption(ctx, req.RouteID, from, to, req.ByCarriage)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.GetProductConsumptionResponse{
+			RouteID:    req.RouteID,
+			From:       req.From,
+			To:         req.To,
+			ByCarriage: req.ByCarriage,
+			Format:     req.Format,
+			Products:   make([]schemas.ProductConsumption, 0, len(products)),
+		}
+		for _, p := range products {
+			response.Products = append(response.Products, mapDomainProductConsumptionToSchemaProductConsumption(p))
+		}
+		return response, nil
+	}
+}
+
+// MakeGetAnomaliesEndpoint handles getting the anomalies flagged by the analyzer
+//
+// @Summary      Get Anomalies
+// @Description  Returns the suspicious patterns flagged for the UTC days in the range, the day of a trip flag is the day the trip started. Kinds: refund_ratio (refunds total over sales and refunds total of an employee in a trip), outlier_cart (cart total over the median cart total of its trip), outside_trip (minutes an operation is outside its trip), corrections (items of the carts of an employee corrected on the day).
+// @Tags         Anomalies
+// @Produce      json
+// @Param        from         query     string  true   "First day, YYYY-MM-DD"
+// @Param        to           query     string  true   "Last day, YYYY-MM-DD"
+// @Param        kind         query     string  false  "refund_ratio, outlier_cart, outside_trip or corrections"
+// @Param        route_id     query     string  false  "Route ID"
+// @Param        employee_id  query     string  false  "Employee ID"
+// @Success      200          {object}  schemas.GetAnomaliesResponse
+// @Failure      400          {object}  schemas.ErrorResponse
+// @Router       /anomaly [get]
+func MakeGetAnomaliesEndpoint(svc service.SalesService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(schemas.GetAnomaliesRequest)
+		if !ok {
+			return nil, errors.New(invalidRequestTypeErrorMessage)
+		}
+
+		from, err := time.Parse(time.DateOnly, req.From)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+		to, err := time.Parse(time.DateOnly, req.To)
+		if err != nil {
+			return nil, errors.New(invalidDateErrorMessage)
+		}
+
+		filter := models.AnomalyFilter{From: from, To: to, Kind: req.Kind, RouteID: req.RouteID, EmployeeID: req.EmployeeID}
+		anomalies, err := svc.GetAnomalies(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		response := schemas.GetAnomaliesResponse{Anomalies: make([]schemas.Anomaly, 0, len(anomalies))}
+		for _, a := range anomalies {
+			response.Anomalies = append(response.Anomalies, mapDomainAnomalyToSchemaAnomaly(a))
+		}
+		return response, nil
+	}
+}
+
 // MakeUpdateItemQuantityEndpoint handles updating quantity of an item in a cart
 //
 // @Summary      Update Item Quantity
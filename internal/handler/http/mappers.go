@@ -30,3 +30,125 @@ func mapDomainItemsToSchemaItems(items []models.Item) []schemas.Item {
 	}
 	return schemaItems
 }
+
+// mapDomainArchivedTripToSchemaArchivedTrip converts a domain ArchivedTrip into a schema ArchivedTrip.
+func mapDomainArchivedTripToSchemaArchivedTrip(archived models.ArchivedTrip) schemas.ArchivedTrip {
+	return schemas.ArchivedTrip{
+		TripID: schemas.TripID{
+			RouteID:   archived.TripID.RouteID,
+			Year:      archived.TripID.Year,
+			StartTime: archived.TripID.StartTime.Format(time.RFC3339),
+		},
+		ArchiveFile: archived.ArchiveFile,
+		Reports:     archived.Reports,
+		Operations:  archived.Operations,
+		ArchivedAt:  archived.ArchivedAt.Format(time.RFC3339),
+	}
+}
+
+// mapDomainRevenueToSchemaRevenue converts domain Revenue into schema Revenue.
+func mapDomainRevenueToSchemaRevenue(revenue models.Revenue) schemas.Revenue {
+	return schemas.Revenue{
+		Sales:         revenue.Sales,
+		Refunds:       revenue.Refunds,
+		ItemsSold:     revenue.ItemsSold,
+		ItemsRefunded: revenue.ItemsRefunded,
+		SalesTotal:    revenue.SalesTotal,
+		RefundsTotal:  revenue.RefundsTotal,
+		NetTotal:      revenue.NetTotal,
+	}
+}
+
+// mapDomainOperationToSchemaOperation converts a domain Operation into a schema Operation.
+func mapDomainOperationToSchemaOperation(op models.Operation) schemas.Operation {
+	return schemas.Operation{
+		TripID: schemas.TripID{
+			RouteID:   op.TripID.RouteID,
+			Year:      op.TripID.Year,
+			StartTime: op.TripID.StartTime.Format(time.RFC3339),
+		},
+		CarriageID: op.CarriageID,
+		CartID: schemas.CartID{
+			EmployeeID:    op.CartID.EmployeeID,
+			OperationTime: op.CartID.OperationTime.Format(time.RFC3339),
+		},
+		OperationType: op.OperationType,
+		ProductID:     op.Item.ProductID,
+		Quantity:      op.Item.Quantity,
+		Price:         op.Item.Price,
+		Amount:        op.Amount,
+	}
+}
+
+// mapDomainReceiptToSchemaReceipt converts a domain Receipt into a schema Receipt.
+func mapDomainReceiptToSchemaReceipt(receipt models.Receipt) schemas.Receipt {
+	return schemas.Receipt{
+		ReportID: receipt.ReportID,
+		TripID: schemas.TripID{
+			RouteID:   receipt.TripID.RouteID,
+			Year:      receipt.TripID.Year,
+			StartTime: receipt.TripID.StartTime.Format(time.RFC3339),
+		},
+		CarriageID:  receipt.CarriageID,
+		ContentHash: receipt.ContentHash,
+		Carts:       receipt.Carts,
+		Items:       receipt.Items,
+		ReceivedAt:  receipt.ReceivedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// mapDomainEmployeeStatsToSchemaEmployeeStats converts domain EmployeeStats into schema EmployeeStats.
+func mapDomainEmployeeStatsToSchemaEmployeeStats(stats models.EmployeeStats) schemas.EmployeeStats {
+	products := make([]schemas.ProductStats, 0, len(stats.TopProducts))
+	for _, p := range stats.TopProducts {
+		products = append(products, schemas.ProductStats{ProductID: p.ProductID, Quantity: p.Quantity, NetTotal: p.NetTotal})
+	}
+	return schemas.EmployeeStats{
+		EmployeeID:  stats.EmployeeID,
+		Rank:        stats.Rank,
+		Trips:       stats.Trips,
+		Carts:       stats.Carts,
+		Revenue:     mapDomainRevenueToSchemaRevenue(stats.Revenue),
+		RefundRatio: stats.RefundRatio,
+		AverageCart: stats.AverageCart,
+		TopProducts: products,
+	}
+}
+
+// mapDomainProductConsumptionToSchemaProductConsumption converts a domain ProductConsumption into a schema ProductConsumption.
+func mapDomainProductConsumptionToSchemaProductConsumption(product models.ProductConsumption) schemas.ProductConsumption {
+	return schemas.ProductConsumption{
+		Day:        product.Day,
+		CarriageID: product.CarriageID,
+		ProductID:  product.ProductID,
+		Rank:       product.Rank,
+		Sold:       product.Sold,
+		Refunded:   product.Refunded,
+		Quantity:   product.Quantity,
+		NetTotal:   product.NetTotal,
+	}
+}
+
+// mapDomainAnomalyToSchemaAnomaly converts a domain Anomaly into a schema Anomaly.
+func mapDomainAnomalyToSchemaAnomaly(anomaly models.Anomaly) schemas.Anomaly {
+	res := schemas.Anomaly{
+		ID:         anomaly.ID,
+		Day:        anomaly.Day,
+		Kind:       anomaly.Kind,
+		EmployeeID: anomaly.EmployeeID,
+		Value:      anomaly.Value,
+		Threshold:  anomaly.Threshold,
+		DetectedAt: anomaly.DetectedAt.Format(time.RFC3339),
+	}
+	if anomaly.TripID.RouteID != "" {
+		res.TripID = &schemas.TripID{
+			RouteID:   anomaly.TripID.RouteID,
+			Year:      anomaly.TripID.Year,
+			StartTime: anomaly.TripID.StartTime.Format(time.RFC3339),
+		}
+	}
+	if !anomaly.OperationTime.IsZero() {
+		res.OperationTime = anomaly.OperationTime.Format(time.RFC3339)
+	}
+	return res
+}
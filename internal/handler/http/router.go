@@ -56,6 +56,27 @@ func registerV1Routes(logger log.Logger, router *mux.Router, svc service.SalesSe
 		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
 	))
 
+	v1.Methods("PUT").Path("/sale").Handler(kitHttp.NewServer(
+		MakeReplaceSalesEndpoint(svc),
+		decoder.DecodeInsertSalesRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/sale/{report_id}").Handler(kitHttp.NewServer(
+		MakeGetReceiptEndpoint(svc),
+		decoder.DecodeGetReceiptRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("POST").Path("/sale/bulk").Handler(kitHttp.NewServer(
+		MakeInsertSalesBulkEndpoint(svc, logger),
+		decoder.DecodeInsertSalesBulkRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
 	v1.Methods("GET").Path("/trip/cart/employee").Handler(kitHttp.NewServer(
 		MakeGetEmployeeCartsInTripEndpoint(svc),
 		decoder.DecodeGetEmployeeCartsInTripRequest,
@@ -84,6 +105,55 @@ func registerV1Routes(logger log.Logger, router *mux.Router, svc service.SalesSe
 		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
 	))
 
+	v1.Methods("GET").Path("/trip/archived").Handler(kitHttp.NewServer(
+		MakeGetArchivedTripsEndpoint(svc),
+		decoder.DecodeGetArchivedTripsRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/trip/revenue").Handler(kitHttp.NewServer(
+		MakeGetTripRevenueEndpoint(svc),
+		decoder.DecodeGetTripRevenueRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/revenue/daily").Handler(kitHttp.NewServer(
+		MakeGetDailyRevenueEndpoint(svc),
+		decoder.DecodeGetDailyRevenueRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/operation/search").Handler(kitHttp.NewServer(
+		MakeSearchOperationsEndpoint(svc),
+		decoder.DecodeSearchOperationsRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/analytics/employee").Handler(kitHttp.NewServer(
+		MakeGetEmployeeAnalyticsEndpoint(svc),
+		decoder.DecodeGetEmployeeAnalyticsRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/analytics/product").Handler(kitHttp.NewServer(
+		MakeGetProductConsumptionEndpoint(svc),
+		decoder.DecodeGetProductConsumptionRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
+	v1.Methods("GET").Path("/anomaly").Handler(kitHttp.NewServer(
+		MakeGetAnomaliesEndpoint(svc),
+		decoder.DecodeGetAnomaliesRequest,
+		encoder.EncodeResponse,
+		kitHttp.ServerErrorEncoder(encoder.EncodeError(logger)),
+	))
+
 	v1.Methods("PUT").Path("/trip/cart/item/quantity").Handler(kitHttp.NewServer(
 		MakeUpdateItemQuantityEndpoint(svc),
 		decoder.DecodeUpdateItemQuantityRequest,
@@ -1,6 +1,9 @@
 package schemas
 
-import "time"
+import (
+	"ChaikaReports/internal/models"
+	"time"
+)
 
 // TripID represents the trip identifier in the request
 type TripID struct {
@@ -41,17 +44,85 @@ type InsertSalesRequest struct {
 	EndTime    string `json:"end_time" validate:"required,datetime=2006-01-02T15:04:05Z07:00"`
 	CarriageID int8   `json:"carriage_id" validate:"required"`
 	Carts      []Cart `json:"carts" validate:"required,dive"`
+	// DeviceTime is the terminal clock at upload time, used to detect clock skew
+	DeviceTime string `json:"device_time,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+// Receipt represents the server acknowledgement of an accepted carriage report
+type Receipt struct {
+	ReportID    string `json:"report_id"`
+	TripID      TripID `json:"trip_id"`
+	CarriageID  int8   `json:"carriage_id"`
+	ContentHash string `json:"content_hash"`
+	Carts       int    `json:"carts"`
+	Items       int    `json:"items"`
+	ReceivedAt  string `json:"received_at"`
 }
 
 // InsertSalesResponse represents the response body for a successful insert
 type InsertSalesResponse struct {
-	Message string `json:"message"`
+	Message string  `json:"message"`
+	Receipt Receipt `json:"receipt"`
+	// ClockSkewMs is the server time minus the terminal device time, present when device_time was sent
+	ClockSkewMs      *int64 `json:"clock_skew_ms,omitempty"`
+	ClockSkewFlagged bool   `json:"clock_skew_flagged,omitempty"`
+	ClockCorrected   bool   `json:"clock_corrected,omitempty"`
+}
+
+// ReplaceSalesResponse represents the response body for a successful carriage report replacement
+type ReplaceSalesResponse struct {
+	Message   string  `json:"message"`
+	Receipt   Receipt `json:"receipt"`
+	Inserted  int     `json:"inserted"`
+	Updated   int     `json:"updated"`
+	Deleted   int     `json:"deleted"`
+	Unchanged int     `json:"unchanged"`
+}
+
+// GetReceiptRequest represents the request for the GET /api/v1/report/sale/{report_id} endpoint
+type GetReceiptRequest struct {
+	ReportID string `json:"report_id" validate:"required"`
+}
+
+// GetReceiptResponse represents the response with a stored receipt
+type GetReceiptResponse struct {
+	Receipt Receipt `json:"receipt"`
+}
+
+// InsertSalesBulkRequest represents the decoded NDJSON body of the POST /api/v1/report/sale/bulk endpoint
+type InsertSalesBulkRequest struct {
+	Lines []InsertSalesBulkLine
+}
+
+// InsertSalesBulkLine is a single NDJSON line; Report is nil and Error is set when the line could not be decoded
+type InsertSalesBulkLine struct {
+	Line   int
+	Report *models.CarriageReport
+	Error  string
+}
+
+// InsertSalesBulkResult represents the outcome of a single line of a bulk upload
+type InsertSalesBulkResult struct {
+	Line    int      `json:"line"`
+	Status  string   `json:"status"` // "accepted" or "rejected"
+	Error   string   `json:"error,omitempty"`
+	Receipt *Receipt `json:"receipt,omitempty"`
+}
+
+// InsertSalesBulkResponse represents the response body for a bulk upload
+type InsertSalesBulkResponse struct {
+	Accepted int                     `json:"accepted"`
+	Rejected int                     `json:"rejected"`
+	Results  []InsertSalesBulkResult `json:"results"`
 }
 
 // GetEmployeeCartsInTripRequest represents the request for the GET /api/v1/sales/trip/cart/employee endpoint.
 type GetEmployeeCartsInTripRequest struct {
 	TripID     TripID `json:"trip_id" validate:"required"`
 	EmployeeID string `json:"employee_id" validate:"required"`
+	Order      string `json:"order,omitempty"` // asc or desc by operation time, desc when empty
+	From       string `json:"from,omitempty"`  // RFC3339, inclusive
+	To         string `json:"to,omitempty"`    // RFC3339, exclusive
 }
 
 // GetEmployeeCartsInTripResponse represents the response with the list of carts.
@@ -64,12 +135,16 @@ type GetEmployeeCartsInTripPagedRequest struct {
 	EmployeeID string `json:"employee_id" validate:"required"`
 	Limit      int    `json:"limit,omitempty"`
 	Cursor     string `json:"cursor,omitempty"`
+	Order      string `json:"order,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
 }
 
 // Paged variant: response
 type GetEmployeeCartsInTripPagedResponse struct {
 	Carts      []Cart `json:"carts"`
 	NextCursor string `json:"next_cursor"` // "" means no more carts
+	PrevCursor string `json:"prev_cursor"` // "" means this is the first page
 }
 
 type GetEmployeeIDsByTripRequest struct {
@@ -90,6 +165,193 @@ type GetEmployeeTripsResponse struct {
 	EmployeeTrips []EmployeeTrip `json:"employee_trips" validate:"required"`
 }
 
+// GetArchivedTripsRequest represents the request for the GET /api/v1/report/trip/archived endpoint
+type GetArchivedTripsRequest struct {
+	RouteID string `json:"route_id"`
+}
+
+// ArchivedTrip is a trip moved from the database to an archive file
+type ArchivedTrip struct {
+	TripID      TripID `json:"trip_id"`
+	ArchiveFile string `json:"archive_file"`
+	Reports     int    `json:"reports"`
+	Operations  int    `json:"operations"`
+	ArchivedAt  string `json:"archived_at"`
+}
+
+// GetArchivedTripsResponse represents the archive catalog response
+type GetArchivedTripsResponse struct {
+	ArchivedTrips []ArchivedTrip `json:"archived_trips"`
+}
+
+// GetTripRevenueRequest represents the request for the GET /api/v1/report/trip/revenue endpoint
+type GetTripRevenueRequest struct {
+	TripID TripID `json:"trip_id" validate:"required"`
+}
+
+// Revenue holds operation counts and totals, amounts are in kopecks
+type Revenue struct {
+	Sales         int   `json:"sales"`
+	Refunds       int   `json:"refunds"`
+	ItemsSold     int   `json:"items_sold"`
+	ItemsRefunded int   `json:"items_refunded"`
+	SalesTotal    int64 `json:"sales_total"`
+	RefundsTotal  int64 `json:"refunds_total"`
+	NetTotal      int64 `json:"net_total"`
+}
+
+// GetTripRevenueResponse represents the revenue aggregate of a trip
+type GetTripRevenueResponse struct {
+	TripID    TripID  `json:"trip_id"`
+	Revenue   Revenue `json:"revenue"`
+	UpdatedAt string  `json:"updated_at"`
+}
+
+// GetDailyRevenueRequest represents the request for the GET /api/v1/report/revenue/daily endpoint,
+// exactly one of RouteID, EmployeeID and ProductID is set
+type GetDailyRevenueRequest struct {
+	RouteID    string `json:"route_id,omitempty"`
+	EmployeeID string `json:"employee_id,omitempty"`
+	ProductID  *int   `json:"product_id,omitempty"`
+	From       string `json:"from" validate:"required"`
+	To         string `json:"to" validate:"required"`
+}
+
+// DailyRevenue is the revenue of one UTC day
+type DailyRevenue struct {
+	Day     string  `json:"day"`
+	Revenue Revenue `json:"revenue"`
+}
+
+// GetDailyRevenueResponse lists the days of the requested range that have operations
+type GetDailyRevenueResponse struct {
+	Days []DailyRevenue `json:"days"`
+}
+
+// SearchOperationsRequest represents the request for the GET /api/v1/report/operation/search endpoint
+type SearchOperationsRequest struct {
+	RouteID       string `json:"route_id,omitempty"`
+	From          string `json:"from" validate:"required"`
+	To            string `json:"to" validate:"required"`
+	CarriageID    *int8  `json:"carriage_id,omitempty"`
+	EmployeeID    string `json:"employee_id,omitempty"`
+	OperationType int8   `json:"operation_type,omitempty"`
+	ProductID     *int   `json:"product_id,omitempty"`
+	MinAmount     *int64 `json:"min_amount,omitempty"`
+	MaxAmount     *int64 `json:"max_amount,omitempty"`
+	Limit         int    `json:"limit,omitempty"`
+	Cursor        string `json:"cursor,omitempty"`
+}
+
+// Operation is one product line of a cart found by a search
+type Operation struct {
+	TripID        TripID `json:"trip_id"`
+	CarriageID    int8   `json:"carriage_id"`
+	CartID        CartID `json:"cart_id"`
+	OperationType int8   `json:"operation_type"`
+	ProductID     int    `json:"product_id"`
+	Quantity      int16  `json:"quantity"`
+	Price         int64  `json:"price"`
+	Amount        int64  `json:"amount"` // quantity times price, kopecks
+}
+
+// SearchOperationsResponse is a page of matching operations
+type SearchOperationsResponse struct {
+	Operations []Operation `json:"operations"`
+	NextCursor string      `json:"next_cursor"` // "" means the search is complete
+}
+
+// GetEmployeeAnalyticsRequest represents the request for the GET /api/v1/report/analytics/employee endpoint,
+// at least one of RouteID and EmployeeID is set
+type GetEmployeeAnalyticsRequest struct {
+	RouteID    string `json:"route_id,omitempty"`
+	EmployeeID string `json:"employee_id,omitempty"`
+	From       string `json:"from" validate:"required"`
+	To         string `json:"to" validate:"required"`
+}
+
+// ProductStats is what an employee sold of a product, quantity and net total are sales minus refunds
+type ProductStats struct {
+	ProductID int   `json:"product_id"`
+	Quantity  int   `json:"quantity"`
+	NetTotal  int64 `json:"net_total"`
+}
+
+// EmployeeStats is the performance of an employee over the trips of the requested range
+type EmployeeStats struct {
+	EmployeeID  string         `json:"employee_id"`
+	Rank        int            `json:"rank,omitempty"` // by net total among the employees of the route, only set for a route
+	Trips       int            `json:"trips"`
+	Carts       int            `json:"carts"`
+	Revenue     Revenue        `json:"revenue"`
+	RefundRatio float64        `json:"refund_ratio"`
+	AverageCart int64          `json:"average_cart_value"`
+	TopProducts []ProductStats `json:"top_products"`
+}
+
+// GetEmployeeAnalyticsResponse lists the employees that worked the trips of the requested range
+type GetEmployeeAnalyticsResponse struct {
+	Employees []EmployeeStats `json:"employees"`
+}
+
+// GetProductConsumptionRequest represents the request for the GET /api/v1/report/analytics/product endpoint
+type GetProductConsumptionRequest struct {
+	RouteID    string `json:"route_id" validate:"required"`
+	From       string `json:"from" validate:"required"`
+	To         string `json:"to" validate:"required"`
+	ByCarriage bool   `json:"by_carriage,omitempty"`
+	Format     string `json:"format,omitempty"` // json or csv
+}
+
+// ProductConsumption is what was consumed of a product on the trips started on one day
+type ProductConsumption struct {
+	Day        string `json:"day"`
+	CarriageID *int8  `json:"carriage_id,omitempty"` // only set when the carriages are broken down
+	ProductID  int    `json:"product_id"`
+	Rank       int    `json:"rank"` // by net quantity within the day, or the carriage on the day
+	Sold       int    `json:"sold"`
+	Refunded   int    `json:"refunded"`
+	Quantity   int    `json:"quantity"`  // sold minus refunded
+	NetTotal   int64  `json:"net_total"` // kopecks
+}
+
+// GetProductConsumptionResponse lists the products consumed on the trips of a route, written as CSV when Format is csv
+type GetProductConsumptionResponse struct {
+	RouteID    string               `json:"route_id"`
+	From       string               `json:"from"`
+	To         string               `json:"to"`
+	ByCarriage bool                 `json:"-"`
+	Format     string               `json:"-"`
+	Products   []ProductConsumption `json:"products"`
+}
+
+// GetAnomaliesRequest represents the request for the GET /api/v1/report/anomaly endpoint
+type GetAnomaliesRequest struct {
+	From       string `json:"from" validate:"required"`
+	To         string `json:"to" validate:"required"`
+	Kind       string `json:"kind,omitempty"`
+	RouteID    string `json:"route_id,omitempty"`
+	EmployeeID string `json:"employee_id,omitempty"`
+}
+
+// Anomaly is a suspicious pattern flagged for fraud review
+type Anomaly struct {
+	ID            string  `json:"id"`
+	Day           string  `json:"day"`
+	Kind          string  `json:"kind"`
+	TripID        *TripID `json:"trip_id,omitempty"` // not set for corrections
+	EmployeeID    string  `json:"employee_id"`
+	OperationTime string  `json:"operation_time,omitempty"` // of the flagged cart
+	Value         float64 `json:"value"`
+	Threshold     float64 `json:"threshold"`
+	DetectedAt    string  `json:"detected_at"`
+}
+
+// GetAnomaliesResponse lists the anomalies flagged for the requested days
+type GetAnomaliesResponse struct {
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
 type UpdateItemQuantityRequest struct {
 	TripID      TripID `json:"trip_id" validate:"required"`
 	CartID      CartID `json:"cart_id" validate:"required"`
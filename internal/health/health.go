@@ -0,0 +1,189 @@
+// Package health reports liveness and readiness of the service.
+//
+// Liveness only says the process is serving requests. Readiness follows a periodic probe of
+// the database and turns false for good once shutdown has started, so load balancers stop
+// routing new requests before the servers close.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+)
+
+// Reasons reported while the service is not ready
+const (
+	reasonStarting     = "waiting for the first database probe"
+	reasonShuttingDown = "shutting down"
+)
+
+// Probe checks a dependency, it must return before ctx is done
+type Probe func(ctx context.Context) error
+
+// Status is the readiness of the service
+type Status struct {
+	Ready     bool      `json:"ready"`
+	Reason    string    `json:"reason,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+}
+
+// Checker runs the readiness probe periodically and notifies listeners when readiness changes
+type Checker struct {
+	probe    Probe
+	log      log.Logger
+	interval time.Duration
+	timeout  time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex
+	status       Status
+	shuttingDown bool
+	listeners    []func(ready bool)
+}
+
+// NewChecker Creates a checker probing every interval with the given timeout, zero values use 10s and 2s
+func NewChecker(probe Probe, logger log.Logger, interval, timeout time.Duration) *Checker {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Checker{
+		probe:    probe,
+		log:      logger,
+		interval: interval,
+		timeout:  timeout,
+		now:      time.Now,
+		status:   Status{Reason: reasonStarting},
+	}
+}
+
+// OnChange Registers fn to be called with the new readiness whenever it changes
+func (c *Checker) OnChange(fn func(ready bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Run Probes immediately and then every interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check Runs the probe once and updates the status
+func (c *Checker) Check(ctx context.Context) {
+	probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	err := c.probe(probeCtx)
+	cancel()
+
+	status := Status{Ready: err == nil, CheckedAt: c.now().UTC()}
+	if err != nil {
+		status.Reason = "database probe failed: " + err.Error()
+	}
+
+	c.mu.Lock()
+	if c.shuttingDown {
+		c.mu.Unlock()
+		return
+	}
+	changed := c.status.Ready != status.Ready
+	c.status = status
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	if changed {
+		_ = c.log.Log("msg", "readiness changed", "ready", status.Ready, "reason", status.Reason)
+		notify(listeners, status.Ready)
+	}
+}
+
+// Shutdown Marks the service as not ready for the rest of its life
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	if c.shuttingDown {
+		c.mu.Unlock()
+		return
+	}
+	c.shuttingDown = true
+	c.status = Status{Reason: reasonShuttingDown, CheckedAt: c.now().UTC()}
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	notify(listeners, false)
+}
+
+// Status Returns the current readiness
+func (c *Checker) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func notify(listeners []func(bool), ready bool) {
+	for _, fn := range listeners {
+		fn(ready)
+	}
+}
+
+// LivenessHandler Answers 200 as long as the process serves HTTP requests
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadinessHandler Answers 200 when c is ready and 503 with the reason otherwise
+func ReadinessHandler(c *Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		status := c.Status()
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, status)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// GRPCStatusUpdater Returns a listener reflecting readiness in a grpc.health.v1 server,
+// for the overall server status ("") and for every given service
+func GRPCStatusUpdater(srv *grpchealth.Server, services ...string) func(ready bool) {
+	return func(ready bool) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if ready {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		srv.SetServingStatus("", status)
+		for _, service := range services {
+			srv.SetServingStatus(service, status)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// probeResults returns a probe answering with errs in turn, repeating the last one
+func probeResults(errs ...error) Probe {
+	i := 0
+	return func(ctx context.Context) error {
+		err := errs[i]
+		if i < len(errs)-1 {
+			i++
+		}
+		return err
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	c := NewChecker(probeResults(nil, fmt.Errorf("no hosts available"), nil), log.NewNopLogger(), 0, 0)
+	var changes []bool
+	c.OnChange(func(ready bool) { changes = append(changes, ready) })
+
+	status := c.Status()
+	assert.False(t, status.Ready)
+	assert.Equal(t, reasonStarting, status.Reason)
+
+	c.Check(context.Background())
+	assert.True(t, c.Status().Ready)
+
+	c.Check(context.Background())
+	status = c.Status()
+	assert.False(t, status.Ready)
+	assert.Contains(t, status.Reason, "no hosts available")
+	assert.False(t, status.CheckedAt.IsZero())
+
+	c.Check(context.Background())
+	c.Check(context.Background())
+	assert.True(t, c.Status().Ready)
+
+	assert.Equal(t, []bool{true, false, true}, changes)
+}
+
+func TestChecker_ProbeTimeout(t *testing.T) {
+	probe := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c := NewChecker(probe, log.NewNopLogger(), time.Minute, 10*time.Millisecond)
+
+	c.Check(context.Background())
+	status := c.Status()
+	assert.False(t, status.Ready)
+	assert.Contains(t, status.Reason, context.DeadlineExceeded.Error())
+}
+
+func TestChecker_Shutdown(t *testing.T) {
+	c := NewChecker(probeResults(nil), log.NewNopLogger(), 0, 0)
+	var changes []bool
+	c.OnChange(func(ready bool) { changes = append(changes, ready) })
+
+	c.Check(context.Background())
+	c.Shutdown()
+	c.Shutdown()
+	c.Check(context.Background())
+
+	status := c.Status()
+	assert.False(t, status.Ready)
+	assert.Equal(t, reasonShuttingDown, status.Reason)
+	assert.Equal(t, []bool{true, false}, changes)
+}
+
+func TestChecker_Run(t *testing.T) {
+	probed := make(chan struct{}, 10)
+	probe := func(ctx context.Context) error {
+		probed <- struct{}{}
+		return nil
+	}
+	c := NewChecker(probe, log.NewNopLogger(), 5*time.Millisecond, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-probed:
+		case <-time.After(time.Second):
+			t.Fatal("probe was not run")
+		}
+	}
+	assert.True(t, c.Status().Ready)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	c := NewChecker(probeResults(nil, fmt.Errorf("no hosts available")), log.NewNopLogger(), 0, 0)
+	handler := ReadinessHandler(c)
+
+	serve := func() (int, Status) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var status Status
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		return rec.Code, status
+	}
+
+	code, status := serve()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, reasonStarting, status.Reason)
+
+	c.Check(context.Background())
+	code, status = serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Ready)
+
+	c.Check(context.Background())
+	code, status = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, status.Reason, "no hosts available")
+}
+
+func TestGRPCStatusUpdater(t *testing.T) {
+	srv := grpchealth.NewServer()
+	c := NewChecker(probeResults(nil), log.NewNopLogger(), 0, 0)
+	c.OnChange(GRPCStatusUpdater(srv, "chaika.SalesService"))
+
+	servingStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	c.Check(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus("chaika.SalesService"))
+
+	c.Shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus("chaika.SalesService"))
+}
@@ -1,6 +1,16 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidCursor is returned when a paging cursor is forged, expired or used for another query
+var ErrInvalidCursor = errors.New("invalid cursor")
 
 // Operation types in Cart
 const (
@@ -12,6 +22,26 @@ type Trip struct {
 	Carriage []CarriageReport `json:"carriage_report"`
 }
 
+// Sort Orders carriages by ID, the carts of every carriage by operation time then employee,
+// and the items of every cart by product ID, so the same data always reads the same way
+func (t *Trip) Sort() {
+	sort.Slice(t.Carriage, func(i, j int) bool { return t.Carriage[i].CarriageID < t.Carriage[j].CarriageID })
+	for i := range t.Carriage {
+		carts := t.Carriage[i].Carts
+		sort.Slice(carts, func(a, b int) bool {
+			ca, cb := carts[a].CartID, carts[b].CartID
+			if !ca.OperationTime.Equal(cb.OperationTime) {
+				return ca.OperationTime.Before(cb.OperationTime)
+			}
+			return ca.EmployeeID < cb.EmployeeID
+		})
+		for _, cart := range carts {
+			items := cart.Items
+			sort.Slice(items, func(a, b int) bool { return items[a].ProductID < items[b].ProductID })
+		}
+	}
+}
+
 // Item is a domain model that specifies the quantity, id and price of a product in a cart
 type Item struct {
 	ProductID int   `json:"product_id"`
@@ -23,13 +53,48 @@ type Cart struct {
 	CartID        CartID `json:"cart_id"`
 	OperationType int8   `json:"operation_type"`
 	Items         []Item `json:"items"`
+	// RawOperationTime is the terminal-reported operation time, set only when CartID.OperationTime was corrected for clock skew
+	RawOperationTime *time.Time `json:"raw_operation_time,omitempty"`
+}
+
+// SortOrder orders carts by operation time
+type SortOrder string
+
+const (
+	SortDesc SortOrder = "desc" // newest first, the default
+	SortAsc  SortOrder = "asc"
+)
+
+// CartFilter orders the carts of an employee and limits them to the operation time window [From, To),
+// a zero From or To leaves that side open
+type CartFilter struct {
+	Order SortOrder
+	From  time.Time
+	To    time.Time
+}
+
+// CartPage is a page of carts with the cursors of the neighbouring pages, an empty cursor means there is no such page
+type CartPage struct {
+	Carts      []Cart
+	NextCursor string
+	PrevCursor string
 }
 
 type CarriageReport struct {
-	TripID     TripID    `json:"trip_id"`
-	EndTime    time.Time `json:"end_time"`
-	CarriageID int8      `json:"carriage_id" validate:"required,gte=0,lte=127"`
-	Carts      []Cart    `json:"carts"`
+	TripID     TripID     `json:"trip_id"`
+	EndTime    time.Time  `json:"end_time"`
+	CarriageID int8       `json:"carriage_id" validate:"required,gte=0,lte=127"`
+	Carts      []Cart     `json:"carts"`
+	ClockSkew  *ClockSkew `json:"clock_skew,omitempty"`
+}
+
+// ClockSkew records how far the terminal clock was from server time when a carriage report was received
+type ClockSkew struct {
+	DeviceTime time.Time     `json:"device_time"`
+	ServerTime time.Time     `json:"server_time"`
+	Skew       time.Duration `json:"skew"` // server time minus device time
+	Flagged    bool          `json:"flagged"`
+	Corrected  bool          `json:"corrected"`
 }
 
 type TripID struct {
@@ -49,3 +114,193 @@ type EmployeeTrip struct {
 	TripID     TripID    `json:"trip_id"`
 	EndTime    time.Time `json:"end_time"`
 }
+
+// ReportDiff summarizes the rows changed when a carriage report replaces the stored one
+type ReportDiff struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Receipt is the server acknowledgement of a stored carriage report
+type Receipt struct {
+	ReportID    string    `json:"report_id"`
+	TripID      TripID    `json:"trip_id"`
+	CarriageID  int8      `json:"carriage_id"`
+	ContentHash string    `json:"content_hash"`
+	Carts       int       `json:"carts"`
+	Items       int       `json:"items"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// TripSummary aggregates the operations of a trip
+type TripSummary struct {
+	TripID        TripID `json:"trip_id"`
+	Carriages     int    `json:"carriages"`
+	Employees     int    `json:"employees"`
+	Carts         int    `json:"carts"`
+	Sales         int    `json:"sales"`
+	Refunds       int    `json:"refunds"`
+	ItemsSold     int    `json:"items_sold"`
+	ItemsRefunded int    `json:"items_refunded"`
+	SalesTotal    int64  `json:"sales_total"`   // kopecks
+	RefundsTotal  int64  `json:"refunds_total"` // kopecks
+	NetTotal      int64  `json:"net_total"`     // kopecks
+}
+
+// Revenue holds the operation counts and totals of a set of carts
+type Revenue struct {
+	Sales         int   `json:"sales"`
+	Refunds       int   `json:"refunds"`
+	ItemsSold     int   `json:"items_sold"`
+	ItemsRefunded int   `json:"items_refunded"`
+	SalesTotal    int64 `json:"sales_total"`   // kopecks
+	RefundsTotal  int64 `json:"refunds_total"` // kopecks
+	NetTotal      int64 `json:"net_total"`     // kopecks
+}
+
+// Add Adds the counts and totals of other to r
+func (r *Revenue) Add(other Revenue) {
+	r.Sales += other.Sales
+	r.Refunds += other.Refunds
+	r.ItemsSold += other.ItemsSold
+	r.ItemsRefunded += other.ItemsRefunded
+	r.SalesTotal += other.SalesTotal
+	r.RefundsTotal += other.RefundsTotal
+	r.NetTotal = r.SalesTotal - r.RefundsTotal
+}
+
+// TripRevenue is the stored revenue aggregate of a trip
+type TripRevenue struct {
+	TripID    TripID    `json:"trip_id"`
+	Revenue   Revenue   `json:"revenue"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RevenueScope selects a daily revenue aggregate, exactly one of its fields is set
+type RevenueScope struct {
+	RouteID    string
+	EmployeeID string
+	ProductID  *int
+}
+
+// DailyRevenue is the revenue of a route, employee or product on one UTC day
+type DailyRevenue struct {
+	Day     string  `json:"day"` // YYYY-MM-DD
+	Revenue Revenue `json:"revenue"`
+}
+
+// EmployeeStats is the performance of an employee over the trips of a date range
+type EmployeeStats struct {
+	EmployeeID  string         `json:"employee_id"`
+	Rank        int            `json:"rank,omitempty"` // 1 for the highest net total among the employees of a route
+	Trips       int            `json:"trips"`
+	Carts       int            `json:"carts"`
+	Revenue     Revenue        `json:"revenue"`
+	RefundRatio float64        `json:"refund_ratio"`       // refunds total over sales total
+	AverageCart int64          `json:"average_cart_value"` // sales total over sale carts, kopecks
+	TopProducts []ProductStats `json:"top_products"`
+}
+
+// ProductStats is what an employee sold of a product
+type ProductStats struct {
+	ProductID int   `json:"product_id"`
+	Quantity  int   `json:"quantity"`  // sold minus refunded
+	NetTotal  int64 `json:"net_total"` // kopecks
+}
+
+// ProductConsumption is what was consumed of a product on the trips of a route started on one UTC day
+type ProductConsumption struct {
+	Day        string `json:"day"`
+	CarriageID *int8  `json:"carriage_id,omitempty"` // nil when the carriages are not broken down
+	ProductID  int    `json:"product_id"`
+	Rank       int    `json:"rank"` // 1 for the highest net quantity of the day, or of the carriage on the day
+	Sold       int    `json:"sold"`
+	Refunded   int    `json:"refunded"`
+	Quantity   int    `json:"quantity"`  // sold minus refunded
+	NetTotal   int64  `json:"net_total"` // kopecks
+}
+
+// ArchivedTrip is a catalog entry of a trip moved from the keyspace to an archive file
+type ArchivedTrip struct {
+	TripID      TripID    `json:"trip_id"`
+	ArchiveFile string    `json:"archive_file"`
+	Reports     int       `json:"reports"`
+	Operations  int       `json:"operations"`
+	ArchivedAt  time.Time `json:"archived_at"`
+}
+
+// OperationFilter selects the operations of the trips started on the UTC days From..To inclusive,
+// of RouteID or of every route when it is empty; the other empty or nil fields match every operation
+type OperationFilter struct {
+	RouteID       string
+	From          time.Time
+	To            time.Time
+	CarriageID    *int8
+	EmployeeID    string
+	OperationType int8
+	ProductID     *int
+	MinAmount     *int64 // kopecks, inclusive
+	MaxAmount     *int64 // kopecks, inclusive
+}
+
+// Operation is one product line of a cart with the trip and carriage it was sold in
+type Operation struct {
+	TripID        TripID `json:"trip_id"`
+	CarriageID    int8   `json:"carriage_id"`
+	CartID        CartID `json:"cart_id"`
+	OperationType int8   `json:"operation_type"`
+	Item          Item   `json:"item"`
+	Amount        int64  `json:"amount"` // quantity times price, kopecks
+}
+
+// OperationPage is a page of search results, an empty NextCursor means the search is complete
+type OperationPage struct {
+	Operations []Operation
+	NextCursor string
+}
+
+// Anomaly kinds
+const (
+	AnomalyRefundRatio = "refund_ratio" // refunds of an employee in a trip are a large share of their operations
+	AnomalyOutlierCart = "outlier_cart" // cart total far above the median cart total of its trip
+	AnomalyOutsideTrip = "outside_trip" // operation time before the trip started or after it ended
+	AnomalyCorrections = "corrections"  // many items of the carts of an employee corrected on one day
+)
+
+// Anomaly is a suspicious pattern flagged for fraud review
+type Anomaly struct {
+	ID            string    `json:"id"`  // stable across analyzer runs, unique within Day and Kind
+	Day           string    `json:"day"` // UTC day of the trip start, or of the corrections, YYYY-MM-DD
+	Kind          string    `json:"kind"`
+	TripID        TripID    `json:"trip_id"` // zero for corrections
+	EmployeeID    string    `json:"employee_id"`
+	OperationTime time.Time `json:"operation_time"` // of the flagged cart, zero when the flag is not about one cart
+	Value         float64   `json:"value"`
+	Threshold     float64   `json:"threshold"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// AnomalyFilter selects the anomalies of the UTC days From..To inclusive, empty fields match every anomaly
+type AnomalyFilter struct {
+	From       time.Time
+	To         time.Time
+	Kind       string
+	RouteID    string
+	EmployeeID string
+}
+
+// Correction is an item of a stored cart changed or deleted after the report was received
+type Correction struct {
+	TripID      TripID
+	CartID      CartID
+	ProductID   int
+	CorrectedAt time.Time
+}
+
+// InsertResult is the outcome of storing one carriage report of a bulk upload
+type InsertResult struct {
+	Receipt Receipt
+	Err     error
+}
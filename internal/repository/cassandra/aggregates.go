@@ -0,0 +1,426 @@
+package cassandra
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// revenueDayLayout is the format of the day column of the daily revenue tables, days are UTC dates of the operation time
+const revenueDayLayout = "2006-01-02"
+
+const getTripRevenueQuery = `SELECT sales, refunds, items_sold, items_refunded, sales_total, refunds_total, days, employees, products, updated_at
+	FROM trip_revenue
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?`
+
+// Aggregate rows are written with the time the operations were read as their write timestamp,
+// so when two refreshes of a trip race the one that read the newer operations wins
+const insertTripRevenueQuery = `
+	INSERT INTO trip_revenue (
+	    route_id,
+	    year,
+	    start_time,
+	    sales,
+	    refunds,
+	    items_sold,
+	    items_refunded,
+	    sales_total,
+	    refunds_total,
+	    days,
+	    employees,
+	    products,
+	    updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	USING TIMESTAMP ?`
+
+const insertRouteDailyRevenueQuery = `
+	INSERT INTO route_daily_revenue (
+	    route_id,
+	    day,
+	    start_time,
+	    sales,
+	    refunds,
+	    items_sold,
+	    items_refunded,
+	    sales_total,
+	    refunds_total)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	USING TIMESTAMP ?`
+
+const insertEmployeeDailyRevenueQuery = `
+	INSERT INTO employee_daily_revenue (
+	    employee_id,
+	    day,
+	    route_id,
+	    start_time,
+	    sales,
+	    refunds,
+	    items_sold,
+	    items_refunded,
+	    sales_total,
+	    refunds_total)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	USING TIMESTAMP ?`
+
+const insertProductDailyRevenueQuery = `
+	INSERT INTO product_daily_revenue (
+	    product_id,
+	    day,
+	    route_id,
+	    start_time,
+	    sales,
+	    refunds,
+	    items_sold,
+	    items_refunded,
+	    sales_total,
+	    refunds_total)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	USING TIMESTAMP ?`
+
+const deleteTripRevenueQuery = `DELETE FROM trip_revenue USING TIMESTAMP ?
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?`
+
+const deleteRouteDailyRevenueQuery = `DELETE FROM route_daily_revenue USING TIMESTAMP ?
+	WHERE route_id = ?
+	  AND day = ?
+	  AND start_time = ?`
+
+const deleteEmployeeDailyRevenueQuery = `DELETE FROM employee_daily_revenue USING TIMESTAMP ?
+	WHERE employee_id = ?
+	  AND day = ?
+	  AND route_id = ?
+	  AND start_time = ?`
+
+const deleteProductDailyRevenueQuery = `DELETE FROM product_daily_revenue USING TIMESTAMP ?
+	WHERE product_id = ?
+	  AND day = ?
+	  AND route_id = ?
+	  AND start_time = ?`
+
+const getRouteDailyRevenueQuery = `SELECT sales, refunds, items_sold, items_refunded, sales_total, refunds_total
+	FROM route_daily_revenue
+	WHERE route_id = ?
+	  AND day = ?`
+
+const getEmployeeDailyRevenueQuery = `SELECT sales, refunds, items_sold, items_refunded, sales_total, refunds_total
+	FROM employee_daily_revenue
+	WHERE employee_id = ?
+	  AND day = ?`
+
+const getProductDailyRevenueQuery = `SELECT sales, refunds, items_sold, items_refunded, sales_total, refunds_total
+	FROM product_daily_revenue
+	WHERE product_id = ?
+	  AND day = ?`
+
+type employeeDay struct {
+	employeeID string
+	day        string
+}
+
+type productDay struct {
+	productID int
+	day       string
+}
+
+// tripAggregates is the revenue of one trip split by the keys of the aggregate tables
+type tripAggregates struct {
+	total        models.Revenue
+	routeDays    map[string]models.Revenue
+	employeeDays map[employeeDay]models.Revenue
+	productDays  map[productDay]models.Revenue
+}
+
+// storedTripRevenue is the trip_revenue row of a trip, the sets list what the trip contributed to the daily tables
+type storedTripRevenue struct {
+	revenue   models.Revenue
+	days      []string
+	employees []string
+	products  []int
+	updatedAt time.Time
+}
+
+// RefreshTripAggregates Recomputes the revenue aggregates of a trip from its operations.
+// Daily rows the trip no longer contributes to are deleted, the trip_revenue row is written last
+// so a failed refresh still knows which rows to clean up next time
+func (r *SalesRepository) RefreshTripAggregates(ctx context.Context, tripID *models.TripID) error {
+	readAt := r.now()
+
+	stored, _, err := r.getStoredTripRevenue(ctx, tripID)
+	if err != nil {
+		return err
+	}
+	trip, err := r.GetTrip(ctx, tripID)
+	if err != nil {
+		return err
+	}
+
+	statements := tripAggregateStatements(tripID, aggregateTrip(trip), stored, readAt)
+	for i, chunk := range chunkStatements(statements, r.maxBatchBytes) {
+		batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		addStatements(batch, chunk)
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to write trip aggregates chunk %d: %v", i+1, err))
+			return fmt.Errorf("failed to write trip aggregates: %w", err)
+		}
+	}
+	return nil
+}
+
+// RebuildAggregates Recomputes the revenue aggregates of every trip in operations, returns the number of trips rebuilt
+func (r *SalesRepository) RebuildAggregates(ctx context.Context) (int, error) {
+	iter := r.session.Query(getTripKeysQuery).WithContext(ctx).Iter()
+
+	var count int
+	var tripID models.TripID
+	for iter.Scan(&tripID.RouteID, &tripID.Year, &tripID.StartTime) {
+		if err := r.RefreshTripAggregates(ctx, &tripID); err != nil {
+			_ = iter.Close()
+			_ = r.log.Log("error", fmt.Sprintf("Failed to rebuild aggregates of trip %s %v: %v", tripID.RouteID, tripID.StartTime, err))
+			return count, err
+		}
+		count++
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to scan trips for aggregate rebuild: %v", err))
+		return count, err
+	}
+	return count, nil
+}
+
+// GetTripRevenue Gets the revenue aggregate of a trip, returns models.ErrNotFound if it has none
+func (r *SalesRepository) GetTripRevenue(ctx context.Context, tripID *models.TripID) (models.TripRevenue, error) {
+	stored, found, err := r.getStoredTripRevenue(ctx, tripID)
+	if err != nil {
+		return models.TripRevenue{}, err
+	}
+	if !found {
+		return models.TripRevenue{}, fmt.Errorf("revenue of trip %s %v: %w", tripID.RouteID, tripID.StartTime, models.ErrNotFound)
+	}
+	return models.TripRevenue{TripID: *tripID, Revenue: stored.revenue, UpdatedAt: stored.updatedAt}, nil
+}
+
+// GetDailyRevenue Gets the revenue of a route, employee or product for every UTC day in [from, to] that has operations
+func (r *SalesRepository) GetDailyRevenue(ctx context.Context, scope models.RevenueScope, from, to time.Time) ([]models.DailyRevenue, error) {
+	stmt, key, err := dailyRevenueQuery(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []models.DailyRevenue
+	last := to.UTC().Format(revenueDayLayout)
+	for d := from.UTC(); d.Format(revenueDayLayout) <= last; d = d.AddDate(0, 0, 1) {
+		day := d.Format(revenueDayLayout)
+		iter := r.session.Query(stmt, key, day).WithContext(ctx).Iter()
+
+		var total models.Revenue
+		var found bool
+		var row models.Revenue
+		for iter.Scan(&row.Sales, &row.Refunds, &row.ItemsSold, &row.ItemsRefunded, &row.SalesTotal, &row.RefundsTotal) {
+			total.Add(row)
+			found = true
+		}
+		if err := iter.Close(); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to get daily revenue for %s: %v", day, err))
+			return nil, err
+		}
+		if found {
+			days = append(days, models.DailyRevenue{Day: day, Revenue: total})
+		}
+	}
+	return days, nil
+}
+
+func dailyRevenueQuery(scope models.RevenueScope) (string, interface{}, error) {
+	switch {
+	case scope.RouteID != "":
+		return getRouteDailyRevenueQuery, scope.RouteID, nil
+	case scope.EmployeeID != "":
+		return getEmployeeDailyRevenueQuery, scope.EmployeeID, nil
+	case scope.ProductID != nil:
+		return getProductDailyRevenueQuery, *scope.ProductID, nil
+	}
+	return "", nil, errors.New("revenue scope needs a route, employee or product")
+}
+
+func (r *SalesRepository) getStoredTripRevenue(ctx context.Context, tripID *models.TripID) (storedTripRevenue, bool, error) {
+	iter := r.session.Query(getTripRevenueQuery,
+		tripID.RouteID,
+		tripID.Year,
+		tripID.StartTime).WithContext(ctx).Iter()
+
+	var stored storedTripRevenue
+	found := iter.Scan(
+		&stored.revenue.Sales,
+		&stored.revenue.Refunds,
+		&stored.revenue.ItemsSold,
+		&stored.revenue.ItemsRefunded,
+		&stored.revenue.SalesTotal,
+		&stored.revenue.RefundsTotal,
+		&stored.days,
+		&stored.employees,
+		&stored.products,
+		&stored.updatedAt,
+	)
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get trip revenue: %v", err))
+		return storedTripRevenue{}, false, err
+	}
+	stored.revenue.NetTotal = stored.revenue.SalesTotal - stored.revenue.RefundsTotal
+	return stored, found, nil
+}
+
+// aggregateTrip Splits the carts of a trip by day, employee and product, item price is per unit
+func aggregateTrip(trip models.Trip) tripAggregates {
+	agg := tripAggregates{
+		routeDays:    make(map[string]models.Revenue),
+		employeeDays: make(map[employeeDay]models.Revenue),
+		productDays:  make(map[productDay]models.Revenue),
+	}
+
+	for _, report := range trip.Carriage {
+		for _, cart := range report.Carts {
+			day := cart.CartID.OperationTime.UTC().Format(revenueDayLayout)
+
+			var cartRevenue models.Revenue
+			for _, item := range cart.Items {
+				itemRevenue := operationRevenue(cart.OperationType, int(item.Quantity), int64(item.Quantity)*item.Price)
+				key := productDay{productID: item.ProductID, day: day}
+				rev := agg.productDays[key]
+				rev.Add(itemRevenue)
+				agg.productDays[key] = rev
+				cartRevenue.Add(itemRevenue)
+			}
+			// A cart counts as one sale or refund, not one per item
+			switch cart.OperationType {
+			case models.OperationTypeSale:
+				cartRevenue.Sales = 1
+			case models.OperationTypeRefund:
+				cartRevenue.Refunds = 1
+			}
+
+			agg.total.Add(cartRevenue)
+			rev := agg.routeDays[day]
+			rev.Add(cartRevenue)
+			agg.routeDays[day] = rev
+			key := employeeDay{employeeID: cart.CartID.EmployeeID, day: day}
+			rev = agg.employeeDays[key]
+			rev.Add(cartRevenue)
+			agg.employeeDays[key] = rev
+		}
+	}
+	return agg
+}
+
+// operationRevenue Returns the revenue of one operation, operations that are neither sales nor refunds count as nothing
+func operationRevenue(operationType int8, quantity int, total int64) models.Revenue {
+	switch operationType {
+	case models.OperationTypeSale:
+		return models.Revenue{Sales: 1, ItemsSold: quantity, SalesTotal: total, NetTotal: total}
+	case models.OperationTypeRefund:
+		return models.Revenue{Refunds: 1, ItemsRefunded: quantity, RefundsTotal: total, NetTotal: -total}
+	}
+	return models.Revenue{}
+}
+
+// tripAggregateStatements Builds the writes replacing the stored aggregates of a trip with agg
+func tripAggregateStatements(tripID *models.TripID, agg tripAggregates, stored storedTripRevenue, readAt time.Time) []batchStatement {
+	ts := readAt.UnixMicro()
+	var statements []batchStatement
+
+	days := make([]string, 0, len(agg.routeDays))
+	for day, rev := range agg.routeDays {
+		days = append(days, day)
+		statements = append(statements, batchStatement{
+			stmt:   insertRouteDailyRevenueQuery,
+			values: append([]interface{}{tripID.RouteID, day, tripID.StartTime}, revenueValues(rev, ts)...),
+		})
+	}
+	employees := make(map[string]struct{})
+	for key, rev := range agg.employeeDays {
+		employees[key.employeeID] = struct{}{}
+		statements = append(statements, batchStatement{
+			stmt:   insertEmployeeDailyRevenueQuery,
+			values: append([]interface{}{key.employeeID, key.day, tripID.RouteID, tripID.StartTime}, revenueValues(rev, ts)...),
+		})
+	}
+	products := make(map[int]struct{})
+	for key, rev := range agg.productDays {
+		products[key.productID] = struct{}{}
+		statements = append(statements, batchStatement{
+			stmt:   insertProductDailyRevenueQuery,
+			values: append([]interface{}{key.productID, key.day, tripID.RouteID, tripID.StartTime}, revenueValues(rev, ts)...),
+		})
+	}
+
+	// Rows of the previous refresh the trip no longer contributes to
+	for _, day := range stored.days {
+		if _, ok := agg.routeDays[day]; !ok {
+			statements = append(statements, batchStatement{
+				stmt:   deleteRouteDailyRevenueQuery,
+				values: []interface{}{ts, tripID.RouteID, day, tripID.StartTime},
+			})
+		}
+		for _, employeeID := range stored.employees {
+			if _, ok := agg.employeeDays[employeeDay{employeeID: employeeID, day: day}]; !ok {
+				statements = append(statements, batchStatement{
+					stmt:   deleteEmployeeDailyRevenueQuery,
+					values: []interface{}{ts, employeeID, day, tripID.RouteID, tripID.StartTime},
+				})
+			}
+		}
+		for _, productID := range stored.products {
+			if _, ok := agg.productDays[productDay{productID: productID, day: day}]; !ok {
+				statements = append(statements, batchStatement{
+					stmt:   deleteProductDailyRevenueQuery,
+					values: []interface{}{ts, productID, day, tripID.RouteID, tripID.StartTime},
+				})
+			}
+		}
+	}
+
+	if len(days) == 0 {
+		// Every operation of the trip is gone
+		return append(statements, batchStatement{
+			stmt:   deleteTripRevenueQuery,
+			values: []interface{}{ts, tripID.RouteID, tripID.Year, tripID.StartTime},
+		})
+	}
+	return append(statements, batchStatement{
+		stmt: insertTripRevenueQuery,
+		values: []interface{}{
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			agg.total.Sales,
+			agg.total.Refunds,
+			agg.total.ItemsSold,
+			agg.total.ItemsRefunded,
+			agg.total.SalesTotal,
+			agg.total.RefundsTotal,
+			days,
+			setKeys(employees),
+			setKeys(products),
+			readAt,
+			ts,
+		},
+	})
+}
+
+func revenueValues(rev models.Revenue, ts int64) []interface{} {
+	return []interface{}{rev.Sales, rev.Refunds, rev.ItemsSold, rev.ItemsRefunded, rev.SalesTotal, rev.RefundsTotal, ts}
+}
+
+func setKeys[K comparable](set map[K]struct{}) []K {
+	keys := make([]K, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	return keys
+}
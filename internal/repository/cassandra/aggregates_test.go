@@ -0,0 +1,271 @@
+package cassandra
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateTrip(t *testing.T) {
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	beforeMidnight := time.Date(2024, 3, 1, 23, 50, 0, 0, time.UTC)
+	afterMidnight := time.Date(2024, 3, 2, 0, 10, 0, 0, time.UTC)
+	trip := models.Trip{Carriage: []models.CarriageReport{{
+		TripID:     models.TripID{RouteID: "r1", Year: "2024", StartTime: start},
+		CarriageID: 1,
+		Carts: []models.Cart{
+			{
+				CartID:        models.CartID{EmployeeID: "empA", OperationTime: beforeMidnight},
+				OperationType: models.OperationTypeSale,
+				Items:         []models.Item{{ProductID: 1, Quantity: 2, Price: 100}, {ProductID: 2, Quantity: 1, Price: 50}},
+			},
+			{
+				CartID:        models.CartID{EmployeeID: "empB", OperationTime: afterMidnight},
+				OperationType: models.OperationTypeRefund,
+				Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}},
+			},
+		},
+	}}}
+
+	agg := aggregateTrip(trip)
+
+	assert.Equal(t, models.Revenue{Sales: 1, Refunds: 1, ItemsSold: 3, ItemsRefunded: 1, SalesTotal: 250, RefundsTotal: 100, NetTotal: 150}, agg.total)
+	// Operations are bucketed by their own UTC day, not by the trip start
+	assert.Equal(t, map[string]models.Revenue{
+		"2024-03-01": {Sales: 1, ItemsSold: 3, SalesTotal: 250, NetTotal: 250},
+		"2024-03-02": {Refunds: 1, ItemsRefunded: 1, RefundsTotal: 100, NetTotal: -100},
+	}, agg.routeDays)
+	assert.Equal(t, models.Revenue{Refunds: 1, ItemsRefunded: 1, RefundsTotal: 100, NetTotal: -100},
+		agg.employeeDays[employeeDay{employeeID: "empB", day: "2024-03-02"}])
+	assert.Len(t, agg.employeeDays, 2)
+	assert.Equal(t, models.Revenue{Sales: 1, ItemsSold: 2, SalesTotal: 200, NetTotal: 200},
+		agg.productDays[productDay{productID: 1, day: "2024-03-01"}])
+	assert.Len(t, agg.productDays, 3)
+}
+
+// aggregateSession sets up a session returning the stored trip_revenue row and the trip operations,
+// and a batch recording every statement written
+func aggregateSession(stored [][]interface{}, operations []tripOpRow) (*MockSession, *FakeBatch) {
+	mockSession := new(MockSession)
+
+	revenueQuery := new(FakeQuery)
+	revenueQuery.On("WithContext", mock.Anything).Return(revenueQuery)
+	revenueQuery.On("Iter").Return(&rowsIter{rows: stored})
+	mockSession.On("Query", getTripRevenueQuery, mock.Anything).Return(revenueQuery)
+
+	tripQuery := new(FakeQuery)
+	tripQuery.On("WithContext", mock.Anything).Return(tripQuery)
+	tripQuery.On("Iter").Return(&fakeTripIter{rows: operations})
+	mockSession.On("Query", getTripQuery, mock.Anything).Return(tripQuery)
+	expectReportCommits(mockSession)
+
+	batch := new(FakeBatch)
+	batch.On("WithContext", mock.Anything).Return(batch)
+	batch.On("Query", mock.Anything, mock.Anything).Return()
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(batch)
+	mockSession.On("ExecuteBatch", batch).Return(nil)
+	return mockSession, batch
+}
+
+// writtenStatements returns the statements added to batch in order
+func writtenStatements(batch *FakeBatch) []batchStatement {
+	var statements []batchStatement
+	for _, call := range batch.Calls {
+		if call.Method == "Query" {
+			statements = append(statements, batchStatement{stmt: call.Arguments.String(0), values: call.Arguments.Get(1).([]interface{})})
+		}
+	}
+	return statements
+}
+
+func TestRefreshTripAggregates_RemovesStaleRows(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	readAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: start}
+
+	// empC and product 9 were corrected away since the last refresh
+	stored := [][]interface{}{{2, 0, 3, 0, int64(400), int64(0),
+		[]string{"2024-03-01"}, []string{"empA", "empC"}, []int{1, 9}, start}}
+	operations := []tripOpRow{
+		{"r1", start, "empA", start.Add(time.Hour), 1, 1, start.Add(5 * time.Hour), models.OperationTypeSale, 100, 2},
+	}
+	mockSession, batch := aggregateSession(stored, operations)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+	repo.now = func() time.Time { return readAt }
+
+	require.NoError(t, repo.RefreshTripAggregates(context.Background(), tripID))
+
+	statements := writtenStatements(batch)
+	ts := readAt.UnixMicro()
+	assert.Contains(t, statements, batchStatement{
+		stmt:   insertRouteDailyRevenueQuery,
+		values: []interface{}{"r1", "2024-03-01", start, 1, 0, 2, 0, int64(200), int64(0), ts},
+	})
+	assert.Contains(t, statements, batchStatement{
+		stmt:   deleteEmployeeDailyRevenueQuery,
+		values: []interface{}{ts, "empC", "2024-03-01", "r1", start},
+	})
+	assert.Contains(t, statements, batchStatement{
+		stmt:   deleteProductDailyRevenueQuery,
+		values: []interface{}{ts, 9, "2024-03-01", "r1", start},
+	})
+	for _, s := range statements {
+		assert.NotEqual(t, deleteRouteDailyRevenueQuery, s.stmt, "the route still has operations that day")
+	}
+
+	// trip_revenue is written last so a failed refresh keeps the previous sets
+	last := statements[len(statements)-1]
+	assert.Equal(t, insertTripRevenueQuery, last.stmt)
+	assert.Equal(t, []interface{}{"r1", "2024", start, 1, 0, 2, 0, int64(200), int64(0),
+		[]string{"2024-03-01"}, []string{"empA"}, []int{1}, readAt, ts}, last.values)
+}
+
+func TestRefreshTripAggregates_EmptyTrip(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: start}
+	stored := [][]interface{}{{1, 0, 1, 0, int64(100), int64(0),
+		[]string{"2024-03-01"}, []string{"empA"}, []int{1}, start}}
+	mockSession, batch := aggregateSession(stored, nil)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	require.NoError(t, repo.RefreshTripAggregates(context.Background(), tripID))
+
+	var stmts []string
+	for _, s := range writtenStatements(batch) {
+		stmts = append(stmts, s.stmt)
+	}
+	assert.Equal(t, []string{
+		deleteRouteDailyRevenueQuery,
+		deleteEmployeeDailyRevenueQuery,
+		deleteProductDailyRevenueQuery,
+		deleteTripRevenueQuery,
+	}, stmts)
+}
+
+func TestRefreshTripAggregates_WriteError(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	for _, stmt := range []string{getTripRevenueQuery, getTripQuery} {
+		q := new(FakeQuery)
+		q.On("WithContext", mock.Anything).Return(q)
+		q.On("Iter").Return(&rowsIter{})
+		mockSession.On("Query", stmt, mock.Anything).Return(q)
+	}
+	batch := new(FakeBatch)
+	batch.On("WithContext", mock.Anything).Return(batch)
+	batch.On("Query", mock.Anything, mock.Anything).Return()
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(batch)
+	mockSession.On("ExecuteBatch", batch).Return(fmt.Errorf("write timeout"))
+
+	err := repo.RefreshTripAggregates(context.Background(), &models.TripID{RouteID: "r1", Year: "2024", StartTime: start})
+	assert.ErrorContains(t, err, "write timeout")
+}
+
+func TestGetDailyRevenue(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+	productID := 7
+
+	// Two trips sold the product on the first day, nothing on the second
+	day1 := new(FakeQuery)
+	day1.On("WithContext", mock.Anything).Return(day1)
+	day1.On("Iter").Return(&rowsIter{rows: [][]interface{}{
+		{1, 0, 2, 0, int64(200), int64(0)},
+		{1, 1, 3, 1, int64(300), int64(100)},
+	}})
+	mockSession.On("Query", getProductDailyRevenueQuery, []interface{}{7, "2024-03-01"}).Return(day1).Once()
+
+	day2 := new(FakeQuery)
+	day2.On("WithContext", mock.Anything).Return(day2)
+	day2.On("Iter").Return(&rowsIter{})
+	mockSession.On("Query", getProductDailyRevenueQuery, []interface{}{7, "2024-03-02"}).Return(day2).Once()
+
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	days, err := repo.GetDailyRevenue(context.Background(), models.RevenueScope{ProductID: &productID}, from, from.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.DailyRevenue{{
+		Day:     "2024-03-01",
+		Revenue: models.Revenue{Sales: 2, Refunds: 1, ItemsSold: 5, ItemsRefunded: 1, SalesTotal: 500, RefundsTotal: 100, NetTotal: 400},
+	}}, days)
+	mockSession.AssertExpectations(t)
+
+	_, err = repo.GetDailyRevenue(context.Background(), models.RevenueScope{}, from, from)
+	assert.Error(t, err)
+}
+
+func TestGetTripRevenue_NotFound(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	q := new(FakeQuery)
+	q.On("WithContext", mock.Anything).Return(q)
+	q.On("Iter").Return(&rowsIter{})
+	mockSession.On("Query", getTripRevenueQuery, mock.Anything).Return(q)
+
+	_, err := repo.GetTripRevenue(context.Background(), &models.TripID{RouteID: "r1", Year: "2024"})
+	assert.ErrorIs(t, err, models.ErrNotFound)
+}
+
+func TestRefreshTripAggregates_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	tripID := models.TripID{RouteID: "r1", Year: "2024", StartTime: start}
+	late := models.CartID{EmployeeID: "empB", OperationTime: time.Date(2024, 3, 2, 0, 10, 0, 0, time.UTC)}
+	require.NoError(t, repo.InsertData(ctx, &models.CarriageReport{
+		TripID:     tripID,
+		EndTime:    start.Add(6 * time.Hour),
+		CarriageID: 1,
+		Carts: []models.Cart{
+			{
+				CartID:        models.CartID{EmployeeID: "empA", OperationTime: time.Date(2024, 3, 1, 23, 50, 0, 0, time.UTC)},
+				OperationType: models.OperationTypeSale,
+				Items:         []models.Item{{ProductID: 1, Quantity: 2, Price: 100}},
+			},
+			{CartID: late, OperationType: models.OperationTypeSale, Items: []models.Item{{ProductID: 2, Quantity: 1, Price: 50}}},
+		},
+	}, nil))
+	require.NoError(t, repo.RefreshTripAggregates(ctx, &tripID))
+
+	revenue, err := repo.GetTripRevenue(ctx, &tripID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Revenue{Sales: 2, ItemsSold: 3, SalesTotal: 250, NetTotal: 250}, revenue.Revenue)
+	days, err := repo.GetDailyRevenue(ctx, models.RevenueScope{RouteID: "r1"}, start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Len(t, days, 2)
+
+	// Removing the only operation of the second day must remove its daily rows
+	productID := 2
+	require.NoError(t, repo.DeleteItemFromCart(ctx, &tripID, &late, &productID))
+	require.NoError(t, repo.RefreshTripAggregates(ctx, &tripID))
+
+	days, err = repo.GetDailyRevenue(ctx, models.RevenueScope{RouteID: "r1"}, start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.DailyRevenue{{Day: "2024-03-01", Revenue: models.Revenue{Sales: 1, ItemsSold: 2, SalesTotal: 200, NetTotal: 200}}}, days)
+	assert.Len(t, session.rows(t, "employee_daily_revenue"), 1)
+	assert.Len(t, session.rows(t, "product_daily_revenue"), 1)
+	stored := session.rows(t, "trip_revenue")
+	require.Len(t, stored, 1)
+	assert.Equal(t, []interface{}{"empA"}, stored[0]["employees"])
+
+	// Once the trip is empty its aggregate is gone
+	productID = 1
+	first := models.CartID{EmployeeID: "empA", OperationTime: time.Date(2024, 3, 1, 23, 50, 0, 0, time.UTC)}
+	require.NoError(t, repo.DeleteItemFromCart(ctx, &tripID, &first, &productID))
+	require.NoError(t, repo.RefreshTripAggregates(ctx, &tripID))
+	_, err = repo.GetTripRevenue(ctx, &tripID)
+	assert.ErrorIs(t, err, models.ErrNotFound)
+	assert.Empty(t, session.rows(t, "route_daily_revenue"))
+}
@@ -0,0 +1,171 @@
+package cassandra
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// dayBuckets is the number of partitions the corrections and anomalies of one day are spread over
+const dayBuckets = 8
+
+const insertCorrectionQuery = `
+	INSERT INTO corrections (
+	    day,
+	    bucket,
+	    employee_id,
+	    route_id,
+	    start_time,
+	    operation_time,
+	    product_id,
+	    year,
+	    corrected_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const getCorrectionsQuery = `SELECT employee_id, route_id, start_time, operation_time, product_id, year, corrected_at
+	FROM corrections
+	WHERE day = ?
+	  AND bucket = ?`
+
+const insertAnomalyQuery = `
+	INSERT INTO anomalies (
+	    day,
+	    bucket,
+	    kind,
+	    anomaly_id,
+	    route_id,
+	    year,
+	    start_time,
+	    employee_id,
+	    operation_time,
+	    value,
+	    threshold,
+	    detected_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const getAnomaliesQuery = `SELECT kind, anomaly_id, route_id, year, start_time, employee_id, operation_time, value, threshold, detected_at
+	FROM anomalies
+	WHERE day = ?
+	  AND bucket = ?`
+
+// dayBucket Returns the bucket of a row of a day; corrections are bucketed by employee, so the corrections
+// of an employee on a day stay together, and anomalies by ID, so a flag found again replaces its row
+func dayBucket(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % dayBuckets)
+}
+
+// correctionStatement builds the insert recording a correction
+func correctionStatement(c *models.Correction) batchStatement {
+	return batchStatement{
+		stmt: insertCorrectionQuery,
+		values: []interface{}{
+			c.CorrectedAt.UTC().Format(revenueDayLayout),
+			dayBucket(c.CartID.EmployeeID),
+			c.CartID.EmployeeID,
+			c.TripID.RouteID,
+			c.TripID.StartTime,
+			c.CartID.OperationTime,
+			c.ProductID,
+			c.TripID.Year,
+			c.CorrectedAt,
+		},
+	}
+}
+
+// GetCorrections Gets the corrections made on the UTC day of day, ordered by employee. It reads the
+// dayBuckets partitions of the day one after the other
+func (r *SalesRepository) GetCorrections(ctx context.Context, day time.Time) ([]models.Correction, error) {
+	dayKey := day.UTC().Format(revenueDayLayout)
+
+	var res []models.Correction
+	for bucket := 0; bucket < dayBuckets; bucket++ {
+		iter := r.session.Query(getCorrectionsQuery, dayKey, bucket).WithContext(ctx).Iter()
+		var c models.Correction
+		for iter.Scan(
+			&c.CartID.EmployeeID,
+			&c.TripID.RouteID,
+			&c.TripID.StartTime,
+			&c.CartID.OperationTime,
+			&c.ProductID,
+			&c.TripID.Year,
+			&c.CorrectedAt,
+		) {
+			res = append(res, c)
+		}
+		if err := iter.Close(); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to get corrections of %s: %v", dayKey, err))
+			return nil, err
+		}
+	}
+	// Every employee is in one bucket, in clustering order there
+	sort.SliceStable(res, func(i, j int) bool { return res[i].CartID.EmployeeID < res[j].CartID.EmployeeID })
+	return res, nil
+}
+
+// InsertAnomaly Stores an anomaly, replacing the one with the same day, kind and ID
+func (r *SalesRepository) InsertAnomaly(ctx context.Context, anomaly *models.Anomaly) error {
+	err := r.session.Query(insertAnomalyQuery,
+		anomaly.Day,
+		dayBucket(anomaly.ID),
+		anomaly.Kind,
+		anomaly.ID,
+		anomaly.TripID.RouteID,
+		anomaly.TripID.Year,
+		anomaly.TripID.StartTime,
+		anomaly.EmployeeID,
+		anomaly.OperationTime,
+		anomaly.Value,
+		anomaly.Threshold,
+		anomaly.DetectedAt).WithContext(ctx).Exec()
+	if err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to insert anomaly %s %s: %v", anomaly.Kind, anomaly.ID, err))
+		return err
+	}
+	return nil
+}
+
+// GetAnomalies Gets the anomalies of every UTC day in [from, to], ordered by day, kind and ID
+func (r *SalesRepository) GetAnomalies(ctx context.Context, from, to time.Time) ([]models.Anomaly, error) {
+	var res []models.Anomaly
+	last := to.UTC().Format(revenueDayLayout)
+	for d := from.UTC(); d.Format(revenueDayLayout) <= last; d = d.AddDate(0, 0, 1) {
+		day := d.Format(revenueDayLayout)
+		first := len(res)
+		for bucket := 0; bucket < dayBuckets; bucket++ {
+			iter := r.session.Query(getAnomaliesQuery, day, bucket).WithContext(ctx).Iter()
+
+			a := models.Anomaly{Day: day}
+			for iter.Scan(
+				&a.Kind,
+				&a.ID,
+				&a.TripID.RouteID,
+				&a.TripID.Year,
+				&a.TripID.StartTime,
+				&a.EmployeeID,
+				&a.OperationTime,
+				&a.Value,
+				&a.Threshold,
+				&a.DetectedAt,
+			) {
+				res = append(res, a)
+			}
+			if err := iter.Close(); err != nil {
+				_ = r.log.Log("error", fmt.Sprintf("Failed to get anomalies of %s: %v", day, err))
+				return nil, err
+			}
+		}
+		dayAnomalies := res[first:]
+		sort.Slice(dayAnomalies, func(i, j int) bool {
+			if dayAnomalies[i].Kind != dayAnomalies[j].Kind {
+				return dayAnomalies[i].Kind < dayAnomalies[j].Kind
+			}
+			return dayAnomalies[i].ID < dayAnomalies[j].ID
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,142 @@
+package cassandra
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrections_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 7, Quantity: 2, Price: 100}))
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	cartID := report.Carts[0].CartID
+	productID := 7
+	correctedAt := time.Date(2024, 3, 2, 23, 30, 0, 0, time.UTC)
+	correct := func(at time.Time, quantity int16) {
+		repo.now = func() time.Time { return at }
+		require.NoError(t, repo.UpdateItemQuantity(ctx, &report.TripID, &cartID, &productID, &quantity))
+	}
+
+	correct(correctedAt, 3)
+	// Correcting the same item again on the same day replaces the row
+	correct(correctedAt.Add(10*time.Minute), 4)
+	// Corrections are kept under the UTC day they were made
+	correct(correctedAt.Add(time.Hour), 5)
+
+	correction := models.Correction{TripID: report.TripID, CartID: cartID, ProductID: productID}
+	again := correction
+	again.CorrectedAt = correctedAt.Add(10 * time.Minute)
+	nextDay := correction
+	nextDay.CorrectedAt = correctedAt.Add(time.Hour)
+
+	corrections, err := repo.GetCorrections(ctx, correctedAt)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Correction{again}, corrections)
+	corrections, err = repo.GetCorrections(ctx, correctedAt.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.Correction{nextDay}, corrections)
+}
+
+func TestCorrections_Buckets(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	var carts []models.Cart
+	for i := 0; i < 20; i++ {
+		carts = append(carts, memoryTestCart(fmt.Sprintf("e%02d", i), i, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	}
+	report := memoryTestReport(1, carts...)
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+
+	productID := 1
+	for i := range carts {
+		require.NoError(t, repo.DeleteItemFromCart(ctx, &report.TripID, &carts[i].CartID, &productID))
+	}
+
+	// The corrections of a day are spread over partitions and read back in employee order
+	buckets := make(map[int64]bool)
+	for _, row := range session.rows(t, "corrections") {
+		buckets[row["bucket"].(int64)] = true
+	}
+	assert.Greater(t, len(buckets), 1)
+
+	corrections, err := repo.GetCorrections(ctx, repo.now())
+	require.NoError(t, err)
+	require.Len(t, corrections, len(carts))
+	for i, c := range corrections {
+		assert.Equal(t, fmt.Sprintf("e%02d", i), c.CartID.EmployeeID)
+	}
+}
+
+func TestReplaceCarriageReport_RecordsCorrections(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	require.NoError(t, repo.InsertData(ctx, memoryTestReport(1,
+		memoryTestCart("e1", 0,
+			models.Item{ProductID: 1, Quantity: 2, Price: 100},
+			models.Item{ProductID: 2, Quantity: 1, Price: 50},
+			models.Item{ProductID: 3, Quantity: 1, Price: 70}),
+	), nil))
+
+	// Product 1 is kept, 2 is changed, 3 is removed and 4 is added
+	replacement := memoryTestReport(1, memoryTestCart("e1", 0,
+		models.Item{ProductID: 1, Quantity: 2, Price: 100},
+		models.Item{ProductID: 2, Quantity: 5, Price: 50},
+		models.Item{ProductID: 4, Quantity: 1, Price: 30}))
+	diff, err := repo.ReplaceCarriageReport(ctx, replacement, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReportDiff{Inserted: 1, Updated: 1, Deleted: 1, Unchanged: 1}, diff)
+
+	corrections, err := repo.GetCorrections(ctx, repo.now())
+	require.NoError(t, err)
+	var products []int
+	for _, c := range corrections {
+		assert.Equal(t, replacement.Carts[0].CartID, c.CartID)
+		products = append(products, c.ProductID)
+	}
+	assert.ElementsMatch(t, []int{2, 3}, products)
+}
+
+func TestAnomalies_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	detectedAt := time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC)
+
+	outlier := models.Anomaly{
+		ID:            "r1|1709323200000|e1|1709326800000",
+		Day:           "2024-03-01",
+		Kind:          models.AnomalyOutlierCart,
+		TripID:        models.TripID{RouteID: "r1", Year: "2024", StartTime: start},
+		EmployeeID:    "e1",
+		OperationTime: start.Add(time.Hour),
+		Value:         12.5,
+		Threshold:     10,
+		DetectedAt:    detectedAt,
+	}
+	corrections := models.Anomaly{ID: "e2", Day: "2024-03-02", Kind: models.AnomalyCorrections, EmployeeID: "e2", Value: 11, Threshold: 10, DetectedAt: detectedAt}
+	require.NoError(t, repo.InsertAnomaly(ctx, &corrections))
+	require.NoError(t, repo.InsertAnomaly(ctx, &outlier))
+	// The same day, kind and ID replaces the stored anomaly
+	outlier.Value = 14
+	require.NoError(t, repo.InsertAnomaly(ctx, &outlier))
+
+	anomalies, err := repo.GetAnomalies(ctx, start, start.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, []models.Anomaly{outlier, corrections}, anomalies)
+
+	anomalies, err = repo.GetAnomalies(ctx, start.AddDate(0, 0, 1), start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.Anomaly{corrections}, anomalies)
+}
@@ -3,24 +3,39 @@ package cassandra
 import (
 	"ChaikaReports/internal/models"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/go-kit/log"
 	"github.com/gocql/gocql"
+	"sort"
 	"strconv"
 	"time"
 )
 
+// defaultMaxBatchBytes keeps the estimated size of a single batch well below Cassandra's default
+// batch_size_fail_threshold_in_kb (50 KB)
+const defaultMaxBatchBytes = 32 * 1024
+
 type SalesRepository struct {
 	session CassandraSession
 	log     log.Logger
+
+	// maxBatchBytes is the estimated batch size above which a report is split into several batches
+	maxBatchBytes int
+
+	// now is the clock used for aggregate write timestamps
+	now func() time.Time
 }
 
 func NewSalesRepository(session CassandraSession, logger log.Logger) *SalesRepository {
 	return &SalesRepository{
-		session: session,
-		log:     logger,
+		session:       session,
+		log:           logger,
+		maxBatchBytes: defaultMaxBatchBytes,
+		now:           time.Now,
 	}
 }
 
@@ -36,8 +51,11 @@ const insertOperationQuery = `
 	    operation_time,
 		product_id,
 	    quantity,
-		price)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		price,
+		raw_operation_time,
+		clock_skew_ms,
+		commit_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 const insertEmployeeTripsQuery = `
 	INSERT INTO employee_trips (
@@ -60,36 +78,135 @@ const insertRouteQuery = `
 	    route_id)
 	VALUES (?)`
 
-const getTripQuery = `SELECT route_id, start_time, employee_id, operation_time, product_id, carriage_id, end_time, operation_type, price, quantity
+const insertTripEmployeeQuery = `
+	INSERT INTO trip_employees (
+	    route_id,
+	    year,
+	    start_time,
+	    employee_id)
+	VALUES (?, ?, ?, ?)`
+
+const insertRouteTripQuery = `
+	INSERT INTO route_trips (
+	    route_id,
+	    year,
+	    start_time,
+	    end_time)
+	VALUES (?, ?, ?, ?)`
+
+const insertReportCommitQuery = `
+	INSERT INTO report_commits (
+	    route_id,
+	    year,
+	    start_time,
+	    carriage_id,
+	    commit_id,
+	    operations,
+	    batches,
+	    committed_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+const getReportCommitsQuery = `SELECT commit_id
+	FROM report_commits
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?`
+
+const insertClockSkewReportQuery = `
+	INSERT INTO clock_skew_reports (
+	    route_id,
+	    year,
+	    start_time,
+	    carriage_id,
+	    device_time,
+	    server_time,
+	    skew_ms,
+	    corrected,
+	    flagged)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const getClockSkewReportQuery = `SELECT device_time, server_time, skew_ms, corrected, flagged
+	FROM clock_skew_reports
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?
+	  AND carriage_id = ?`
+
+const insertReceiptQuery = `
+	INSERT INTO report_receipts (
+	    report_id,
+	    route_id,
+	    year,
+	    start_time,
+	    carriage_id,
+	    content_hash,
+	    carts,
+	    items,
+	    received_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const insertTripReceiptQuery = `INSERT INTO trip_receipts (route_id, year, start_time, report_id) VALUES (?, ?, ?, ?)`
+
+const getTripReceiptsQuery = `SELECT report_id FROM trip_receipts WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const deleteReceiptQuery = `DELETE FROM report_receipts WHERE report_id = ?`
+
+const deleteTripReceiptsQuery = `DELETE FROM trip_receipts WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const getReceiptQuery = `SELECT route_id, year, start_time, carriage_id, content_hash, carts, items, received_at
+	FROM report_receipts
+	WHERE report_id = ?`
+
+const getTripQuery = `SELECT route_id, start_time, employee_id, operation_time, product_id, carriage_id, end_time, operation_type, price, quantity, commit_id
 	FROM operations
 	WHERE route_id = ?
 	AND year = ?
 	AND start_time = ?
 `
 
-const getEmployeeCartsInTripQuery = `SELECT operation_time, operation_type, product_id, quantity, price
+// getEmployeeCartsInTripQuery reads the operations of an employee in an inclusive operation time range, newest first
+const getEmployeeCartsInTripQuery = `SELECT operation_time, operation_type, product_id, quantity, price, commit_id
 	FROM operations
 	WHERE route_id = ?
 	  AND year = ?
 	  AND start_time = ?
-	  AND employee_id = ?`
-
-const getEmployeeCartsInTripAfterCursorQuery = `
-SELECT operation_time, operation_type, product_id, quantity, price
-FROM operations
-WHERE route_id = ?
-  AND year = ?
-  AND start_time = ?
-  AND employee_id = ?
-  AND operation_time < ?
-`
+	  AND employee_id = ?
+	  AND operation_time >= ?
+	  AND operation_time <= ?`
+
+// getEmployeeCartsInTripAscQuery is getEmployeeCartsInTripQuery oldest first, reversing the clustering order
+// also reverses the products of a cart
+const getEmployeeCartsInTripAscQuery = `SELECT operation_time, operation_type, product_id, quantity, price, commit_id
+	FROM operations
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?
+	  AND employee_id = ?
+	  AND operation_time >= ?
+	  AND operation_time <= ?
+	ORDER BY employee_id DESC, operation_time ASC`
 
-const getEmployeeIdsByTripQuery = `SELECT employee_id 
+// getOperationEmployeeIDsQuery reads every operation row of a trip, it is only used to backfill trip_employees
+const getOperationEmployeeIDsQuery = `SELECT employee_id 
 	FROM operations 
 	WHERE route_id = ?
 	  AND year = ?
 	  AND start_time = ?`
 
+const getTripEmployeesQuery = `SELECT employee_id
+	FROM trip_employees
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?`
+
+const hasEmployeeOperationsQuery = `SELECT employee_id
+	FROM operations
+	WHERE route_id = ?
+	  AND year = ?
+	  AND start_time = ?
+	  AND employee_id = ?
+	LIMIT 1`
+
 const getEmployeeTripsQuery = `SELECT route_id, start_time, end_time
 	FROM employee_trips
 	WHERE employee_id = ?
@@ -114,60 +231,371 @@ const deleteItemFromCartQuery = `DELETE FROM operations WHERE route_id = ?
       AND product_id = ?
     IF EXISTS`
 
+const deleteOperationQuery = `DELETE FROM operations WHERE route_id = ?
+      AND year = ?
+      AND start_time = ?
+      AND employee_id = ?
+      AND operation_time = ?
+      AND product_id = ?`
+
+const getRoutesQuery = `SELECT route_id FROM routes`
+
+const getRouteTripsQuery = `SELECT start_time FROM route_trips
+    WHERE route_id = ?
+      AND year = ?
+      AND start_time >= ?
+      AND start_time < ?`
+
+// getTripKeysQuery scans every partition of the operations table, it is only used to backfill route_trips
+const getTripKeysQuery = `SELECT DISTINCT route_id, year, start_time FROM operations`
+
+const deleteTripOperationsQuery = `DELETE FROM operations WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const deleteEmployeeTripQuery = `DELETE FROM employee_trips
+    WHERE employee_id = ?
+      AND year = ?
+      AND start_time = ?
+      AND route_id = ?`
+
+const deleteTripEmployeeQuery = `DELETE FROM trip_employees
+    WHERE route_id = ?
+      AND year = ?
+      AND start_time = ?
+      AND employee_id = ?`
+
+const deleteTripEmployeesQuery = `DELETE FROM trip_employees WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const deleteRouteTripQuery = `DELETE FROM route_trips WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const deleteReportCommitsQuery = `DELETE FROM report_commits WHERE route_id = ? AND year = ? AND start_time = ?`
+
+const deleteClockSkewReportsQuery = `DELETE FROM clock_skew_reports WHERE route_id = ? AND year = ? AND start_time = ?`
+
+// deleteUnsynchronizedTripQuery unlike deleteTripFromUnsynchronizedTripsQuery is not conditional, so it can be batched
+const deleteUnsynchronizedTripQuery = `DELETE FROM unsynchronized_trips WHERE route_id = ? AND start_time = ?`
+
+const insertArchivedTripQuery = `
+	INSERT INTO archived_trips (
+	    route_id,
+	    start_time,
+	    year,
+	    archive_file,
+	    reports,
+	    operations,
+	    archived_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+const getArchivedTripsQuery = `SELECT route_id, start_time, year, archive_file, reports, operations, archived_at FROM archived_trips`
+
+const getArchivedTripsByRouteQuery = getArchivedTripsQuery + ` WHERE route_id = ?`
+
 const deleteTripFromUnsynchronizedTripsQuery = `DELETE FROM unsynchronized_trips WHERE route_id = ?
 	  AND start_time = ?
 	IF EXISTS`
 
-// InsertData Inserts all data from a CarriageReport into the Cassandra database
-func (r *SalesRepository) InsertData(ctx context.Context, carriageReport *models.CarriageReport) error {
-	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+// InsertData Inserts all data from a CarriageReport into the Cassandra database, together with its receipt
+// when one is given.
+func (r *SalesRepository) InsertData(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) error {
 	carriageReport.TripID.Year = strconv.Itoa(carriageReport.TripID.StartTime.Year())
-	for _, cart := range carriageReport.Carts {
-		batch.Query(insertEmployeeTripsQuery,
-			&cart.CartID.EmployeeID,
-			&carriageReport.TripID.Year,
-			&carriageReport.TripID.RouteID,
-			&carriageReport.TripID.StartTime,
-			&carriageReport.EndTime,
-		)
+	commitID, statements := reportOperations(carriageReport)
+	return r.writeReport(ctx, carriageReport, commitID, statements, auxiliaryStatements(carriageReport, receipt))
+}
 
-		batch.Query(insertUnsynchronizedTripQuery,
-			&carriageReport.TripID.RouteID,
-			&carriageReport.TripID.StartTime,
-			&carriageReport.TripID.Year,
-		)
+// ImportData Inserts a carriage report restored from an archive. The operations and index tables are written
+// as by InsertData, but the trip is not queued for synchronization, no receipt is stored and the clock skew
+// is not recorded: the report was accepted, and received by its consumers, before it was archived.
+func (r *SalesRepository) ImportData(ctx context.Context, carriageReport *models.CarriageReport) error {
+	carriageReport.TripID.Year = strconv.Itoa(carriageReport.TripID.StartTime.Year())
+	commitID, statements := reportOperations(carriageReport)
+	return r.writeReport(ctx, carriageReport, commitID, statements, indexStatements(carriageReport))
+}
 
-		batch.Query(insertRouteQuery,
-			&carriageReport.TripID.RouteID,
-		)
+// ReplaceCarriageReport Replaces the stored operations of a trip carriage with the ones in carriageReport:
+// new rows are inserted, changed rows are overwritten and rows missing from the report are deleted, and the
+// changed and deleted rows are recorded as corrections. The changes are applied in one logged batch, a
+// replacement too large for it is rejected, as are reports listing an item twice and items whose row is
+// stored for another carriage of the trip
+func (r *SalesRepository) ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) (models.ReportDiff, error) {
+	carriageReport.TripID.Year = strconv.Itoa(carriageReport.TripID.StartTime.Year())
 
-		for _, item := range cart.Items {
-			// Batch query allows to save data integrity by stopping transaction if at least one insertion fails
-			batch.Query(insertOperationQuery,
-				&carriageReport.TripID.RouteID,
-				&carriageReport.TripID.Year,
-				&carriageReport.TripID.StartTime,
-				&carriageReport.EndTime,
-				&carriageReport.CarriageID,
-				&cart.CartID.EmployeeID,
-				&cart.OperationType,
-				&cart.CartID.OperationTime,
-				&item.ProductID,
-				&item.Quantity,
-				&item.Price,
-			)
-		}
-	}
-
-	err := r.session.ExecuteBatch(batch)
+	rows, err := r.getCarriageOperations(ctx, &carriageReport.TripID, carriageReport.CarriageID)
 	if err != nil {
-		_ = r.log.Log("error", fmt.Sprintf("Failed to insert carriage trip info: %v", err))
+		_ = r.log.Log("error", fmt.Sprintf("Failed to read stored carriage report: %v", err))
+		return models.ReportDiff{}, err
+	}
+
+	var diff models.ReportDiff
+	var statements, corrections []batchStatement
+	correctedAt := r.now().UTC()
+	correction := func(old operationRow) batchStatement {
+		return correctionStatement(&models.Correction{
+			TripID:      carriageReport.TripID,
+			CartID:      models.CartID{EmployeeID: old.employeeID, OperationTime: old.operationTime},
+			ProductID:   old.productID,
+			CorrectedAt: correctedAt,
+		})
+	}
+	incoming := operationRows(carriageReport)
+	commitID := reportCommitID(incoming)
+	seen := make(map[string]struct{}, len(incoming))
+	for _, row := range incoming {
+		row.commitID = commitID
+		key := row.key()
+		if _, ok := seen[key]; ok {
+			return models.ReportDiff{}, fmt.Errorf("product %d is listed twice in the cart of employee %s at %s",
+				row.productID, row.employeeID, row.operationTime.UTC().Format(time.RFC3339Nano))
+		}
+		// carriage_id is not part of the operations key, writing the row would move it from the other carriage
+		if other, ok := rows.others[key]; ok {
+			return models.ReportDiff{}, fmt.Errorf("product %d in the cart of employee %s at %s is stored for carriage %d",
+				row.productID, row.employeeID, row.operationTime.UTC().Format(time.RFC3339Nano), other)
+		}
+		seen[key] = struct{}{}
+
+		old, exists := rows.stored[key]
+		switch {
+		case !exists:
+			diff.Inserted++
+		case !old.sameAs(row.normalized()):
+			diff.Updated++
+			corrections = append(corrections, correction(old))
+		default:
+			diff.Unchanged++
+			continue
+		}
+		statements = append(statements, insertOperationStatement(&carriageReport.TripID, row))
+	}
+
+	reported := make(map[string]struct{})
+	for _, cart := range carriageReport.Carts {
+		reported[cart.CartID.EmployeeID] = struct{}{}
+	}
+	var removedEmployees []string
+	for key, old := range rows.stored {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		if _, ok := reported[old.employeeID]; !ok {
+			reported[old.employeeID] = struct{}{}
+			// The employee may still have operations in other carriages, this is checked after the write
+			removedEmployees = append(removedEmployees, old.employeeID)
+		}
+		diff.Deleted++
+		statements = append(statements, deleteOperationStatement(&carriageReport.TripID, old))
+		corrections = append(corrections, correction(old))
+	}
+	// Rows of an earlier write that never committed are not part of the stored report, the ones the
+	// replacement does not overwrite are removed so a late commit of that write cannot bring them back
+	for key, old := range rows.pending {
+		if _, ok := seen[key]; !ok {
+			statements = append(statements, deleteOperationStatement(&carriageReport.TripID, old))
+		}
+	}
+
+	// Split reports only hide rows until they commit, deletes and updates would be visible chunk by chunk
+	aux := append(auxiliaryStatements(carriageReport, receipt), corrections...)
+	if size := statementsSize(statements) + statementsSize(aux); size > r.maxBatchBytes {
+		return models.ReportDiff{}, fmt.Errorf("replacement changes %d rows, more than can be applied in one atomic batch", len(statements))
+	}
+	if err := r.writeReport(ctx, carriageReport, commitID, statements, aux); err != nil {
+		return models.ReportDiff{}, err
+	}
+	r.pruneTripEmployees(ctx, &carriageReport.TripID, removedEmployees)
+	return diff, nil
+}
+
+// writeReport writes the operation statements of a carriage report together with its auxiliary rows aux.
+//
+// Reports whose statements fit in maxBatchBytes are written in a single logged batch. Larger reports
+// are split: operation rows go first in unlogged batches (they all share the trip partition, so every
+// chunk is applied atomically), then the auxiliary tables and the report commit marker are written
+// together in a final logged batch. The operation rows carry commitID and reads skip rows whose commit
+// has no marker, so neither readers nor synchronization consumers see the report before every chunk
+// has been stored, and the terminal can safely retry the whole report after a failure.
+func (r *SalesRepository) writeReport(ctx context.Context, carriageReport *models.CarriageReport, commitID string, operations, aux []batchStatement) error {
+	if statementsSize(operations)+statementsSize(aux) <= r.maxBatchBytes {
+		batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		addStatements(batch, operations)
+		addStatements(batch, aux)
+		addStatements(batch, []batchStatement{reportCommitStatement(carriageReport, commitID, len(operations), 1)})
+
+		// Batch query allows to save data integrity by stopping transaction if at least one insertion fails
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to insert carriage trip info: %v", err))
+			return fmt.Errorf("failed to execute batch: %w", err)
+		}
+		return nil
+	}
+
+	chunks := chunkStatements(operations, r.maxBatchBytes)
+	for i, chunk := range chunks {
+		batch := r.session.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		addStatements(batch, chunk)
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to insert operations chunk %d/%d: %v", i+1, len(chunks), err))
+			return fmt.Errorf("failed to execute batch: %w", err)
+		}
+	}
+
+	// Auxiliary rows and the commit marker are written last so the report becomes visible only when complete
+	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	addStatements(batch, aux)
+	addStatements(batch, []batchStatement{reportCommitStatement(carriageReport, commitID, len(operations), len(chunks)+1)})
+	if err := r.session.ExecuteBatch(batch); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to commit carriage trip info: %v", err))
 		return fmt.Errorf("failed to execute batch: %w", err)
 	}
 	return nil
 }
 
+// carriageRows are the operation rows of a trip as seen by the replacement of one of its carriage reports
+type carriageRows struct {
+	// stored are the committed rows of the carriage and pending the rows of its writes that never committed,
+	// both keyed by operationRow.key
+	stored, pending map[string]operationRow
+	// others maps the keys of the committed rows of other carriages to their carriage
+	others map[string]int8
+}
+
+// getCarriageOperations Gets the operation rows of a trip for replacing the report of carriageID
+func (r *SalesRepository) getCarriageOperations(ctx context.Context, tripID *models.TripID, carriageID int8) (carriageRows, error) {
+	committed, err := r.getReportCommits(ctx, tripID)
+	if err != nil {
+		return carriageRows{}, err
+	}
+	iter := r.session.Query(getTripQuery,
+		tripID.RouteID,
+		tripID.Year,
+		tripID.StartTime).WithContext(ctx).Iter()
+
+	rows := carriageRows{
+		stored:  make(map[string]operationRow),
+		pending: make(map[string]operationRow),
+		others:  make(map[string]int8),
+	}
+	var (
+		_routeID   string
+		_startTime time.Time
+		row        operationRow
+	)
+	for iter.Scan(
+		&_routeID,
+		&_startTime,
+		&row.employeeID,
+		&row.operationTime,
+		&row.productID,
+		&row.carriageID,
+		&row.endTime,
+		&row.operationType,
+		&row.price,
+		&row.quantity,
+		&row.commitID,
+	) {
+		// carriage_id is a regular column, so rows of other carriages are filtered here
+		switch {
+		case row.carriageID != carriageID:
+			if committed.visible(row.commitID) {
+				rows.others[row.key()] = row.carriageID
+			}
+		case committed.visible(row.commitID):
+			rows.stored[row.key()] = row.normalized()
+		default:
+			rows.pending[row.key()] = row.normalized()
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return carriageRows{}, err
+	}
+	return rows, nil
+}
+
+// reportCommits is the set of commit IDs of a trip whose report writes completed
+type reportCommits map[string]struct{}
+
+// visible reports whether a row written with commitID can be read, rows stored before commit IDs existed have none
+func (c reportCommits) visible(commitID string) bool {
+	if commitID == "" {
+		return true
+	}
+	_, ok := c[commitID]
+	return ok
+}
+
+// getReportCommits Gets the commit IDs of the completed report writes of a trip
+func (r *SalesRepository) getReportCommits(ctx context.Context, tripID *models.TripID) (reportCommits, error) {
+	iter := r.session.Query(getReportCommitsQuery,
+		tripID.RouteID,
+		tripID.Year,
+		tripID.StartTime).WithContext(ctx).Iter()
+
+	commits := make(reportCommits)
+	var commitID string
+	for iter.Scan(&commitID) {
+		commits[commitID] = struct{}{}
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get report commits: %v", err))
+		return nil, err
+	}
+	return commits, nil
+}
+
+// GetReceipt Gets a receipt by report ID, returns models.ErrNotFound if it does not exist
+func (r *SalesRepository) GetReceipt(ctx context.Context, reportID string) (models.Receipt, error) {
+	iter := r.session.Query(getReceiptQuery, reportID).WithContext(ctx).Iter()
+
+	receipt := models.Receipt{ReportID: reportID}
+	found := iter.Scan(
+		&receipt.TripID.RouteID,
+		&receipt.TripID.Year,
+		&receipt.TripID.StartTime,
+		&receipt.CarriageID,
+		&receipt.ContentHash,
+		&receipt.Carts,
+		&receipt.Items,
+		&receipt.ReceivedAt,
+	)
+
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get report receipt: %v", err))
+		return models.Receipt{}, err
+	}
+	if !found {
+		return models.Receipt{}, fmt.Errorf("receipt %s: %w", reportID, models.ErrNotFound)
+	}
+	return receipt, nil
+}
+
+// GetClockSkew Gets the clock skew stored for a trip carriage report, returns models.ErrNotFound if none was stored
+func (r *SalesRepository) GetClockSkew(ctx context.Context, tripID *models.TripID, carriageID int8) (models.ClockSkew, error) {
+	iter := r.session.
+		Query(getClockSkewReportQuery, tripID.RouteID, tripID.Year, tripID.StartTime, carriageID).
+		WithContext(ctx).
+		Iter()
+
+	var (
+		cs     models.ClockSkew
+		skewMs int64
+	)
+	found := iter.Scan(&cs.DeviceTime, &cs.ServerTime, &skewMs, &cs.Corrected, &cs.Flagged)
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get clock skew report: %v", err))
+		return models.ClockSkew{}, err
+	}
+	if !found {
+		return models.ClockSkew{}, fmt.Errorf("clock skew of carriage %d: %w", carriageID, models.ErrNotFound)
+	}
+	cs.Skew = time.Duration(skewMs) * time.Millisecond
+	return cs, nil
+}
+
 func (r *SalesRepository) GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error) {
+	committed, err := r.getReportCommits(ctx, tripID)
+	if err != nil {
+		return models.Trip{}, err
+	}
+
 	// Fire the query
 	iter := r.session.
 		Query(getTripQuery, tripID.RouteID, tripID.Year, tripID.StartTime).
@@ -191,6 +619,7 @@ func (r *SalesRepository) GetTrip(ctx context.Context, tripID *models.TripID) (m
 		opType     int8
 		price      int64
 		quantity   int16
+		commitID   string
 	)
 
 	// Iterate through every operation in this trip
@@ -205,7 +634,13 @@ func (r *SalesRepository) GetTrip(ctx context.Context, tripID *models.TripID) (m
 		&opType,
 		&price,
 		&quantity,
+		&commitID,
 	) {
+		// Rows of a report whose batches have not all been stored yet are skipped
+		if !committed.visible(commitID) {
+			continue
+		}
+
 		// 1) ensure we have a CarriageReport object
 		_, ok := carriageMap[carriageID]
 		if !ok {
@@ -251,19 +686,24 @@ func (r *SalesRepository) GetTrip(ctx context.Context, tripID *models.TripID) (m
 		car.Carts = carts
 		trip.Carriage = append(trip.Carriage, *car)
 	}
+	trip.Sort()
 
 	return trip, nil
 }
 
-// GetEmployeeCartsInTrip Gets all carts employee has sold during trip, returns array of Carts
-func (r *SalesRepository) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string) ([]models.Cart, error) {
-	iter := r.session.Query(getEmployeeCartsInTripQuery,
-		&tripID.RouteID,
-		&tripID.Year,
-		&tripID.StartTime,
-		&employeeID).WithContext(ctx).Iter()
+// GetEmployeeCartsInTrip Gets all carts employee has sold during trip within the window of filter, ordered by operation time
+func (r *SalesRepository) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string, filter models.CartFilter) ([]models.Cart, error) {
+	window := newOperationTimeWindow(filter)
+	if window.empty() {
+		return []models.Cart{}, nil
+	}
+	committed, err := r.getReportCommits(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+	iter := r.selectCartsIter(ctx, tripID, *employeeID, window, filter.Order == models.SortAsc)
 
-	carts, err := aggregateCartsFromRows(iter, *employeeID)
+	carts, err := aggregateCartsFromRows(iter, *employeeID, committed)
 	if err != nil {
 		_ = r.log.Log("error", fmt.Sprintf("Failed to aggregate carts by employee in trip %v", err))
 		return nil, err
@@ -275,56 +715,88 @@ func (r *SalesRepository) GetEmployeeCartsInTrip(ctx context.Context, tripID *mo
 		return nil, err
 	}
 
+	sortCarts(carts, filter.Order == models.SortAsc)
 	return carts, nil
 }
 
-// GetEmployeeCartsInTripPaged Gets paged carts an employee has sold during trip, returns array of Carts and a cursor for paging
+// GetEmployeeCartsInTripPaged Gets a page of the carts an employee has sold during trip, with the cursors of the
+// previous and next pages
 func (r *SalesRepository) GetEmployeeCartsInTripPaged(
 	ctx context.Context,
 	tripID *models.TripID,
 	employeeID string,
+	filter models.CartFilter,
 	cartLimit int,
 	cursorB64 string,
-) ([]models.Cart, string, error) {
+) (models.CartPage, error) {
 
 	cur, err := decodeCursor(cursorB64)
 	if err != nil {
 		_ = r.log.Log("error", fmt.Sprintf("invalid cursor: %v", err))
-		return nil, "", fmt.Errorf("invalid cursor")
+		return models.CartPage{}, fmt.Errorf("invalid cursor")
 	}
 
-	iter := r.selectCartsIter(ctx, tripID, employeeID, cur)
-	defer func() {
-		// If we returned early inside pager (on limit), iter.Close was called there.
-		// If we complete the scan, this Close here will run.
-	}()
+	// A previous page is read in the opposite order, starting next to the first cart of the current page
+	ascending := filter.Order == models.SortAsc
+	backward := cur != nil && cur.Prev
+	scanAscending := ascending != backward
+	window := newOperationTimeWindow(filter)
+	if cur != nil {
+		window = window.after(cur.LastOpTime, scanAscending)
+	}
+	if window.empty() {
+		return models.CartPage{Carts: []models.Cart{}}, nil
+	}
+	committed, err := r.getReportCommits(ctx, tripID)
+	if err != nil {
+		return models.CartPage{}, err
+	}
 
-	p := newCartPager(iter, r.log, employeeID, cartLimit)
+	iter := r.selectCartsIter(ctx, tripID, employeeID, window, scanAscending)
+	p := newCartPager(iter, r.log, employeeID, cartLimit, committed)
 
-	next, earlyErr := p.scanAll()
+	more, earlyErr := p.scanAll()
 	if earlyErr != nil {
-		return nil, "", earlyErr
+		return models.CartPage{}, earlyErr
+	}
+	if !more {
+		if err := iter.Close(); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("iter.Close failed: %v", err))
+			return models.CartPage{}, err
+		}
 	}
 
-	if err := iter.Close(); err != nil {
-		_ = r.log.Log("error", fmt.Sprintf("iter.Close failed: %v", err))
-		return nil, "", err
+	page := models.CartPage{Carts: p.carts}
+	if len(page.Carts) == 0 {
+		return page, nil
+	}
+	if backward {
+		reverseCarts(page.Carts)
+	}
+	first := page.Carts[0].CartID.OperationTime
+	last := page.Carts[len(page.Carts)-1].CartID.OperationTime
+	// Reading forward, there are earlier carts when the page was reached with a cursor;
+	// reading backward, the page was reached from the next one
+	if backward && more || !backward && cur != nil {
+		page.PrevCursor = encodeCursor(cartCursor{LastOpTime: first, Prev: true})
+	}
+	if !backward && more || backward {
+		page.NextCursor = encodeCursor(cartCursor{LastOpTime: last})
 	}
-	return p.carts, next, nil
+	return page, nil
 }
 
 // GetEmployeeIDsByTrip Gets all employees by TripID (RouteID, StartTime)
 func (r *SalesRepository) GetEmployeeIDsByTrip(ctx context.Context, tripID *models.TripID) ([]string, error) {
-	iter := r.session.Query(getEmployeeIdsByTripQuery,
-		&tripID.RouteID,
-		&tripID.Year,
-		&tripID.StartTime).WithContext(ctx).Iter()
+	iter := r.session.Query(getTripEmployeesQuery,
+		tripID.RouteID,
+		tripID.Year,
+		tripID.StartTime).WithContext(ctx).Iter()
 
-	// Making a map to get unique ID's since Cassandra only allows DISTINCT for partition keys
-	uniqueIDs := make(map[string]struct{})
+	var employeeIDs []string
 	var employeeID string
 	for iter.Scan(&employeeID) {
-		uniqueIDs[employeeID] = struct{}{}
+		employeeIDs = append(employeeIDs, employeeID)
 	}
 
 	if err := iter.Close(); err != nil {
@@ -332,12 +804,88 @@ func (r *SalesRepository) GetEmployeeIDsByTrip(ctx context.Context, tripID *mode
 		return nil, err
 	}
 
-	var employeeIDs []string
+	return employeeIDs, nil
+}
+
+// BackfillTripEmployees Indexes the employees of trips stored before trip_employees existed,
+// returns the number of trips indexed. It reads every operation row, so it is meant to be run once
+func (r *SalesRepository) BackfillTripEmployees(ctx context.Context) (int, error) {
+	iter := r.session.Query(getTripKeysQuery).WithContext(ctx).Iter()
+
+	var count int
+	var tripID models.TripID
+	for iter.Scan(&tripID.RouteID, &tripID.Year, &tripID.StartTime) {
+		if err := r.backfillTripEmployees(ctx, &tripID); err != nil {
+			_ = iter.Close()
+			_ = r.log.Log("error", fmt.Sprintf("Failed to backfill employees of trip %s %v: %v", tripID.RouteID, tripID.StartTime, err))
+			return count, err
+		}
+		count++
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to scan trips for backfill: %v", err))
+		return count, err
+	}
+	return count, nil
+}
+
+func (r *SalesRepository) backfillTripEmployees(ctx context.Context, tripID *models.TripID) error {
+	iter := r.session.Query(getOperationEmployeeIDsQuery,
+		tripID.RouteID,
+		tripID.Year,
+		tripID.StartTime).WithContext(ctx).Iter()
+
+	// Cassandra only allows DISTINCT for partition keys, so employees are deduplicated here
+	uniqueIDs := make(map[string]struct{})
+	var employeeID string
+	for iter.Scan(&employeeID) {
+		uniqueIDs[employeeID] = struct{}{}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
 	for id := range uniqueIDs {
-		employeeIDs = append(employeeIDs, id)
+		err := r.session.Query(insertTripEmployeeQuery,
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			id).WithContext(ctx).Exec()
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return employeeIDs, nil
+// pruneTripEmployees Removes employees from trip_employees once they have no operations left in the trip.
+// The operations were already changed when this runs, so failures are logged and the stale row is left behind
+func (r *SalesRepository) pruneTripEmployees(ctx context.Context, tripID *models.TripID, employeeIDs []string) {
+	for _, employeeID := range employeeIDs {
+		iter := r.session.Query(hasEmployeeOperationsQuery,
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			employeeID).WithContext(ctx).Iter()
+		var found string
+		hasOperations := iter.Scan(&found)
+		if err := iter.Close(); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to check operations of employee %s: %v", employeeID, err))
+			continue
+		}
+		if hasOperations {
+			continue
+		}
+
+		err := r.session.Query(deleteTripEmployeeQuery,
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			employeeID).WithContext(ctx).Exec()
+		if err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to remove employee %s from trip employees: %v", employeeID, err))
+		}
+	}
 }
 
 func (r *SalesRepository) GetEmployeeTrips(ctx context.Context, employeeID string, year string) ([]models.EmployeeTrip, error) {
@@ -393,7 +941,9 @@ func (r *SalesRepository) GetUnsyncedTrips(ctx context.Context) ([]models.TripID
 	return res, nil
 }
 
-// UpdateItemQuantity Updates quantity of items in cart
+// UpdateItemQuantity Updates quantity of items in cart and records the correction.
+// The conditional update cannot share a batch with the corrections partition, so the correction is written
+// after the update is applied; a failed correction write is logged and the update still succeeds
 func (r *SalesRepository) UpdateItemQuantity(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int, newQuantity *int16) error {
 	applied, err := r.session.Query(updateItemQuantityQuery, newQuantity,
 		tripID.RouteID,
@@ -411,10 +961,12 @@ func (r *SalesRepository) UpdateItemQuantity(ctx context.Context, tripID *models
 		return fmt.Errorf("transaction does not exist")
 	}
 
+	r.recordCorrection(ctx, tripID, cartID, *productID)
 	return nil
 }
 
-// DeleteItemFromCart Deletes cart item (operation)
+// DeleteItemFromCart Deletes cart item (operation) and records the correction after the delete is applied,
+// a failed correction write is logged and the delete still succeeds
 func (r *SalesRepository) DeleteItemFromCart(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int) error {
 	deleted, err := r.session.Query(deleteItemFromCartQuery,
 		tripID.RouteID,
@@ -433,9 +985,26 @@ func (r *SalesRepository) DeleteItemFromCart(ctx context.Context, tripID *models
 		return fmt.Errorf("item does not exist")
 	}
 
+	r.recordCorrection(ctx, tripID, cartID, *productID)
+	r.pruneTripEmployees(ctx, tripID, []string{cartID.EmployeeID})
 	return nil
 }
 
+// recordCorrection Records a correction of an item that was already applied. The item is changed by then,
+// so a failure is only logged, and the corrections of the day miss that item
+func (r *SalesRepository) recordCorrection(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID int) {
+	c := correctionStatement(&models.Correction{
+		TripID:      *tripID,
+		CartID:      *cartID,
+		ProductID:   productID,
+		CorrectedAt: r.now().UTC(),
+	})
+	if err := r.session.Query(c.stmt, c.values...).WithContext(ctx).Exec(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to record correction of product %d in cart of %s: %v",
+			productID, cartID.EmployeeID, err))
+	}
+}
+
 func (r *SalesRepository) DeleteSyncedTrip(ctx context.Context, routeID string, startTime time.Time) error {
 	deleted, err := r.session.Query(deleteTripFromUnsynchronizedTripsQuery,
 		routeID,
@@ -453,8 +1022,553 @@ func (r *SalesRepository) DeleteSyncedTrip(ctx context.Context, routeID string,
 	return nil
 }
 
-// Helper function to process rows and return an array of Carts
-func aggregateCartsFromRows(iter Iter, employeeID string) ([]models.Cart, error) {
+// GetRoutes Gets the IDs of every route that has reported trips
+func (r *SalesRepository) GetRoutes(ctx context.Context) ([]string, error) {
+	iter := r.session.Query(getRoutesQuery).WithContext(ctx).Iter()
+
+	var routes []string
+	var routeID string
+	for iter.Scan(&routeID) {
+		routes = append(routes, routeID)
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get routes: %v", err))
+		return nil, err
+	}
+	return routes, nil
+}
+
+// GetRouteTrips Gets the trips of a route started in [from, to), ordered by start time
+func (r *SalesRepository) GetRouteTrips(ctx context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	var trips []models.TripID
+	// route_trips is partitioned by year, so every year of the range is a separate query
+	for year := from.UTC().Year(); year <= to.UTC().Year(); year++ {
+		yearStr := strconv.Itoa(year)
+		iter := r.session.Query(getRouteTripsQuery, routeID, yearStr, from, to).WithContext(ctx).Iter()
+
+		var start time.Time
+		for iter.Scan(&start) {
+			trips = append(trips, models.TripID{RouteID: routeID, Year: yearStr, StartTime: start})
+		}
+		if err := iter.Close(); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to get trips of route %s in %s: %v", routeID, yearStr, err))
+			return nil, err
+		}
+	}
+	return trips, nil
+}
+
+// BackfillRouteTrips Indexes trips stored before route_trips existed, returns the number of trips indexed.
+// It scans every partition of the operations table, so it is meant to be run once after the migration
+func (r *SalesRepository) BackfillRouteTrips(ctx context.Context) (int, error) {
+	iter := r.session.Query(getTripKeysQuery).WithContext(ctx).Iter()
+
+	var count int
+	var routeID, year string
+	var start time.Time
+	for iter.Scan(&routeID, &year, &start) {
+		// end_time is left unset, it is only known to the operation rows
+		err := r.session.Query(insertRouteTripQuery, routeID, year, start, nil).WithContext(ctx).Exec()
+		if err != nil {
+			_ = iter.Close()
+			_ = r.log.Log("error", fmt.Sprintf("Failed to backfill route trip %s %v: %v", routeID, start, err))
+			return count, err
+		}
+		count++
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to scan trips for backfill: %v", err))
+		return count, err
+	}
+	return count, nil
+}
+
+// DeleteTrip Deletes a trip with every row derived from it. The receipts and the daily revenue rows the trip
+// contributed to are deleted first, then the operations partition is deleted together with the employee_trips,
+// trip_employees, route_trips, report_commits, unsynchronized_trips, clock_skew_reports, trip_receipts and
+// trip_revenue rows in one logged batch. Until that batch is applied the trip is still listed and its
+// trip_revenue row still names the daily rows, so a failed delete can simply be repeated
+func (r *SalesRepository) DeleteTrip(ctx context.Context, tripID *models.TripID, employeeIDs []string) error {
+	deletedAt := r.now()
+	stored, _, err := r.getStoredTripRevenue(ctx, tripID)
+	if err != nil {
+		return err
+	}
+	reportIDs, err := r.getTripReceiptIDs(ctx, tripID)
+	if err != nil {
+		return err
+	}
+
+	// Without operations every daily row of the trip is deleted, its trip_revenue row goes with the operations
+	var derived []batchStatement
+	for _, statement := range tripAggregateStatements(tripID, tripAggregates{}, stored, deletedAt) {
+		if statement.stmt != deleteTripRevenueQuery {
+			derived = append(derived, statement)
+		}
+	}
+	for _, reportID := range reportIDs {
+		derived = append(derived, batchStatement{stmt: deleteReceiptQuery, values: []interface{}{reportID}})
+	}
+	for i, chunk := range chunkStatements(derived, r.maxBatchBytes) {
+		batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		addStatements(batch, chunk)
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			_ = r.log.Log("error", fmt.Sprintf("Failed to delete derived rows of trip %s %v, chunk %d: %v", tripID.RouteID, tripID.StartTime, i+1, err))
+			return fmt.Errorf("failed to execute batch: %w", err)
+		}
+	}
+
+	key := []interface{}{tripID.RouteID, tripID.Year, tripID.StartTime}
+	statements := []batchStatement{
+		{stmt: deleteTripOperationsQuery, values: key},
+	}
+	for _, employeeID := range employeeIDs {
+		statements = append(statements, batchStatement{
+			stmt:   deleteEmployeeTripQuery,
+			values: []interface{}{employeeID, tripID.Year, tripID.StartTime, tripID.RouteID},
+		})
+	}
+	statements = append(statements,
+		batchStatement{stmt: deleteTripEmployeesQuery, values: key},
+		batchStatement{stmt: deleteRouteTripQuery, values: key},
+		batchStatement{stmt: deleteReportCommitsQuery, values: key},
+		batchStatement{stmt: deleteUnsynchronizedTripQuery, values: []interface{}{tripID.RouteID, tripID.StartTime}},
+		batchStatement{stmt: deleteClockSkewReportsQuery, values: key},
+		batchStatement{stmt: deleteTripReceiptsQuery, values: key},
+		batchStatement{
+			stmt:   deleteTripRevenueQuery,
+			values: []interface{}{deletedAt.UnixMicro(), tripID.RouteID, tripID.Year, tripID.StartTime},
+		},
+	)
+
+	batch := r.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+	addStatements(batch, statements)
+	if err := r.session.ExecuteBatch(batch); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to delete trip %s %v: %v", tripID.RouteID, tripID.StartTime, err))
+		return fmt.Errorf("failed to execute batch: %w", err)
+	}
+	return nil
+}
+
+// getTripReceiptIDs Gets the IDs of the receipts issued for the reports of a trip
+func (r *SalesRepository) getTripReceiptIDs(ctx context.Context, tripID *models.TripID) ([]string, error) {
+	iter := r.session.Query(getTripReceiptsQuery, tripID.RouteID, tripID.Year, tripID.StartTime).WithContext(ctx).Iter()
+
+	var reportIDs []string
+	var reportID string
+	for iter.Scan(&reportID) {
+		reportIDs = append(reportIDs, reportID)
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get trip receipts: %v", err))
+		return nil, err
+	}
+	return reportIDs, nil
+}
+
+// InsertArchivedTrip Records a trip in the archive catalog
+func (r *SalesRepository) InsertArchivedTrip(ctx context.Context, archived *models.ArchivedTrip) error {
+	err := r.session.Query(insertArchivedTripQuery,
+		archived.TripID.RouteID,
+		archived.TripID.StartTime,
+		archived.TripID.Year,
+		archived.ArchiveFile,
+		archived.Reports,
+		archived.Operations,
+		archived.ArchivedAt).WithContext(ctx).Exec()
+	if err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to insert archived trip %s %v: %v", archived.TripID.RouteID, archived.TripID.StartTime, err))
+		return err
+	}
+	return nil
+}
+
+// GetArchivedTrips Gets the archive catalog of a route, or of every route when routeID is empty
+func (r *SalesRepository) GetArchivedTrips(ctx context.Context, routeID string) ([]models.ArchivedTrip, error) {
+	var query Query
+	if routeID == "" {
+		query = r.session.Query(getArchivedTripsQuery)
+	} else {
+		query = r.session.Query(getArchivedTripsByRouteQuery, routeID)
+	}
+	iter := query.WithContext(ctx).Iter()
+
+	var res []models.ArchivedTrip
+	var a models.ArchivedTrip
+	for iter.Scan(
+		&a.TripID.RouteID,
+		&a.TripID.StartTime,
+		&a.TripID.Year,
+		&a.ArchiveFile,
+		&a.Reports,
+		&a.Operations,
+		&a.ArchivedAt,
+	) {
+		res = append(res, a)
+	}
+	if err := iter.Close(); err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to get archived trips: %v", err))
+		return nil, err
+	}
+	return res, nil
+}
+
+// RequeueTrip Adds a trip back to the unsynced trip table
+func (r *SalesRepository) RequeueTrip(ctx context.Context, tripID *models.TripID) error {
+	err := r.session.Query(insertUnsynchronizedTripQuery,
+		tripID.RouteID,
+		tripID.StartTime,
+		tripID.Year).WithContext(ctx).Exec()
+	if err != nil {
+		_ = r.log.Log("error", fmt.Sprintf("Failed to requeue trip %s %v: %v", tripID.RouteID, tripID.StartTime, err))
+		return err
+	}
+	return nil
+}
+
+// batchStatement is a single statement with its bound values, queued for a batch
+type batchStatement struct {
+	stmt   string
+	values []interface{}
+}
+
+// operationRow is a single row of the operations table
+type operationRow struct {
+	employeeID    string
+	operationTime time.Time
+	productID     int
+	carriageID    int8
+	endTime       time.Time
+	operationType int8
+	price         int64
+	quantity      int16
+
+	// terminal clock details, not compared when diffing reports
+	rawOperationTime time.Time
+	clockSkewMs      *int64
+
+	// commitID identifies the report write that stored the row
+	commitID string
+}
+
+// sameAs reports whether two rows hold the same sale data
+func (o operationRow) sameAs(other operationRow) bool {
+	return o.employeeID == other.employeeID &&
+		o.operationTime.Equal(other.operationTime) &&
+		o.productID == other.productID &&
+		o.carriageID == other.carriageID &&
+		o.endTime.Equal(other.endTime) &&
+		o.operationType == other.operationType &&
+		o.price == other.price &&
+		o.quantity == other.quantity
+}
+
+// key identifies the row by its clustering columns; Cassandra timestamps have millisecond precision
+func (o operationRow) key() string {
+	return fmt.Sprintf("%s|%d|%d", o.employeeID, o.operationTime.UnixMilli(), o.productID)
+}
+
+// normalized truncates timestamps to the millisecond UTC values Cassandra returns, so rows compare equal
+func (o operationRow) normalized() operationRow {
+	o.operationTime = o.operationTime.Truncate(time.Millisecond).UTC()
+	o.endTime = o.endTime.Truncate(time.Millisecond).UTC()
+	return o
+}
+
+// operationRows flattens the carts of a report into operation rows, one per item
+func operationRows(report *models.CarriageReport) []operationRow {
+	var skewMs *int64
+	if report.ClockSkew != nil {
+		ms := report.ClockSkew.Skew.Milliseconds()
+		skewMs = &ms
+	}
+
+	var rows []operationRow
+	for _, cart := range report.Carts {
+		raw := cart.CartID.OperationTime
+		if cart.RawOperationTime != nil {
+			raw = *cart.RawOperationTime
+		}
+		for _, item := range cart.Items {
+			rows = append(rows, operationRow{
+				employeeID:       cart.CartID.EmployeeID,
+				operationTime:    cart.CartID.OperationTime,
+				productID:        item.ProductID,
+				carriageID:       report.CarriageID,
+				endTime:          report.EndTime,
+				operationType:    cart.OperationType,
+				price:            item.Price,
+				quantity:         item.Quantity,
+				rawOperationTime: raw,
+				clockSkewMs:      skewMs,
+			})
+		}
+	}
+	return rows
+}
+
+// insertOperationStatement builds the insert of a single operation row of a trip
+func insertOperationStatement(tripID *models.TripID, row operationRow) batchStatement {
+	return batchStatement{
+		stmt: insertOperationQuery,
+		values: []interface{}{
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			row.endTime,
+			row.carriageID,
+			row.employeeID,
+			row.operationType,
+			row.operationTime,
+			row.productID,
+			row.quantity,
+			row.price,
+			row.rawOperationTime,
+			row.clockSkewMs,
+			row.commitID,
+		},
+	}
+}
+
+// deleteOperationStatement builds the delete of a single operation row of a trip
+func deleteOperationStatement(tripID *models.TripID, row operationRow) batchStatement {
+	return batchStatement{
+		stmt: deleteOperationQuery,
+		values: []interface{}{
+			tripID.RouteID,
+			tripID.Year,
+			tripID.StartTime,
+			row.employeeID,
+			row.operationTime,
+			row.productID,
+		},
+	}
+}
+
+// reportOperations builds one insert per item of every cart in the report, returns them with their commit ID
+func reportOperations(report *models.CarriageReport) (string, []batchStatement) {
+	rows := operationRows(report)
+	commitID := reportCommitID(rows)
+	statements := make([]batchStatement, 0, len(rows))
+	for _, row := range rows {
+		row.commitID = commitID
+		statements = append(statements, insertOperationStatement(&report.TripID, row))
+	}
+	return commitID, statements
+}
+
+// reportCommitID derives the commit ID of a report write from the rows it stores, so retrying the same
+// report reuses the commit of an earlier attempt and rows it already made visible stay visible
+func reportCommitID(rows []operationRow) string {
+	h := sha256.New()
+	for _, row := range rows {
+		row = row.normalized()
+		_, _ = fmt.Fprintf(h, "%s|%d|%d|%d|%d|%d|%d|%d\n",
+			row.employeeID,
+			row.operationTime.UnixMilli(),
+			row.productID,
+			row.carriageID,
+			row.endTime.UnixMilli(),
+			row.operationType,
+			row.price,
+			row.quantity)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// auxiliaryStatements builds the rows written with an uploaded report besides its operations: the receipt when
+// one is given, also for a report without carts, the index tables, the unsynchronized_trips queue and the clock skew
+func auxiliaryStatements(report *models.CarriageReport, receipt *models.Receipt) []batchStatement {
+	var statements []batchStatement
+	if receipt != nil {
+		statements = append(statements, batchStatement{
+			stmt: insertReceiptQuery,
+			values: []interface{}{
+				receipt.ReportID,
+				receipt.TripID.RouteID,
+				receipt.TripID.Year,
+				receipt.TripID.StartTime,
+				receipt.CarriageID,
+				receipt.ContentHash,
+				receipt.Carts,
+				receipt.Items,
+				receipt.ReceivedAt,
+			},
+		}, batchStatement{
+			stmt:   insertTripReceiptQuery,
+			values: []interface{}{receipt.TripID.RouteID, receipt.TripID.Year, receipt.TripID.StartTime, receipt.ReportID},
+		})
+	}
+	if len(report.Carts) == 0 {
+		return statements
+	}
+
+	statements = append(statements, indexStatements(report)...)
+	statements = append(statements, batchStatement{
+		stmt:   insertUnsynchronizedTripQuery,
+		values: []interface{}{report.TripID.RouteID, report.TripID.StartTime, report.TripID.Year},
+	})
+	if cs := report.ClockSkew; cs != nil && !cs.DeviceTime.IsZero() {
+		statements = append(statements, batchStatement{
+			stmt: insertClockSkewReportQuery,
+			values: []interface{}{
+				report.TripID.RouteID,
+				report.TripID.Year,
+				report.TripID.StartTime,
+				report.CarriageID,
+				cs.DeviceTime,
+				cs.ServerTime,
+				cs.Skew.Milliseconds(),
+				cs.Corrected,
+				cs.Flagged,
+			},
+		})
+	}
+	return statements
+}
+
+// indexStatements builds the employee_trips, trip_employees, routes and route_trips inserts for a report,
+// writing each employee once no matter how many carts the employee has
+func indexStatements(report *models.CarriageReport) []batchStatement {
+	if len(report.Carts) == 0 {
+		return nil
+	}
+
+	var statements []batchStatement
+	seen := make(map[string]struct{})
+	for _, cart := range report.Carts {
+		if _, ok := seen[cart.CartID.EmployeeID]; ok {
+			continue
+		}
+		seen[cart.CartID.EmployeeID] = struct{}{}
+		statements = append(statements,
+			batchStatement{
+				stmt: insertEmployeeTripsQuery,
+				values: []interface{}{
+					cart.CartID.EmployeeID,
+					report.TripID.Year,
+					report.TripID.RouteID,
+					report.TripID.StartTime,
+					report.EndTime,
+				},
+			},
+			batchStatement{
+				stmt: insertTripEmployeeQuery,
+				values: []interface{}{
+					report.TripID.RouteID,
+					report.TripID.Year,
+					report.TripID.StartTime,
+					cart.CartID.EmployeeID,
+				},
+			},
+		)
+	}
+
+	statements = append(statements,
+		batchStatement{
+			stmt:   insertRouteQuery,
+			values: []interface{}{report.TripID.RouteID},
+		},
+		batchStatement{
+			stmt:   insertRouteTripQuery,
+			values: []interface{}{report.TripID.RouteID, report.TripID.Year, report.TripID.StartTime, report.EndTime},
+		},
+	)
+	return statements
+}
+
+// reportCommitStatement builds the marker recording that the write commitID of a carriage report was stored completely
+func reportCommitStatement(report *models.CarriageReport, commitID string, operations, batches int) batchStatement {
+	return batchStatement{
+		stmt: insertReportCommitQuery,
+		values: []interface{}{
+			report.TripID.RouteID,
+			report.TripID.Year,
+			report.TripID.StartTime,
+			report.CarriageID,
+			commitID,
+			operations,
+			batches,
+			time.Now().UTC(),
+		},
+	}
+}
+
+// chunkStatements splits statements into chunks whose estimated size is at most maxBytes,
+// a statement larger than maxBytes gets a chunk of its own
+func chunkStatements(statements []batchStatement, maxBytes int) [][]batchStatement {
+	var chunks [][]batchStatement
+	start, size := 0, 0
+	for i, st := range statements {
+		n := st.size()
+		if i > start && size+n > maxBytes {
+			chunks = append(chunks, statements[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(statements) {
+		chunks = append(chunks, statements[start:])
+	}
+	return chunks
+}
+
+// cellOverhead approximates what Cassandra stores with every cell of a mutation besides its value:
+// flags, timestamp and value length
+const cellOverhead = 8
+
+// size Estimates the size the statement adds to a batch mutation, which is what batch_size_fail_threshold_in_kb limits
+func (st batchStatement) size() int {
+	size := 0
+	for _, v := range st.values {
+		size += cellOverhead
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case *string:
+			if v != nil {
+				size += len(*v)
+			}
+		case []byte:
+			size += len(v)
+		case int8, bool:
+			size++
+		case int16:
+			size += 2
+		case int32:
+			size += 4
+		case []string:
+			for _, e := range v {
+				size += cellOverhead + len(e)
+			}
+		case []int:
+			size += len(v) * (cellOverhead + 4)
+		case nil:
+		default:
+			// int, int64, timestamps and pointers to them
+			size += 8
+		}
+	}
+	return size
+}
+
+// statementsSize Estimates the size the statements add to a batch mutation
+func statementsSize(statements []batchStatement) int {
+	size := 0
+	for _, st := range statements {
+		size += st.size()
+	}
+	return size
+}
+
+func addStatements(batch Batch, statements []batchStatement) {
+	for _, st := range statements {
+		batch.Query(st.stmt, st.values...)
+	}
+}
+
+// Helper function to process the committed rows and return an array of Carts
+func aggregateCartsFromRows(iter Iter, employeeID string, committed reportCommits) ([]models.Cart, error) {
 	cartMap := make(map[string]*models.Cart)
 
 	var operationTime time.Time
@@ -462,8 +1576,12 @@ func aggregateCartsFromRows(iter Iter, employeeID string) ([]models.Cart, error)
 	var productID int
 	var quantity int16
 	var price int64
+	var commitID string
 
-	for iter.Scan(&operationTime, &operationType, &productID, &quantity, &price) {
+	for iter.Scan(&operationTime, &operationType, &productID, &quantity, &price, &commitID) {
+		if !committed.visible(commitID) {
+			continue
+		}
 
 		// Create a cartID struct
 		cartID := models.CartID{
@@ -500,25 +1618,87 @@ func (r *SalesRepository) selectCartsIter(
 	ctx context.Context,
 	tripID *models.TripID,
 	employeeID string,
-	cur *cartCursor,
+	window operationTimeWindow,
+	ascending bool,
 ) Iter {
-	if cur == nil {
-		return r.session.Query(
-			getEmployeeCartsInTripQuery,
-			&tripID.RouteID, &tripID.Year, &tripID.StartTime, &employeeID,
-		).WithContext(ctx).Iter()
-	}
-	return r.session.Query(
-		getEmployeeCartsInTripAfterCursorQuery,
+	stmt := getEmployeeCartsInTripQuery
+	if ascending {
+		stmt = getEmployeeCartsInTripAscQuery
+	}
+	return r.session.Query(stmt,
 		&tripID.RouteID, &tripID.Year, &tripID.StartTime, &employeeID,
-		&cur.LastOpTime,
+		window.from, window.to,
 	).WithContext(ctx).Iter()
 }
 
+// Operation times are stored with millisecond precision, the bounds of the timestamps Cassandra accepts
+// that still convert to milliseconds without overflow
+var (
+	minOperationTime = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxOperationTime = time.Date(9999, 12, 31, 23, 59, 59, 999_000_000, time.UTC)
+)
+
+// operationTimeWindow is an inclusive range of operation times
+type operationTimeWindow struct {
+	from, to time.Time
+}
+
+// newOperationTimeWindow Converts the half-open window of filter to an inclusive one
+func newOperationTimeWindow(filter models.CartFilter) operationTimeWindow {
+	w := operationTimeWindow{from: minOperationTime, to: maxOperationTime}
+	if !filter.From.IsZero() && filter.From.After(w.from) {
+		w.from = filter.From
+	}
+	if !filter.To.IsZero() && filter.To.Add(-time.Millisecond).Before(w.to) {
+		w.to = filter.To.Add(-time.Millisecond)
+	}
+	return w
+}
+
+// after Narrows the window to the operation times following boundary in the scan order
+func (w operationTimeWindow) after(boundary time.Time, ascending bool) operationTimeWindow {
+	if ascending {
+		if next := boundary.Add(time.Millisecond); next.After(w.from) {
+			w.from = next
+		}
+	} else if previous := boundary.Add(-time.Millisecond); previous.Before(w.to) {
+		w.to = previous
+	}
+	return w
+}
+
+func (w operationTimeWindow) empty() bool {
+	return w.from.After(w.to)
+}
+
+// sortCarts Orders carts by operation time and the items of every cart by product ID
+func sortCarts(carts []models.Cart, ascending bool) {
+	sort.Slice(carts, func(i, j int) bool {
+		if ascending {
+			return carts[i].CartID.OperationTime.Before(carts[j].CartID.OperationTime)
+		}
+		return carts[i].CartID.OperationTime.After(carts[j].CartID.OperationTime)
+	})
+	for _, cart := range carts {
+		sortItems(cart.Items)
+	}
+}
+
+func sortItems(items []models.Item) {
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductID < items[j].ProductID })
+}
+
+func reverseCarts(carts []models.Cart) {
+	for i, j := 0, len(carts)-1; i < j; i, j = i+1, j-1 {
+		carts[i], carts[j] = carts[j], carts[i]
+	}
+}
+
 type cartPager struct {
 	iter       Iter
 	logger     log.Logger
 	employeeID string
+	committed  reportCommits
 
 	limit     int
 	unlimited bool
@@ -532,18 +1712,20 @@ type cartPager struct {
 	haveCart  bool
 
 	// scan row vars
-	opTime time.Time
-	opType int8
-	pid    int
-	qty    int16
-	price  int64
+	opTime   time.Time
+	opType   int8
+	pid      int
+	qty      int16
+	price    int64
+	commitID string
 }
 
-func newCartPager(iter Iter, logger log.Logger, employeeID string, cartLimit int) *cartPager {
+func newCartPager(iter Iter, logger log.Logger, employeeID string, cartLimit int, committed reportCommits) *cartPager {
 	p := &cartPager{
 		iter:       iter,
 		logger:     logger,
 		employeeID: employeeID,
+		committed:  committed,
 		limit:      cartLimit,
 		unlimited:  cartLimit <= 0,
 	}
@@ -580,56 +1762,68 @@ func (p *cartPager) appendItem(pid int, qty int16, price int64) {
 	})
 }
 
-func (p *cartPager) emitAndMaybeReturn() (stop bool, next string, retErr error) {
+// emit Appends the current cart, with its items ordered by product ID
+func (p *cartPager) emit() {
+	sortItems(p.curCart.Items)
 	p.carts = append(p.carts, *p.curCart)
+}
+
+// emitAndMaybeReturn Emits the current cart at a cart boundary and stops once the page is full,
+// the next cart having started tells there are more carts
+func (p *cartPager) emitAndMaybeReturn() (stop bool, retErr error) {
+	p.emit()
 	if p.unlimited || len(p.carts) < p.limit {
-		return false, "", nil
+		return false, nil
 	}
-	nextCur := cartCursor{LastOpTime: p.curOpTime}
 	if err := p.iter.Close(); err != nil {
 		_ = p.logger.Log("error", fmt.Sprintf("iter.Close failed: %v", err))
-		return true, "", err
+		return true, err
 	}
-	return true, encodeCursor(nextCur), nil
+	return true, nil
 }
 
-func (p *cartPager) handleBoundary() (stop bool, next string, err error) {
-	stop, next, err = p.emitAndMaybeReturn()
+func (p *cartPager) handleBoundary() (stop bool, err error) {
+	stop, err = p.emitAndMaybeReturn()
 	if stop || err != nil {
-		return stop, next, err
+		return stop, err
 	}
 	p.startCart(p.opTime, p.opType)
-	return false, "", nil
+	return false, nil
 }
 
-func (p *cartPager) scanLoop() (stop bool, next string, err error) {
-	for p.iter.Scan(&p.opTime, &p.opType, &p.pid, &p.qty, &p.price) {
+func (p *cartPager) scanLoop() (stop bool, err error) {
+	for p.iter.Scan(&p.opTime, &p.opType, &p.pid, &p.qty, &p.price, &p.commitID) {
+		if !p.committed.visible(p.commitID) {
+			continue
+		}
 		if !p.haveCart {
 			p.startCart(p.opTime, p.opType)
 		} else if !p.opTime.Equal(p.curOpTime) {
-			stop, next, err = p.handleBoundary()
+			stop, err = p.handleBoundary()
 			if stop || err != nil {
-				return stop, next, err
+				return stop, err
 			}
 		}
 		p.appendItem(p.pid, p.qty, p.price)
 	}
-	return false, "", nil
+	return false, nil
 }
 
 func (p *cartPager) finalize() {
 	if p.haveCart {
-		p.carts = append(p.carts, *p.curCart)
+		p.emit()
 	}
 }
 
-func (p *cartPager) scanAll() (next string, err error) {
-	stop, next, err := p.scanLoop()
+// scanAll Reads carts until the page is full, more reports whether carts remain; the iterator
+// is closed when more is true
+func (p *cartPager) scanAll() (more bool, err error) {
+	stop, err := p.scanLoop()
 	if stop || err != nil {
-		return next, err
+		return stop, err
 	}
 	p.finalize()
-	return "", nil
+	return false, nil
 }
 
 func createCartKey(employeeID string, operationTime time.Time) string {
@@ -656,8 +1850,10 @@ func createNewCart(cartID models.CartID, operationType int8, item models.Item) *
 	}
 }
 
+// cartCursor points next to the cart at LastOpTime, towards the previous page when Prev is set
 type cartCursor struct {
 	LastOpTime time.Time `json:"t"`
+	Prev       bool      `json:"p,omitempty"`
 }
 
 func encodeCursor(c cartCursor) string {
@@ -677,5 +1873,9 @@ func decodeCursor(b64 string) (*cartCursor, error) {
 	if err := json.Unmarshal(raw, &c); err != nil {
 		return nil, err
 	}
+	// null, {} and cursors of another shape decode to the zero time, which would silently end paging
+	if c.LastOpTime.IsZero() {
+		return nil, fmt.Errorf("cursor has no operation time")
+	}
 	return &c, nil
 }
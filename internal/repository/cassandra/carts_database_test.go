@@ -9,6 +9,8 @@ import (
 	"github.com/gocql/gocql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -35,8 +37,8 @@ func (s *SimpleFakeIter) Scan(dest ...interface{}) bool {
 	row := s.rows[s.index]
 	s.index++
 
-	// Expect exactly 5 destinations.
-	if len(dest) != 5 {
+	// Expect exactly 6 destinations, the rows have no commit ID.
+	if len(dest) != 6 {
 		return false
 	}
 	if ptr, ok := dest[0].(*time.Time); ok {
@@ -64,6 +66,11 @@ func (s *SimpleFakeIter) Scan(dest ...interface{}) bool {
 	} else {
 		return false
 	}
+	if ptr, ok := dest[5].(*string); ok {
+		*ptr = ""
+	} else {
+		return false
+	}
 	return true
 }
 
@@ -230,8 +237,8 @@ func (f *fakeTripIter) Scan(dest ...interface{}) bool {
 	r := f.rows[f.index]
 	f.index++
 
-	// 9 columns
-	if len(dest) != 10 {
+	// 11 columns, the rows have no commit ID
+	if len(dest) != 11 {
 		return false
 	}
 
@@ -245,6 +252,7 @@ func (f *fakeTripIter) Scan(dest ...interface{}) bool {
 	*dest[7].(*int8) = r.operationType
 	*dest[8].(*int64) = r.price
 	*dest[9].(*int16) = r.quantity
+	*dest[10].(*string) = ""
 	return true
 }
 
@@ -282,8 +290,51 @@ func (f *fakeUnsyncIter) Scan(dest ...interface{}) bool {
 
 func (f *fakeUnsyncIter) Close() error { return f.err }
 
+// --- Generic iterator, assigns each row's values to the scan destinations in order ---
+
+type rowsIter struct {
+	rows     [][]interface{}
+	index    int
+	closeErr error
+}
+
+func (f *rowsIter) Scan(dest ...interface{}) bool {
+	if f.index >= len(f.rows) {
+		return false
+	}
+	row := f.rows[f.index]
+	f.index++
+	if len(dest) != len(row) {
+		return false
+	}
+	for i, v := range row {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return true
+}
+
+func (f *rowsIter) Close() error      { return f.closeErr }
+func (f *rowsIter) PageState() []byte { return nil }
+
+// expectReportCommits Makes the session return commitIDs as the completed report writes of any trip
+func expectReportCommits(m *MockSession, commitIDs ...string) {
+	rows := make([][]interface{}, 0, len(commitIDs))
+	for _, id := range commitIDs {
+		rows = append(rows, []interface{}{id})
+	}
+	q := new(FakeQuery)
+	q.On("WithContext", mock.Anything).Return(q)
+	q.On("Iter").Return(&rowsIter{rows: rows})
+	m.On("Query", getReportCommitsQuery, mock.Anything).Return(q)
+}
+
 // --- MOCK TYPES ---
 
+// statementNamed matches a statement argument by its registered name
+func statementNamed(name string) interface{} {
+	return mock.MatchedBy(func(stmt string) bool { return StatementName(stmt) == name })
+}
+
 // MockSession implements cassandra.CassandraSession.
 type MockSession struct {
 	mock.Mock
@@ -381,13 +432,15 @@ func TestInsertData(t *testing.T) {
 
 	// Prepare a fake batch.
 	fakeBatch := new(FakeBatch)
-	fakeBatch.On("Query", mock.Anything, mock.Anything).Times(4).Return()
+	// One operation, one employee trip, one trip employee, the unsynced trip, the route, the route trip and the report commit marker.
+	for _, name := range []string{"insert_operation", "insert_employee_trip", "insert_trip_employee",
+		"insert_unsynchronized_trip", "insert_route", "insert_route_trip", "insert_report_commit"} {
+		fakeBatch.On("Query", statementNamed(name), mock.Anything).Once().Return()
+	}
 	fakeBatch.On("WithContext", mock.Anything).Return(fakeBatch)
 	mockSession.On("NewBatch", gocql.LoggedBatch).Return(fakeBatch)
 	mockSession.On("ExecuteBatch", fakeBatch).Return(nil)
 
-	// (Optionally, you can set expectations on fakeBatch.Query if you want to verify the queries added.)
-
 	tripStartTime := time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)
 	carriage := &models.CarriageReport{
 		TripID: models.TripID{
@@ -410,9 +463,10 @@ func TestInsertData(t *testing.T) {
 		},
 	}
 
-	err := repo.InsertData(context.Background(), carriage)
+	err := repo.InsertData(context.Background(), carriage, nil)
 	assert.NoError(t, err)
 	mockSession.AssertExpectations(t)
+	fakeBatch.AssertExpectations(t)
 }
 
 func TestInsertData_ExecuteBatchError(t *testing.T) {
@@ -425,9 +479,9 @@ func TestInsertData_ExecuteBatchError(t *testing.T) {
 	fakeBatch := new(FakeBatch)
 	// Expect WithContext to be called and return the same fake batch.
 	fakeBatch.On("WithContext", mock.Anything).Return(fakeBatch)
-	// In this test we have one cart with one employee trip insertion and one item insertion.
-	// Therefore, we expect two calls to Query.
-	fakeBatch.On("Query", mock.Anything, mock.Anything).Times(4).Return()
+	// In this test we have one cart with one employee trip, one trip employee and one item insertion,
+	// plus the unsynced trip, route, route trip and report commit marker.
+	fakeBatch.On("Query", mock.Anything, mock.Anything).Times(7).Return()
 
 	// Set up the session so that when NewBatch is called it returns our fake batch.
 	mockSession.On("NewBatch", gocql.LoggedBatch).Return(fakeBatch)
@@ -459,7 +513,7 @@ func TestInsertData_ExecuteBatchError(t *testing.T) {
 	}
 
 	// Call InsertData which should now hit the error branch.
-	err := repo.InsertData(context.Background(), carriage)
+	err := repo.InsertData(context.Background(), carriage, nil)
 	assert.Error(t, err, "expected error from ExecuteBatch")
 	// Since we are using a NopLogger, Log returns nil; the error should be wrapped with "failed to execute batch:".
 	assert.Contains(t, err.Error(), "failed to execute batch:")
@@ -469,9 +523,193 @@ func TestInsertData_ExecuteBatchError(t *testing.T) {
 	fakeBatch.AssertExpectations(t)
 }
 
+func TestInsertData_SplitsLargeReport(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	// Five operations by the same employee in three carts: chunks of 2, 2 and 1 operation rows.
+	opBatches := []*FakeBatch{new(FakeBatch), new(FakeBatch), new(FakeBatch)}
+	for i, b := range opBatches {
+		n := 2
+		if i == 2 {
+			n = 1
+		}
+		b.On("WithContext", mock.Anything).Return(b)
+		b.On("Query", insertOperationQuery, mock.Anything).Times(n).Return()
+		mockSession.On("NewBatch", gocql.UnloggedBatch).Return(b).Once()
+		mockSession.On("ExecuteBatch", b).Return(nil).Once()
+	}
+
+	// The final logged batch carries one employee trip and trip employee (deduplicated), the unsynced trip, the route, the route trip and the commit marker.
+	commitBatch := new(FakeBatch)
+	commitBatch.On("WithContext", mock.Anything).Return(commitBatch)
+	commitBatch.On("Query", insertEmployeeTripsQuery, mock.Anything).Once().Return()
+	commitBatch.On("Query", insertTripEmployeeQuery, mock.Anything).Once().Return()
+	commitBatch.On("Query", insertUnsynchronizedTripQuery, mock.Anything).Once().Return()
+	commitBatch.On("Query", insertRouteQuery, mock.Anything).Once().Return()
+	commitBatch.On("Query", insertRouteTripQuery, mock.Anything).Once().Return()
+	commitBatch.On("Query", insertReportCommitQuery, mock.Anything).Once().Return()
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(commitBatch).Once()
+	mockSession.On("ExecuteBatch", commitBatch).Return(nil).Once()
+
+	start := time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)
+	carriage := &models.CarriageReport{
+		TripID:     models.TripID{RouteID: "route_test", StartTime: start},
+		EndTime:    start.Add(time.Hour),
+		CarriageID: 10,
+		Carts: []models.Cart{
+			{
+				CartID:        models.CartID{EmployeeID: "12345", OperationTime: start.Add(time.Minute)},
+				OperationType: 1,
+				Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}, {ProductID: 2, Quantity: 1, Price: 100}},
+			},
+			{
+				CartID:        models.CartID{EmployeeID: "12345", OperationTime: start.Add(2 * time.Minute)},
+				OperationType: 1,
+				Items:         []models.Item{{ProductID: 3, Quantity: 1, Price: 100}, {ProductID: 4, Quantity: 1, Price: 100}},
+			},
+			{
+				CartID:        models.CartID{EmployeeID: "12345", OperationTime: start.Add(3 * time.Minute)},
+				OperationType: 2,
+				Items:         []models.Item{{ProductID: 5, Quantity: 1, Price: 100}},
+			},
+		},
+	}
+	carriage.TripID.Year = "2023"
+	_, statements := reportOperations(carriage)
+	repo.maxBatchBytes = statementsSize(statements[:2])
+
+	err := repo.InsertData(context.Background(), carriage, nil)
+	assert.NoError(t, err)
+
+	mockSession.AssertExpectations(t)
+	for _, b := range opBatches {
+		b.AssertExpectations(t)
+	}
+	commitBatch.AssertExpectations(t)
+}
+
+func TestInsertData_SplitChunkErrorSkipsCommit(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+	repo.maxBatchBytes = 1
+
+	chunk := new(FakeBatch)
+	chunk.On("WithContext", mock.Anything).Return(chunk)
+	chunk.On("Query", insertOperationQuery, mock.Anything).Return()
+	mockSession.On("NewBatch", gocql.UnloggedBatch).Return(chunk).Once()
+	mockSession.On("ExecuteBatch", chunk).Return(fmt.Errorf("batch too large")).Once()
+
+	start := time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)
+	carriage := &models.CarriageReport{
+		TripID:     models.TripID{RouteID: "route_test", StartTime: start},
+		EndTime:    start.Add(time.Hour),
+		CarriageID: 10,
+		Carts: []models.Cart{{
+			CartID:        models.CartID{EmployeeID: "12345", OperationTime: start},
+			OperationType: 1,
+			Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}, {ProductID: 2, Quantity: 1, Price: 100}},
+		}},
+	}
+
+	err := repo.InsertData(context.Background(), carriage, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "batch too large")
+
+	// No logged batch means neither the unsynced trip nor the commit marker was written.
+	mockSession.AssertNotCalled(t, "NewBatch", gocql.LoggedBatch)
+	mockSession.AssertExpectations(t)
+}
+
+func TestReplaceCarriageReport(t *testing.T) {
+	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	op1 := start.Add(10 * time.Minute)
+	op2 := start.Add(20 * time.Minute)
+
+	stored := &fakeTripIter{
+		rows: []tripOpRow{
+			{"r1", start, "empA", op1, 1, 10, end, 1, 100, 2},  // quantity changes
+			{"r1", start, "empA", op1, 2, 10, end, 1, 200, 1},  // unchanged
+			{"r1", start, "empA", start, 9, 10, end, 1, 50, 1}, // removed from the report
+			{"r1", start, "empC", op2, 7, 11, end, 1, 70, 1},   // other carriage, ignored
+		},
+	}
+	fq := new(FakeQuery)
+	fq.On("WithContext", mock.Anything).Return(fq)
+	fq.On("Iter").Return(stored)
+	mockSession.On("Query", getTripQuery, mock.Anything).Return(fq)
+
+	batch := new(FakeBatch)
+	batch.On("WithContext", mock.Anything).Return(batch)
+	batch.On("Query", insertOperationQuery, mock.Anything).Times(2).Return()
+	batch.On("Query", deleteOperationQuery, mock.Anything).Once().Return()
+	batch.On("Query", insertEmployeeTripsQuery, mock.Anything).Times(2).Return()
+	batch.On("Query", insertTripEmployeeQuery, mock.Anything).Times(2).Return()
+	batch.On("Query", insertUnsynchronizedTripQuery, mock.Anything).Once().Return()
+	batch.On("Query", insertRouteQuery, mock.Anything).Once().Return()
+	batch.On("Query", insertRouteTripQuery, mock.Anything).Once().Return()
+	batch.On("Query", insertReportCommitQuery, mock.Anything).Once().Return()
+	// The changed and the removed item are recorded as corrections
+	for _, productID := range []int{1, 9} {
+		productID := productID
+		batch.On("Query", insertCorrectionQuery, mock.MatchedBy(func(values []interface{}) bool {
+			return values[2] == "empA" && values[6] == productID
+		})).Once().Return()
+	}
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(batch)
+	mockSession.On("ExecuteBatch", batch).Return(nil)
+
+	report := &models.CarriageReport{
+		TripID:     models.TripID{RouteID: "r1", StartTime: start},
+		EndTime:    end,
+		CarriageID: 10,
+		Carts: []models.Cart{
+			{
+				CartID:        models.CartID{EmployeeID: "empA", OperationTime: op1},
+				OperationType: 1,
+				Items:         []models.Item{{ProductID: 1, Quantity: 5, Price: 100}, {ProductID: 2, Quantity: 1, Price: 200}},
+			},
+			{
+				CartID:        models.CartID{EmployeeID: "empB", OperationTime: op2},
+				OperationType: 1,
+				Items:         []models.Item{{ProductID: 3, Quantity: 1, Price: 300}},
+			},
+		},
+	}
+
+	diff, err := repo.ReplaceCarriageReport(context.Background(), report, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ReportDiff{Inserted: 1, Updated: 1, Deleted: 1, Unchanged: 1}, diff)
+
+	mockSession.AssertExpectations(t)
+	batch.AssertExpectations(t)
+}
+
+func TestReplaceCarriageReport_ReadError(t *testing.T) {
+	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	fq := new(FakeQuery)
+	fq.On("WithContext", mock.Anything).Return(fq)
+	fq.On("Iter").Return(&fakeTripIter{closeErr: fmt.Errorf("read timeout")})
+	mockSession.On("Query", getTripQuery, mock.Anything).Return(fq)
+
+	report := &models.CarriageReport{TripID: models.TripID{RouteID: "r1", StartTime: time.Now()}, CarriageID: 1}
+	_, err := repo.ReplaceCarriageReport(context.Background(), report, nil)
+	assert.EqualError(t, err, "read timeout")
+	mockSession.AssertNotCalled(t, "NewBatch", mock.Anything)
+}
+
 func TestGetEmployeeCartsInTrip(t *testing.T) {
 	// Create a mock session.
 	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	// Create a FakeQuery and set expectations.
@@ -514,7 +752,7 @@ func TestGetEmployeeCartsInTrip(t *testing.T) {
 	employeeID := "testEmp"
 
 	// Call the method under test.
-	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &employeeID)
+	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &employeeID, models.CartFilter{})
 	assert.NoError(t, err)
 
 	// Verify that two carts were aggregated.
@@ -561,7 +799,7 @@ func TestGetEmployeeCartsInTrip_AggregateCloseError(t *testing.T) {
 	}
 	empID := "emp123"
 
-	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &empID)
+	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &empID, models.CartFilter{})
 	assert.Nil(t, carts)
 	assert.Error(t, err)
 	// We expect the error from fakeIterWithError.
@@ -593,7 +831,7 @@ func TestGetEmployeeCartsInTrip_PostAggregateCloseError(t *testing.T) {
 	}
 	empID := "emp123"
 
-	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &empID)
+	carts, err := repo.GetEmployeeCartsInTrip(context.Background(), tripID, &empID, models.CartFilter{})
 	assert.Nil(t, carts)
 	assert.Error(t, err)
 	// The error is from the second call to Close.
@@ -614,6 +852,7 @@ func (f *fakeUnsyncIter) PageState() []byte               { return nil }
 
 func TestGetEmployeeCartsInTripPaged_FirstPage_WithLimit_ReturnsTwoCarts_AndCursor(t *testing.T) {
 	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	// Times
@@ -647,8 +886,9 @@ func TestGetEmployeeCartsInTripPaged_FirstPage_WithLimit_ReturnsTwoCarts_AndCurs
 	tripID := &models.TripID{RouteID: "routeX", Year: "2025", StartTime: start}
 	emp := "emp1"
 
-	carts, next, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, 2, "")
+	page, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, models.CartFilter{}, 2, "")
 	assert.NoError(t, err)
+	carts := page.Carts
 
 	// Should return 2 complete carts (op1 and op2)
 	require := assert.New(t)
@@ -676,8 +916,9 @@ func TestGetEmployeeCartsInTripPaged_FirstPage_WithLimit_ReturnsTwoCarts_AndCurs
 	require.True(foundOp1)
 	require.True(foundOp2)
 
-	// We should get a non-empty cursor based on last emitted cart (op2)
-	assert.NotEmpty(t, next)
+	// We should get a non-empty cursor based on last emitted cart (op2), and none for the first page
+	assert.NotEmpty(t, page.NextCursor)
+	assert.Empty(t, page.PrevCursor)
 
 	mockSession.AssertExpectations(t)
 	q1.AssertExpectations(t)
@@ -685,6 +926,7 @@ func TestGetEmployeeCartsInTripPaged_FirstPage_WithLimit_ReturnsTwoCarts_AndCurs
 
 func TestGetEmployeeCartsInTripPaged_NextPage_WithCursor_ReturnsRemaining_NoCursor(t *testing.T) {
 	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	start := time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)
@@ -712,10 +954,10 @@ func TestGetEmployeeCartsInTripPaged_NextPage_WithCursor_ReturnsRemaining_NoCurs
 
 	tripID := &models.TripID{RouteID: "routeX", Year: "2025", StartTime: start}
 
-	firstPage, cursor, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, 1, "")
+	firstPage, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, models.CartFilter{}, 1, "")
 	assert.NoError(t, err)
-	assert.Len(t, firstPage, 1)
-	assert.NotEmpty(t, cursor)
+	assert.Len(t, firstPage.Carts, 1)
+	assert.NotEmpty(t, firstPage.NextCursor)
 	mockSession.AssertExpectations(t)
 	q1.AssertExpectations(t)
 
@@ -729,12 +971,16 @@ func TestGetEmployeeCartsInTripPaged_NextPage_WithCursor_ReturnsRemaining_NoCurs
 	q2.On("WithContext", mock.Anything).Return(q2)
 	q2.On("Iter").Return(iter2)
 	mockSession.ExpectedCalls = nil // reset expectations for second call
-	mockSession.On("Query", getEmployeeCartsInTripAfterCursorQuery, mock.Anything).Return(q2)
+	expectReportCommits(mockSession)
+	// The cursor moves the upper bound of the window right below the last cart of the first page
+	mockSession.On("Query", getEmployeeCartsInTripQuery,
+		[]interface{}{&tripID.RouteID, &tripID.Year, &tripID.StartTime, &emp, minOperationTime, op1.Add(-time.Millisecond)}).Return(q2)
 
-	secondPage, next, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, 2, cursor)
+	secondPage, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, emp, models.CartFilter{}, 2, firstPage.NextCursor)
 	assert.NoError(t, err)
-	assert.Len(t, secondPage, 1)
-	assert.Empty(t, next)
+	assert.Len(t, secondPage.Carts, 1)
+	assert.Empty(t, secondPage.NextCursor)
+	assert.NotEmpty(t, secondPage.PrevCursor)
 
 	mockSession.AssertExpectations(t)
 	q2.AssertExpectations(t)
@@ -748,7 +994,7 @@ func TestGetEmployeeCartsInTripPaged_InvalidCursor_ReturnsError(t *testing.T) {
 	tripID := &models.TripID{RouteID: "routeX", Year: "2025", StartTime: start}
 
 	// Pass junk base64 to trigger decode error
-	_, _, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, "emp1", 2, "!!!not-base64!!!")
+	_, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, "emp1", models.CartFilter{}, 2, "!!!not-base64!!!")
 	assert.Error(t, err)
 	assert.EqualError(t, err, "invalid cursor")
 
@@ -758,6 +1004,7 @@ func TestGetEmployeeCartsInTripPaged_InvalidCursor_ReturnsError(t *testing.T) {
 
 func TestGetEmployeeCartsInTripPaged_NoLimit_ReturnsAll_NoCursor(t *testing.T) {
 	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	start := time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)
@@ -779,10 +1026,11 @@ func TestGetEmployeeCartsInTripPaged_NoLimit_ReturnsAll_NoCursor(t *testing.T) {
 
 	tripID := &models.TripID{RouteID: "routeX", Year: "2025", StartTime: start}
 
-	carts, next, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, "emp1", 0, "")
+	page, err := repo.GetEmployeeCartsInTripPaged(context.Background(), tripID, "emp1", models.CartFilter{}, 0, "")
 	assert.NoError(t, err)
-	assert.Len(t, carts, 2) // two carts (op1, op2)
-	assert.Empty(t, next)
+	assert.Len(t, page.Carts, 2) // two carts (op1, op2)
+	assert.Empty(t, page.NextCursor)
+	assert.Empty(t, page.PrevCursor)
 
 	mockSession.AssertExpectations(t)
 	q.AssertExpectations(t)
@@ -797,15 +1045,15 @@ func TestGetEmployeeIDsByTrip(t *testing.T) {
 	fakeQuery := new(FakeQuery)
 	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
 
-	// Prepare a fake iterator that returns multiple employee IDs, including duplicates.
+	// Prepare a fake iterator that returns the employees of the trip.
 	fakeIter := &simpleFakeIterString{
-		employeeIDs: []string{"emp1", "emp2", "emp1"},
+		employeeIDs: []string{"emp1", "emp2"},
 		index:       0,
 	}
 	fakeQuery.On("Iter").Return(fakeIter)
 
-	// Expect the repository to call Query on the session.
-	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
+	// Expect the repository to read the trip_employees partition.
+	mockSession.On("Query", getTripEmployeesQuery, []interface{}{"route_test", "2023", time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)}).Return(fakeQuery)
 
 	// Define a test TripID.
 	tripID := &models.TripID{
@@ -818,7 +1066,6 @@ func TestGetEmployeeIDsByTrip(t *testing.T) {
 	employeeIDs, err := repo.GetEmployeeIDsByTrip(context.Background(), tripID)
 	assert.NoError(t, err)
 
-	// Expect only unique IDs.
 	expectedIDs := []string{"emp1", "emp2"}
 	assert.ElementsMatch(t, expectedIDs, employeeIDs)
 
@@ -957,12 +1204,33 @@ func TestGetEmployeeTrips_CloseError(t *testing.T) {
 	fakeQuery.AssertExpectations(t)
 }
 
+// expectItemChange Makes the conditional change of an item named change return applied and err
+func expectItemChange(m *MockSession, change string, values interface{}, applied bool, err error) *FakeQuery {
+	q := new(FakeQuery)
+	q.On("WithContext", mock.Anything).Return(q)
+	q.On("ScanCAS", mock.Anything).Return(applied, err).Once()
+	m.On("Query", statementNamed(change), values).Return(q).Once()
+	return q
+}
+
+// expectCorrection Expects the correction of product 1 in the cart of employee 12345 to be recorded
+func expectCorrection(m *MockSession, err error) *FakeQuery {
+	q := new(FakeQuery)
+	q.On("WithContext", mock.Anything).Return(q)
+	q.On("Exec").Return(err).Once()
+	m.On("Query", statementNamed("insert_correction"), mock.MatchedBy(func(values []interface{}) bool {
+		return values[2] == "12345" && values[6] == 1
+	})).Return(q).Once()
+	return q
+}
+
 func TestUpdateItemQuantity(t *testing.T) {
 	mockSession := new(MockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	tripID := &models.TripID{
 		RouteID:   "route_test",
+		Year:      "2023",
 		StartTime: time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC),
 	}
 	cartID := &models.CartID{
@@ -972,98 +1240,69 @@ func TestUpdateItemQuantity(t *testing.T) {
 	productID := 1
 	newQuantity := int16(15)
 
-	// Create a fake query and set expectations for ScanCAS.
-	fakeQuery := new(FakeQuery)
-	fakeQuery.On("ScanCAS", mock.Anything).Return(true, nil)
-	// For WithContext, simply return the fakeQuery itself.
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
-
-	// Expect Query to be called with a statement matching the update query.
-	updateQueryMatcher := mock.MatchedBy(func(stmt string) bool {
-		return stmt == updateItemQuantityQuery || len(stmt) > 0 && stmt[0:6] == "UPDATE"
-	})
-	mockSession.On("Query", updateQueryMatcher, mock.Anything).Return(fakeQuery)
+	update := expectItemChange(mockSession, "update_item_quantity", []interface{}{
+		&newQuantity, "route_test", "2023", tripID.StartTime, "12345", cartID.OperationTime, &productID,
+	}, true, nil)
+	// The correction is recorded once the update is applied
+	correction := expectCorrection(mockSession, nil)
 
 	err := repo.UpdateItemQuantity(context.Background(), tripID, cartID, &productID, &newQuantity)
 	assert.NoError(t, err)
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
+	update.AssertExpectations(t)
+	correction.AssertExpectations(t)
 }
 
 func TestUpdateItemQuantity_ScanCASError(t *testing.T) {
-	// Create a mock session and repository.
 	mockSession := new(MockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
-	// Define dummy parameters.
-	tripID := &models.TripID{
-		RouteID:   "route_test",
-		Year:      "2023",
-		StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC),
-	}
-	cartID := &models.CartID{
-		EmployeeID:    "12345",
-		OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC),
-	}
+	tripID := &models.TripID{RouteID: "route_test", Year: "2023", StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)}
+	cartID := &models.CartID{EmployeeID: "12345", OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)}
 	productID := 1
 	newQuantity := int16(15)
 
-	// Set up a fake query.
-	fakeQuery := new(FakeQuery)
-	// When WithContext is called, return the fakeQuery.
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
-	// Simulate ScanCAS returning an error.
 	scanErr := fmt.Errorf("scan error")
-	fakeQuery.On("ScanCAS", mock.Anything).Return(false, scanErr)
+	expectItemChange(mockSession, "update_item_quantity", mock.Anything, false, scanErr)
 
-	// Expect Query to be called on the session.
-	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
-
-	// Call the method under test.
+	// The update may not have been applied, so no correction is recorded
 	err := repo.UpdateItemQuantity(context.Background(), tripID, cartID, &productID, &newQuantity)
-	// Assert that an error is returned and it matches the simulated error.
-	assert.Error(t, err)
 	assert.Equal(t, scanErr, err)
-
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
 }
 
 func TestUpdateItemQuantity_NotApplied(t *testing.T) {
-	// Create a mock session and repository.
 	mockSession := new(MockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
-	// Define dummy parameters.
-	tripID := &models.TripID{
-		RouteID:   "route_test",
-		Year:      "2023",
-		StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC),
-	}
-	cartID := &models.CartID{
-		EmployeeID:    "12345",
-		OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC),
-	}
+	tripID := &models.TripID{RouteID: "route_test", Year: "2023", StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)}
+	cartID := &models.CartID{EmployeeID: "12345", OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)}
 	productID := 1
 	newQuantity := int16(15)
 
-	// Set up a fake query.
-	fakeQuery := new(FakeQuery)
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
-	// Simulate ScanCAS returning (false, nil) indicating that the update was not applied.
-	fakeQuery.On("ScanCAS", mock.Anything).Return(false, nil)
-
-	// Expect Query to be called on the session.
-	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
+	expectItemChange(mockSession, "update_item_quantity", mock.Anything, false, nil)
 
-	// Call the method under test.
 	err := repo.UpdateItemQuantity(context.Background(), tripID, cartID, &productID, &newQuantity)
-	// Assert that an error is returned with the message "transaction does not exist".
-	assert.Error(t, err)
 	assert.EqualError(t, err, "transaction does not exist")
+	mockSession.AssertExpectations(t)
+}
+
+func TestUpdateItemQuantity_CorrectionError(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
+	tripID := &models.TripID{RouteID: "route_test", Year: "2023", StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)}
+	cartID := &models.CartID{EmployeeID: "12345", OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)}
+	productID := 1
+	newQuantity := int16(15)
+
+	expectItemChange(mockSession, "update_item_quantity", mock.Anything, true, nil)
+	expectCorrection(mockSession, fmt.Errorf("write timeout"))
+
+	// The update is applied, a lost correction record does not fail it
+	err := repo.UpdateItemQuantity(context.Background(), tripID, cartID, &productID, &newQuantity)
+	assert.NoError(t, err)
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
 }
 
 func TestDeleteItemFromCart(t *testing.T) {
@@ -1081,59 +1320,71 @@ func TestDeleteItemFromCart(t *testing.T) {
 	}
 	productID := 1
 
-	fakeQuery := new(FakeQuery)
-	fakeQuery.On("ScanCAS", mock.Anything).Return(true, nil)
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
+	deleteItem := expectItemChange(mockSession, "delete_item_from_cart",
+		[]interface{}{"route_test", "2023", tripID.StartTime, "12345", cartID.OperationTime, &productID}, true, nil)
+	correction := expectCorrection(mockSession, nil)
 
-	deleteQueryMatcher := mock.MatchedBy(func(stmt string) bool {
-		return stmt == deleteItemFromCartQuery || len(stmt) > 0 && stmt[0:6] == "DELETE"
-	})
-	mockSession.On("Query", deleteQueryMatcher, mock.Anything).Return(fakeQuery)
+	// The employee has other operations in the trip, so it stays in trip_employees
+	checkQuery := new(FakeQuery)
+	checkQuery.On("WithContext", mock.Anything).Return(checkQuery)
+	checkQuery.On("Iter").Return(&rowsIter{rows: [][]interface{}{{"12345"}}})
+	mockSession.On("Query", hasEmployeeOperationsQuery, []interface{}{"route_test", "2023", tripID.StartTime, "12345"}).Return(checkQuery).Once()
 
 	err := repo.DeleteItemFromCart(context.Background(), tripID, cartID, &productID)
 	assert.NoError(t, err)
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
+	deleteItem.AssertExpectations(t)
+	correction.AssertExpectations(t)
+}
+
+func TestDeleteItemFromCart_PrunesTripEmployee(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2023", StartTime: start}
+	cartID := &models.CartID{EmployeeID: "12345", OperationTime: start.Add(time.Hour)}
+	productID := 1
+
+	expectItemChange(mockSession, "delete_item_from_cart", mock.Anything, true, nil)
+	// A lost correction record does not stop the trip employees from being pruned
+	expectCorrection(mockSession, fmt.Errorf("write timeout"))
+
+	// The deleted item was the last operation of the employee in the trip
+	checkQuery := new(FakeQuery)
+	checkQuery.On("WithContext", mock.Anything).Return(checkQuery)
+	checkQuery.On("Iter").Return(&rowsIter{})
+	mockSession.On("Query", hasEmployeeOperationsQuery, []interface{}{"r1", "2023", start, "12345"}).Return(checkQuery).Once()
+
+	prune := new(FakeQuery)
+	prune.On("WithContext", mock.Anything).Return(prune)
+	prune.On("Exec").Return(nil).Once()
+	mockSession.On("Query", deleteTripEmployeeQuery, []interface{}{"r1", "2023", start, "12345"}).Return(prune).Once()
+
+	assert.NoError(t, repo.DeleteItemFromCart(context.Background(), tripID, cartID, &productID))
+	mockSession.AssertExpectations(t)
+	prune.AssertExpectations(t)
 }
 
 func TestDeleteItemFromCart_ScanCASError(t *testing.T) {
-	// This test simulates an error from ScanCAS.
 	mockSession := new(MockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
-	tripID := &models.TripID{
-		RouteID:   "route_test",
-		Year:      "2023",
-		StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC),
-	}
-	cartID := &models.CartID{
-		EmployeeID:    "12345",
-		OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC),
-	}
+	tripID := &models.TripID{RouteID: "route_test", Year: "2023", StartTime: time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)}
+	cartID := &models.CartID{EmployeeID: "12345", OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)}
 	productID := 1
 
-	// Create a fake query and set expectations.
-	fakeQuery := new(FakeQuery)
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
-	// Simulate ScanCAS returning an error.
 	scanErr := fmt.Errorf("scan error")
-	fakeQuery.On("ScanCAS", mock.Anything).Return(false, scanErr)
+	expectItemChange(mockSession, "delete_item_from_cart", mock.Anything, false, scanErr)
 
-	// Expect Query to be called on the session.
-	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
-
-	// Call DeleteItemFromCart, which should hit the error branch.
+	// Neither the correction nor trip_employees is written
 	err := repo.DeleteItemFromCart(context.Background(), tripID, cartID, &productID)
-	assert.Error(t, err)
 	assert.Equal(t, scanErr, err)
-
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
 }
 
 func TestDeleteItemFromCart_NotDeleted(t *testing.T) {
-	// This test simulates a case where ScanCAS succeeds but returns false,
-	// so the deletion is not applied.
+	// The item does not exist, so nothing is written
 	mockSession := new(MockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
@@ -1148,23 +1399,16 @@ func TestDeleteItemFromCart_NotDeleted(t *testing.T) {
 	}
 	productID := 1
 
-	fakeQuery := new(FakeQuery)
-	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
-	// Simulate ScanCAS returning (false, nil) to indicate the item was not deleted.
-	fakeQuery.On("ScanCAS", mock.Anything).Return(false, nil)
-
-	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
+	expectItemChange(mockSession, "delete_item_from_cart", mock.Anything, false, nil)
 
 	err := repo.DeleteItemFromCart(context.Background(), tripID, cartID, &productID)
-	assert.Error(t, err)
 	assert.EqualError(t, err, "item does not exist")
-
 	mockSession.AssertExpectations(t)
-	fakeQuery.AssertExpectations(t)
 }
 
 func TestGetTrip(t *testing.T) {
 	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
 	repo := NewSalesRepository(mockSession, log.NewNopLogger())
 
 	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
@@ -1217,6 +1461,55 @@ func TestGetTrip(t *testing.T) {
 	fakeQuery.AssertExpectations(t)
 }
 
+func TestGetTrip_Ordered(t *testing.T) {
+	mockSession := new(MockSession)
+	expectReportCommits(mockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	later := start.Add(40 * time.Minute)
+	earlier := start.Add(30 * time.Minute)
+
+	// Rows come in partition order, by employee first
+	iter := &fakeTripIter{
+		rows: []tripOpRow{
+			{"r1", start, "empA", later, 1, 2, end, 1, 50, 1},
+			{"r1", start, "empA", earlier, 1, 1, end, 1, 100, 2},
+			{"r1", start, "empB", earlier, 7, 1, end, 1, 200, 1},
+			{"r1", start, "empB", earlier, 3, 1, end, 1, 150, 1},
+			{"r1", start, "empC", start, 5, 1, end, 2, 80, -1},
+		},
+	}
+	fakeQuery := new(FakeQuery)
+	fakeQuery.On("WithContext", mock.Anything).Return(fakeQuery)
+	fakeQuery.On("Iter").Return(iter)
+	mockSession.On("Query", mock.Anything, mock.Anything).Return(fakeQuery)
+	tripID := &models.TripID{RouteID: "r1", Year: "2023", StartTime: start}
+
+	for i := 0; i < 5; i++ {
+		iter.index = 0
+		got, err := repo.GetTrip(context.Background(), tripID)
+		require.NoError(t, err)
+
+		// Carriages by ID, carts by operation time then employee, items by product
+		assert.Equal(t, models.Trip{Carriage: []models.CarriageReport{
+			{TripID: *tripID, EndTime: end, CarriageID: 1, Carts: []models.Cart{
+				{CartID: models.CartID{EmployeeID: "empC", OperationTime: start}, OperationType: 2,
+					Items: []models.Item{{ProductID: 5, Quantity: -1, Price: 80}}},
+				{CartID: models.CartID{EmployeeID: "empA", OperationTime: earlier}, OperationType: 1,
+					Items: []models.Item{{ProductID: 1, Quantity: 2, Price: 100}}},
+				{CartID: models.CartID{EmployeeID: "empB", OperationTime: earlier}, OperationType: 1,
+					Items: []models.Item{{ProductID: 3, Quantity: 1, Price: 150}, {ProductID: 7, Quantity: 1, Price: 200}}},
+			}},
+			{TripID: *tripID, EndTime: end, CarriageID: 2, Carts: []models.Cart{
+				{CartID: models.CartID{EmployeeID: "empA", OperationTime: later}, OperationType: 1,
+					Items: []models.Item{{ProductID: 1, Quantity: 1, Price: 50}}},
+			}},
+		}}, got)
+	}
+}
+
 /* ----------------------- GetTrip: iterator.Close error -------------------- */
 
 func TestGetTrip_CloseError(t *testing.T) {
@@ -1327,3 +1620,692 @@ func TestDeleteSyncedTrip_ScanError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, scanErr, err)
 }
+
+func TestRequeueTrip(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2023", StartTime: start}
+
+	fq := new(FakeQuery)
+	fq.On("WithContext", mock.Anything).Return(fq)
+	fq.On("Exec").Return(nil).Once()
+	mockSession.On("Query", insertUnsynchronizedTripQuery, []interface{}{"r1", start, "2023"}).Return(fq)
+
+	err := repo.RequeueTrip(context.Background(), tripID)
+	assert.NoError(t, err)
+	mockSession.AssertExpectations(t)
+
+	fq.On("Exec").Return(fmt.Errorf("write timeout"))
+	err = repo.RequeueTrip(context.Background(), tripID)
+	assert.EqualError(t, err, "write timeout")
+}
+
+func TestGetRouteTrips_SpansYears(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	from := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	dec := time.Date(2023, 12, 30, 8, 0, 0, 0, time.UTC)
+	jan := time.Date(2024, 1, 5, 8, 0, 0, 0, time.UTC)
+
+	q2023 := new(FakeQuery)
+	q2023.On("WithContext", mock.Anything).Return(q2023)
+	q2023.On("Iter").Return(&rowsIter{rows: [][]interface{}{{dec}}})
+	mockSession.On("Query", getRouteTripsQuery, []interface{}{"r1", "2023", from, to}).Return(q2023)
+
+	q2024 := new(FakeQuery)
+	q2024.On("WithContext", mock.Anything).Return(q2024)
+	q2024.On("Iter").Return(&rowsIter{rows: [][]interface{}{{jan}}})
+	mockSession.On("Query", getRouteTripsQuery, []interface{}{"r1", "2024", from, to}).Return(q2024)
+
+	trips, err := repo.GetRouteTrips(context.Background(), "r1", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TripID{
+		{RouteID: "r1", Year: "2023", StartTime: dec},
+		{RouteID: "r1", Year: "2024", StartTime: jan},
+	}, trips)
+	mockSession.AssertExpectations(t)
+}
+
+func TestGetRouteTrips_IterError(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	fq := new(FakeQuery)
+	fq.On("WithContext", mock.Anything).Return(fq)
+	fq.On("Iter").Return(&rowsIter{closeErr: fmt.Errorf("unavailable")})
+	mockSession.On("Query", getRouteTripsQuery, mock.Anything).Return(fq)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := repo.GetRouteTrips(context.Background(), "r1", from, from.AddDate(0, 1, 0))
+	assert.EqualError(t, err, "unavailable")
+}
+
+func TestBackfillRouteTrips(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	s1 := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	s2 := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+
+	scan := new(FakeQuery)
+	scan.On("WithContext", mock.Anything).Return(scan)
+	scan.On("Iter").Return(&rowsIter{rows: [][]interface{}{{"r1", "2023", s1}, {"r2", "2024", s2}}})
+	mockSession.On("Query", getTripKeysQuery, mock.Anything).Return(scan)
+
+	insert := new(FakeQuery)
+	insert.On("WithContext", mock.Anything).Return(insert)
+	insert.On("Exec").Return(nil).Twice()
+	mockSession.On("Query", insertRouteTripQuery, []interface{}{"r1", "2023", s1, nil}).Return(insert).Once()
+	mockSession.On("Query", insertRouteTripQuery, []interface{}{"r2", "2024", s2, nil}).Return(insert).Once()
+
+	n, err := repo.BackfillRouteTrips(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	mockSession.AssertExpectations(t)
+	insert.AssertExpectations(t)
+}
+
+func TestBackfillTripEmployees(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	scan := new(FakeQuery)
+	scan.On("WithContext", mock.Anything).Return(scan)
+	scan.On("Iter").Return(&rowsIter{rows: [][]interface{}{{"r1", "2023", start}}})
+	mockSession.On("Query", getTripKeysQuery, mock.Anything).Return(scan)
+
+	// Employees appear once per operation and are deduplicated before the insert
+	ops := new(FakeQuery)
+	ops.On("WithContext", mock.Anything).Return(ops)
+	ops.On("Iter").Return(&rowsIter{rows: [][]interface{}{{"empA"}, {"empB"}, {"empA"}}})
+	mockSession.On("Query", getOperationEmployeeIDsQuery, []interface{}{"r1", "2023", start}).Return(ops)
+
+	insert := new(FakeQuery)
+	insert.On("WithContext", mock.Anything).Return(insert)
+	insert.On("Exec").Return(nil).Twice()
+	mockSession.On("Query", insertTripEmployeeQuery, []interface{}{"r1", "2023", start, "empA"}).Return(insert).Once()
+	mockSession.On("Query", insertTripEmployeeQuery, []interface{}{"r1", "2023", start, "empB"}).Return(insert).Once()
+
+	n, err := repo.BackfillTripEmployees(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	mockSession.AssertExpectations(t)
+	insert.AssertExpectations(t)
+}
+
+func TestDeleteTrip(t *testing.T) {
+	mockSession := new(MockSession)
+	repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+	start := time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2021", StartTime: start}
+	deletedAt := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	repo.now = func() time.Time { return deletedAt }
+
+	// The trip has neither aggregates nor receipts, so everything is deleted in one batch
+	for _, stmt := range []string{getTripRevenueQuery, getTripReceiptsQuery} {
+		fq := new(FakeQuery)
+		fq.On("WithContext", mock.Anything).Return(fq)
+		fq.On("Iter").Return(&rowsIter{})
+		mockSession.On("Query", stmt, []interface{}{"r1", "2021", start}).Return(fq)
+	}
+
+	batch := new(FakeBatch)
+	batch.On("WithContext", mock.Anything).Return(batch)
+	batch.On("Query", deleteTripOperationsQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteEmployeeTripQuery, []interface{}{"empA", "2021", start, "r1"}).Once().Return()
+	batch.On("Query", deleteEmployeeTripQuery, []interface{}{"empB", "2021", start, "r1"}).Once().Return()
+	batch.On("Query", deleteTripEmployeesQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteRouteTripQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteReportCommitsQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteUnsynchronizedTripQuery, []interface{}{"r1", start}).Once().Return()
+	batch.On("Query", deleteClockSkewReportsQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteTripReceiptsQuery, []interface{}{"r1", "2021", start}).Once().Return()
+	batch.On("Query", deleteTripRevenueQuery, []interface{}{deletedAt.UnixMicro(), "r1", "2021", start}).Once().Return()
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(batch)
+	mockSession.On("ExecuteBatch", batch).Return(nil).Once()
+
+	err := repo.DeleteTrip(context.Background(), tripID, []string{"empA", "empB"})
+	assert.NoError(t, err)
+	batch.AssertExpectations(t)
+
+	mockSession.On("ExecuteBatch", batch).Return(fmt.Errorf("write timeout"))
+	batch.On("Query", mock.Anything, mock.Anything).Return()
+	err = repo.DeleteTrip(context.Background(), tripID, nil)
+	assert.ErrorContains(t, err, "write timeout")
+}
+
+func TestGetReceipt(t *testing.T) {
+	start := time.Date(2023, 1, 15, 10, 0, 0, 0, time.UTC)
+	received := start.Add(5 * time.Hour)
+
+	tests := []struct {
+		name     string
+		iter     *rowsIter
+		expected models.Receipt
+		err      error
+	}{
+		{
+			name: "Found",
+			iter: &rowsIter{rows: [][]interface{}{
+				{"r1", "2023", start, int8(3), "sha256:abc", 2, 7, received},
+			}},
+			expected: models.Receipt{
+				ReportID:    "report-1",
+				TripID:      models.TripID{RouteID: "r1", Year: "2023", StartTime: start},
+				CarriageID:  3,
+				ContentHash: "sha256:abc",
+				Carts:       2,
+				Items:       7,
+				ReceivedAt:  received,
+			},
+		},
+		{
+			name: "Not found",
+			iter: &rowsIter{},
+			err:  models.ErrNotFound,
+		},
+		{
+			name: "Close error",
+			iter: &rowsIter{closeErr: fmt.Errorf("read timeout")},
+			err:  fmt.Errorf("read timeout"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSession := new(MockSession)
+			repo := NewSalesRepository(mockSession, log.NewNopLogger())
+
+			fq := new(FakeQuery)
+			fq.On("WithContext", mock.Anything).Return(fq)
+			fq.On("Iter").Return(tt.iter)
+			mockSession.On("Query", getReceiptQuery, mock.Anything).Return(fq)
+
+			got, err := repo.GetReceipt(context.Background(), "report-1")
+			if tt.err != nil {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err.Error())
+				if tt.err == models.ErrNotFound {
+					assert.ErrorIs(t, err, models.ErrNotFound)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+// --- TESTS AGAINST THE MEMORY SESSION ---
+
+func memoryTestReport(carriageID int8, carts ...models.Cart) *models.CarriageReport {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	return &models.CarriageReport{
+		TripID:     models.TripID{RouteID: "r1", Year: "2024", StartTime: start},
+		EndTime:    start.Add(4 * time.Hour),
+		CarriageID: carriageID,
+		Carts:      carts,
+	}
+}
+
+func memoryTestCart(employeeID string, minute int, items ...models.Item) models.Cart {
+	return models.Cart{
+		CartID: models.CartID{
+			EmployeeID:    employeeID,
+			OperationTime: time.Date(2024, 3, 1, 9, minute, 0, 0, time.UTC),
+		},
+		OperationType: models.OperationTypeSale,
+		Items:         items,
+	}
+}
+
+func TestInsertData_StoresReport(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	report := memoryTestReport(3,
+		memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}, models.Item{ProductID: 2, Quantity: 1, Price: 50}),
+		memoryTestCart("e2", 5, models.Item{ProductID: 1, Quantity: 1, Price: 100}),
+	)
+	ctx := context.Background()
+
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+
+	trip, err := repo.GetTrip(ctx, &report.TripID)
+	require.NoError(t, err)
+	require.Len(t, trip.Carriage, 1)
+	assert.Equal(t, int8(3), trip.Carriage[0].CarriageID)
+	assert.True(t, report.EndTime.Equal(trip.Carriage[0].EndTime))
+	assert.ElementsMatch(t, report.Carts, trip.Carriage[0].Carts)
+
+	employees, err := repo.GetEmployeeIDsByTrip(ctx, &report.TripID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e1", "e2"}, employees)
+
+	assert.Len(t, session.rows(t, "operations"), 3)
+	assert.Len(t, session.rows(t, "route_trips"), 1)
+	commits := session.rows(t, "report_commits")
+	require.Len(t, commits, 1)
+	assert.Equal(t, int64(3), commits[0]["operations"])
+	assert.Equal(t, int64(1), commits[0]["batches"])
+}
+
+func TestInsertData_SplitReportStoresEveryChunk(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	var carts []models.Cart
+	for minute := 0; minute < 5; minute++ {
+		carts = append(carts, memoryTestCart("e1", minute, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	}
+	report := memoryTestReport(1, carts...)
+	_, statements := reportOperations(report)
+	repo.maxBatchBytes = statementsSize(statements[:2])
+
+	require.NoError(t, repo.InsertData(context.Background(), report, nil))
+
+	assert.Len(t, session.rows(t, "operations"), 5)
+	commits := session.rows(t, "report_commits")
+	require.Len(t, commits, 1)
+	assert.Equal(t, int64(4), commits[0]["batches"])
+}
+
+func TestInsertData_UncommittedReportIsHidden(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	var carts []models.Cart
+	for minute := 0; minute < 5; minute++ {
+		carts = append(carts, memoryTestCart("e1", minute, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	}
+	report := memoryTestReport(1, carts...)
+	_, statements := reportOperations(report)
+	repo.maxBatchBytes = statementsSize(statements[:2])
+	employeeID := "e1"
+
+	// Every chunk is stored but the final batch with the commit marker fails
+	session.failOn("insert_report_commit", fmt.Errorf("write timeout"))
+	require.ErrorContains(t, repo.InsertData(ctx, report, nil), "write timeout")
+	assert.Len(t, session.rows(t, "operations"), 5)
+
+	trip, err := repo.GetTrip(ctx, &report.TripID)
+	require.NoError(t, err)
+	assert.Empty(t, trip.Carriage)
+	carts, err = repo.GetEmployeeCartsInTrip(ctx, &report.TripID, &employeeID, models.CartFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, carts)
+	page, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, employeeID, models.CartFilter{}, 2, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Carts)
+
+	// A replacement does not count the uncommitted rows as stored and removes the ones it does not keep,
+	// it is applied in a single batch
+	repo.maxBatchBytes = defaultMaxBatchBytes
+	replacement := memoryTestReport(1, report.Carts[:2]...)
+	_, err = repo.ReplaceCarriageReport(ctx, replacement, nil)
+	require.ErrorContains(t, err, "write timeout")
+	session.failOn("insert_report_commit", nil)
+	diff, err := repo.ReplaceCarriageReport(ctx, replacement, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReportDiff{Inserted: 2}, diff)
+	assert.Len(t, session.rows(t, "operations"), 2)
+
+	trip, err = repo.GetTrip(ctx, &report.TripID)
+	require.NoError(t, err)
+	require.Len(t, trip.Carriage, 1)
+	assert.Len(t, trip.Carriage[0].Carts, 2)
+}
+
+func TestChunkStatements_BySize(t *testing.T) {
+	small := batchStatement{stmt: deleteRouteTripQuery, values: []interface{}{"r1", "2024", time.Time{}}}
+	large := batchStatement{stmt: insertRouteQuery, values: []interface{}{string(make([]byte, 100))}}
+	limit := 2 * small.size()
+
+	chunks := chunkStatements([]batchStatement{small, small, small, large, small}, limit)
+	assert.Equal(t, [][]batchStatement{{small, small}, {small}, {large}, {small}}, chunks)
+	assert.Empty(t, chunkStatements(nil, limit))
+}
+
+func TestReplaceCarriageReport_StoresDiff(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	require.NoError(t, repo.InsertData(ctx, memoryTestReport(1,
+		memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}, models.Item{ProductID: 2, Quantity: 1, Price: 50}),
+		memoryTestCart("e2", 5, models.Item{ProductID: 1, Quantity: 1, Price: 100}),
+	), nil))
+
+	replacement := memoryTestReport(1,
+		memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 3, Price: 100}, models.Item{ProductID: 2, Quantity: 1, Price: 50}),
+		memoryTestCart("e1", 10, models.Item{ProductID: 4, Quantity: 1, Price: 70}),
+	)
+	diff, err := repo.ReplaceCarriageReport(ctx, replacement, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReportDiff{Inserted: 1, Updated: 1, Deleted: 1, Unchanged: 1}, diff)
+
+	trip, err := repo.GetTrip(ctx, &replacement.TripID)
+	require.NoError(t, err)
+	require.Len(t, trip.Carriage, 1)
+	assert.ElementsMatch(t, replacement.Carts, trip.Carriage[0].Carts)
+
+	// e2 has no operation left in the trip
+	employees, err := repo.GetEmployeeIDsByTrip(ctx, &replacement.TripID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"e1"}, employees)
+}
+
+func TestReplaceCarriageReport_Rejected(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	stored := memoryTestReport(2, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}))
+	require.NoError(t, repo.InsertData(ctx, stored, nil))
+
+	tests := []struct {
+		name        string
+		replacement *models.CarriageReport
+		err         string
+	}{
+		{
+			name: "duplicate item",
+			replacement: memoryTestReport(1, memoryTestCart("e1", 5,
+				models.Item{ProductID: 3, Quantity: 1, Price: 100}, models.Item{ProductID: 3, Quantity: 2, Price: 100})),
+			err: "product 3 is listed twice in the cart of employee e1 at 2024-03-01T09:05:00Z",
+		},
+		{
+			name:        "row of another carriage",
+			replacement: memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100})),
+			err:         "product 1 in the cart of employee e1 at 2024-03-01T09:00:00Z is stored for carriage 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := repo.ReplaceCarriageReport(ctx, tt.replacement, nil)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+
+	t.Run("too large for one batch", func(t *testing.T) {
+		repo.maxBatchBytes = 1
+		_, err := repo.ReplaceCarriageReport(ctx, memoryTestReport(1, memoryTestCart("e1", 5, models.Item{ProductID: 3, Quantity: 1, Price: 100})), nil)
+		assert.EqualError(t, err, "replacement changes 1 rows, more than can be applied in one atomic batch")
+	})
+
+	// Nothing was written by the rejected replacements
+	trip, err := repo.GetTrip(ctx, &stored.TripID)
+	require.NoError(t, err)
+	require.Len(t, trip.Carriage, 1)
+	assert.Equal(t, int8(2), trip.Carriage[0].CarriageID)
+	assert.Equal(t, stored.Carts, trip.Carriage[0].Carts)
+}
+
+func TestUpdateAndDeleteItem_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}))
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	cartID := report.Carts[0].CartID
+	productID := 1
+	missingProductID := 9
+	quantity := int16(5)
+
+	require.NoError(t, repo.UpdateItemQuantity(ctx, &report.TripID, &cartID, &productID, &quantity))
+	assert.Error(t, repo.UpdateItemQuantity(ctx, &report.TripID, &cartID, &missingProductID, &quantity))
+	carts, err := repo.GetEmployeeCartsInTrip(ctx, &report.TripID, &cartID.EmployeeID, models.CartFilter{})
+	require.NoError(t, err)
+	require.Len(t, carts, 1)
+	assert.Equal(t, []models.Item{{ProductID: 1, Quantity: 5, Price: 100}}, carts[0].Items)
+
+	require.NoError(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID))
+	assert.Error(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID))
+	assert.Empty(t, session.rows(t, "operations"))
+	assert.Empty(t, session.rows(t, "trip_employees"))
+
+	// An update must not resurrect the deleted item
+	assert.Error(t, repo.UpdateItemQuantity(ctx, &report.TripID, &cartID, &productID, &quantity))
+	assert.Empty(t, session.rows(t, "operations"))
+
+	// The applied update and delete were recorded, the failed attempts were not
+	corrections := session.rows(t, "corrections")
+	require.Len(t, corrections, 1, "both corrections of the item on one day share a row")
+	assert.Equal(t, "e1", corrections[0]["employee_id"])
+	assert.Equal(t, int64(1), corrections[0]["product_id"])
+}
+
+func TestDeleteItemFromCart_RetryKeepsCorrection(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}))
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	cartID := report.Carts[0].CartID
+	productID := 1
+
+	// The delete was applied but its response was lost, the retry finds no item
+	require.NoError(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID))
+	assert.EqualError(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID), "item does not exist")
+
+	corrections, err := repo.GetCorrections(ctx, repo.now())
+	require.NoError(t, err)
+	require.Len(t, corrections, 1)
+	assert.Equal(t, cartID.EmployeeID, corrections[0].CartID.EmployeeID)
+	assert.Equal(t, productID, corrections[0].ProductID)
+
+	// A failed delete records nothing
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	session.failOn("delete_item_from_cart", fmt.Errorf("write timeout"))
+	assert.Error(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID))
+	assert.Len(t, session.rows(t, "operations"), 1)
+	assert.Len(t, session.rows(t, "corrections"), 1)
+
+	// A delete whose correction cannot be recorded is still applied
+	session.failOn("delete_item_from_cart", nil)
+	session.failOn("insert_correction", fmt.Errorf("write timeout"))
+	require.NoError(t, repo.DeleteItemFromCart(ctx, &report.TripID, &cartID, &productID))
+	assert.Empty(t, session.rows(t, "operations"))
+	assert.Empty(t, session.rows(t, "trip_employees"))
+}
+
+func TestGetEmployeeCartsInTripPaged_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1,
+		memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100}),
+		memoryTestCart("e1", 10, models.Item{ProductID: 1, Quantity: 1, Price: 100}, models.Item{ProductID: 2, Quantity: 1, Price: 30}),
+		memoryTestCart("e1", 20, models.Item{ProductID: 3, Quantity: 2, Price: 10}),
+		memoryTestCart("e2", 30, models.Item{ProductID: 3, Quantity: 2, Price: 10}),
+	)
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+
+	// Carts come newest first, following the clustering order of operation_time
+	first, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", models.CartFilter{}, 2, "")
+	require.NoError(t, err)
+	require.Len(t, first.Carts, 2)
+	assert.Equal(t, report.Carts[2].CartID, first.Carts[0].CartID)
+	assert.Equal(t, report.Carts[1].CartID, first.Carts[1].CartID)
+	assert.Len(t, first.Carts[1].Items, 2)
+	assert.Empty(t, first.PrevCursor)
+	require.NotEmpty(t, first.NextCursor)
+
+	rest, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", models.CartFilter{}, 2, first.NextCursor)
+	require.NoError(t, err)
+	require.Len(t, rest.Carts, 1)
+	assert.Equal(t, report.Carts[0].CartID, rest.Carts[0].CartID)
+	assert.Empty(t, rest.NextCursor)
+	require.NotEmpty(t, rest.PrevCursor)
+
+	// The previous cursor leads back to the first page
+	back, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", models.CartFilter{}, 2, rest.PrevCursor)
+	require.NoError(t, err)
+	assert.Equal(t, first.Carts, back.Carts)
+	assert.Empty(t, back.PrevCursor)
+	assert.NotEmpty(t, back.NextCursor)
+}
+
+func TestGetEmployeeCartsInTripPaged_AscendingWindow_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1,
+		memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100}),
+		memoryTestCart("e1", 10, models.Item{ProductID: 2, Quantity: 1, Price: 30}, models.Item{ProductID: 1, Quantity: 1, Price: 100}),
+		memoryTestCart("e1", 20, models.Item{ProductID: 3, Quantity: 2, Price: 10}),
+		memoryTestCart("e1", 30, models.Item{ProductID: 4, Quantity: 1, Price: 10}),
+		memoryTestCart("e1", 40, models.Item{ProductID: 5, Quantity: 1, Price: 10}),
+	)
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	cartIDs := func(carts []models.Cart) []time.Time {
+		var times []time.Time
+		for _, cart := range carts {
+			times = append(times, cart.CartID.OperationTime)
+		}
+		return times
+	}
+	opTime := func(i int) time.Time { return report.Carts[i].CartID.OperationTime }
+
+	// The window keeps the carts from minute 10 up to, not including, minute 40
+	filter := models.CartFilter{Order: models.SortAsc, From: opTime(1), To: opTime(4)}
+	first, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", filter, 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{opTime(1), opTime(2)}, cartIDs(first.Carts))
+	assert.Equal(t, []models.Item{{ProductID: 1, Quantity: 1, Price: 100}, {ProductID: 2, Quantity: 1, Price: 30}}, first.Carts[0].Items)
+	assert.Empty(t, first.PrevCursor)
+
+	last, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", filter, 2, first.NextCursor)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{opTime(3)}, cartIDs(last.Carts))
+	assert.Empty(t, last.NextCursor)
+
+	back, err := repo.GetEmployeeCartsInTripPaged(ctx, &report.TripID, "e1", filter, 2, last.PrevCursor)
+	require.NoError(t, err)
+	assert.Equal(t, first.Carts, back.Carts)
+
+	// The unpaged listing applies the same order and window
+	carts, err := repo.GetEmployeeCartsInTrip(ctx, &report.TripID, &report.Carts[0].CartID.EmployeeID, filter)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{opTime(1), opTime(2), opTime(3)}, cartIDs(carts))
+	assert.Equal(t, first.Carts[0].Items, carts[0].Items)
+
+	carts, err = repo.GetEmployeeCartsInTrip(ctx, &report.TripID, &report.Carts[0].CartID.EmployeeID, models.CartFilter{To: opTime(2)})
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{opTime(1), opTime(0)}, cartIDs(carts))
+
+	// An empty window is not queried
+	carts, err = repo.GetEmployeeCartsInTrip(ctx, &report.TripID, &report.Carts[0].CartID.EmployeeID, models.CartFilter{From: opTime(2), To: opTime(2)})
+	require.NoError(t, err)
+	assert.Empty(t, carts)
+}
+
+func TestDeleteTrip_Stored(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	report.ClockSkew = &models.ClockSkew{DeviceTime: report.TripID.StartTime, ServerTime: report.TripID.StartTime.Add(time.Hour), Skew: time.Hour, Flagged: true}
+	receipt := &models.Receipt{ReportID: "report-1", TripID: report.TripID, CarriageID: 1, Carts: 1, Items: 1}
+	require.NoError(t, repo.InsertData(ctx, report, receipt))
+	require.NoError(t, repo.RefreshTripAggregates(ctx, &report.TripID))
+	require.NotEmpty(t, session.rows(t, "product_daily_revenue"))
+
+	require.NoError(t, repo.DeleteTrip(ctx, &report.TripID, []string{"e1"}))
+
+	for _, table := range []string{"operations", "employee_trips", "trip_employees", "route_trips", "report_commits",
+		"unsynchronized_trips", "clock_skew_reports", "report_receipts", "trip_receipts", "trip_revenue",
+		"route_daily_revenue", "employee_daily_revenue", "product_daily_revenue"} {
+		assert.Empty(t, session.rows(t, table), table)
+	}
+	// The route stays listed
+	assert.Len(t, session.rows(t, "routes"), 1)
+}
+
+func TestInsertData_BatchFailureStoresNothing(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	session.failOn(batchStatementName, fmt.Errorf("write timeout"))
+
+	err := repo.InsertData(context.Background(), memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100})), nil)
+	assert.ErrorContains(t, err, "write timeout")
+	assert.Empty(t, session.rows(t, "operations"))
+}
+
+func TestGetClockSkew_StoredReport(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	deviceTime := time.Date(2024, 3, 1, 11, 59, 0, 0, time.UTC)
+	report.ClockSkew = &models.ClockSkew{
+		DeviceTime: deviceTime,
+		ServerTime: deviceTime.Add(time.Minute),
+		Skew:       time.Minute,
+	}
+
+	_, err := repo.GetClockSkew(ctx, &report.TripID, 1)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	// The skew of a report is stored even when it is not flagged
+	require.NoError(t, repo.InsertData(ctx, report, nil))
+	cs, err := repo.GetClockSkew(ctx, &report.TripID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, *report.ClockSkew, cs)
+}
+
+func TestInsertData_StoresReceiptWithCommit(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 1, Price: 100}))
+	receipt := models.Receipt{
+		ReportID:    "report-1",
+		TripID:      report.TripID,
+		CarriageID:  1,
+		ContentHash: "sha256:abc",
+		Carts:       1,
+		Items:       1,
+		ReceivedAt:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	// The receipt is part of the batch that commits the report, so a failed commit stores neither
+	session.failOn("insert_report_commit", fmt.Errorf("write timeout"))
+	require.ErrorContains(t, repo.InsertData(ctx, report, &receipt), "write timeout")
+	_, err := repo.GetReceipt(ctx, receipt.ReportID)
+	require.ErrorIs(t, err, models.ErrNotFound)
+
+	session.failOn("insert_report_commit", nil)
+	require.NoError(t, repo.InsertData(ctx, report, &receipt))
+	stored, err := repo.GetReceipt(ctx, receipt.ReportID)
+	require.NoError(t, err)
+	assert.Equal(t, receipt, stored)
+
+	// A replacement removing every cart still stores its receipt
+	receipt.ReportID, receipt.Carts, receipt.Items = "report-2", 0, 0
+	_, err = repo.ReplaceCarriageReport(ctx, memoryTestReport(1), &receipt)
+	require.NoError(t, err)
+	stored, err = repo.GetReceipt(ctx, receipt.ReportID)
+	require.NoError(t, err)
+	assert.Equal(t, receipt, stored)
+}
+
+func TestImportData_NotQueuedForSynchronization(t *testing.T) {
+	session := newMemorySession(t)
+	repo := NewSalesRepository(session, log.NewNopLogger())
+	ctx := context.Background()
+	report := memoryTestReport(1, memoryTestCart("e1", 0, models.Item{ProductID: 1, Quantity: 2, Price: 100}))
+
+	require.NoError(t, repo.ImportData(ctx, report))
+
+	trip, err := repo.GetTrip(ctx, &report.TripID)
+	require.NoError(t, err)
+	require.Len(t, trip.Carriage, 1)
+	assert.Equal(t, report.Carts, trip.Carriage[0].Carts)
+	assert.Len(t, session.rows(t, "route_trips"), 1)
+	assert.Len(t, session.rows(t, "trip_employees"), 1)
+	assert.Empty(t, session.rows(t, "unsynchronized_trips"))
+}
@@ -1,29 +1,43 @@
 package cassandra
 
 import (
+	"ChaikaReports/internal/config"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"github.com/go-kit/log"
 	"github.com/gocql/gocql"
 	"time"
 )
 
-func InitCassandra(logger log.Logger, keyspace string, hosts []string, username, password string, timeout time.Duration, delay time.Duration, attempts int) (CassandraSession, error) {
-	cluster := gocql.NewCluster(hosts...)
-	cluster.Keyspace = keyspace
-	cluster.Consistency = gocql.Quorum
-	cluster.Timeout = timeout
-	cluster.Authenticator = gocql.PasswordAuthenticator{
-		Username: username,
-		Password: password,
+// healthCheckQuery is a cheap query answered by the coordinator node alone
+const healthCheckQuery = "SELECT now() FROM system.local"
+
+// defaultConsistency is used for reads and writes when the config does not set a level
+const defaultConsistency = gocql.Quorum
+
+// defaultSerialConsistency is used for conditional statements when the config does not set a level
+const defaultSerialConsistency = gocql.Serial
+
+// InitCassandra Connects to the cluster described by cfg, retrying cfg.RetryAttempts times
+func InitCassandra(logger log.Logger, cfg config.StorageConfig) (CassandraSession, error) {
+	cluster, err := newClusterConfig(cfg)
+	if err != nil {
+		return nil, err
 	}
+	readConsistency, writeConsistency, err := consistencyLevels(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	var session *gocql.Session
-	var err error
-	for i := 0; i < attempts; i++ {
+	for i := 0; i < cfg.RetryAttempts; i++ {
 		session, err = cluster.CreateSession()
 		if err == nil {
 			break
 		}
 		_ = logger.Log("msg", "Failed to create session, retrying", "attempt", i+1, "error", err)
-		time.Sleep(delay)
+		time.Sleep(cfg.RetryDelay)
 	}
 
 	if err != nil {
@@ -31,13 +45,114 @@ func InitCassandra(logger log.Logger, keyspace string, hosts []string, username,
 		return nil, err
 	}
 
-	if err := session.Query("SELECT now() FROM system.local").Exec(); err != nil {
+	if err := session.Query(healthCheckQuery).Exec(); err != nil {
 		_ = logger.Log("msg", "Cassandra health check failed", "error", err)
 		session.Close()
 		return nil, err
 	}
 
-	return &realSession{s: session}, nil
+	rs := &realSession{s: session, readConsistency: readConsistency, writeConsistency: writeConsistency}
+	if cfg.SpeculativeExecution.Enabled {
+		rs.speculative = &gocql.SimpleSpeculativeExecution{
+			NumAttempts:  cfg.SpeculativeExecution.Attempts,
+			TimeoutDelay: cfg.SpeculativeExecution.Delay,
+		}
+	}
+	return rs, nil
+}
+
+// newClusterConfig Builds the driver configuration: token-aware routing (restricted to LocalDC when set),
+// TLS, connection pool size and the query retry policy
+func newClusterConfig(cfg config.StorageConfig) (*gocql.ClusterConfig, error) {
+	cluster := gocql.NewCluster(cfg.Hosts...)
+	cluster.Keyspace = cfg.Keyspace
+	cluster.Timeout = cfg.Timeout
+	cluster.Authenticator = gocql.PasswordAuthenticator{
+		Username: cfg.User,
+		Password: cfg.Password,
+	}
+
+	_, writeConsistency, err := consistencyLevels(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cluster.Consistency = writeConsistency
+
+	// Queries and batches of the session inherit the serial consistency, Cassandra only uses it for IF statements
+	serialConsistency, err := parseSerialConsistency(cfg.SerialConsistency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial consistency: %w", err)
+	}
+	cluster.SerialConsistency = serialConsistency
+
+	if cfg.LocalDC != "" {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.DCAwareRoundRobinPolicy(cfg.LocalDC))
+	} else {
+		cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	}
+
+	if cfg.NumConns > 0 {
+		cluster.NumConns = cfg.NumConns
+	}
+
+	if cfg.TLS.Enabled {
+		cluster.SslOpts = &gocql.SslOptions{
+			Config: &tls.Config{
+				ServerName:         cfg.TLS.ServerName,
+				InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+			},
+			CaPath:                 cfg.TLS.CAFile,
+			CertPath:               cfg.TLS.CertFile,
+			KeyPath:                cfg.TLS.KeyFile,
+			EnableHostVerification: !cfg.TLS.InsecureSkipVerify,
+		}
+	}
+
+	if cfg.QueryRetry.NumRetries > 0 {
+		cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+			NumRetries: cfg.QueryRetry.NumRetries,
+			Min:        cfg.QueryRetry.MinBackoff,
+			Max:        cfg.QueryRetry.MaxBackoff,
+		}
+	}
+
+	return cluster, nil
+}
+
+// consistencyLevels Parses the read and write consistency of cfg, empty levels are QUORUM
+func consistencyLevels(cfg config.StorageConfig) (gocql.Consistency, gocql.Consistency, error) {
+	read, err := parseConsistency(cfg.ReadConsistency)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid read consistency: %w", err)
+	}
+	write, err := parseConsistency(cfg.WriteConsistency)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid write consistency: %w", err)
+	}
+	return read, write, nil
+}
+
+func parseConsistency(level string) (gocql.Consistency, error) {
+	if level == "" {
+		return defaultConsistency, nil
+	}
+	return gocql.ParseConsistencyWrapper(level)
+}
+
+func parseSerialConsistency(level string) (gocql.SerialConsistency, error) {
+	if level == "" {
+		return defaultSerialConsistency, nil
+	}
+	var serial gocql.SerialConsistency
+	if err := serial.UnmarshalText([]byte(level)); err != nil {
+		return 0, err
+	}
+	return serial, nil
+}
+
+// Ping Runs the health check query, used as the readiness probe of the service
+func Ping(ctx context.Context, session CassandraSession) error {
+	return session.Query(healthCheckQuery).WithContext(ctx).Exec()
 }
 
 func CloseCassandra(session CassandraSession) {
@@ -0,0 +1,86 @@
+package cassandra
+
+import (
+	"ChaikaReports/internal/config"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClusterConfig_Defaults(t *testing.T) {
+	cluster, err := newClusterConfig(config.StorageConfig{Hosts: []string{"db1"}, Keyspace: "ks", Timeout: time.Second})
+	require.NoError(t, err)
+
+	assert.Equal(t, gocql.Quorum, cluster.Consistency)
+	assert.Equal(t, gocql.Serial, cluster.SerialConsistency)
+	assert.NotNil(t, cluster.PoolConfig.HostSelectionPolicy)
+	assert.Nil(t, cluster.SslOpts)
+	assert.Equal(t, 2, cluster.NumConns, "driver default is kept")
+	assert.Nil(t, cluster.RetryPolicy)
+}
+
+func TestNewClusterConfig(t *testing.T) {
+	cluster, err := newClusterConfig(config.StorageConfig{
+		Hosts:             []string{"db1", "db2"},
+		Keyspace:          "ks",
+		LocalDC:           "dc1",
+		ReadConsistency:   "LOCAL_ONE",
+		WriteConsistency:  "LOCAL_QUORUM",
+		SerialConsistency: "LOCAL_SERIAL",
+		NumConns:          4,
+		TLS: config.TLSConfig{
+			Enabled:    true,
+			CAFile:     "/etc/cassandra/ca.pem",
+			ServerName: "cassandra.internal",
+		},
+		QueryRetry: config.QueryRetryConfig{NumRetries: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: time.Second},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, gocql.LocalQuorum, cluster.Consistency)
+	assert.Equal(t, gocql.LocalSerial, cluster.SerialConsistency)
+	assert.Equal(t, 4, cluster.NumConns)
+	require.NotNil(t, cluster.SslOpts)
+	assert.Equal(t, "/etc/cassandra/ca.pem", cluster.SslOpts.CaPath)
+	assert.Equal(t, "cassandra.internal", cluster.SslOpts.Config.ServerName)
+	assert.True(t, cluster.SslOpts.EnableHostVerification)
+	assert.Equal(t, &gocql.ExponentialBackoffRetryPolicy{NumRetries: 3, Min: 10 * time.Millisecond, Max: time.Second}, cluster.RetryPolicy)
+
+	read, write, err := consistencyLevels(config.StorageConfig{ReadConsistency: "LOCAL_ONE"})
+	require.NoError(t, err)
+	assert.Equal(t, gocql.LocalOne, read)
+	assert.Equal(t, gocql.Quorum, write)
+}
+
+func TestNewClusterConfig_InvalidConsistency(t *testing.T) {
+	_, err := newClusterConfig(config.StorageConfig{Hosts: []string{"db1"}, WriteConsistency: "MOST"})
+	assert.ErrorContains(t, err, "invalid write consistency")
+
+	_, err = newClusterConfig(config.StorageConfig{Hosts: []string{"db1"}, SerialConsistency: "QUORUM"})
+	assert.ErrorContains(t, err, "invalid serial consistency")
+}
+
+func TestIsReadStatement(t *testing.T) {
+	assert.True(t, isReadStatement(getTripQuery))
+	assert.True(t, isReadStatement("\n\tselect * from routes"))
+	assert.False(t, isReadStatement(insertOperationQuery))
+	assert.False(t, isReadStatement(deleteOperationQuery))
+}
+
+func TestPing(t *testing.T) {
+	mockSession := new(MockSession)
+	q := new(FakeQuery)
+	q.On("WithContext", mock.Anything).Return(q)
+	q.On("Exec").Return(nil).Once()
+	q.On("Exec").Return(fmt.Errorf("no hosts available"))
+	mockSession.On("Query", healthCheckQuery, mock.Anything).Return(q)
+
+	assert.NoError(t, Ping(context.Background(), mockSession))
+	assert.ErrorContains(t, Ping(context.Background(), mockSession), "no hosts available")
+}
@@ -4,6 +4,7 @@ package cassandra
 import (
 	"context"
 	"github.com/gocql/gocql"
+	"strings"
 )
 
 // Query abstracts the methods of a gocql.Query used in the repository layer
@@ -100,18 +101,33 @@ func (bw *batchWrapper) Query(stmt string, values ...interface{}) {
 	bw.b.Query(stmt, values...)
 }
 
-// realSession wraps a *gocql.Session to implement CassandraSession
+// realSession wraps a *gocql.Session to implement CassandraSession.
+// SELECT statements run with the read consistency, everything else with the write consistency
 type realSession struct {
-	s *gocql.Session
+	s                *gocql.Session
+	readConsistency  gocql.Consistency
+	writeConsistency gocql.Consistency
+	// speculative is the speculative execution policy of reads, nil when disabled
+	speculative gocql.SpeculativeExecutionPolicy
 }
 
 func (rs *realSession) Query(stmt string, values ...interface{}) Query {
 	q := rs.s.Query(stmt, values...)
+	if isReadStatement(stmt) {
+		q = q.Consistency(rs.readConsistency)
+		if rs.speculative != nil {
+			// Only reads are idempotent for sure, writes are never executed speculatively
+			q = q.Idempotent(true).SetSpeculativeExecutionPolicy(rs.speculative)
+		}
+	} else {
+		q = q.Consistency(rs.writeConsistency)
+	}
 	return &queryWrapper{q: q}
 }
 
 func (rs *realSession) NewBatch(batchType gocql.BatchType) Batch {
 	b := rs.s.NewBatch(batchType)
+	b.SetConsistency(rs.writeConsistency)
 	return &batchWrapper{b: b}
 }
 
@@ -122,6 +138,21 @@ func (rs *realSession) ExecuteBatch(batch Batch) error {
 	return nil
 }
 
+// prepare Prepares stmt on a connection without executing it. gocql has no public prepare call, but
+// computing the routing key of a query prepares its statement and reports preparation errors
+func (rs *realSession) prepare(ctx context.Context, stmt string) error {
+	values := make([]interface{}, strings.Count(stmt, "?"))
+	for i := range values {
+		values[i] = gocql.UnsetValue
+	}
+	_, err := rs.s.Query(stmt, values...).WithContext(ctx).GetRoutingKey()
+	return err
+}
+
 func (rs *realSession) Close() {
 	rs.s.Close()
 }
+
+func isReadStatement(stmt string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(stmt)), "SELECT")
+}
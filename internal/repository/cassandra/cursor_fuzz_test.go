@@ -0,0 +1,42 @@
+package cassandra
+
+import (
+	"testing"
+	"time"
+)
+
+func FuzzDecodeCursor(f *testing.F) {
+	f.Add(encodeCursor(cartCursor{LastOpTime: time.Date(2025, 8, 20, 10, 0, 0, 0, time.UTC)}))
+	f.Add(encodeCursor(cartCursor{LastOpTime: time.Date(2025, 8, 20, 10, 0, 0, 123456789, time.FixedZone("MSK", 3*60*60))}))
+	f.Add("")
+	f.Add("not-base64!")
+	f.Add("bnVsbA==") // null
+	f.Add("e30=")     // {}
+
+	f.Fuzz(func(t *testing.T, b64 string) {
+		cur, err := decodeCursor(b64)
+		if err != nil {
+			if cur != nil {
+				t.Fatalf("decodeCursor returned both %+v and error %v", cur, err)
+			}
+			return
+		}
+		if cur == nil {
+			if b64 != "" {
+				t.Fatalf("cursor %q decoded to no cursor", b64)
+			}
+			return
+		}
+		if cur.LastOpTime.IsZero() {
+			t.Fatalf("cursor %q decoded without an operation time", b64)
+		}
+
+		again, err := decodeCursor(encodeCursor(*cur))
+		if err != nil {
+			t.Fatalf("re-encoded cursor %q does not decode: %v", b64, err)
+		}
+		if !again.LastOpTime.Equal(cur.LastOpTime) {
+			t.Fatalf("cursor %q round-trips to %s instead of %s", b64, again.LastOpTime, cur.LastOpTime)
+		}
+	})
+}
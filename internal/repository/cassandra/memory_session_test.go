@@ -0,0 +1,1881 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySession is a CassandraSession keeping its tables in memory, so repository tests can check the
+// stored data instead of the sequence of driver calls.
+//
+// It runs the CQL subset of the repository and the migrations: CREATE, ALTER and DROP TABLE, INSERT,
+// SELECT restricted by partition key and clustering columns with ORDER BY, LIMIT and paging, UPDATE and
+// DELETE with IF EXISTS and USING TIMESTAMP. Cells keep their write timestamp and deletes leave
+// tombstones, so the newest write wins as in Cassandra. Statements outside the subset, or queries
+// Cassandra would reject without ALLOW FILTERING, fail instead of silently matching nothing.
+type memorySession struct {
+	mu        sync.Mutex
+	tables    map[string]*memTable
+	parsed    map[string]*cqlStatement
+	failures  map[string]error
+	lastWrite int64
+	now       func() time.Time
+}
+
+// newMemorySession Creates a memory session with every migration applied
+func newMemorySession(t *testing.T) *memorySession {
+	t.Helper()
+	s := &memorySession{
+		tables:   make(map[string]*memTable),
+		parsed:   make(map[string]*cqlStatement),
+		failures: make(map[string]error),
+		now:      time.Now,
+	}
+	migrator, err := NewMigrator(s, log.NewNopLogger())
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+	return s
+}
+
+// failOn Makes every execution of the registered statement name fail with err,
+// batchStatementName fails batches
+func (s *memorySession) failOn(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[name] = err
+}
+
+// rows Returns the live rows of table as column to value maps, text as string, integers as int64,
+// timestamps as UTC time.Time and sets as sorted []interface{}
+func (s *memorySession) rows(t *testing.T, table string) []map[string]interface{} {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tbl, ok := s.tables[table]
+	require.True(t, ok, "unknown table %s", table)
+	res, err := tbl.selectRows(&cqlStatement{kind: "select", table: table}, nil)
+	require.NoError(t, err)
+
+	rows := make([]map[string]interface{}, 0, len(res.rows))
+	for _, row := range res.rows {
+		m := make(map[string]interface{}, len(row))
+		for i, column := range res.columns {
+			if row[i] != nil {
+				m[column] = row[i]
+			}
+		}
+		rows = append(rows, m)
+	}
+	return rows
+}
+
+func (s *memorySession) Query(stmt string, values ...interface{}) Query {
+	return &memoryQuery{session: s, stmt: stmt, values: values, ctx: context.Background()}
+}
+
+func (s *memorySession) NewBatch(batchType gocql.BatchType) Batch {
+	return &memoryBatch{batchType: batchType, ctx: context.Background()}
+}
+
+// ExecuteBatch Applies every statement of the batch with one write timestamp, or none of them on error
+func (s *memorySession) ExecuteBatch(batch Batch) error {
+	b, ok := batch.(*memoryBatch)
+	if !ok {
+		return fmt.Errorf("memory session cannot execute batch %T", batch)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures[batchStatementName]; err != nil {
+		return err
+	}
+
+	ts := s.nextTimestamp()
+	writes := make([]memWrite, 0, len(b.entries))
+	for _, entry := range b.entries {
+		if err := s.failures[StatementName(entry.stmt)]; err != nil {
+			return err
+		}
+		st, err := s.parse(entry.stmt)
+		if err != nil {
+			return err
+		}
+		if st.ifExists || st.ifNotExists {
+			return fmt.Errorf("conditional statements in batches are not supported by the memory session")
+		}
+		w, err := s.planWrite(st, entry.values, ts)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w)
+	}
+	for _, w := range writes {
+		w.apply()
+	}
+	return nil
+}
+
+func (s *memorySession) Close() {}
+
+// nextTimestamp Returns a write timestamp in microseconds, later than every previous one
+func (s *memorySession) nextTimestamp() int64 {
+	ts := s.now().UnixMicro()
+	if ts <= s.lastWrite {
+		ts = s.lastWrite + 1
+	}
+	s.lastWrite = ts
+	return ts
+}
+
+func (s *memorySession) parse(stmt string) (*cqlStatement, error) {
+	if st, ok := s.parsed[stmt]; ok {
+		return st, nil
+	}
+	st, err := parseCQL(stmt)
+	if err != nil {
+		return nil, err
+	}
+	s.parsed[stmt] = st
+	return st, nil
+}
+
+// execute Runs one statement outside a batch
+func (s *memorySession) execute(ctx context.Context, stmt string, values []interface{}) (memResult, error) {
+	if err := ctx.Err(); err != nil {
+		return memResult{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures[StatementName(stmt)]; err != nil {
+		return memResult{}, err
+	}
+	st, err := s.parse(stmt)
+	if err != nil {
+		return memResult{}, err
+	}
+	if len(values) != st.markers {
+		return memResult{}, fmt.Errorf("gocql: expected %d values send got %d", st.markers, len(values))
+	}
+
+	switch st.kind {
+	case "create", "alter", "drop":
+		return memResult{applied: true}, s.executeDDL(st)
+	case "select":
+		tbl, err := s.table(st.table)
+		if err != nil {
+			return memResult{}, err
+		}
+		return tbl.selectRows(st, values)
+	default:
+		w, err := s.planWrite(st, values, s.nextTimestamp())
+		if err != nil {
+			return memResult{}, err
+		}
+		return memResult{applied: w.apply()}, nil
+	}
+}
+
+func (s *memorySession) table(name string) (*memTable, error) {
+	tbl, ok := s.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("unconfigured table %s", name)
+	}
+	return tbl, nil
+}
+
+func (s *memorySession) executeDDL(st *cqlStatement) error {
+	switch st.kind {
+	case "create":
+		if _, ok := s.tables[st.table]; ok {
+			if st.ifNotExists {
+				return nil
+			}
+			return fmt.Errorf("table %s already exists", st.table)
+		}
+		// The parsed statement is cached, so the stored table must be a copy of its definition
+		s.tables[st.table] = st.definition.emptyCopy()
+		return nil
+	case "drop":
+		if _, ok := s.tables[st.table]; !ok {
+			if st.ifExists {
+				return nil
+			}
+			return fmt.Errorf("unconfigured table %s", st.table)
+		}
+		delete(s.tables, st.table)
+		return nil
+	}
+
+	tbl, err := s.table(st.table)
+	if err != nil {
+		return err
+	}
+	if st.alterAdd != "" {
+		if _, ok := tbl.columns[st.alterAdd]; ok {
+			return fmt.Errorf("column %s already exists in %s", st.alterAdd, tbl.name)
+		}
+		tbl.columns[st.alterAdd] = st.alterType
+		return nil
+	}
+	if _, ok := tbl.columns[st.alterDrop]; !ok {
+		return fmt.Errorf("column %s was not found in table %s", st.alterDrop, tbl.name)
+	}
+	if tbl.isKey(st.alterDrop) {
+		return fmt.Errorf("cannot drop primary key column %s", st.alterDrop)
+	}
+	delete(tbl.columns, st.alterDrop)
+	for _, p := range tbl.partitions {
+		for _, row := range p.rows {
+			delete(row.cells, st.alterDrop)
+		}
+	}
+	return nil
+}
+
+// planWrite Resolves an INSERT, UPDATE or DELETE against its table without changing it
+func (s *memorySession) planWrite(st *cqlStatement, values []interface{}, ts int64) (memWrite, error) {
+	if len(values) != st.markers {
+		return memWrite{}, fmt.Errorf("gocql: expected %d values send got %d", st.markers, len(values))
+	}
+	if st.kind != "insert" && st.kind != "update" && st.kind != "delete" {
+		return memWrite{}, fmt.Errorf("%s statements cannot be written", st.kind)
+	}
+	tbl, err := s.table(st.table)
+	if err != nil {
+		return memWrite{}, err
+	}
+	w := memWrite{table: tbl, kind: st.kind, ts: ts, ifExists: st.ifExists, ifNotExists: st.ifNotExists}
+	if st.timestamp != nil {
+		v, err := normalizeValue("bigint", st.timestamp.value(values))
+		if err != nil || v == nil {
+			return memWrite{}, fmt.Errorf("invalid write timestamp: %v", st.timestamp.value(values))
+		}
+		w.ts = v.(int64)
+	}
+
+	keys := make(map[string]interface{})
+	if st.kind == "insert" {
+		w.cells = make(map[string]interface{})
+		for i, column := range st.columns {
+			typ, ok := tbl.columns[column]
+			if !ok {
+				return memWrite{}, fmt.Errorf("undefined column name %s in table %s", column, tbl.name)
+			}
+			raw := st.terms[i].value(values)
+			if raw == gocql.UnsetValue {
+				if tbl.isKey(column) {
+					return memWrite{}, fmt.Errorf("invalid unset value for primary key column %s", column)
+				}
+				continue
+			}
+			v, err := normalizeValue(typ, raw)
+			if err != nil {
+				return memWrite{}, fmt.Errorf("column %s: %w", column, err)
+			}
+			if tbl.isKey(column) {
+				keys[column] = v
+			} else {
+				w.cells[column] = v
+			}
+		}
+	} else {
+		for _, pred := range st.where {
+			typ, ok := tbl.columns[pred.column]
+			if !ok {
+				return memWrite{}, fmt.Errorf("undefined column name %s in table %s", pred.column, tbl.name)
+			}
+			if pred.op != "=" || !tbl.isKey(pred.column) {
+				return memWrite{}, fmt.Errorf("%s restricts %s with %s, only primary key equalities are supported", st.kind, pred.column, pred.op)
+			}
+			v, err := normalizeValue(typ, pred.term.value(values))
+			if err != nil {
+				return memWrite{}, fmt.Errorf("column %s: %w", pred.column, err)
+			}
+			keys[pred.column] = v
+		}
+		if st.kind == "update" {
+			w.cells = make(map[string]interface{})
+			for i, column := range st.columns {
+				typ, ok := tbl.columns[column]
+				if !ok {
+					return memWrite{}, fmt.Errorf("undefined column name %s in table %s", column, tbl.name)
+				}
+				if tbl.isKey(column) {
+					return memWrite{}, fmt.Errorf("cannot update primary key column %s", column)
+				}
+				v, err := normalizeValue(typ, st.terms[i].value(values))
+				if err != nil {
+					return memWrite{}, fmt.Errorf("column %s: %w", column, err)
+				}
+				w.cells[column] = v
+			}
+		}
+	}
+
+	for _, column := range tbl.partitionKey {
+		v, ok := keys[column]
+		if !ok || v == nil {
+			return memWrite{}, fmt.Errorf("missing or null partition key column %s", column)
+		}
+		w.partition = append(w.partition, v)
+	}
+	for _, column := range tbl.clustering {
+		v, ok := keys[column]
+		if !ok {
+			break
+		}
+		if v == nil {
+			return memWrite{}, fmt.Errorf("invalid null value for clustering column %s", column)
+		}
+		w.clustering = append(w.clustering, v)
+	}
+	if len(w.clustering) != len(tbl.clustering) {
+		// Only a delete of the whole partition may leave the clustering columns out
+		if st.kind != "delete" || len(w.clustering) != 0 || st.ifExists {
+			return memWrite{}, fmt.Errorf("%s on %s needs every primary key column", st.kind, tbl.name)
+		}
+		w.wholePartition = true
+	}
+	if len(keys) != len(w.partition)+len(w.clustering) {
+		return memWrite{}, fmt.Errorf("%s on %s restricts a clustering column after a missing one", st.kind, tbl.name)
+	}
+	return w, nil
+}
+
+type memoryQuery struct {
+	session   *memorySession
+	stmt      string
+	values    []interface{}
+	ctx       context.Context
+	pageSize  int
+	pageState []byte
+	paged     bool
+}
+
+func (q *memoryQuery) WithContext(ctx context.Context) Query {
+	c := *q
+	c.ctx = ctx
+	return &c
+}
+
+func (q *memoryQuery) Exec() error {
+	_, err := q.session.execute(q.ctx, q.stmt, q.values)
+	return err
+}
+
+// Iter Returns every row, or a single page once PageState was set as gocql stops paging automatically then
+func (q *memoryQuery) Iter() Iter {
+	res, err := q.session.execute(q.ctx, q.stmt, q.values)
+	if err != nil {
+		return &memoryIter{err: err}
+	}
+	iter := &memoryIter{columns: res.columns, rows: res.rows}
+	if !q.paged {
+		return iter
+	}
+
+	offset := 0
+	if len(q.pageState) > 0 {
+		if offset, err = strconv.Atoi(string(q.pageState)); err != nil || offset < 0 {
+			return &memoryIter{err: fmt.Errorf("invalid page state %q", q.pageState)}
+		}
+	}
+	size := q.pageSize
+	if size <= 0 {
+		size = 5000
+	}
+	if offset > len(iter.rows) {
+		offset = len(iter.rows)
+	}
+	end := offset + size
+	if end < len(iter.rows) {
+		iter.pageState = []byte(strconv.Itoa(end))
+	} else {
+		end = len(iter.rows)
+	}
+	iter.rows = iter.rows[offset:end]
+	return iter
+}
+
+func (q *memoryQuery) ScanCAS(dest ...interface{}) (bool, error) {
+	res, err := q.session.execute(q.ctx, q.stmt, q.values)
+	return res.applied, err
+}
+
+func (q *memoryQuery) PageSize(n int) Query {
+	q.pageSize = n
+	return q
+}
+
+func (q *memoryQuery) PageState(state []byte) Query {
+	q.pageState = state
+	q.paged = true
+	return q
+}
+
+type memoryIter struct {
+	columns   []string
+	rows      [][]interface{}
+	pos       int
+	err       error
+	pageState []byte
+}
+
+func (it *memoryIter) Scan(dest ...interface{}) bool {
+	if it.err != nil || it.pos >= len(it.rows) {
+		return false
+	}
+	if len(dest) != len(it.columns) {
+		it.err = fmt.Errorf("gocql: not enough columns to scan into: have %d want %d", len(dest), len(it.columns))
+		return false
+	}
+	row := it.rows[it.pos]
+	it.pos++
+	for i := range dest {
+		if err := assignValue(dest[i], row[i]); err != nil {
+			it.err = fmt.Errorf("column %s: %w", it.columns[i], err)
+			return false
+		}
+	}
+	return true
+}
+
+func (it *memoryIter) Close() error      { return it.err }
+func (it *memoryIter) PageState() []byte { return it.pageState }
+
+type memoryBatch struct {
+	batchType gocql.BatchType
+	ctx       context.Context
+	entries   []memoryBatchEntry
+}
+
+type memoryBatchEntry struct {
+	stmt   string
+	values []interface{}
+}
+
+func (b *memoryBatch) WithContext(ctx context.Context) Batch {
+	b.ctx = ctx
+	return b
+}
+
+func (b *memoryBatch) Query(stmt string, values ...interface{}) {
+	b.entries = append(b.entries, memoryBatchEntry{stmt: stmt, values: values})
+}
+
+// --- Storage ---
+
+type memTable struct {
+	name         string
+	columns      map[string]string // column name to CQL type
+	partitionKey []string
+	clustering   []string
+	descending   []bool // clustering order of each clustering column
+	partitions   map[string]*memPartition
+}
+
+type memPartition struct {
+	key       []interface{}
+	deletedAt int64 // partition tombstone timestamp, zero when never deleted
+	rows      map[string]*memRow
+}
+
+type memRow struct {
+	clustering []interface{}
+	deletedAt  int64 // row tombstone timestamp
+	marker     int64 // timestamp of the INSERT that created the row, zero for rows only written by UPDATE
+	cells      map[string]memCell
+}
+
+type memCell struct {
+	value     interface{} // nil for a written null
+	writtenAt int64
+}
+
+type memResult struct {
+	columns []string
+	rows    [][]interface{}
+	applied bool
+}
+
+func (t *memTable) emptyCopy() *memTable {
+	columns := make(map[string]string, len(t.columns))
+	for name, typ := range t.columns {
+		columns[name] = typ
+	}
+	return &memTable{
+		name:         t.name,
+		columns:      columns,
+		partitionKey: t.partitionKey,
+		clustering:   t.clustering,
+		descending:   t.descending,
+		partitions:   make(map[string]*memPartition),
+	}
+}
+
+func (t *memTable) isKey(column string) bool {
+	return indexOf(t.partitionKey, column) >= 0 || indexOf(t.clustering, column) >= 0
+}
+
+// allColumns Lists the columns in SELECT * order: partition key, clustering columns, then the others by name
+func (t *memTable) allColumns() []string {
+	columns := append(append([]string{}, t.partitionKey...), t.clustering...)
+	var regular []string
+	for name := range t.columns {
+		if !t.isKey(name) {
+			regular = append(regular, name)
+		}
+	}
+	sort.Strings(regular)
+	return append(columns, regular...)
+}
+
+func (t *memTable) value(p *memPartition, row *memRow, column string) interface{} {
+	if i := indexOf(t.partitionKey, column); i >= 0 {
+		return p.key[i]
+	}
+	if i := indexOf(t.clustering, column); i >= 0 {
+		return row.clustering[i]
+	}
+	return row.cells[column].value
+}
+
+// compareClustering Orders rows by their clustering columns in the table clustering order
+func (t *memTable) compareClustering(a, b *memRow) int {
+	for i := range t.clustering {
+		c := compareValues(a.clustering[i], b.clustering[i])
+		if t.descending[i] {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (r *memRow) live() bool {
+	if r.marker != 0 {
+		return true
+	}
+	for _, cell := range r.cells {
+		if cell.value != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBefore Removes the row data written at or before ts
+func (r *memRow) deleteBefore(ts int64) {
+	if ts > r.deletedAt {
+		r.deletedAt = ts
+	}
+	if r.marker <= ts {
+		r.marker = 0
+	}
+	for column, cell := range r.cells {
+		if cell.writtenAt <= ts {
+			delete(r.cells, column)
+		}
+	}
+}
+
+type memRestriction struct {
+	op    string
+	value interface{}
+}
+
+// selectRows Runs a SELECT, rejecting restrictions Cassandra would need ALLOW FILTERING for
+func (t *memTable) selectRows(st *cqlStatement, values []interface{}) (memResult, error) {
+	restrictions := make(map[string][]memRestriction)
+	for _, pred := range st.where {
+		typ, ok := t.columns[pred.column]
+		if !ok {
+			return memResult{}, fmt.Errorf("undefined column name %s in table %s", pred.column, t.name)
+		}
+		if !t.isKey(pred.column) {
+			return memResult{}, fmt.Errorf("restricting regular column %s requires ALLOW FILTERING", pred.column)
+		}
+		v, err := normalizeValue(typ, pred.term.value(values))
+		if err != nil {
+			return memResult{}, fmt.Errorf("column %s: %w", pred.column, err)
+		}
+		restrictions[pred.column] = append(restrictions[pred.column], memRestriction{op: pred.op, value: v})
+	}
+
+	var partitionKey []interface{}
+	for _, column := range t.partitionKey {
+		r := restrictions[column]
+		if len(r) == 1 && r[0].op == "=" {
+			partitionKey = append(partitionKey, r[0].value)
+		} else if len(r) > 0 {
+			return memResult{}, fmt.Errorf("partition key column %s only supports equality", column)
+		}
+	}
+	restricted := partitionKey != nil
+	if restricted && len(partitionKey) != len(t.partitionKey) {
+		return memResult{}, fmt.Errorf("partition key of %s is partially restricted, that requires ALLOW FILTERING", t.name)
+	}
+
+	var clusteringEq []interface{}
+	rangeColumn := -1
+	for i, column := range t.clustering {
+		r := restrictions[column]
+		if len(r) == 0 {
+			for _, later := range t.clustering[i+1:] {
+				if len(restrictions[later]) > 0 {
+					return memResult{}, fmt.Errorf("clustering column %s is restricted but %s is not", later, column)
+				}
+			}
+			break
+		}
+		if !restricted {
+			return memResult{}, fmt.Errorf("restricting clustering column %s without the partition key requires ALLOW FILTERING", column)
+		}
+		if len(r) == 1 && r[0].op == "=" {
+			clusteringEq = append(clusteringEq, r[0].value)
+			continue
+		}
+		for _, restriction := range r {
+			if restriction.op == "=" {
+				return memResult{}, fmt.Errorf("clustering column %s has both equality and range restrictions", column)
+			}
+		}
+		rangeColumn = i
+		for _, later := range t.clustering[i+1:] {
+			if len(restrictions[later]) > 0 {
+				return memResult{}, fmt.Errorf("clustering column %s cannot be restricted after the range on %s", later, column)
+			}
+		}
+		break
+	}
+
+	// ORDER BY follows a prefix of the clustering columns, either all in the table order or all reversed
+	reverse := false
+	if len(st.orderBy) > 0 {
+		if !restricted {
+			return memResult{}, fmt.Errorf("ORDER BY is only supported when the partition key is restricted")
+		}
+		for i, ordering := range st.orderBy {
+			if i >= len(t.clustering) || ordering.column != t.clustering[i] {
+				return memResult{}, fmt.Errorf("ORDER BY must follow the clustering columns of %s in order", t.name)
+			}
+			columnReversed := ordering.desc != t.descending[i]
+			if i == 0 {
+				reverse = columnReversed
+			} else if columnReversed != reverse {
+				return memResult{}, fmt.Errorf("ORDER BY of %s must keep or reverse the clustering order of every column", t.name)
+			}
+		}
+	}
+
+	columns := st.columns
+	if columns == nil {
+		columns = t.allColumns()
+	}
+	for _, column := range columns {
+		if _, ok := t.columns[column]; !ok {
+			return memResult{}, fmt.Errorf("undefined column name %s in table %s", column, t.name)
+		}
+		if st.distinct && indexOf(t.partitionKey, column) < 0 {
+			return memResult{}, fmt.Errorf("SELECT DISTINCT may only select partition key columns, not %s", column)
+		}
+	}
+
+	var partitions []*memPartition
+	if restricted {
+		if p, ok := t.partitions[encodeKey(partitionKey)]; ok {
+			partitions = append(partitions, p)
+		}
+	} else {
+		for _, p := range t.partitions {
+			partitions = append(partitions, p)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return compareValues(partitions[i].key, partitions[j].key) < 0 })
+	}
+
+	limit := -1
+	if st.limit != nil {
+		v, err := normalizeValue("int", st.limit.value(values))
+		if err != nil || v == nil || v.(int64) <= 0 {
+			return memResult{}, fmt.Errorf("invalid LIMIT %v", st.limit.value(values))
+		}
+		limit = int(v.(int64))
+	}
+
+	res := memResult{columns: columns}
+	for _, p := range partitions {
+		var rows []*memRow
+		for _, row := range p.rows {
+			if row.live() && matchesClustering(row, clusteringEq, rangeColumn, restrictions[columnAt(t.clustering, rangeColumn)]) {
+				rows = append(rows, row)
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool { return t.compareClustering(rows[i], rows[j]) < 0 })
+		if reverse {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+		if st.distinct && len(rows) > 1 {
+			rows = rows[:1]
+		}
+		for _, row := range rows {
+			out := make([]interface{}, len(columns))
+			for i, column := range columns {
+				out[i] = t.value(p, row, column)
+			}
+			res.rows = append(res.rows, out)
+		}
+	}
+	if limit >= 0 && len(res.rows) > limit {
+		res.rows = res.rows[:limit]
+	}
+	return res, nil
+}
+
+func matchesClustering(row *memRow, eq []interface{}, rangeColumn int, ranges []memRestriction) bool {
+	for i, v := range eq {
+		if compareValues(row.clustering[i], v) != 0 {
+			return false
+		}
+	}
+	if rangeColumn < 0 {
+		return true
+	}
+	for _, r := range ranges {
+		c := compareValues(row.clustering[rangeColumn], r.value)
+		switch r.op {
+		case "<":
+			if c >= 0 {
+				return false
+			}
+		case "<=":
+			if c > 0 {
+				return false
+			}
+		case ">":
+			if c <= 0 {
+				return false
+			}
+		case ">=":
+			if c < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// memWrite is a resolved INSERT, UPDATE or DELETE, applied only once a whole batch resolved
+type memWrite struct {
+	table          *memTable
+	kind           string
+	ts             int64
+	partition      []interface{}
+	clustering     []interface{}
+	wholePartition bool
+	cells          map[string]interface{}
+	ifExists       bool
+	ifNotExists    bool
+}
+
+// apply Changes the table, returns false when the IF condition did not hold
+func (w memWrite) apply() bool {
+	pKey := encodeKey(w.partition)
+	p, ok := w.table.partitions[pKey]
+	if !ok {
+		p = &memPartition{key: w.partition, rows: make(map[string]*memRow)}
+		w.table.partitions[pKey] = p
+	}
+
+	if w.wholePartition {
+		if w.ts > p.deletedAt {
+			p.deletedAt = w.ts
+		}
+		for _, row := range p.rows {
+			row.deleteBefore(w.ts)
+		}
+		return true
+	}
+
+	rKey := encodeKey(w.clustering)
+	row, ok := p.rows[rKey]
+	exists := ok && row.live()
+	if (w.ifExists && !exists) || (w.ifNotExists && exists) {
+		return false
+	}
+	if !ok {
+		row = &memRow{clustering: w.clustering, cells: make(map[string]memCell)}
+		p.rows[rKey] = row
+	}
+
+	if w.kind == "delete" {
+		row.deleteBefore(w.ts)
+		return true
+	}
+	if w.ts <= p.deletedAt || w.ts <= row.deletedAt {
+		// Shadowed by a newer tombstone
+		return true
+	}
+	if w.kind == "insert" && w.ts >= row.marker {
+		row.marker = w.ts
+	}
+	for column, v := range w.cells {
+		if cell, ok := row.cells[column]; ok && cell.writtenAt > w.ts {
+			continue
+		}
+		row.cells[column] = memCell{value: v, writtenAt: w.ts}
+	}
+	return true
+}
+
+// --- Values ---
+
+var intRanges = map[string][2]int64{
+	"tinyint":  {math.MinInt8, math.MaxInt8},
+	"smallint": {math.MinInt16, math.MaxInt16},
+	"int":      {math.MinInt32, math.MaxInt32},
+	"bigint":   {math.MinInt64, math.MaxInt64},
+}
+
+func isSupportedType(typ string) bool {
+	if elem, ok := setElemType(typ); ok {
+		typ = elem
+	}
+	switch typ {
+	case "text", "timestamp", "boolean", "double":
+		return true
+	}
+	_, ok := intRanges[typ]
+	return ok
+}
+
+func setElemType(typ string) (string, bool) {
+	if strings.HasPrefix(typ, "set<") && strings.HasSuffix(typ, ">") {
+		return typ[len("set<") : len(typ)-1], true
+	}
+	return "", false
+}
+
+// normalizeValue Converts a bind value to the stored form of a column type, rejecting values gocql would
+// not marshal. Null, zero timestamps and empty sets are stored as nil
+func normalizeValue(typ string, v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil, nil
+	}
+
+	if elem, ok := setElemType(typ); ok {
+		var items []interface{}
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				items = append(items, rv.Index(i).Interface())
+			}
+		case reflect.Map:
+			for _, key := range rv.MapKeys() {
+				items = append(items, key.Interface())
+			}
+		default:
+			return nil, fmt.Errorf("can not marshal %T into %s", v, typ)
+		}
+		set := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			n, err := normalizeValue(elem, item)
+			if err != nil {
+				return nil, err
+			}
+			if n == nil {
+				return nil, fmt.Errorf("null element in %s", typ)
+			}
+			set = append(set, n)
+		}
+		if len(set) == 0 {
+			return nil, nil
+		}
+		sort.Slice(set, func(i, j int) bool { return compareValues(set[i], set[j]) < 0 })
+		unique := set[:1]
+		for _, item := range set[1:] {
+			if compareValues(item, unique[len(unique)-1]) != 0 {
+				unique = append(unique, item)
+			}
+		}
+		return unique, nil
+	}
+
+	switch typ {
+	case "text":
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case "boolean":
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case "double":
+		if rv.Kind() == reflect.Float64 || rv.Kind() == reflect.Float32 {
+			return rv.Float(), nil
+		}
+	case "timestamp":
+		switch t := rv.Interface().(type) {
+		case time.Time:
+			if t.IsZero() {
+				return nil, nil
+			}
+			return t.UTC().Truncate(time.Millisecond), nil
+		case int64:
+			return time.UnixMilli(t).UTC(), nil
+		}
+	default:
+		bounds, ok := intRanges[typ]
+		if !ok {
+			return nil, fmt.Errorf("unsupported column type %s", typ)
+		}
+		var n int64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = rv.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if rv.Uint() > math.MaxInt64 {
+				return nil, fmt.Errorf("value %d out of range for %s", rv.Uint(), typ)
+			}
+			n = int64(rv.Uint())
+		case reflect.String:
+			parsed, err := strconv.ParseInt(rv.String(), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("can not marshal %q into %s", rv.String(), typ)
+			}
+			n = parsed
+		default:
+			return nil, fmt.Errorf("can not marshal %T into %s", v, typ)
+		}
+		if n < bounds[0] || n > bounds[1] {
+			return nil, fmt.Errorf("value %d out of range for %s", n, typ)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("can not marshal %T into %s", v, typ)
+}
+
+// compareValues Orders two stored values of the same type, nil first
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case int64:
+		y := b.(int64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return x.Compare(b.(time.Time))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	}
+	panic(fmt.Sprintf("cannot compare %T", a))
+}
+
+func encodeKey(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		switch x := v.(type) {
+		case string:
+			parts[i] = strconv.Quote(x)
+		case time.Time:
+			parts[i] = "t" + strconv.FormatInt(x.UnixMilli(), 10)
+		default:
+			parts[i] = fmt.Sprint(x)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// assignValue Sets the scan destination dest from a stored value, nil scans as the zero value
+func assignValue(dest interface{}, value interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return fmt.Errorf("can not unmarshal into non-pointer %T", dest)
+	}
+	return setValue(dv.Elem(), value)
+}
+
+func setValue(dv reflect.Value, value interface{}) error {
+	if value == nil {
+		dv.Set(reflect.Zero(dv.Type()))
+		return nil
+	}
+	switch dv.Kind() {
+	case reflect.Interface:
+		dv.Set(reflect.ValueOf(value))
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(dv.Type().Elem())
+		if err := setValue(p.Elem(), value); err != nil {
+			return err
+		}
+		dv.Set(p)
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		if dv.Kind() == reflect.String {
+			dv.SetString(v)
+			return nil
+		}
+	case int64:
+		switch dv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dv.OverflowInt(v) {
+				return fmt.Errorf("value %d overflows %s", v, dv.Type())
+			}
+			dv.SetInt(v)
+			return nil
+		}
+	case bool:
+		if dv.Kind() == reflect.Bool {
+			dv.SetBool(v)
+			return nil
+		}
+	case float64:
+		if dv.Kind() == reflect.Float64 {
+			dv.SetFloat(v)
+			return nil
+		}
+	case time.Time:
+		if dv.Type() == reflect.TypeOf(time.Time{}) {
+			dv.Set(reflect.ValueOf(v))
+			return nil
+		}
+	case []interface{}:
+		if dv.Kind() == reflect.Slice {
+			s := reflect.MakeSlice(dv.Type(), len(v), len(v))
+			for i, item := range v {
+				if err := setValue(s.Index(i), item); err != nil {
+					return err
+				}
+			}
+			dv.Set(s)
+			return nil
+		}
+	}
+	return fmt.Errorf("can not unmarshal %T into %s", value, dv.Type())
+}
+
+func indexOf(columns []string, column string) int {
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+func columnAt(columns []string, i int) string {
+	if i < 0 {
+		return ""
+	}
+	return columns[i]
+}
+
+// --- CQL parsing ---
+
+// cqlOrdering is a column of an ORDER BY clause
+type cqlOrdering struct {
+	column string
+	desc   bool
+}
+
+type cqlStatement struct {
+	kind      string // select, insert, update, delete, create, alter or drop
+	table     string
+	columns   []string  // selected, inserted or updated columns, nil for SELECT *
+	terms     []cqlTerm // values of the inserted or updated columns
+	distinct  bool
+	where     []cqlPredicate
+	orderBy   []cqlOrdering
+	limit     *cqlTerm
+	timestamp *cqlTerm
+	markers   int
+
+	ifExists    bool
+	ifNotExists bool
+
+	definition *memTable // CREATE TABLE
+	alterAdd   string
+	alterType  string
+	alterDrop  string
+}
+
+// cqlTerm is a bind marker or a literal
+type cqlTerm struct {
+	marker  int // index of the bind value, -1 for a literal
+	literal interface{}
+}
+
+func (t cqlTerm) value(values []interface{}) interface{} {
+	if t.marker < 0 {
+		return t.literal
+	}
+	return values[t.marker]
+}
+
+type cqlPredicate struct {
+	column string
+	op     string
+	term   cqlTerm
+}
+
+type cqlToken struct {
+	kind byte // 'i' identifier, 'n' number, 's' string, 'p' punctuation, 0 end
+	text string
+}
+
+func tokenizeCQL(stmt string) ([]cqlToken, error) {
+	var tokens []cqlToken
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(stmt[i:], "--"):
+			for i < len(stmt) && stmt[i] != '\n' {
+				i++
+			}
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(stmt) && stmt[i+1] >= '0' && stmt[i+1] <= '9':
+			start := i
+			i++
+			for i < len(stmt) && stmt[i] >= '0' && stmt[i] <= '9' {
+				i++
+			}
+			tokens = append(tokens, cqlToken{kind: 'n', text: stmt[start:i]})
+		case isIdent(c):
+			start := i
+			for i < len(stmt) && isIdent(stmt[i]) {
+				i++
+			}
+			tokens = append(tokens, cqlToken{kind: 'i', text: stmt[start:i]})
+		case c == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(stmt) {
+					return nil, fmt.Errorf("unterminated string in %q", stmt)
+				}
+				if stmt[i] == '\'' {
+					if i+1 < len(stmt) && stmt[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(stmt[i])
+				i++
+			}
+			tokens = append(tokens, cqlToken{kind: 's', text: b.String()})
+		case strings.HasPrefix(stmt[i:], "<=") || strings.HasPrefix(stmt[i:], ">="):
+			tokens = append(tokens, cqlToken{kind: 'p', text: stmt[i : i+2]})
+			i += 2
+		case strings.ContainsRune("(),=<>*;?", rune(c)):
+			tokens = append(tokens, cqlToken{kind: 'p', text: string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q in %q", c, stmt)
+		}
+	}
+	return tokens, nil
+}
+
+type cqlParser struct {
+	tokens  []cqlToken
+	pos     int
+	markers int
+}
+
+func (p *cqlParser) peek() cqlToken {
+	if p.pos >= len(p.tokens) {
+		return cqlToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *cqlParser) next() cqlToken {
+	tok := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return tok
+}
+
+// keyword Consumes the keywords words if they come next
+func (p *cqlParser) keyword(words ...string) bool {
+	for i, word := range words {
+		if p.pos+i >= len(p.tokens) {
+			return false
+		}
+		tok := p.tokens[p.pos+i]
+		if tok.kind != 'i' || !strings.EqualFold(tok.text, word) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *cqlParser) expectKeyword(words ...string) error {
+	if !p.keyword(words...) {
+		return fmt.Errorf("expected %s, found %q", strings.Join(words, " "), p.peek().text)
+	}
+	return nil
+}
+
+func (p *cqlParser) symbol(s string) bool {
+	if tok := p.peek(); tok.kind == 'p' && tok.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *cqlParser) expectSymbol(s string) error {
+	if !p.symbol(s) {
+		return fmt.Errorf("expected %q, found %q", s, p.peek().text)
+	}
+	return nil
+}
+
+func (p *cqlParser) ident() (string, error) {
+	tok := p.next()
+	if tok.kind != 'i' {
+		return "", fmt.Errorf("expected an identifier, found %q", tok.text)
+	}
+	return strings.ToLower(tok.text), nil
+}
+
+func (p *cqlParser) identList() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.symbol(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *cqlParser) term() (cqlTerm, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == 'p' && tok.text == "?":
+		p.markers++
+		return cqlTerm{marker: p.markers - 1}, nil
+	case tok.kind == 'n':
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		return cqlTerm{marker: -1, literal: n}, err
+	case tok.kind == 's':
+		return cqlTerm{marker: -1, literal: tok.text}, nil
+	case tok.kind == 'i' && strings.EqualFold(tok.text, "true"):
+		return cqlTerm{marker: -1, literal: true}, nil
+	case tok.kind == 'i' && strings.EqualFold(tok.text, "false"):
+		return cqlTerm{marker: -1, literal: false}, nil
+	case tok.kind == 'i' && strings.EqualFold(tok.text, "null"):
+		return cqlTerm{marker: -1}, nil
+	}
+	return cqlTerm{}, fmt.Errorf("expected a value, found %q", tok.text)
+}
+
+func (p *cqlParser) typeName() (string, error) {
+	typ, err := p.ident()
+	if err != nil {
+		return "", err
+	}
+	if p.symbol("<") {
+		elem, err := p.ident()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expectSymbol(">"); err != nil {
+			return "", err
+		}
+		typ += "<" + elem + ">"
+	}
+	if typ == "varchar" {
+		typ = "text"
+	}
+	if !isSupportedType(typ) {
+		return "", fmt.Errorf("unsupported column type %s", typ)
+	}
+	return typ, nil
+}
+
+func (p *cqlParser) where() ([]cqlPredicate, error) {
+	var preds []cqlPredicate
+	for {
+		column, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+		switch op.text {
+		case "=", "<", "<=", ">", ">=":
+		default:
+			return nil, fmt.Errorf("unsupported operator %q on %s", op.text, column)
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		preds = append(preds, cqlPredicate{column: column, op: op.text, term: term})
+		if !p.keyword("AND") {
+			return preds, nil
+		}
+	}
+}
+
+func (p *cqlParser) usingTimestamp(st *cqlStatement) error {
+	if !p.keyword("USING", "TIMESTAMP") {
+		return nil
+	}
+	term, err := p.term()
+	if err != nil {
+		return err
+	}
+	st.timestamp = &term
+	return nil
+}
+
+// parseCQL Parses a statement of the subset run by the memory session
+func parseCQL(stmt string) (*cqlStatement, error) {
+	tokens, err := tokenizeCQL(stmt)
+	if err != nil {
+		return nil, err
+	}
+	p := &cqlParser{tokens: tokens}
+	st, err := p.statement()
+	if err != nil {
+		return nil, fmt.Errorf("memory session cannot run %q: %w", strings.Join(strings.Fields(stmt), " "), err)
+	}
+	p.symbol(";")
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("memory session cannot run %q: unexpected %q", strings.Join(strings.Fields(stmt), " "), p.peek().text)
+	}
+	st.markers = p.markers
+	return st, nil
+}
+
+func (p *cqlParser) statement() (*cqlStatement, error) {
+	switch {
+	case p.keyword("SELECT"):
+		return p.selectStatement()
+	case p.keyword("INSERT", "INTO"):
+		return p.insertStatement()
+	case p.keyword("UPDATE"):
+		return p.updateStatement()
+	case p.keyword("DELETE", "FROM"):
+		return p.deleteStatement()
+	case p.keyword("CREATE", "TABLE"):
+		return p.createTable()
+	case p.keyword("ALTER", "TABLE"):
+		return p.alterTable()
+	case p.keyword("DROP", "TABLE"):
+		st := &cqlStatement{kind: "drop", ifExists: p.keyword("IF", "EXISTS")}
+		var err error
+		st.table, err = p.ident()
+		return st, err
+	}
+	return nil, fmt.Errorf("unsupported statement")
+}
+
+func (p *cqlParser) selectStatement() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "select", distinct: p.keyword("DISTINCT")}
+	if !p.symbol("*") {
+		columns, err := p.identList()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().text == "(" {
+			return nil, fmt.Errorf("functions are not supported")
+		}
+		st.columns = columns
+	}
+	var err error
+	if err = p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.keyword("WHERE") {
+		if st.where, err = p.where(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("ORDER", "BY") {
+		for {
+			var ordering cqlOrdering
+			if ordering.column, err = p.ident(); err != nil {
+				return nil, err
+			}
+			ordering.desc = p.keyword("DESC")
+			if !ordering.desc {
+				p.keyword("ASC")
+			}
+			st.orderBy = append(st.orderBy, ordering)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("LIMIT") {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		st.limit = &term
+	}
+	if p.keyword("ALLOW", "FILTERING") {
+		return nil, fmt.Errorf("ALLOW FILTERING is not supported")
+	}
+	return st, nil
+}
+
+func (p *cqlParser) insertStatement() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "insert"}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	if st.columns, err = p.identList(); err != nil {
+		return nil, err
+	}
+	if err = p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	if err = p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		st.terms = append(st.terms, term)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err = p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if len(st.terms) != len(st.columns) {
+		return nil, fmt.Errorf("%d columns but %d values", len(st.columns), len(st.terms))
+	}
+	st.ifNotExists = p.keyword("IF", "NOT", "EXISTS")
+	if err = p.usingTimestamp(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (p *cqlParser) updateStatement() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "update"}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.usingTimestamp(st); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		column, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		term, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		st.columns = append(st.columns, column)
+		st.terms = append(st.terms, term)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err = p.expectKeyword("WHERE"); err != nil {
+		return nil, err
+	}
+	if st.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	st.ifExists = p.keyword("IF", "EXISTS")
+	return st, nil
+}
+
+func (p *cqlParser) deleteStatement() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "delete"}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.usingTimestamp(st); err != nil {
+		return nil, err
+	}
+	if err = p.expectKeyword("WHERE"); err != nil {
+		return nil, err
+	}
+	if st.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	st.ifExists = p.keyword("IF", "EXISTS")
+	return st, nil
+}
+
+func (p *cqlParser) createTable() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "create", ifNotExists: p.keyword("IF", "NOT", "EXISTS")}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	tbl := &memTable{name: st.table, columns: make(map[string]string), partitions: make(map[string]*memPartition)}
+	if err = p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.keyword("PRIMARY", "KEY") {
+			if err := p.primaryKey(tbl); err != nil {
+				return nil, err
+			}
+		} else {
+			column, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if tbl.columns[column], err = p.typeName(); err != nil {
+				return nil, err
+			}
+			if p.keyword("PRIMARY", "KEY") {
+				tbl.partitionKey = []string{column}
+			}
+		}
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if err = p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	if len(tbl.partitionKey) == 0 {
+		return nil, fmt.Errorf("table %s has no primary key", tbl.name)
+	}
+	for _, column := range append(append([]string{}, tbl.partitionKey...), tbl.clustering...) {
+		if _, ok := tbl.columns[column]; !ok {
+			return nil, fmt.Errorf("primary key column %s of %s is not defined", column, tbl.name)
+		}
+	}
+	tbl.descending = make([]bool, len(tbl.clustering))
+
+	if p.keyword("WITH", "CLUSTERING", "ORDER", "BY") {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		for i := 0; ; i++ {
+			column, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if i >= len(tbl.clustering) || tbl.clustering[i] != column {
+				return nil, fmt.Errorf("clustering order of %s must follow the clustering columns", tbl.name)
+			}
+			tbl.descending[i] = p.keyword("DESC")
+			if !tbl.descending[i] {
+				if err := p.expectKeyword("ASC"); err != nil {
+					return nil, err
+				}
+			}
+			if !p.symbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("AND") || p.keyword("WITH") {
+		return nil, fmt.Errorf("table options other than CLUSTERING ORDER are not supported")
+	}
+	st.definition = tbl
+	return st, nil
+}
+
+func (p *cqlParser) primaryKey(tbl *memTable) error {
+	if err := p.expectSymbol("("); err != nil {
+		return err
+	}
+	if p.symbol("(") {
+		columns, err := p.identList()
+		if err != nil {
+			return err
+		}
+		tbl.partitionKey = columns
+		if err := p.expectSymbol(")"); err != nil {
+			return err
+		}
+	} else {
+		column, err := p.ident()
+		if err != nil {
+			return err
+		}
+		tbl.partitionKey = []string{column}
+	}
+	if p.symbol(",") {
+		columns, err := p.identList()
+		if err != nil {
+			return err
+		}
+		tbl.clustering = columns
+	}
+	return p.expectSymbol(")")
+}
+
+func (p *cqlParser) alterTable() (*cqlStatement, error) {
+	st := &cqlStatement{kind: "alter"}
+	var err error
+	if st.table, err = p.ident(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.keyword("ADD"):
+		if st.alterAdd, err = p.ident(); err != nil {
+			return nil, err
+		}
+		st.alterType, err = p.typeName()
+		return st, err
+	case p.keyword("DROP"):
+		st.alterDrop, err = p.ident()
+		return st, err
+	}
+	return nil, fmt.Errorf("only ALTER TABLE ADD and DROP are supported")
+}
+
+// --- Tests of the memory session itself ---
+
+func TestMemorySession_RegisteredStatementsParse(t *testing.T) {
+	for name, stmt := range registeredStatements {
+		if name == "health_check" {
+			continue
+		}
+		_, err := parseCQL(stmt)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestMemorySession_ClusteringOrderAndRanges(t *testing.T) {
+	s := newMemorySession(t)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.Query(insertRouteTripQuery, "r1", "2024", start.Add(time.Duration(i)*time.Hour), nil).Exec())
+	}
+
+	var starts []time.Time
+	var st time.Time
+	iter := s.Query(getRouteTripsQuery, "r1", "2024", start.Add(time.Hour), start.Add(3*time.Hour)).Iter()
+	for iter.Scan(&st) {
+		starts = append(starts, st)
+	}
+	require.NoError(t, iter.Close())
+	assert.Equal(t, []time.Time{start.Add(time.Hour), start.Add(2 * time.Hour)}, starts)
+
+	iter = s.Query(`SELECT start_time FROM route_trips WHERE route_id = ? AND year = ? ORDER BY start_time DESC LIMIT 1`, "r1", "2024").Iter()
+	require.True(t, iter.Scan(&st))
+	assert.Equal(t, start.Add(3*time.Hour), st)
+	require.NoError(t, iter.Close())
+
+	iter = s.Query(`SELECT start_time FROM route_trips WHERE route_id = ? AND year = ? ORDER BY start_time ASC, route_id DESC`, "r1", "2024").Iter()
+	assert.False(t, iter.Scan(&st))
+	assert.ErrorContains(t, iter.Close(), "ORDER BY must follow the clustering columns")
+
+	iter = s.Query(`SELECT start_time FROM route_trips WHERE start_time > ?`, start).Iter()
+	assert.False(t, iter.Scan(&st))
+	assert.ErrorContains(t, iter.Close(), "ALLOW FILTERING")
+}
+
+func TestMemorySession_Paging(t *testing.T) {
+	s := newMemorySession(t)
+	for _, route := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, s.Query(insertRouteQuery, route).Exec())
+	}
+
+	var routes []string
+	var state []byte
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5)
+		iter := s.Query(getRoutesQuery).PageSize(2).PageState(state).Iter()
+		var route string
+		for iter.Scan(&route) {
+			routes = append(routes, route)
+		}
+		require.NoError(t, iter.Close())
+		if state = iter.PageState(); len(state) == 0 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, routes)
+}
+
+func TestMemorySession_TimestampsAndTombstones(t *testing.T) {
+	s := newMemorySession(t)
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	insert := func(ts int64, sales int) {
+		require.NoError(t, s.Query(insertRouteDailyRevenueQuery, "r1", "2024-03-01", start, sales, 0, 0, 0, int64(0), int64(0), ts).Exec())
+	}
+
+	insert(200, 2)
+	insert(100, 1) // older write loses
+	rows := s.rows(t, "route_daily_revenue")
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(2), rows[0]["sales"])
+
+	require.NoError(t, s.Query(deleteRouteDailyRevenueQuery, int64(300), "r1", "2024-03-01", start).Exec())
+	insert(250, 3) // shadowed by the newer tombstone
+	assert.Empty(t, s.rows(t, "route_daily_revenue"))
+
+	insert(400, 4)
+	rows = s.rows(t, "route_daily_revenue")
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(4), rows[0]["sales"])
+}
+
+func TestMemorySession_ConditionalWrites(t *testing.T) {
+	s := newMemorySession(t)
+	tripStart := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	opTime := tripStart.Add(time.Minute)
+	update := func() bool {
+		applied, err := s.Query(updateItemQuantityQuery, int16(3), "r1", "2024", tripStart, "e1", opTime, 7).ScanCAS()
+		require.NoError(t, err)
+		return applied
+	}
+
+	assert.False(t, update())
+	assert.Empty(t, s.rows(t, "operations"))
+
+	require.NoError(t, s.Query(insertOperationQuery, "r1", "2024", tripStart, tripStart.Add(time.Hour), int8(1), "e1",
+		int8(1), opTime, 7, int16(1), int64(100), time.Time{}, nil, nil).Exec())
+	assert.True(t, update())
+	rows := s.rows(t, "operations")
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(3), rows[0]["quantity"])
+	assert.NotContains(t, rows[0], "raw_operation_time")
+}
+
+func TestMemorySession_BatchIsAtomic(t *testing.T) {
+	s := newMemorySession(t)
+	batch := s.NewBatch(gocql.LoggedBatch)
+	batch.Query(insertRouteQuery, "r1")
+	batch.Query(insertRouteTripQuery, "r1", 2024, time.Now(), nil) // year is text
+	err := s.ExecuteBatch(batch)
+	assert.ErrorContains(t, err, "can not marshal int into text")
+	assert.Empty(t, s.rows(t, "routes"))
+}
+
+func TestMemorySession_MigrationsDown(t *testing.T) {
+	s := newMemorySession(t)
+	migrator, err := NewMigrator(s, log.NewNopLogger())
+	require.NoError(t, err)
+
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	done, err := migrator.Down(context.Background(), len(migrations))
+	require.NoError(t, err)
+	assert.Len(t, done, len(migrations))
+	assert.Equal(t, []string{"schema_migrations"}, func() []string {
+		var names []string
+		for name := range s.tables {
+			names = append(names, name)
+		}
+		return names
+	}())
+}
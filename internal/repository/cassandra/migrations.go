@@ -0,0 +1,278 @@
+package cassandra
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/go-kit/log"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.cql
+var migrationFiles embed.FS
+
+// migrationFileName matches files like 0001_initial_schema.up.cql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.cql$`)
+
+const createSchemaMigrationsQuery = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	    version    int PRIMARY KEY,
+	    name       text,
+	    applied_at timestamp)`
+
+const getSchemaMigrationsQuery = `SELECT version, applied_at FROM schema_migrations`
+
+const insertSchemaMigrationQuery = `
+	INSERT INTO schema_migrations (
+	    version,
+	    name,
+	    applied_at)
+	VALUES (?, ?, ?)`
+
+const deleteSchemaMigrationQuery = `DELETE FROM schema_migrations WHERE version = ?`
+
+// Migration is a versioned schema change with the CQL statements to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// MigrationStatus reports whether a migration has been applied to the keyspace
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// LoadMigrations Parses the CQL migrations embedded in the binary, ordered by version
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		content, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = splitCQLStatements(string(content))
+		} else {
+			migration.Down = splitCQLStatements(string(content))
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down statements", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitCQLStatements Splits a CQL script into statements, dropping "--" comment lines
+func splitCQLStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var statements []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+// Migrator applies and reverts schema migrations, recording applied versions in schema_migrations
+type Migrator struct {
+	session    CassandraSession
+	log        log.Logger
+	migrations []Migration
+}
+
+// NewMigrator Creates a Migrator for the embedded migrations
+func NewMigrator(session CassandraSession, logger log.Logger) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{session: session, log: logger, migrations: migrations}, nil
+}
+
+// Status Lists every known migration and whether it has been applied, without changing the schema
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// Up Applies every pending migration in version order, returns the applied ones
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.exec(ctx, migration, migration.Up); err != nil {
+			return done, err
+		}
+		err := m.session.Query(insertSchemaMigrationQuery,
+			migration.Version,
+			migration.Name,
+			time.Now().UTC()).WithContext(ctx).Exec()
+		if err != nil {
+			return done, fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+		_ = m.log.Log("msg", "applied migration", "version", migration.Version, "name", migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down Reverts the last steps applied migrations in reverse version order, returns the reverted ones
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := m.createMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.exec(ctx, migration, migration.Down); err != nil {
+			return done, err
+		}
+		err := m.session.Query(deleteSchemaMigrationQuery, migration.Version).WithContext(ctx).Exec()
+		if err != nil {
+			return done, fmt.Errorf("failed to record revert of migration %d: %w", migration.Version, err)
+		}
+		_ = m.log.Log("msg", "reverted migration", "version", migration.Version, "name", migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// exec Runs the statements of a migration. Cassandra has no IF [NOT] EXISTS for columns, so an ALTER TABLE that
+// finds its column already added or dropped is skipped; this lets a migration that failed part way be run again
+func (m *Migrator) exec(ctx context.Context, migration Migration, statements []string) error {
+	for i, stmt := range statements {
+		err := m.session.Query(stmt).WithContext(ctx).Exec()
+		if err != nil && columnAlreadyAltered(stmt, err) {
+			_ = m.log.Log("msg", "skipped applied statement", "version", migration.Version, "statement", i+1, "reason", err)
+			continue
+		}
+		if err != nil {
+			_ = m.log.Log("error", fmt.Sprintf("Migration %d_%s failed at statement %d: %v", migration.Version, migration.Name, i+1, err))
+			return fmt.Errorf("migration %d_%s statement %d: %w", migration.Version, migration.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// createMigrationsTable Creates the schema_migrations table if needed
+func (m *Migrator) createMigrationsTable(ctx context.Context) error {
+	if err := m.session.Query(createSchemaMigrationsQuery).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedVersions Returns applied versions with their time, none when the schema_migrations table does not exist yet
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int]time.Time, error) {
+	iter := m.session.Query(getSchemaMigrationsQuery).WithContext(ctx).Iter()
+	applied := make(map[int]time.Time)
+	var version int
+	var appliedAt time.Time
+	for iter.Scan(&version, &appliedAt) {
+		applied[version] = appliedAt
+	}
+	if err := iter.Close(); err != nil {
+		if strings.Contains(err.Error(), "unconfigured table") {
+			return map[int]time.Time{}, nil
+		}
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// columnAlreadyAltered reports whether err means an ALTER TABLE ... ADD found the column already there or an
+// ALTER TABLE ... DROP found it already gone
+func columnAlreadyAltered(stmt string, err error) bool {
+	fields := strings.Fields(strings.ToUpper(stmt))
+	if len(fields) < 4 || fields[0] != "ALTER" || fields[1] != "TABLE" {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	switch fields[3] {
+	case "ADD":
+		return strings.Contains(msg, "already exists") || strings.Contains(msg, "conflicts with an existing column")
+	case "DROP":
+		return strings.Contains(msg, "was not found")
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package cassandra
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migration versions must be contiguous")
+		assert.NotEmpty(t, m.Up, "migration %d has no up statements", m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d has no down statements", m.Version)
+	}
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "bad file name",
+			fsys: fstest.MapFS{"m/schema.cql": {Data: []byte("CREATE TABLE t (a int PRIMARY KEY);")}},
+		},
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{"m/0001_a.up.cql": {Data: []byte("CREATE TABLE t (a int PRIMARY KEY);")}},
+		},
+		{
+			name: "conflicting names",
+			fsys: fstest.MapFS{
+				"m/0001_a.up.cql":   {Data: []byte("CREATE TABLE t (a int PRIMARY KEY);")},
+				"m/0001_b.down.cql": {Data: []byte("DROP TABLE t;")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.fsys, "m")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSplitCQLStatements(t *testing.T) {
+	script := `-- leading comment
+CREATE TABLE a (
+    id int PRIMARY KEY
+);
+
+  -- indented comment
+DROP TABLE b;
+`
+	assert.Equal(t, []string{
+		"CREATE TABLE a (\n    id int PRIMARY KEY\n)",
+		"DROP TABLE b",
+	}, splitCQLStatements(script))
+}
+
+// newMigrationTestSession Returns a session that reports the given versions as applied
+// and accepts any other statement
+func newMigrationTestSession(applied [][]interface{}, execErr error) (*MockSession, *FakeQuery) {
+	mockSession := new(MockSession)
+
+	readQuery := new(FakeQuery)
+	readQuery.On("WithContext", mock.Anything).Return(readQuery)
+	readQuery.On("Iter").Return(&rowsIter{rows: applied})
+	mockSession.On("Query", getSchemaMigrationsQuery, mock.Anything).Return(readQuery)
+
+	execQuery := new(FakeQuery)
+	execQuery.On("WithContext", mock.Anything).Return(execQuery)
+	execQuery.On("Exec").Return(execErr)
+	mockSession.On("Query", mock.Anything, mock.Anything).Return(execQuery)
+
+	return mockSession, execQuery
+}
+
+func TestMigrator_Up(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: []string{"CREATE TABLE a (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE a"}},
+		{Version: 2, Name: "two", Up: []string{"CREATE TABLE b (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE b"}},
+	}
+	applied := [][]interface{}{{1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	mockSession, _ := newMigrationTestSession(applied, nil)
+	m := &Migrator{session: mockSession, log: log.NewNopLogger(), migrations: migrations}
+
+	done, err := m.Up(context.Background())
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, 2, done[0].Version)
+
+	mockSession.AssertCalled(t, "Query", "CREATE TABLE b (id int PRIMARY KEY)", mock.Anything)
+	mockSession.AssertNotCalled(t, "Query", "CREATE TABLE a (id int PRIMARY KEY)", mock.Anything)
+	mockSession.AssertCalled(t, "Query", insertSchemaMigrationQuery, mock.MatchedBy(func(values []interface{}) bool {
+		return len(values) == 3 && values[0] == 2 && values[1] == "two"
+	}))
+}
+
+func TestMigrator_UpStopsOnError(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: []string{"CREATE TABLE a (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE a"}},
+	}
+
+	mockSession := new(MockSession)
+	readQuery := new(FakeQuery)
+	readQuery.On("WithContext", mock.Anything).Return(readQuery)
+	readQuery.On("Iter").Return(&rowsIter{})
+	mockSession.On("Query", getSchemaMigrationsQuery, mock.Anything).Return(readQuery)
+
+	okQuery := new(FakeQuery)
+	okQuery.On("WithContext", mock.Anything).Return(okQuery)
+	okQuery.On("Exec").Return(nil)
+	mockSession.On("Query", createSchemaMigrationsQuery, mock.Anything).Return(okQuery)
+
+	failQuery := new(FakeQuery)
+	failQuery.On("WithContext", mock.Anything).Return(failQuery)
+	failQuery.On("Exec").Return(errors.New("syntax error"))
+	mockSession.On("Query", "CREATE TABLE a (id int PRIMARY KEY)", mock.Anything).Return(failQuery)
+
+	m := &Migrator{session: mockSession, log: log.NewNopLogger(), migrations: migrations}
+
+	done, err := m.Up(context.Background())
+	assert.Error(t, err)
+	assert.Empty(t, done)
+	mockSession.AssertNotCalled(t, "Query", insertSchemaMigrationQuery, mock.Anything)
+}
+
+func TestMigrator_Down(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: []string{"CREATE TABLE a (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE a"}},
+		{Version: 2, Name: "two", Up: []string{"CREATE TABLE b (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE b"}},
+		{Version: 3, Name: "three", Up: []string{"CREATE TABLE c (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE c"}},
+	}
+	appliedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	applied := [][]interface{}{{1, appliedAt}, {2, appliedAt}}
+
+	mockSession, _ := newMigrationTestSession(applied, nil)
+	m := &Migrator{session: mockSession, log: log.NewNopLogger(), migrations: migrations}
+
+	done, err := m.Down(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, 2, done[0].Version)
+
+	mockSession.AssertCalled(t, "Query", "DROP TABLE b", mock.Anything)
+	mockSession.AssertNotCalled(t, "Query", "DROP TABLE c", mock.Anything)
+	mockSession.AssertNotCalled(t, "Query", "DROP TABLE a", mock.Anything)
+	mockSession.AssertCalled(t, "Query", deleteSchemaMigrationQuery, []interface{}{2})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: []string{"CREATE TABLE a (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE a"}},
+		{Version: 2, Name: "two", Up: []string{"CREATE TABLE b (id int PRIMARY KEY)"}, Down: []string{"DROP TABLE b"}},
+	}
+	appliedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mockSession, _ := newMigrationTestSession([][]interface{}{{1, appliedAt}}, nil)
+	m := &Migrator{session: mockSession, log: log.NewNopLogger(), migrations: migrations}
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.True(t, statuses[0].Applied)
+	assert.Equal(t, appliedAt, statuses[0].AppliedAt)
+	assert.False(t, statuses[1].Applied)
+	mockSession.AssertNotCalled(t, "Query", createSchemaMigrationsQuery, mock.Anything)
+}
+
+func TestMigrator_StatusOfEmptyKeyspace(t *testing.T) {
+	session := &memorySession{
+		tables:   make(map[string]*memTable),
+		parsed:   make(map[string]*cqlStatement),
+		failures: make(map[string]error),
+		now:      time.Now,
+	}
+	m, err := NewMigrator(session, log.NewNopLogger())
+	require.NoError(t, err)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	for _, status := range statuses {
+		assert.False(t, status.Applied, "migration %d", status.Version)
+	}
+	assert.Empty(t, session.tables, "status must not create schema_migrations")
+}
+
+func TestMigrator_UpRerunsPartiallyAppliedMigration(t *testing.T) {
+	session := newMemorySession(t)
+	m, err := NewMigrator(session, log.NewNopLogger())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 0003 added its columns and then failed before it was recorded
+	require.NoError(t, session.Query(deleteSchemaMigrationQuery, 3).Exec())
+
+	done, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, 3, done[0].Version)
+	assert.Error(t, session.Query("ALTER TABLE operations ADD clock_skew_ms bigint").Exec(), "column is still there")
+}
+
+func TestColumnAlreadyAltered(t *testing.T) {
+	tests := []struct {
+		stmt string
+		err  error
+		want bool
+	}{
+		{"ALTER TABLE operations ADD commit_id text", errors.New("Invalid column name commit_id because it conflicts with an existing column"), true},
+		{"alter table operations add commit_id text", errors.New("Column with name 'commit_id' already exists"), true},
+		{"ALTER TABLE operations DROP commit_id", errors.New("Column commit_id was not found in table operations"), true},
+		{"ALTER TABLE operations ADD commit_id text", errors.New("unconfigured table operations"), false},
+		{"CREATE TABLE a (id int PRIMARY KEY)", errors.New("table a already exists"), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, columnAlreadyAltered(tt.stmt, tt.err), tt.stmt)
+	}
+}
@@ -0,0 +1,274 @@
+package cassandra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// unregisteredStatement is the name reported for statements missing from the registry,
+// such as the DDL run by migrations
+const unregisteredStatement = "unregistered"
+
+// batchStatementName is the name reported for batch executions
+const batchStatementName = "batch"
+
+// registeredStatements names every CQL statement the repository runs
+var registeredStatements = map[string]string{
+	"health_check": healthCheckQuery,
+
+	"insert_operation":               insertOperationQuery,
+	"insert_employee_trip":           insertEmployeeTripsQuery,
+	"insert_unsynchronized_trip":     insertUnsynchronizedTripQuery,
+	"insert_route":                   insertRouteQuery,
+	"insert_trip_employee":           insertTripEmployeeQuery,
+	"insert_route_trip":              insertRouteTripQuery,
+	"insert_report_commit":           insertReportCommitQuery,
+	"get_report_commits":             getReportCommitsQuery,
+	"insert_clock_skew_report":       insertClockSkewReportQuery,
+	"get_clock_skew_report":          getClockSkewReportQuery,
+	"insert_receipt":                 insertReceiptQuery,
+	"get_receipt":                    getReceiptQuery,
+	"insert_trip_receipt":            insertTripReceiptQuery,
+	"get_trip_receipts":              getTripReceiptsQuery,
+	"delete_receipt":                 deleteReceiptQuery,
+	"delete_trip_receipts":           deleteTripReceiptsQuery,
+	"get_trip":                       getTripQuery,
+	"get_employee_carts_in_trip":     getEmployeeCartsInTripQuery,
+	"get_employee_carts_in_trip_asc": getEmployeeCartsInTripAscQuery,
+	"get_operation_employee_ids":     getOperationEmployeeIDsQuery,
+	"get_trip_employees":             getTripEmployeesQuery,
+	"has_employee_operations":        hasEmployeeOperationsQuery,
+	"get_employee_trips":             getEmployeeTripsQuery,
+	"get_unsynced_trips":             getUnsyncedTripsQuery,
+	"update_item_quantity":           updateItemQuantityQuery,
+	"delete_item_from_cart":          deleteItemFromCartQuery,
+	"delete_operation":               deleteOperationQuery,
+	"get_routes":                     getRoutesQuery,
+	"get_route_trips":                getRouteTripsQuery,
+	"get_trip_keys":                  getTripKeysQuery,
+	"delete_trip_operations":         deleteTripOperationsQuery,
+	"delete_employee_trip":           deleteEmployeeTripQuery,
+	"delete_trip_employee":           deleteTripEmployeeQuery,
+	"delete_trip_employees":          deleteTripEmployeesQuery,
+	"delete_route_trip":              deleteRouteTripQuery,
+	"delete_report_commits":          deleteReportCommitsQuery,
+	"delete_clock_skew_reports":      deleteClockSkewReportsQuery,
+	"delete_queued_trip":             deleteUnsynchronizedTripQuery,
+	"insert_archived_trip":           insertArchivedTripQuery,
+	"get_archived_trips":             getArchivedTripsQuery,
+	"get_archived_trips_by_route":    getArchivedTripsByRouteQuery,
+	"delete_unsynchronized_trip":     deleteTripFromUnsynchronizedTripsQuery,
+	"get_trip_revenue":               getTripRevenueQuery,
+	"insert_trip_revenue":            insertTripRevenueQuery,
+	"insert_route_daily_revenue":     insertRouteDailyRevenueQuery,
+	"insert_employee_daily_revenue":  insertEmployeeDailyRevenueQuery,
+	"insert_product_daily_revenue":   insertProductDailyRevenueQuery,
+	"delete_trip_revenue":            deleteTripRevenueQuery,
+	"delete_route_daily_revenue":     deleteRouteDailyRevenueQuery,
+	"delete_employee_daily_revenue":  deleteEmployeeDailyRevenueQuery,
+	"delete_product_daily_revenue":   deleteProductDailyRevenueQuery,
+	"get_route_daily_revenue":        getRouteDailyRevenueQuery,
+	"get_employee_daily_revenue":     getEmployeeDailyRevenueQuery,
+	"get_product_daily_revenue":      getProductDailyRevenueQuery,
+	"insert_correction":              insertCorrectionQuery,
+	"get_corrections":                getCorrectionsQuery,
+	"insert_anomaly":                 insertAnomalyQuery,
+	"get_anomalies":                  getAnomaliesQuery,
+}
+
+// statementNames maps the CQL of every registered statement back to its name
+var statementNames = func() map[string]string {
+	names := make(map[string]string, len(registeredStatements))
+	for name, stmt := range registeredStatements {
+		if other, ok := names[stmt]; ok {
+			panic(fmt.Sprintf("statements %s and %s have the same CQL", other, name))
+		}
+		names[stmt] = name
+	}
+	return names
+}()
+
+// StatementName Returns the registered name of stmt
+func StatementName(stmt string) string {
+	if name, ok := statementNames[stmt]; ok {
+		return name
+	}
+	return unregisteredStatement
+}
+
+// statementPreparer is implemented by sessions able to prepare a statement without running it
+type statementPreparer interface {
+	prepare(ctx context.Context, stmt string) error
+}
+
+// PrepareStatements Prepares every registered statement, so a keyspace that does not match the
+// statements (missing table or column) fails at startup rather than on the first request using it
+func PrepareStatements(ctx context.Context, session CassandraSession) error {
+	p, ok := session.(statementPreparer)
+	if !ok {
+		return fmt.Errorf("session %T cannot prepare statements", session)
+	}
+
+	names := make([]string, 0, len(registeredStatements))
+	for name := range registeredStatements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := p.prepare(ctx, registeredStatements[name]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to prepare %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StatementObserver receives the latency and outcome of every statement execution
+type StatementObserver interface {
+	Observe(name string, latency time.Duration, err error)
+}
+
+// StatementStat is the execution statistics of one statement
+type StatementStat struct {
+	Name         string        `json:"name"`
+	Calls        int64         `json:"calls"`
+	Errors       int64         `json:"errors"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// StatementStats is a StatementObserver keeping call, error and latency totals per statement
+type StatementStats struct {
+	mu    sync.Mutex
+	stats map[string]*StatementStat
+}
+
+// NewStatementStats Creates empty statement statistics
+func NewStatementStats() *StatementStats {
+	return &StatementStats{stats: make(map[string]*StatementStat)}
+}
+
+// Observe Records one execution of the statement name
+func (s *StatementStats) Observe(name string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat, ok := s.stats[name]
+	if !ok {
+		stat = &StatementStat{Name: name}
+		s.stats[name] = stat
+	}
+	stat.Calls++
+	if err != nil {
+		stat.Errors++
+	}
+	stat.TotalLatency += latency
+	if latency > stat.MaxLatency {
+		stat.MaxLatency = latency
+	}
+}
+
+// Snapshot Returns a copy of the statistics sorted by statement name
+func (s *StatementStats) Snapshot() []StatementStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]StatementStat, 0, len(s.stats))
+	for _, stat := range s.stats {
+		snapshot = append(snapshot, *stat)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Name < snapshot[j].Name })
+	return snapshot
+}
+
+// NewInstrumentedSession Wraps session so every query and batch execution is reported to observer
+// under its statement name
+func NewInstrumentedSession(session CassandraSession, observer StatementObserver) CassandraSession {
+	return &instrumentedSession{CassandraSession: session, observer: observer, now: time.Now}
+}
+
+type instrumentedSession struct {
+	CassandraSession
+	observer StatementObserver
+	now      func() time.Time
+}
+
+func (s *instrumentedSession) Query(stmt string, values ...interface{}) Query {
+	return &instrumentedQuery{q: s.CassandraSession.Query(stmt, values...), name: StatementName(stmt), session: s}
+}
+
+func (s *instrumentedSession) ExecuteBatch(batch Batch) error {
+	start := s.now()
+	err := s.CassandraSession.ExecuteBatch(batch)
+	s.observer.Observe(batchStatementName, s.now().Sub(start), err)
+	return err
+}
+
+func (s *instrumentedSession) prepare(ctx context.Context, stmt string) error {
+	p, ok := s.CassandraSession.(statementPreparer)
+	if !ok {
+		return fmt.Errorf("session %T cannot prepare statements", s.CassandraSession)
+	}
+	return p.prepare(ctx, stmt)
+}
+
+type instrumentedQuery struct {
+	q       Query
+	name    string
+	session *instrumentedSession
+}
+
+func (iq *instrumentedQuery) WithContext(ctx context.Context) Query {
+	return &instrumentedQuery{q: iq.q.WithContext(ctx), name: iq.name, session: iq.session}
+}
+
+func (iq *instrumentedQuery) Exec() error {
+	start := iq.session.now()
+	err := iq.q.Exec()
+	iq.session.observer.Observe(iq.name, iq.session.now().Sub(start), err)
+	return err
+}
+
+// Iter Times the statement from the first page request until the iterator is closed
+func (iq *instrumentedQuery) Iter() Iter {
+	return &instrumentedIter{Iter: iq.q.Iter(), name: iq.name, session: iq.session, start: iq.session.now()}
+}
+
+func (iq *instrumentedQuery) ScanCAS(dest ...interface{}) (bool, error) {
+	start := iq.session.now()
+	applied, err := iq.q.ScanCAS(dest...)
+	iq.session.observer.Observe(iq.name, iq.session.now().Sub(start), err)
+	return applied, err
+}
+
+func (iq *instrumentedQuery) PageSize(n int) Query {
+	iq.q = iq.q.PageSize(n)
+	return iq
+}
+
+func (iq *instrumentedQuery) PageState(state []byte) Query {
+	iq.q = iq.q.PageState(state)
+	return iq
+}
+
+type instrumentedIter struct {
+	Iter
+	name    string
+	session *instrumentedSession
+	start   time.Time
+	closed  bool
+}
+
+func (ii *instrumentedIter) Close() error {
+	err := ii.Iter.Close()
+	if !ii.closed {
+		ii.closed = true
+		ii.session.observer.Observe(ii.name, ii.session.now().Sub(ii.start), err)
+	}
+	return err
+}
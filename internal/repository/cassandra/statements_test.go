@@ -0,0 +1,139 @@
+package cassandra
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createTableName    = regexp.MustCompile(`(?i)CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+	statementTableName = regexp.MustCompile(`(?i)(?:FROM|INTO|UPDATE)\s+(\w+(?:\.\w+)?)`)
+)
+
+func TestStatementName(t *testing.T) {
+	assert.Equal(t, "get_trip", StatementName(getTripQuery))
+	assert.Equal(t, "insert_operation", StatementName(insertOperationQuery))
+	assert.Equal(t, unregisteredStatement, StatementName(createSchemaMigrationsQuery))
+}
+
+func TestRegisteredStatementsUseMigratedTables(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+
+	tables := map[string]bool{"system.local": true}
+	for _, migration := range migrations {
+		for _, stmt := range migration.Up {
+			if m := createTableName.FindStringSubmatch(stmt); m != nil {
+				tables[m[1]] = true
+			}
+		}
+	}
+
+	for name, stmt := range registeredStatements {
+		m := statementTableName.FindStringSubmatch(stmt)
+		if assert.NotNil(t, m, name) {
+			assert.True(t, tables[m[1]], "statement %s uses table %s, which no migration creates", name, m[1])
+		}
+	}
+}
+
+// fakePreparer records prepared statements and fails the ones in failing
+type fakePreparer struct {
+	MockSession
+	prepared []string
+	failing  map[string]bool
+}
+
+func (p *fakePreparer) prepare(_ context.Context, stmt string) error {
+	p.prepared = append(p.prepared, stmt)
+	if p.failing[stmt] {
+		return fmt.Errorf("unconfigured table")
+	}
+	return nil
+}
+
+func TestPrepareStatements(t *testing.T) {
+	session := &fakePreparer{}
+	require.NoError(t, PrepareStatements(context.Background(), session))
+	assert.Len(t, session.prepared, len(registeredStatements))
+
+	session = &fakePreparer{failing: map[string]bool{getTripQuery: true, getRoutesQuery: true}}
+	err := PrepareStatements(context.Background(), NewInstrumentedSession(session, NewStatementStats()))
+	assert.ErrorContains(t, err, "failed to prepare get_trip: unconfigured table")
+	assert.ErrorContains(t, err, "failed to prepare get_routes: unconfigured table")
+	assert.Len(t, session.prepared, len(registeredStatements))
+
+	err = PrepareStatements(context.Background(), new(MockSession))
+	assert.ErrorContains(t, err, "cannot prepare statements")
+}
+
+func TestInstrumentedSession(t *testing.T) {
+	mockSession := new(MockSession)
+	stats := NewStatementStats()
+	session := NewInstrumentedSession(mockSession, stats).(*instrumentedSession)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	session.now = func() time.Time {
+		clock = clock.Add(10 * time.Millisecond)
+		return clock
+	}
+
+	execQuery := new(FakeQuery)
+	execQuery.On("WithContext", mock.Anything).Return(execQuery)
+	execQuery.On("Exec").Return(nil).Once()
+	execQuery.On("Exec").Return(fmt.Errorf("write timeout"))
+	mockSession.On("Query", statementNamed("insert_receipt"), mock.Anything).Return(execQuery)
+
+	iterQuery := new(FakeQuery)
+	iterQuery.On("Iter").Return(&rowsIter{closeErr: fmt.Errorf("read timeout")})
+	mockSession.On("Query", statementNamed("get_routes"), mock.Anything).Return(iterQuery)
+
+	ddlQuery := new(FakeQuery)
+	ddlQuery.On("Exec").Return(nil)
+	mockSession.On("Query", createSchemaMigrationsQuery, mock.Anything).Return(ddlQuery)
+
+	batch := new(FakeBatch)
+	mockSession.On("ExecuteBatch", batch).Return(nil)
+
+	ctx := context.Background()
+	assert.NoError(t, session.Query(insertReceiptQuery, "id").WithContext(ctx).Exec())
+	assert.Error(t, session.Query(insertReceiptQuery, "id").WithContext(ctx).Exec())
+	iter := session.Query(getRoutesQuery).Iter()
+	assert.False(t, iter.Scan())
+	assert.Error(t, iter.Close())
+	assert.Error(t, iter.Close())
+	assert.NoError(t, session.Query(createSchemaMigrationsQuery).Exec())
+	assert.NoError(t, session.ExecuteBatch(batch))
+
+	ms := 10 * time.Millisecond
+	assert.Equal(t, []StatementStat{
+		{Name: batchStatementName, Calls: 1, TotalLatency: ms, MaxLatency: ms},
+		{Name: "get_routes", Calls: 1, Errors: 1, TotalLatency: ms, MaxLatency: ms},
+		{Name: "insert_receipt", Calls: 2, Errors: 1, TotalLatency: 2 * ms, MaxLatency: ms},
+		{Name: unregisteredStatement, Calls: 1, TotalLatency: ms, MaxLatency: ms},
+	}, stats.Snapshot())
+	mockSession.AssertExpectations(t)
+}
+
+func TestInstrumentedSession_Batch(t *testing.T) {
+	mockSession := new(MockSession)
+	batch := new(FakeBatch)
+	mockSession.On("NewBatch", gocql.LoggedBatch).Return(batch)
+	mockSession.On("ExecuteBatch", batch).Return(fmt.Errorf("batch too large"))
+
+	stats := NewStatementStats()
+	session := NewInstrumentedSession(mockSession, stats)
+	assert.Error(t, session.ExecuteBatch(session.NewBatch(gocql.LoggedBatch)))
+
+	snapshot := stats.Snapshot()
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, batchStatementName, snapshot[0].Name)
+	assert.Equal(t, int64(1), snapshot[0].Errors)
+}
@@ -7,24 +7,84 @@ import (
 )
 
 type SalesRepository interface {
-	// InsertData Inserts all data from a CarriageReport into the Cassandra database
-	InsertData(ctx context.Context, carriageReport *models.CarriageReport) error
+	// InsertData Inserts all data from a CarriageReport into the Cassandra database; receipt, when not nil,
+	// is stored in the same batch that makes the report visible
+	InsertData(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) error
+
+	// ImportData Inserts a carriage report restored from an archive without queueing its trip for synchronization
+	ImportData(ctx context.Context, carriageReport *models.CarriageReport) error
+
+	// ReplaceCarriageReport Replaces the stored operations of a trip carriage with the ones in carriageReport,
+	// storing receipt, when not nil, in the same batch
+	ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) (models.ReportDiff, error)
+
+	// GetReceipt Gets a receipt by report ID, returns models.ErrNotFound if it does not exist
+	GetReceipt(ctx context.Context, reportID string) (models.Receipt, error)
+
+	// GetClockSkew Gets the clock skew stored for the first upload of a trip carriage report,
+	// returns models.ErrNotFound if none was stored
+	GetClockSkew(ctx context.Context, tripID *models.TripID, carriageID int8) (models.ClockSkew, error)
 
 	// GetTrip Gets all reports from a single trip
 	GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error)
 
-	// GetEmployeeCartsInTrip Gets all carts employee has sold during trip, returns array of Carts
-	GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string) ([]models.Cart, error)
+	// GetEmployeeCartsInTrip Gets the carts employee has sold during trip matching filter, ordered by operation time
+	// and product ID
+	GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string, filter models.CartFilter) ([]models.Cart, error)
 
-	// GetEmployeeCartsInTripPaged Gets paged carts an employee has sold during trip, returns array of Carts and a cursor for paging
-	GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, cartLimit int, cursorB64 string) ([]models.Cart, string, error)
+	// GetEmployeeCartsInTripPaged Gets a page of the carts employee has sold during trip matching filter,
+	// cursorB64 is a cursor of a previous page or empty for the first page
+	GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, filter models.CartFilter, cartLimit int, cursorB64 string) (models.CartPage, error)
 
 	// GetEmployeeIDsByTrip Gets all employees in trip
 	GetEmployeeIDsByTrip(ctx context.Context, tripID *models.TripID) ([]string, error)
 
+	// BackfillTripEmployees Indexes employees of trips stored before the trip_employees table existed, returns the number of trips indexed
+	BackfillTripEmployees(ctx context.Context) (int, error)
+
 	// GetEmployeeTrips Gets all trips completed by employee
 	GetEmployeeTrips(ctx context.Context, employeeID string, year string) ([]models.EmployeeTrip, error)
 
+	// GetRoutes Gets the IDs of every route that has reported trips
+	GetRoutes(ctx context.Context) ([]string, error)
+
+	// GetRouteTrips Gets the trips of a route started in [from, to)
+	GetRouteTrips(ctx context.Context, routeID string, from, to time.Time) ([]models.TripID, error)
+
+	// BackfillRouteTrips Indexes trips stored before the route_trips table existed, returns the number indexed
+	BackfillRouteTrips(ctx context.Context) (int, error)
+
+	// DeleteTrip Deletes all rows of a trip from operations and the trip index tables
+	DeleteTrip(ctx context.Context, tripID *models.TripID, employeeIDs []string) error
+
+	// InsertArchivedTrip Records a trip moved to an archive file
+	InsertArchivedTrip(ctx context.Context, archived *models.ArchivedTrip) error
+
+	// GetArchivedTrips Gets archived trips of a route, or of all routes when routeID is empty
+	GetArchivedTrips(ctx context.Context, routeID string) ([]models.ArchivedTrip, error)
+
+	// RefreshTripAggregates Recomputes the revenue aggregates of a trip from its operations
+	RefreshTripAggregates(ctx context.Context, tripID *models.TripID) error
+
+	// RebuildAggregates Recomputes the revenue aggregates of every stored trip, returns the number of trips rebuilt
+	RebuildAggregates(ctx context.Context) (int, error)
+
+	// GetTripRevenue Gets the revenue aggregate of a trip, returns models.ErrNotFound if it has none
+	GetTripRevenue(ctx context.Context, tripID *models.TripID) (models.TripRevenue, error)
+
+	// GetDailyRevenue Gets the revenue of a route, employee or product for every UTC day in [from, to] that has operations
+	GetDailyRevenue(ctx context.Context, scope models.RevenueScope, from, to time.Time) ([]models.DailyRevenue, error)
+
+	// GetCorrections Gets the corrections made on the UTC day of day; UpdateItemQuantity, DeleteItemFromCart
+	// and ReplaceCarriageReport record them when the change is applied
+	GetCorrections(ctx context.Context, day time.Time) ([]models.Correction, error)
+
+	// InsertAnomaly Stores an anomaly, replacing the one with the same day, kind and ID
+	InsertAnomaly(ctx context.Context, anomaly *models.Anomaly) error
+
+	// GetAnomalies Gets the anomalies of every UTC day in [from, to]
+	GetAnomalies(ctx context.Context, from, to time.Time) ([]models.Anomaly, error)
+
 	// GetUnsyncedTrips Gets all unsynced trips for the unsychronized_trips table
 	GetUnsyncedTrips(ctx context.Context) ([]models.TripID, error)
 
@@ -36,4 +96,7 @@ type SalesRepository interface {
 
 	// DeleteSyncedTrip Deletes a synced trip from the unsynced trip table
 	DeleteSyncedTrip(ctx context.Context, routeID string, startTime time.Time) error
+
+	// RequeueTrip Adds a trip back to the unsynced trip table so it is synchronized again
+	RequeueTrip(ctx context.Context, tripID *models.TripID) error
 }
@@ -0,0 +1,348 @@
+// Package retention moves trips older than the hot window out of Cassandra into archive files.
+//
+// The cutoff is year aligned because operations are partitioned by year: with HotYears = 2
+// in 2026 the trips of 2025 and 2026 stay in the keyspace and older ones are archived.
+// Trips of one route and year go to a single archive file. The file is read back and
+// compared with what was written before anything is deleted, and every trip is recorded
+// in the archive catalog before its rows are removed, so a failed run never loses data
+// and can simply be repeated.
+package retention
+
+import (
+	"ChaikaReports/internal/archive"
+	"ChaikaReports/internal/models"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/go-kit/log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Repository is the part of the sales repository used by the retention job
+type Repository interface {
+	archive.Source
+	DeleteTrip(ctx context.Context, tripID *models.TripID, employeeIDs []string) error
+	InsertArchivedTrip(ctx context.Context, archived *models.ArchivedTrip) error
+}
+
+// Result summarizes a retention run
+type Result struct {
+	Trips      int      `json:"trips"`
+	Operations int      `json:"operations"`
+	Files      []string `json:"files"`
+}
+
+// Job archives and deletes trips that started before the retention cutoff
+type Job struct {
+	repo      Repository
+	log       log.Logger
+	dir       string
+	hotYears  int
+	startYear int
+	now       func() time.Time
+	onDelete  func(tripID *models.TripID, employeeIDs []string)
+}
+
+// Option configures optional behaviour of a Job
+type Option func(*Job)
+
+// WithDeleteHook Sets a function called after every trip deletion, used to drop cached copies of the trip
+func WithDeleteHook(onDelete func(tripID *models.TripID, employeeIDs []string)) Option {
+	return func(j *Job) {
+		j.onDelete = onDelete
+	}
+}
+
+// NewJob Creates a retention job keeping hotYears years in the keyspace; startYear is the
+// earliest year scanned for expired trips and dir is where archive files are written
+func NewJob(repo Repository, logger log.Logger, dir string, hotYears, startYear int, opts ...Option) *Job {
+	j := &Job{
+		repo:      repo,
+		log:       logger,
+		dir:       dir,
+		hotYears:  hotYears,
+		startYear: startYear,
+		now:       time.Now,
+		onDelete:  func(*models.TripID, []string) {},
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Cutoff Returns the start time before which trips are archived
+func (j *Job) Cutoff() time.Time {
+	return time.Date(j.now().UTC().Year()-j.hotYears+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Run Runs the job immediately and then every interval until ctx is done
+func (j *Job) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := j.RunOnce(ctx)
+		if err != nil {
+			_ = j.log.Log("msg", "retention run failed", "err", err, "archived_trips", res.Trips)
+		} else {
+			_ = j.log.Log("msg", "retention run finished", "archived_trips", res.Trips, "operations", res.Operations)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce Archives every expired trip, route by route and year by year
+func (j *Job) RunOnce(ctx context.Context) (Result, error) {
+	var res Result
+	if err := os.MkdirAll(j.dir, 0o755); err != nil {
+		return res, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	routes, err := j.repo.GetRoutes(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed to list routes: %w", err)
+	}
+	sort.Strings(routes)
+
+	for year := j.startYear; year < j.Cutoff().Year(); year++ {
+		for _, routeID := range routes {
+			if err := j.archiveRouteYear(ctx, routeID, year, &res); err != nil {
+				return res, fmt.Errorf("route %s year %d: %w", routeID, year, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// tripContent is what was written to the archive for one trip
+type tripContent struct {
+	tripID     models.TripID
+	employees  []string
+	reports    int
+	operations int
+	digest     [sha256.Size]byte
+}
+
+func (j *Job) archiveRouteYear(ctx context.Context, routeID string, year int, res *Result) error {
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tripIDs, err := j.repo.GetRouteTrips(ctx, routeID, from, from.AddDate(1, 0, 0))
+	if err != nil {
+		return fmt.Errorf("failed to list trips: %w", err)
+	}
+	if len(tripIDs) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(j.dir, fmt.Sprintf("%s_%d_%s.jsonl.gz",
+		unsafeFileChars.ReplaceAllString(routeID, "_"), year, j.now().UTC().Format("20060102T150405Z")))
+	written, err := j.writeArchive(ctx, path, tripIDs)
+	if err != nil {
+		return err
+	}
+	if err := verifyArchive(ctx, path, written); err != nil {
+		return fmt.Errorf("archive %s failed verification, nothing deleted: %w", path, err)
+	}
+	res.Files = append(res.Files, path)
+
+	archivedAt := j.now().UTC()
+	for _, trip := range written {
+		// A change stored since the trip was archived would be deleted without being archived,
+		// such trips are kept and archived again by the next run
+		current, err := j.repo.GetTrip(ctx, &trip.tripID)
+		if err != nil {
+			return fmt.Errorf("failed to re-read trip %v: %w", trip.tripID.StartTime, err)
+		}
+		if tripDigest(current) != trip.digest {
+			_ = j.log.Log("msg", "trip changed while it was archived, kept for the next run",
+				"route_id", trip.tripID.RouteID, "start_time", trip.tripID.StartTime)
+			continue
+		}
+
+		// Trips whose operations are already gone only leave index rows behind, they are not cataloged
+		if trip.reports > 0 {
+			err := j.repo.InsertArchivedTrip(ctx, &models.ArchivedTrip{
+				TripID:      trip.tripID,
+				ArchiveFile: filepath.Base(path),
+				Reports:     trip.reports,
+				Operations:  trip.operations,
+				ArchivedAt:  archivedAt,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to catalog trip %v: %w", trip.tripID.StartTime, err)
+			}
+		}
+		err = j.repo.DeleteTrip(ctx, &trip.tripID, trip.employees)
+		// A failed delete may still have removed part of the trip
+		j.onDelete(&trip.tripID, trip.employees)
+		if err != nil {
+			return fmt.Errorf("failed to delete trip %v: %w", trip.tripID.StartTime, err)
+		}
+		res.Trips++
+		res.Operations += trip.operations
+	}
+	return nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// writeArchive Writes the trips to path through a temporary file, so a partial archive never has the final name
+func (j *Job) writeArchive(ctx context.Context, path string, tripIDs []models.TripID) ([]tripContent, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+	}()
+
+	aw, err := archive.NewWriter(f)
+	if err != nil {
+		return nil, err
+	}
+
+	written := make([]tripContent, 0, len(tripIDs))
+	for i := range tripIDs {
+		trip, err := j.repo.GetTrip(ctx, &tripIDs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trip %v: %w", tripIDs[i].StartTime, err)
+		}
+		if err := aw.WriteTrip(trip); err != nil {
+			return nil, err
+		}
+		reports, operations := tripCounts(trip)
+		written = append(written, tripContent{
+			tripID:     tripIDs[i],
+			employees:  employeeIDs(trip),
+			reports:    reports,
+			operations: operations,
+			digest:     tripDigest(trip),
+		})
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// verifyArchive Reads the archive back and checks it holds exactly the reports and operations written
+func verifyArchive(ctx context.Context, path string, written []tripContent) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	type counts struct{ reports, operations int }
+	found := make(map[string]counts)
+	_, err = archive.Read(ctx, f, func(report *models.CarriageReport) error {
+		c := found[tripKey(report.TripID)]
+		c.reports++
+		for _, cart := range report.Carts {
+			c.operations += len(cart.Items)
+		}
+		found[tripKey(report.TripID)] = c
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	expected := 0
+	for _, trip := range written {
+		if trip.reports == 0 {
+			continue
+		}
+		expected++
+		got := found[tripKey(trip.tripID)]
+		if got.reports != trip.reports || got.operations != trip.operations {
+			return fmt.Errorf("trip %v: archived %d reports with %d operations, expected %d with %d",
+				trip.tripID.StartTime, got.reports, got.operations, trip.reports, trip.operations)
+		}
+	}
+	if len(found) != expected {
+		return fmt.Errorf("archive holds %d trips, expected %d", len(found), expected)
+	}
+	return nil
+}
+
+func tripKey(tripID models.TripID) string {
+	return tripID.RouteID + "|" + tripID.StartTime.UTC().Format(time.RFC3339Nano)
+}
+
+// tripCounts Returns the number of carriage reports and operations of a trip, counted as the archive writer does
+func tripCounts(trip models.Trip) (reports, operations int) {
+	for _, report := range trip.Carriage {
+		reports++
+		for _, cart := range report.Carts {
+			operations += len(cart.Items)
+		}
+	}
+	return reports, operations
+}
+
+// tripDigest Returns a hash of the operations of a trip with the fields the archive writer serializes,
+// taken over the sorted operations so it does not depend on the order rows were read in
+func tripDigest(trip models.Trip) [sha256.Size]byte {
+	var lines []string
+	for _, report := range trip.Carriage {
+		for _, cart := range report.Carts {
+			for _, item := range cart.Items {
+				lines = append(lines, fmt.Sprintf("%d|%s|%s|%s|%d|%d|%d|%d\n",
+					report.CarriageID,
+					report.EndTime.UTC().Format(time.RFC3339Nano),
+					cart.CartID.EmployeeID,
+					cart.CartID.OperationTime.UTC().Format(time.RFC3339Nano),
+					cart.OperationType,
+					item.ProductID,
+					item.Quantity,
+					item.Price))
+			}
+		}
+	}
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
+
+func employeeIDs(trip models.Trip) []string {
+	seen := make(map[string]struct{})
+	var ids []string
+	for _, report := range trip.Carriage {
+		for _, cart := range report.Carts {
+			if _, ok := seen[cart.CartID.EmployeeID]; !ok {
+				seen[cart.CartID.EmployeeID] = struct{}{}
+				ids = append(ids, cart.CartID.EmployeeID)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,241 @@
+package retention
+
+import (
+	"ChaikaReports/internal/archive"
+	"ChaikaReports/internal/models"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepository keeps trips in memory and records catalog entries and deletions
+type fakeRepository struct {
+	trips   map[models.TripID]models.Trip
+	catalog []models.ArchivedTrip
+	deleted map[models.TripID][]string
+	tripErr error
+	calls   []string
+
+	// afterRead is called after every GetTrip, to change trips while the job runs
+	afterRead func(tripID models.TripID)
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{trips: make(map[models.TripID]models.Trip), deleted: make(map[models.TripID][]string)}
+}
+
+func (f *fakeRepository) add(routeID string, start time.Time, employees ...string) {
+	report := models.CarriageReport{
+		TripID:     models.TripID{RouteID: routeID, Year: start.Format("2006"), StartTime: start},
+		EndTime:    start.Add(time.Hour),
+		CarriageID: 1,
+	}
+	for i, emp := range employees {
+		report.Carts = append(report.Carts, models.Cart{
+			CartID:        models.CartID{EmployeeID: emp, OperationTime: start.Add(time.Duration(i+1) * time.Minute)},
+			OperationType: models.OperationTypeSale,
+			Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}, {ProductID: 2, Quantity: 2, Price: 50}},
+		})
+	}
+	f.trips[report.TripID] = models.Trip{Carriage: []models.CarriageReport{report}}
+}
+
+func (f *fakeRepository) GetRoutes(_ context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var routes []string
+	for id := range f.trips {
+		if _, ok := seen[id.RouteID]; !ok {
+			seen[id.RouteID] = struct{}{}
+			routes = append(routes, id.RouteID)
+		}
+	}
+	return routes, nil
+}
+
+func (f *fakeRepository) GetRouteTrips(_ context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	var res []models.TripID
+	for id := range f.trips {
+		if id.RouteID == routeID && !id.StartTime.Before(from) && id.StartTime.Before(to) {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeRepository) GetTrip(_ context.Context, tripID *models.TripID) (models.Trip, error) {
+	if f.tripErr != nil {
+		return models.Trip{}, f.tripErr
+	}
+	trip := f.trips[*tripID]
+	if f.afterRead != nil {
+		f.afterRead(*tripID)
+	}
+	return trip, nil
+}
+
+func (f *fakeRepository) DeleteTrip(_ context.Context, tripID *models.TripID, employeeIDs []string) error {
+	f.calls = append(f.calls, "delete")
+	f.deleted[*tripID] = employeeIDs
+	delete(f.trips, *tripID)
+	return nil
+}
+
+func (f *fakeRepository) InsertArchivedTrip(_ context.Context, archived *models.ArchivedTrip) error {
+	f.calls = append(f.calls, "catalog")
+	f.catalog = append(f.catalog, *archived)
+	return nil
+}
+
+func newTestJob(repo Repository, dir string) *Job {
+	job := NewJob(repo, log.NewNopLogger(), dir, 2, 2022)
+	job.now = func() time.Time { return time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC) }
+	return job
+}
+
+func TestCutoff(t *testing.T) {
+	job := newTestJob(newFakeRepository(), t.TempDir())
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), job.Cutoff())
+}
+
+func TestRunOnce_ArchivesExpiredTrips(t *testing.T) {
+	dir := t.TempDir()
+	repo := newFakeRepository()
+	old1 := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	old2 := time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC)
+	hot := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo.add("r1", old1, "empA", "empB")
+	repo.add("r1", old2, "empA")
+	repo.add("r1", hot, "empA")
+
+	res, err := newTestJob(repo, dir).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, res.Trips)
+	assert.Equal(t, 6, res.Operations)
+	require.Len(t, res.Files, 2)
+
+	// The hot trip is kept, the expired ones are deleted with their employees
+	assert.Contains(t, repo.trips, models.TripID{RouteID: "r1", Year: "2024", StartTime: hot})
+	assert.Equal(t, []string{"empA", "empB"}, repo.deleted[models.TripID{RouteID: "r1", Year: "2022", StartTime: old1}])
+	assert.Equal(t, []string{"empA"}, repo.deleted[models.TripID{RouteID: "r1", Year: "2023", StartTime: old2}])
+
+	// Every trip is cataloged before it is deleted
+	assert.Equal(t, []string{"catalog", "delete", "catalog", "delete"}, repo.calls)
+	require.Len(t, repo.catalog, 2)
+	assert.Equal(t, filepath.Base(res.Files[0]), repo.catalog[0].ArchiveFile)
+	assert.Equal(t, 4, repo.catalog[0].Operations)
+
+	// The archive can be imported back
+	f, err := os.Open(res.Files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	stats, err := archive.Read(context.Background(), f, func(*models.CarriageReport) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, archive.Stats{Trips: 1, Reports: 1, Operations: 4}, stats)
+}
+
+func TestRunOnce_ReadErrorDeletesNothing(t *testing.T) {
+	dir := t.TempDir()
+	repo := newFakeRepository()
+	repo.add("r1", time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC), "empA")
+	repo.tripErr = errors.New("timeout")
+
+	res, err := newTestJob(repo, dir).RunOnce(context.Background())
+	assert.Error(t, err)
+	assert.Zero(t, res.Trips)
+	assert.Empty(t, repo.calls)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "partial archives must be removed")
+}
+
+func TestVerifyArchive_DetectsMismatch(t *testing.T) {
+	dir := t.TempDir()
+	repo := newFakeRepository()
+	start := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	repo.add("r1", start, "empA")
+	job := newTestJob(repo, dir)
+
+	tripID := models.TripID{RouteID: "r1", Year: "2022", StartTime: start}
+	path := filepath.Join(dir, "a.jsonl.gz")
+	written, err := job.writeArchive(context.Background(), path, []models.TripID{tripID})
+	require.NoError(t, err)
+	require.NoError(t, verifyArchive(context.Background(), path, written))
+
+	written[0].operations++
+	assert.Error(t, verifyArchive(context.Background(), path, written))
+}
+
+func TestRunOnce_KeepsTripChangedWhileArchiving(t *testing.T) {
+	dir := t.TempDir()
+	repo := newFakeRepository()
+	changed := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	unchanged := time.Date(2022, 4, 1, 8, 0, 0, 0, time.UTC)
+	repo.add("r1", changed, "empA")
+	repo.add("r1", unchanged, "empA")
+
+	// A report of the first trip arrives right after the trip was written to the archive
+	reads := 0
+	repo.afterRead = func(tripID models.TripID) {
+		if tripID.StartTime.Equal(changed) {
+			if reads++; reads == 1 {
+				repo.add("r1", changed, "empA", "empB")
+			}
+		}
+	}
+	var invalidated []models.TripID
+	job := NewJob(repo, log.NewNopLogger(), dir, 2, 2022, WithDeleteHook(func(tripID *models.TripID, employeeIDs []string) {
+		invalidated = append(invalidated, *tripID)
+	}))
+	job.now = func() time.Time { return time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	res, err := job.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Trips)
+
+	changedID := models.TripID{RouteID: "r1", Year: "2022", StartTime: changed}
+	unchangedID := models.TripID{RouteID: "r1", Year: "2022", StartTime: unchanged}
+	assert.Contains(t, repo.trips, changedID, "the changed trip is kept for the next run")
+	assert.NotContains(t, repo.trips, unchangedID)
+	require.Len(t, repo.catalog, 1)
+	assert.Equal(t, unchangedID, repo.catalog[0].TripID)
+	assert.Equal(t, []models.TripID{unchangedID}, invalidated)
+}
+
+func TestRunOnce_KeepsTripWithQuantityChangedWhileArchiving(t *testing.T) {
+	dir := t.TempDir()
+	repo := newFakeRepository()
+	start := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	repo.add("r1", start, "empA")
+	tripID := models.TripID{RouteID: "r1", Year: "2022", StartTime: start}
+
+	// A quantity is corrected right after the trip was written, the counts of the trip stay the same
+	reads := 0
+	repo.afterRead = func(models.TripID) {
+		if reads++; reads == 1 {
+			repo.add("r1", start, "empA")
+			repo.trips[tripID].Carriage[0].Carts[0].Items[0].Quantity = 3
+		}
+	}
+	job := newTestJob(repo, dir)
+
+	res, err := job.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, res.Trips)
+	assert.Contains(t, repo.trips, tripID, "the changed trip is kept for the next run")
+	assert.Empty(t, repo.catalog)
+
+	// The next run archives the corrected quantity
+	res, err = job.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Trips)
+	assert.NotContains(t, repo.trips, tripID)
+}
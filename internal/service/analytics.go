@@ -0,0 +1,196 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxAnalyticsDays caps the range of trip start days employee analytics covers, every trip in it is read whole
+	maxAnalyticsDays = 93
+	// topProductsLimit is the number of products listed per employee
+	topProductsLimit = 5
+)
+
+// GetEmployeeAnalytics Aggregates the carts of the trips started on the UTC days from..to inclusive per employee.
+// With a route every employee of its trips is returned ranked by net total, narrowed to employeeID when it is set;
+// with only an employee the trips of that employee on every route are read and the result is not ranked
+func (s *salesService) GetEmployeeAnalytics(ctx context.Context, routeID, employeeID string, from, to time.Time) ([]models.EmployeeStats, error) {
+	if routeID == "" && employeeID == "" {
+		return nil, fmt.Errorf("route_id or employee_id is required")
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("analytics range ends before it starts")
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxAnalyticsDays {
+		return nil, fmt.Errorf("analytics range of %d days is longer than %d days", days, maxAnalyticsDays)
+	}
+	end := to.AddDate(0, 0, 1)
+
+	var trips []models.TripID
+	var err error
+	if routeID != "" {
+		if trips, err = s.repo.GetRouteTrips(ctx, routeID, from, end); err != nil {
+			return nil, fmt.Errorf("failed to list trips of route %s: %w", routeID, err)
+		}
+	} else if trips, err = s.employeeTripsBetween(ctx, employeeID, from, end); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]*employeeTally)
+	for i := range trips {
+		trip, err := s.repo.GetTrip(ctx, &trips[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trip %s %v: %w", trips[i].RouteID, trips[i].StartTime, err)
+		}
+		tallyTrip(stats, trip, employeeID, routeID != "")
+	}
+
+	result := make([]models.EmployeeStats, 0, len(stats))
+	for _, tally := range stats {
+		result = append(result, tally.stats())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Revenue.NetTotal != result[j].Revenue.NetTotal {
+			return result[i].Revenue.NetTotal > result[j].Revenue.NetTotal
+		}
+		return result[i].EmployeeID < result[j].EmployeeID
+	})
+	if routeID == "" {
+		return result, nil
+	}
+	for i := range result {
+		result[i].Rank = i + 1
+	}
+	if employeeID != "" {
+		for _, employee := range result {
+			if employee.EmployeeID == employeeID {
+				return []models.EmployeeStats{employee}, nil
+			}
+		}
+		return []models.EmployeeStats{}, nil
+	}
+	return result, nil
+}
+
+// employeeTripsBetween Lists the trips of an employee started in [from, end), one trip per route and start time
+func (s *salesService) employeeTripsBetween(ctx context.Context, employeeID string, from, end time.Time) ([]models.TripID, error) {
+	seen := make(map[models.TripID]struct{})
+	var trips []models.TripID
+	for year := from.Year(); year <= end.Year(); year++ {
+		employeeTrips, err := s.repo.GetEmployeeTrips(ctx, employeeID, fmt.Sprint(year))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list trips of employee %s in %d: %w", employeeID, year, err)
+		}
+		for _, employeeTrip := range employeeTrips {
+			tripID := employeeTrip.TripID
+			if tripID.StartTime.Before(from) || !tripID.StartTime.Before(end) {
+				continue
+			}
+			key := models.TripID{RouteID: tripID.RouteID, Year: tripID.Year, StartTime: tripID.StartTime.UTC()}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			trips = append(trips, tripID)
+		}
+	}
+	return trips, nil
+}
+
+// employeeTally accumulates the carts of one employee
+type employeeTally struct {
+	employeeID string
+	trips      int
+	carts      int
+	revenue    models.Revenue
+	products   map[int]*models.ProductStats
+}
+
+// tallyTrip Adds the carts of trip to the tallies of their employees, of employeeID only unless all is set
+func tallyTrip(stats map[string]*employeeTally, trip models.Trip, employeeID string, all bool) {
+	inTrip := make(map[string]struct{})
+	for _, report := range trip.Carriage {
+		for _, cart := range report.Carts {
+			id := cart.CartID.EmployeeID
+			if !all && id != employeeID {
+				continue
+			}
+			tally, ok := stats[id]
+			if !ok {
+				tally = &employeeTally{employeeID: id, products: make(map[int]*models.ProductStats)}
+				stats[id] = tally
+			}
+			if _, ok := inTrip[id]; !ok {
+				inTrip[id] = struct{}{}
+				tally.trips++
+			}
+			tally.add(cart)
+		}
+	}
+}
+
+func (t *employeeTally) add(cart models.Cart) {
+	t.carts++
+	var cartRevenue models.Revenue
+	for _, item := range cart.Items {
+		quantity, total := int(item.Quantity), int64(item.Quantity)*item.Price
+		product, ok := t.products[item.ProductID]
+		if !ok {
+			product = &models.ProductStats{ProductID: item.ProductID}
+			t.products[item.ProductID] = product
+		}
+		switch cart.OperationType {
+		case models.OperationTypeSale:
+			cartRevenue.ItemsSold += quantity
+			cartRevenue.SalesTotal += total
+			product.Quantity += quantity
+			product.NetTotal += total
+		case models.OperationTypeRefund:
+			cartRevenue.ItemsRefunded += quantity
+			cartRevenue.RefundsTotal += total
+			product.Quantity -= quantity
+			product.NetTotal -= total
+		}
+	}
+	switch cart.OperationType {
+	case models.OperationTypeSale:
+		cartRevenue.Sales = 1
+	case models.OperationTypeRefund:
+		cartRevenue.Refunds = 1
+	}
+	t.revenue.Add(cartRevenue)
+}
+
+func (t *employeeTally) stats() models.EmployeeStats {
+	stats := models.EmployeeStats{
+		EmployeeID:  t.employeeID,
+		Trips:       t.trips,
+		Carts:       t.carts,
+		Revenue:     t.revenue,
+		TopProducts: make([]models.ProductStats, 0, len(t.products)),
+	}
+	if t.revenue.SalesTotal > 0 {
+		stats.RefundRatio = float64(t.revenue.RefundsTotal) / float64(t.revenue.SalesTotal)
+	}
+	if t.revenue.Sales > 0 {
+		stats.AverageCart = t.revenue.SalesTotal / int64(t.revenue.Sales)
+	}
+	for _, product := range t.products {
+		stats.TopProducts = append(stats.TopProducts, *product)
+	}
+	sort.Slice(stats.TopProducts, func(i, j int) bool {
+		a, b := stats.TopProducts[i], stats.TopProducts[j]
+		if a.NetTotal != b.NetTotal {
+			return a.NetTotal > b.NetTotal
+		}
+		return a.ProductID < b.ProductID
+	})
+	if len(stats.TopProducts) > topProductsLimit {
+		stats.TopProducts = stats.TopProducts[:topProductsLimit]
+	}
+	return stats
+}
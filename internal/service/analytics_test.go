@@ -0,0 +1,144 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// employeeTripStore adds the employee trip index of the trips in a tripStore, one row per cart like the stored table
+type employeeTripStore struct {
+	*tripStore
+}
+
+func (s employeeTripStore) GetEmployeeTrips(_ context.Context, employeeID string, year string) ([]models.EmployeeTrip, error) {
+	var trips []models.EmployeeTrip
+	for _, routeTrips := range s.trips {
+		for _, trip := range routeTrips {
+			tripID := trip.Carriage[0].TripID
+			if tripID.Year != year {
+				continue
+			}
+			for _, cart := range trip.Carriage[0].Carts {
+				if cart.CartID.EmployeeID == employeeID {
+					trips = append(trips, models.EmployeeTrip{EmployeeID: employeeID, TripID: tripID})
+				}
+			}
+		}
+	}
+	return trips, nil
+}
+
+func analyticsStore() employeeTripStore {
+	store := &tripStore{}
+	store.add(searchTrip("a", 8,
+		searchCart("e1", 490, models.OperationTypeSale, models.Item{ProductID: 3, Quantity: 5, Price: 20}, models.Item{ProductID: 42, Quantity: 1, Price: 100}),
+		searchCart("e1", 500, models.OperationTypeRefund, models.Item{ProductID: 42, Quantity: 1, Price: 100}),
+		searchCart("e2", 510, models.OperationTypeSale, models.Item{ProductID: 42, Quantity: 3, Price: 100}),
+	))
+	store.add(searchTrip("a", 20, searchCart("e1", 1210, models.OperationTypeSale, models.Item{ProductID: 7, Quantity: 1, Price: 100})))
+	store.add(searchTrip("b", 10, searchCart("e1", 610, models.OperationTypeSale, models.Item{ProductID: 9, Quantity: 2, Price: 50})))
+	// Started the day after the range
+	store.add(searchTrip("a", 30, searchCart("e2", 1810, models.OperationTypeSale, models.Item{ProductID: 42, Quantity: 9, Price: 100})))
+	return employeeTripStore{store}
+}
+
+func TestGetEmployeeAnalytics_Route(t *testing.T) {
+	svc := NewSalesService(analyticsStore())
+
+	employees, err := svc.GetEmployeeAnalytics(context.Background(), "a", "", searchDay, searchDay)
+	require.NoError(t, err)
+	assert.Equal(t, []models.EmployeeStats{
+		{
+			EmployeeID:  "e2",
+			Rank:        1,
+			Trips:       1,
+			Carts:       1,
+			Revenue:     models.Revenue{Sales: 1, ItemsSold: 3, SalesTotal: 300, NetTotal: 300},
+			AverageCart: 300,
+			TopProducts: []models.ProductStats{{ProductID: 42, Quantity: 3, NetTotal: 300}},
+		},
+		{
+			EmployeeID: "e1",
+			Rank:       2,
+			Trips:      2,
+			Carts:      3,
+			Revenue: models.Revenue{Sales: 2, Refunds: 1, ItemsSold: 7, ItemsRefunded: 1,
+				SalesTotal: 300, RefundsTotal: 100, NetTotal: 200},
+			RefundRatio: 100.0 / 300,
+			AverageCart: 150,
+			TopProducts: []models.ProductStats{{ProductID: 3, Quantity: 5, NetTotal: 100}, {ProductID: 7, Quantity: 1, NetTotal: 100}, {ProductID: 42, Quantity: 0, NetTotal: 0}},
+		},
+	}, employees)
+
+	employees, err = svc.GetEmployeeAnalytics(context.Background(), "a", "e1", searchDay, searchDay)
+	require.NoError(t, err)
+	require.Len(t, employees, 1)
+	assert.Equal(t, "e1", employees[0].EmployeeID)
+	assert.Equal(t, 2, employees[0].Rank)
+
+	employees, err = svc.GetEmployeeAnalytics(context.Background(), "a", "e3", searchDay, searchDay)
+	require.NoError(t, err)
+	assert.Empty(t, employees)
+}
+
+func TestGetEmployeeAnalytics_Employee(t *testing.T) {
+	store := analyticsStore()
+	svc := NewSalesService(store)
+
+	employees, err := svc.GetEmployeeAnalytics(context.Background(), "", "e1", searchDay, searchDay)
+	require.NoError(t, err)
+	require.Len(t, employees, 1)
+	e1 := employees[0]
+	assert.Equal(t, 0, e1.Rank)
+	assert.Equal(t, 3, e1.Trips)
+	assert.Equal(t, 4, e1.Carts)
+	assert.Equal(t, int64(300), e1.Revenue.NetTotal)
+	// Every trip is read once although the index has a row per cart
+	assert.Equal(t, 3, store.tripReads)
+}
+
+func TestGetEmployeeAnalytics_TopProducts(t *testing.T) {
+	var carts []models.Cart
+	for i := 1; i <= topProductsLimit+2; i++ {
+		carts = append(carts, searchCart("e1", i, models.OperationTypeSale, models.Item{ProductID: i, Quantity: 1, Price: int64(10 * i)}))
+	}
+	store := &tripStore{}
+	store.add(searchTrip("a", 0, carts...))
+	svc := NewSalesService(store)
+
+	employees, err := svc.GetEmployeeAnalytics(context.Background(), "a", "", searchDay, searchDay)
+	require.NoError(t, err)
+	require.Len(t, employees, 1)
+	var products []int
+	for _, p := range employees[0].TopProducts {
+		products = append(products, p.ProductID)
+	}
+	assert.Equal(t, []int{7, 6, 5, 4, 3}, products)
+}
+
+func TestGetEmployeeAnalytics_Rejected(t *testing.T) {
+	svc := NewSalesService(analyticsStore())
+	tests := []struct {
+		name       string
+		routeID    string
+		employeeID string
+		days       int
+		wantErr    string
+	}{
+		{name: "No route or employee", wantErr: "route_id or employee_id is required"},
+		{name: "Reversed range", routeID: "a", days: -1, wantErr: "analytics range ends before it starts"},
+		{name: "Long range", employeeID: "e1", days: maxAnalyticsDays,
+			wantErr: fmt.Sprintf("analytics range of %d days is longer than %d days", maxAnalyticsDays+1, maxAnalyticsDays)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.GetEmployeeAnalytics(context.Background(), tt.routeID, tt.employeeID, searchDay, searchDay.AddDate(0, 0, tt.days))
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
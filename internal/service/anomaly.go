@@ -0,0 +1,36 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+)
+
+// maxAnomalyDays caps the range of an anomaly query, the anomalies of every day are spread over 8 partitions
+// read one after the other, so a query costs 8 reads per day
+const maxAnomalyDays = 31
+
+// GetAnomalies Gets the anomalies flagged for the UTC days of filter, ordered by day, kind and ID
+func (s *salesService) GetAnomalies(ctx context.Context, filter models.AnomalyFilter) ([]models.Anomaly, error) {
+	if filter.To.Before(filter.From) {
+		return nil, fmt.Errorf("anomaly range ends before it starts")
+	}
+	if days := int(filter.To.Sub(filter.From).Hours()/24) + 1; days > maxAnomalyDays {
+		return nil, fmt.Errorf("anomaly range of %d days is longer than %d days", days, maxAnomalyDays)
+	}
+
+	anomalies, err := s.repo.GetAnomalies(ctx, filter.From, filter.To)
+	if err != nil {
+		return nil, err
+	}
+	matching := make([]models.Anomaly, 0, len(anomalies))
+	for _, a := range anomalies {
+		if filter.Kind != "" && a.Kind != filter.Kind ||
+			filter.RouteID != "" && a.TripID.RouteID != filter.RouteID ||
+			filter.EmployeeID != "" && a.EmployeeID != filter.EmployeeID {
+			continue
+		}
+		matching = append(matching, a)
+	}
+	return matching, nil
+}
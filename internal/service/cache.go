@@ -0,0 +1,268 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache stores query results by key; implementations must be safe for concurrent use
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Delete(key string)
+}
+
+// lruCache is an in-process Cache evicting the least recently used entry when full
+// and dropping entries older than ttl
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+	entries  map[string]*list.Element
+	order    *list.List // front is the most recently used
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewLRUCache Creates an in-process cache holding at most capacity entries for ttl each
+func NewLRUCache(capacity int, ttl time.Duration) Cache {
+	return newLRUCache(capacity, ttl, time.Now)
+}
+
+func newLRUCache(capacity int, ttl time.Duration, now func() time.Time) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		now:      now,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *lruCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *lruCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
+
+// cachingSalesService is a read-through cache in front of a SalesService for trip and employee queries.
+// Writes going through it invalidate the entries of the affected trip and employees, in-process writers that
+// bypass it (the retention job) call InvalidateTrip; writes of other instances become visible when the entries expire
+type cachingSalesService struct {
+	SalesService
+	cache Cache
+
+	// invalidations counts invalidations, a loaded value is only stored when no invalidation
+	// happened while it was being read, so a slow read cannot put back data a write replaced
+	invalidations atomic.Uint64
+}
+
+// NewCachingSalesService Wraps next with a read-through cache for GetTrip, GetEmployeeIDsByTrip and GetEmployeeTrips
+func NewCachingSalesService(next SalesService, cache Cache) SalesService {
+	return &cachingSalesService{SalesService: next, cache: cache}
+}
+
+func tripCacheKey(tripID *models.TripID) string {
+	return tripID.RouteID + "|" + tripID.Year + "|" + strconv.FormatInt(tripID.StartTime.UnixNano(), 10)
+}
+
+func tripKey(tripID *models.TripID) string {
+	return "trip|" + tripCacheKey(tripID)
+}
+
+func tripEmployeesKey(tripID *models.TripID) string {
+	return "trip_employees|" + tripCacheKey(tripID)
+}
+
+func employeeTripsKey(employeeID, year string) string {
+	return "employee_trips|" + employeeID + "|" + year
+}
+
+// GetTrip Gets a trip from the cache or loads it, callers get their own copy of the carriage reports
+func (c *cachingSalesService) GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error) {
+	v, err := c.load(tripKey(tripID), func() (interface{}, error) {
+		return c.SalesService.GetTrip(ctx, tripID)
+	})
+	if err != nil {
+		return models.Trip{}, err
+	}
+	return cloneTrip(v.(models.Trip)), nil
+}
+
+func (c *cachingSalesService) GetEmployeeIDsByTrip(ctx context.Context, tripID *models.TripID) ([]string, error) {
+	v, err := c.load(tripEmployeesKey(tripID), func() (interface{}, error) {
+		return c.SalesService.GetEmployeeIDsByTrip(ctx, tripID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), v.([]string)...), nil
+}
+
+func (c *cachingSalesService) GetEmployeeTrips(ctx context.Context, employeeID string, year string) ([]models.EmployeeTrip, error) {
+	v, err := c.load(employeeTripsKey(employeeID, year), func() (interface{}, error) {
+		return c.SalesService.GetEmployeeTrips(ctx, employeeID, year)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]models.EmployeeTrip(nil), v.([]models.EmployeeTrip)...), nil
+}
+
+// load Returns the cached value of key or stores the result of fetch; errors are not cached
+func (c *cachingSalesService) load(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if v, ok := c.cache.Get(key); ok {
+		return v, nil
+	}
+
+	generation := c.invalidations.Load()
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if c.invalidations.Load() == generation {
+		c.cache.Set(key, v)
+	}
+	return v, nil
+}
+
+func (c *cachingSalesService) InsertData(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, error) {
+	defer c.invalidateReport(carriageReport)
+	return c.SalesService.InsertData(ctx, carriageReport)
+}
+
+func (c *cachingSalesService) InsertDataBulk(ctx context.Context, carriageReports []*models.CarriageReport) []models.InsertResult {
+	defer func() {
+		for _, report := range carriageReports {
+			c.invalidateReport(report)
+		}
+	}()
+	return c.SalesService.InsertDataBulk(ctx, carriageReports)
+}
+
+// ReplaceCarriageReport Replaces a report and invalidates the trip lists of the employees of the new report and
+// of every employee stored for the trip before, so employees whose carts the replace removed are dropped too
+func (c *cachingSalesService) ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, models.ReportDiff, error) {
+	// Read past the cache, a cached list may miss employees stored since it was loaded. A failed read leaves
+	// only the employees of the new report to invalidate, the others expire with the TTL
+	stored, _ := c.SalesService.GetEmployeeIDsByTrip(ctx, &carriageReport.TripID)
+	defer func() {
+		c.invalidateReport(carriageReport)
+		year := strconv.Itoa(carriageReport.TripID.StartTime.Year())
+		for _, employeeID := range stored {
+			c.cache.Delete(employeeTripsKey(employeeID, year))
+		}
+	}()
+	return c.SalesService.ReplaceCarriageReport(ctx, carriageReport)
+}
+
+func (c *cachingSalesService) UpdateItemQuantity(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int, newQuantity *int16) error {
+	defer c.invalidateTrip(tripID)
+	return c.SalesService.UpdateItemQuantity(ctx, tripID, cartID, productID, newQuantity)
+}
+
+func (c *cachingSalesService) DeleteItemFromCart(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int) error {
+	defer c.invalidateTrip(tripID)
+	return c.SalesService.DeleteItemFromCart(ctx, tripID, cartID, productID)
+}
+
+// invalidateReport Drops the trip of a report and the trip lists of its employees.
+// It runs after the write, whether it failed or not, since a failed batch may still have been applied
+func (c *cachingSalesService) invalidateReport(report *models.CarriageReport) {
+	c.invalidateTrip(&report.TripID)
+	year := strconv.Itoa(report.TripID.StartTime.Year())
+	for _, cart := range report.Carts {
+		c.cache.Delete(employeeTripsKey(cart.CartID.EmployeeID, year))
+	}
+}
+
+// InvalidateTrip Drops the cached trip and the trip lists of employeeIDs when svc is a caching service,
+// for writes that do not go through svc
+func InvalidateTrip(svc SalesService, tripID *models.TripID, employeeIDs []string) {
+	c, ok := svc.(*cachingSalesService)
+	if !ok {
+		return
+	}
+	c.invalidateTrip(tripID)
+	year := strconv.Itoa(tripID.StartTime.Year())
+	for _, employeeID := range employeeIDs {
+		c.cache.Delete(employeeTripsKey(employeeID, year))
+	}
+}
+
+func (c *cachingSalesService) invalidateTrip(tripID *models.TripID) {
+	c.invalidations.Add(1)
+	c.cache.Delete(tripKey(tripID))
+	c.cache.Delete(tripEmployeesKey(tripID))
+}
+
+// cloneTrip Copies the carriage reports and carts of a trip so callers cannot modify the cached value
+func cloneTrip(trip models.Trip) models.Trip {
+	if trip.Carriage == nil {
+		return trip
+	}
+	reports := make([]models.CarriageReport, len(trip.Carriage))
+	for i, report := range trip.Carriage {
+		carts := make([]models.Cart, len(report.Carts))
+		for j, cart := range report.Carts {
+			cart.Items = append([]models.Item(nil), cart.Items...)
+			carts[j] = cart
+		}
+		report.Carts = carts
+		reports[i] = report
+	}
+	return models.Trip{Carriage: reports}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2, time.Minute, time.Now)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	_, _ = c.Get("a") // b is now the least recently used
+	c.Set("c", 3)
+
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+}
+
+func TestLRUCache_Expires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newLRUCache(10, time.Minute, func() time.Time { return now })
+	c.Set("a", 1)
+
+	now = now.Add(59 * time.Second)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, c.order.Len(), "expired entries are removed")
+}
+
+// countingService counts reads that reach the wrapped service
+type countingService struct {
+	SalesService
+	tripReads     int
+	employeeReads int
+	tripErr       error
+	tripEmployees []string
+}
+
+func (s *countingService) GetTrip(_ context.Context, tripID *models.TripID) (models.Trip, error) {
+	s.tripReads++
+	if s.tripErr != nil {
+		return models.Trip{}, s.tripErr
+	}
+	return models.Trip{Carriage: []models.CarriageReport{{TripID: *tripID, CarriageID: 1}}}, nil
+}
+
+func (s *countingService) GetEmployeeTrips(_ context.Context, employeeID string, year string) ([]models.EmployeeTrip, error) {
+	s.employeeReads++
+	return []models.EmployeeTrip{{EmployeeID: employeeID}}, nil
+}
+
+func (s *countingService) InsertData(_ context.Context, _ *models.CarriageReport) (models.Receipt, error) {
+	return models.Receipt{}, nil
+}
+
+func (s *countingService) GetEmployeeIDsByTrip(_ context.Context, _ *models.TripID) ([]string, error) {
+	return s.tripEmployees, nil
+}
+
+func (s *countingService) ReplaceCarriageReport(_ context.Context, _ *models.CarriageReport) (models.Receipt, models.ReportDiff, error) {
+	return models.Receipt{}, models.ReportDiff{}, nil
+}
+
+func (s *countingService) UpdateItemQuantity(_ context.Context, _ *models.TripID, _ *models.CartID, _ *int, _ *int16) error {
+	return nil
+}
+
+func TestCachingSalesService(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: start}
+	other := &models.TripID{RouteID: "r2", Year: "2024", StartTime: start}
+
+	inner := &countingService{}
+	svc := NewCachingSalesService(inner, NewLRUCache(100, time.Minute))
+
+	// Repeated reads are served from the cache, and callers cannot modify the cached trip
+	trip, err := svc.GetTrip(ctx, tripID)
+	require.NoError(t, err)
+	trip.Carriage[0].CarriageID = 99
+	trip, err = svc.GetTrip(ctx, tripID)
+	require.NoError(t, err)
+	assert.Equal(t, int8(1), trip.Carriage[0].CarriageID)
+	_, _ = svc.GetTrip(ctx, other)
+	_, _ = svc.GetEmployeeTrips(ctx, "empA", "2024")
+	_, _ = svc.GetEmployeeTrips(ctx, "empA", "2024")
+	assert.Equal(t, 2, inner.tripReads)
+	assert.Equal(t, 1, inner.employeeReads)
+
+	// An insert invalidates its trip and the trip lists of its employees only
+	_, err = svc.InsertData(ctx, &models.CarriageReport{
+		TripID: *tripID,
+		Carts:  []models.Cart{{CartID: models.CartID{EmployeeID: "empA", OperationTime: start}}},
+	})
+	require.NoError(t, err)
+	_, _ = svc.GetTrip(ctx, tripID)
+	_, _ = svc.GetTrip(ctx, other)
+	_, _ = svc.GetEmployeeTrips(ctx, "empA", "2024")
+	assert.Equal(t, 3, inner.tripReads)
+	assert.Equal(t, 2, inner.employeeReads)
+
+	// Item corrections invalidate the trip
+	productID, quantity := 1, int16(2)
+	require.NoError(t, svc.UpdateItemQuantity(ctx, tripID, &models.CartID{EmployeeID: "empA"}, &productID, &quantity))
+	_, _ = svc.GetTrip(ctx, tripID)
+	assert.Equal(t, 4, inner.tripReads)
+}
+
+func TestCachingSalesService_ReplaceInvalidatesRemovedEmployees(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: start}
+
+	// empA's carts are stored for the trip, the replacement only has carts of empB
+	inner := &countingService{tripEmployees: []string{"empA"}}
+	svc := NewCachingSalesService(inner, NewLRUCache(100, time.Minute))
+	for _, employeeID := range []string{"empA", "empB", "empC"} {
+		_, err := svc.GetEmployeeTrips(ctx, employeeID, "2024")
+		require.NoError(t, err)
+	}
+
+	_, _, err := svc.ReplaceCarriageReport(ctx, &models.CarriageReport{
+		TripID: *tripID,
+		Carts:  []models.Cart{{CartID: models.CartID{EmployeeID: "empB", OperationTime: start}}},
+	})
+	require.NoError(t, err)
+
+	// The lists of the removed and the new employee are reloaded, the unrelated one is still cached
+	for _, employeeID := range []string{"empA", "empB", "empC"} {
+		_, err := svc.GetEmployeeTrips(ctx, employeeID, "2024")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, inner.employeeReads)
+}
+
+func TestCachingSalesService_ErrorsAreNotCached(t *testing.T) {
+	ctx := context.Background()
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)}
+
+	inner := &countingService{tripErr: errors.New("timeout")}
+	svc := NewCachingSalesService(inner, NewLRUCache(100, time.Minute))
+
+	_, err := svc.GetTrip(ctx, tripID)
+	assert.Error(t, err)
+	inner.tripErr = nil
+	_, err = svc.GetTrip(ctx, tripID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, inner.tripReads)
+}
+
+func TestInvalidateTrip(t *testing.T) {
+	ctx := context.Background()
+	tripID := &models.TripID{RouteID: "r1", Year: "2024", StartTime: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)}
+	inner := &countingService{}
+	svc := NewCachingSalesService(inner, NewLRUCache(10, time.Minute))
+
+	_, err := svc.GetTrip(ctx, tripID)
+	require.NoError(t, err)
+	_, err = svc.GetEmployeeTrips(ctx, "e1", "2024")
+	require.NoError(t, err)
+
+	InvalidateTrip(svc, tripID, []string{"e1"})
+	_, err = svc.GetTrip(ctx, tripID)
+	require.NoError(t, err)
+	_, err = svc.GetEmployeeTrips(ctx, "e1", "2024")
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.tripReads)
+	assert.Equal(t, 2, inner.employeeReads)
+
+	// Services without a cache are left alone
+	InvalidateTrip(inner, tripID, []string{"e1"})
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// maxConsumptionDays caps the range of trip start days a consumption report covers, every trip in it is read whole
+const maxConsumptionDays = 93
+
+// consumptionKey groups the items of a product sold on one day, in one carriage when they are broken down
+type consumptionKey struct {
+	day        string
+	carriageID int8
+	productID  int
+}
+
+// GetProductConsumption Sums the items sold and refunded per product on the trips of a route started on the UTC days
+// from..to inclusive, per start day and, when byCarriage is set, per carriage. Products are ranked by net quantity
+// within their day or carriage and ordered by day, carriage and rank
+func (s *salesService) GetProductConsumption(ctx context.Context, routeID string, from, to time.Time, byCarriage bool) ([]models.ProductConsumption, error) {
+	if routeID == "" {
+		return nil, fmt.Errorf("route_id is required")
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("consumption range ends before it starts")
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxConsumptionDays {
+		return nil, fmt.Errorf("consumption range of %d days is longer than %d days", days, maxConsumptionDays)
+	}
+
+	trips, err := s.repo.GetRouteTrips(ctx, routeID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trips of route %s: %w", routeID, err)
+	}
+
+	consumed := make(map[consumptionKey]*models.ProductConsumption)
+	for i := range trips {
+		trip, err := s.repo.GetTrip(ctx, &trips[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read trip %s %v: %w", trips[i].RouteID, trips[i].StartTime, err)
+		}
+		day := trips[i].StartTime.UTC().Format(time.DateOnly)
+		for _, report := range trip.Carriage {
+			for _, cart := range report.Carts {
+				for _, item := range cart.Items {
+					key := consumptionKey{day: day, productID: item.ProductID}
+					if byCarriage {
+						key.carriageID = report.CarriageID
+					}
+					product, ok := consumed[key]
+					if !ok {
+						product = &models.ProductConsumption{Day: day, ProductID: item.ProductID}
+						if byCarriage {
+							carriageID := report.CarriageID
+							product.CarriageID = &carriageID
+						}
+						consumed[key] = product
+					}
+					quantity, total := int(item.Quantity), int64(item.Quantity)*item.Price
+					switch cart.OperationType {
+					case models.OperationTypeSale:
+						product.Sold += quantity
+						product.Quantity += quantity
+						product.NetTotal += total
+					case models.OperationTypeRefund:
+						product.Refunded += quantity
+						product.Quantity -= quantity
+						product.NetTotal -= total
+					}
+				}
+			}
+		}
+	}
+
+	result := make([]models.ProductConsumption, 0, len(consumed))
+	for _, product := range consumed {
+		result = append(result, *product)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if carriageA, carriageB := carriageOf(a), carriageOf(b); carriageA != carriageB {
+			return carriageA < carriageB
+		}
+		if a.Quantity != b.Quantity {
+			return a.Quantity > b.Quantity
+		}
+		if a.NetTotal != b.NetTotal {
+			return a.NetTotal > b.NetTotal
+		}
+		return a.ProductID < b.ProductID
+	})
+	for i := range result {
+		if i > 0 && result[i].Day == result[i-1].Day && carriageOf(result[i]) == carriageOf(result[i-1]) {
+			result[i].Rank = result[i-1].Rank + 1
+		} else {
+			result[i].Rank = 1
+		}
+	}
+	return result, nil
+}
+
+// carriageOf Returns the carriage of a consumption row, 0 when the carriages are not broken down
+func carriageOf(product models.ProductConsumption) int8 {
+	if product.CarriageID == nil {
+		return 0
+	}
+	return *product.CarriageID
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func consumptionStore() *tripStore {
+	store := &tripStore{}
+	trip := searchTrip("a", 8,
+		searchCart("e1", 490, models.OperationTypeSale, models.Item{ProductID: 3, Quantity: 5, Price: 20}, models.Item{ProductID: 42, Quantity: 2, Price: 100}),
+		searchCart("e1", 500, models.OperationTypeRefund, models.Item{ProductID: 42, Quantity: 1, Price: 100}),
+	)
+	second := trip.Carriage[0]
+	second.CarriageID = 2
+	second.Carts = []models.Cart{searchCart("e2", 510, models.OperationTypeSale, models.Item{ProductID: 42, Quantity: 3, Price: 100})}
+	trip.Carriage = append(trip.Carriage, second)
+	store.add(trip)
+	store.add(searchTrip("a", 20, searchCart("e1", 1210, models.OperationTypeSale, models.Item{ProductID: 7, Quantity: 4, Price: 50})))
+	store.add(searchTrip("a", 30, searchCart("e2", 1810, models.OperationTypeSale, models.Item{ProductID: 42, Quantity: 9, Price: 100})))
+	store.add(searchTrip("b", 10, searchCart("e3", 610, models.OperationTypeSale, models.Item{ProductID: 3, Quantity: 20, Price: 20})))
+	// Started the day after the range
+	store.add(searchTrip("a", 50, searchCart("e2", 3010, models.OperationTypeSale, models.Item{ProductID: 3, Quantity: 1, Price: 20})))
+	return store
+}
+
+func TestGetProductConsumption(t *testing.T) {
+	svc := NewSalesService(consumptionStore())
+
+	products, err := svc.GetProductConsumption(context.Background(), "a", searchDay, searchDay.AddDate(0, 0, 1), false)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ProductConsumption{
+		{Day: "2024-05-06", ProductID: 3, Rank: 1, Sold: 5, Quantity: 5, NetTotal: 100},
+		// 42 and 7 tie on quantity, 42 ranks higher on net total
+		{Day: "2024-05-06", ProductID: 42, Rank: 2, Sold: 5, Refunded: 1, Quantity: 4, NetTotal: 400},
+		{Day: "2024-05-06", ProductID: 7, Rank: 3, Sold: 4, Quantity: 4, NetTotal: 200},
+		{Day: "2024-05-07", ProductID: 42, Rank: 1, Sold: 9, Quantity: 9, NetTotal: 900},
+	}, products)
+}
+
+func TestGetProductConsumption_ByCarriage(t *testing.T) {
+	svc := NewSalesService(consumptionStore())
+	carriage := func(id int8) *int8 { return &id }
+
+	products, err := svc.GetProductConsumption(context.Background(), "a", searchDay, searchDay, true)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ProductConsumption{
+		{Day: "2024-05-06", CarriageID: carriage(1), ProductID: 3, Rank: 1, Sold: 5, Quantity: 5, NetTotal: 100},
+		{Day: "2024-05-06", CarriageID: carriage(1), ProductID: 7, Rank: 2, Sold: 4, Quantity: 4, NetTotal: 200},
+		{Day: "2024-05-06", CarriageID: carriage(1), ProductID: 42, Rank: 3, Sold: 2, Refunded: 1, Quantity: 1, NetTotal: 100},
+		{Day: "2024-05-06", CarriageID: carriage(2), ProductID: 42, Rank: 1, Sold: 3, Quantity: 3, NetTotal: 300},
+	}, products)
+}
+
+func TestGetProductConsumption_Rejected(t *testing.T) {
+	svc := NewSalesService(consumptionStore())
+	ctx := context.Background()
+
+	_, err := svc.GetProductConsumption(ctx, "", searchDay, searchDay, false)
+	assert.EqualError(t, err, "route_id is required")
+	_, err = svc.GetProductConsumption(ctx, "a", searchDay, searchDay.AddDate(0, 0, -1), false)
+	assert.EqualError(t, err, "consumption range ends before it starts")
+	_, err = svc.GetProductConsumption(ctx, "a", searchDay, searchDay.AddDate(0, 0, maxConsumptionDays), false)
+	assert.EqualError(t, err, "consumption range of 94 days is longer than 93 days")
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// cursorVersion prefixes every cursor, so the format can change without misreading older cursors
+const cursorVersion = "v1"
+
+// defaultCursorTTL is how long a cursor stays valid when no TTL is configured
+const defaultCursorTTL = 15 * time.Minute
+
+// cursorScope is a query a cursor pages through, a cursor is only accepted for the query it was issued for
+type cursorScope interface {
+	digest() string
+}
+
+// CursorQuery is the query paging through the carts of an employee
+type CursorQuery struct {
+	TripID     models.TripID
+	EmployeeID string
+	Limit      int
+	Filter     models.CartFilter
+}
+
+// digest Returns a short hash identifying the query
+func (q CursorQuery) digest() string {
+	return digestKey(fmt.Sprintf("%q|%q|%d|%q|%d|%q|%d|%d", q.TripID.RouteID, q.TripID.Year, q.TripID.StartTime.UnixNano(), q.EmployeeID, q.Limit,
+		q.Filter.Order, windowBound(q.Filter.From), windowBound(q.Filter.To)))
+}
+
+// digestKey Returns a short hash of the key of a query
+func digestKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// windowBound Returns the Unix nanoseconds of a window bound, an open bound is 0
+func windowBound(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// cursorPayload is the signed content of a cursor
+type cursorPayload struct {
+	Position  string `json:"p"`
+	Query     string `json:"q"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// CursorSigner seals repository paging positions into HMAC-signed cursors bound to their query,
+// so clients can neither forge a position nor reuse a cursor for another query
+type CursorSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewCursorSigner Creates a CursorSigner; an empty secret is replaced by a random one, which
+// invalidates cursors on restart and across instances, and a ttl <= 0 uses the default TTL
+func NewCursorSigner(secret []byte, ttl time.Duration) *CursorSigner {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("failed to generate cursor secret: %v", err))
+		}
+	}
+	if ttl <= 0 {
+		ttl = defaultCursorTTL
+	}
+	return &CursorSigner{secret: secret, ttl: ttl}
+}
+
+// Seal Returns the cursor for the repository position of query, valid for the TTL from now
+func (s *CursorSigner) Seal(position string, query cursorScope, now time.Time) string {
+	payload, _ := json.Marshal(cursorPayload{
+		Position:  position,
+		Query:     query.digest(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+	})
+	signed := cursorVersion + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.sign(signed))
+}
+
+// Open Verifies cursor against query and returns its repository position;
+// errors wrap models.ErrInvalidCursor
+func (s *CursorSigner) Open(cursor string, query cursorScope, now time.Time) (string, error) {
+	parts := strings.Split(cursor, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: malformed", models.ErrInvalidCursor)
+	}
+	if parts[0] != cursorVersion {
+		return "", fmt.Errorf("%w: unsupported version %q", models.ErrInvalidCursor, parts[0])
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, s.sign(parts[0]+"."+parts[1])) {
+		return "", fmt.Errorf("%w: bad signature", models.ErrInvalidCursor)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed", models.ErrInvalidCursor)
+	}
+	var payload cursorPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return "", fmt.Errorf("%w: malformed", models.ErrInvalidCursor)
+	}
+	if now.Unix() >= payload.ExpiresAt {
+		return "", fmt.Errorf("%w: expired", models.ErrInvalidCursor)
+	}
+	if payload.Query != query.digest() {
+		return "", fmt.Errorf("%w: issued for another trip, employee, limit or filter", models.ErrInvalidCursor)
+	}
+	return payload.Position, nil
+}
+
+func (s *CursorSigner) sign(signed string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorSigner(t *testing.T) {
+	signer := NewCursorSigner([]byte("paging-test-secret"), time.Minute)
+	now := time.Date(2025, 8, 20, 12, 0, 0, 0, time.UTC)
+	query := CursorQuery{
+		TripID:     models.TripID{RouteID: "r1", Year: "2025", StartTime: time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)},
+		EmployeeID: "emp1",
+		Limit:      10,
+	}
+	cursor := signer.Seal("position", query, now)
+	assert.True(t, strings.HasPrefix(cursor, "v1."))
+
+	position, err := signer.Open(cursor, query, now.Add(59*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, "position", position)
+
+	otherTrip := query
+	otherTrip.TripID.StartTime = otherTrip.TripID.StartTime.Add(time.Second)
+	otherEmployee := query
+	otherEmployee.EmployeeID = "emp2"
+	otherLimit := query
+	otherLimit.Limit = 11
+	otherOrder := query
+	otherOrder.Filter.Order = models.SortAsc
+	otherWindow := query
+	otherWindow.Filter.From = now.Add(-time.Hour)
+
+	parts := strings.Split(cursor, ".")
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"p":"elsewhere","q":"x","e":9999999999}`))
+
+	tests := []struct {
+		name    string
+		cursor  string
+		query   CursorQuery
+		now     time.Time
+		wantErr string
+	}{
+		{name: "Expired", cursor: cursor, query: query, now: now.Add(time.Minute), wantErr: "invalid cursor: expired"},
+		{name: "Other trip", cursor: cursor, query: otherTrip, now: now, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Other employee", cursor: cursor, query: otherEmployee, now: now, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Other limit", cursor: cursor, query: otherLimit, now: now, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Other order", cursor: cursor, query: otherOrder, now: now, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Other window", cursor: cursor, query: otherWindow, now: now, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Forged payload", cursor: parts[0] + "." + forgedPayload + "." + parts[2], query: query, now: now, wantErr: "invalid cursor: bad signature"},
+		{name: "Other secret", cursor: NewCursorSigner([]byte("another-secret"), time.Minute).Seal("position", query, now), query: query, now: now, wantErr: "invalid cursor: bad signature"},
+		{name: "Unsigned", cursor: "eyJ0IjoiMjAyNS0wOC0yMFQxMDowMDowMFoifQ==", query: query, now: now, wantErr: "invalid cursor: malformed"},
+		{name: "Unknown version", cursor: "v0." + parts[1] + "." + parts[2], query: query, now: now, wantErr: `invalid cursor: unsupported version "v0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := signer.Open(tt.cursor, tt.query, tt.now)
+			assert.ErrorIs(t, err, models.ErrInvalidCursor)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewCursorSigner_RandomSecret(t *testing.T) {
+	query := CursorQuery{EmployeeID: "emp1", Limit: 10}
+	now := time.Now()
+	cursor := NewCursorSigner(nil, 0).Seal("position", query, now)
+
+	// Another process generates another secret
+	_, err := NewCursorSigner(nil, 0).Open(cursor, query, now)
+	assert.ErrorIs(t, err, models.ErrInvalidCursor)
+}
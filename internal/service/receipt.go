@@ -0,0 +1,121 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// contentHashPrefix names the algorithm used for receipt content hashes
+const contentHashPrefix = "sha256:"
+
+// canonicalReport is the form of a carriage report that is hashed for receipts.
+// Times are UTC RFC3339 with nanoseconds, carts are ordered by operation time then employee ID
+// and items by product ID, so the same report always hashes to the same value.
+type canonicalReport struct {
+	RouteID    string          `json:"route_id"`
+	StartTime  string          `json:"start_time"`
+	EndTime    string          `json:"end_time"`
+	CarriageID int8            `json:"carriage_id"`
+	Carts      []canonicalCart `json:"carts"`
+}
+
+type canonicalCart struct {
+	EmployeeID    string          `json:"employee_id"`
+	OperationTime string          `json:"operation_time"`
+	OperationType int8            `json:"operation_type"`
+	Items         []canonicalItem `json:"items"`
+}
+
+type canonicalItem struct {
+	ProductID int   `json:"product_id"`
+	Quantity  int16 `json:"quantity"`
+	Price     int64 `json:"price"`
+}
+
+// ContentHash Computes the canonical content hash of a carriage report
+func ContentHash(carriageReport *models.CarriageReport) string {
+	c := canonicalReport{
+		RouteID:    carriageReport.TripID.RouteID,
+		StartTime:  canonicalTime(carriageReport.TripID.StartTime),
+		EndTime:    canonicalTime(carriageReport.EndTime),
+		CarriageID: carriageReport.CarriageID,
+		Carts:      make([]canonicalCart, 0, len(carriageReport.Carts)),
+	}
+
+	for _, cart := range carriageReport.Carts {
+		cc := canonicalCart{
+			EmployeeID:    cart.CartID.EmployeeID,
+			OperationTime: canonicalTime(cart.CartID.OperationTime),
+			OperationType: cart.OperationType,
+			Items:         make([]canonicalItem, 0, len(cart.Items)),
+		}
+		for _, item := range cart.Items {
+			cc.Items = append(cc.Items, canonicalItem{
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Price:     item.Price,
+			})
+		}
+		sort.SliceStable(cc.Items, func(i, j int) bool {
+			return cc.Items[i].ProductID < cc.Items[j].ProductID
+		})
+		c.Carts = append(c.Carts, cc)
+	}
+
+	sort.SliceStable(c.Carts, func(i, j int) bool {
+		if c.Carts[i].OperationTime != c.Carts[j].OperationTime {
+			return c.Carts[i].OperationTime < c.Carts[j].OperationTime
+		}
+		return c.Carts[i].EmployeeID < c.Carts[j].EmployeeID
+	})
+
+	// Marshalling plain structs of strings and integers cannot fail
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return contentHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// canonicalTime formats a timestamp in UTC; fixed-width nanoseconds keep string order equal to time order
+func canonicalTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// newReceipt Builds the receipt for a carriage report as received, before clock skew normalization changes
+// its operation times, so the content hash matches what the terminal sent. The repository stores the receipt
+// in the same batch that commits the report, so a receipt proves what the server accepted
+func (s *salesService) newReceipt(carriageReport *models.CarriageReport) (models.Receipt, error) {
+	reportID, err := s.newReportID()
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	items := 0
+	for _, cart := range carriageReport.Carts {
+		items += len(cart.Items)
+	}
+	return models.Receipt{
+		ReportID:    reportID,
+		TripID:      carriageReport.TripID,
+		CarriageID:  carriageReport.CarriageID,
+		ContentHash: ContentHash(carriageReport),
+		Carts:       len(carriageReport.Carts),
+		Items:       items,
+		ReceivedAt:  s.now().UTC(),
+	}, nil
+}
+
+// newRandomReportID Generates a random (version 4) UUID
+func newRandomReportID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate report ID: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"ChaikaReports/internal/repository"
+	"context"
+	"sync"
+
+	"github.com/go-kit/log"
+)
+
+// AggregateRefresher Recomputes the revenue aggregates of written trips in the background. A refresh rescans
+// the whole trip, so a trip scheduled again while it is queued is refreshed once, and concurrent uploads to
+// one trip cost at most one refresh more than a single upload
+type AggregateRefresher struct {
+	repo repository.SalesRepository
+	log  log.Logger
+
+	mu     sync.Mutex
+	queue  []models.TripID
+	queued map[models.TripID]bool
+	wake   chan struct{}
+
+	// draining serializes Run and Drain so a trip is never refreshed twice at the same time
+	draining sync.Mutex
+}
+
+// NewAggregateRefresher Creates an AggregateRefresher, queued trips are refreshed by Run and Drain
+func NewAggregateRefresher(repo repository.SalesRepository, logger log.Logger) *AggregateRefresher {
+	return &AggregateRefresher{
+		repo:   repo,
+		log:    logger,
+		queued: make(map[models.TripID]bool),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Schedule Queues a refresh of the aggregates of a trip unless it is queued already
+func (r *AggregateRefresher) Schedule(tripID models.TripID) {
+	// Map keys compare the location of the start time, equal instants must share one key
+	tripID.StartTime = tripID.StartTime.UTC()
+
+	r.mu.Lock()
+	if !r.queued[tripID] {
+		r.queued[tripID] = true
+		r.queue = append(r.queue, tripID)
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run Refreshes queued trips as they are scheduled until ctx is done
+func (r *AggregateRefresher) Run(ctx context.Context) {
+	for {
+		r.Drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		}
+	}
+}
+
+// Drain Refreshes queued trips until the queue is empty or ctx is done, returns the number of trips refreshed.
+// A failed refresh is logged and dropped, `chaikactl rebuild aggregates` repairs it; a refresh interrupted
+// by ctx stays queued for the next Drain
+func (r *AggregateRefresher) Drain(ctx context.Context) int {
+	r.draining.Lock()
+	defer r.draining.Unlock()
+
+	var refreshed int
+	for ctx.Err() == nil {
+		tripID, ok := r.next()
+		if !ok {
+			break
+		}
+		if err := r.repo.RefreshTripAggregates(ctx, &tripID); err != nil {
+			if ctx.Err() != nil {
+				r.Schedule(tripID)
+				break
+			}
+			_ = r.log.Log("msg", "failed to refresh trip aggregates", "route_id", tripID.RouteID,
+				"start_time", tripID.StartTime, "err", err)
+			continue
+		}
+		refreshed++
+	}
+	return refreshed
+}
+
+// Pending Returns the number of queued trips
+func (r *AggregateRefresher) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue)
+}
+
+func (r *AggregateRefresher) next() (models.TripID, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return models.TripID{}, false
+	}
+	tripID := r.queue[0]
+	r.queue = r.queue[1:]
+	delete(r.queued, tripID)
+	return tripID, true
+}
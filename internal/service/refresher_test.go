@@ -0,0 +1,142 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"ChaikaReports/internal/repository"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refreshStore counts aggregate refreshes per trip and fails the writes listed in failing
+type refreshStore struct {
+	repository.SalesRepository
+
+	mu        sync.Mutex
+	refreshes map[string]int
+	failing   map[string]bool
+	inserted  int
+}
+
+func newRefreshStore() *refreshStore {
+	return &refreshStore{refreshes: make(map[string]int), failing: make(map[string]bool)}
+}
+
+func (s *refreshStore) RefreshTripAggregates(_ context.Context, tripID *models.TripID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing[tripID.RouteID] {
+		return errors.New("write timeout")
+	}
+	s.refreshes[tripID.RouteID]++
+	return nil
+}
+
+func (s *refreshStore) InsertData(_ context.Context, _ *models.CarriageReport, _ *models.Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inserted++
+	return nil
+}
+
+func refreshTripID(routeID string) models.TripID {
+	return models.TripID{RouteID: routeID, Year: "2024", StartTime: time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)}
+}
+
+func TestAggregateRefresher_CoalescesQueuedTrips(t *testing.T) {
+	store := newRefreshStore()
+	refresher := NewAggregateRefresher(store, log.NewNopLogger())
+
+	for i := 0; i < 5; i++ {
+		refresher.Schedule(refreshTripID("r1"))
+	}
+	// The same start time in another location is the same trip
+	moscow := refreshTripID("r1")
+	moscow.StartTime = moscow.StartTime.In(time.FixedZone("MSK", 3*60*60))
+	refresher.Schedule(moscow)
+	refresher.Schedule(refreshTripID("r2"))
+	assert.Equal(t, 2, refresher.Pending())
+
+	assert.Equal(t, 2, refresher.Drain(context.Background()))
+	assert.Equal(t, map[string]int{"r1": 1, "r2": 1}, store.refreshes)
+	assert.Zero(t, refresher.Pending())
+}
+
+func TestAggregateRefresher_FailureIsDropped(t *testing.T) {
+	store := newRefreshStore()
+	store.failing["r1"] = true
+	refresher := NewAggregateRefresher(store, log.NewNopLogger())
+
+	refresher.Schedule(refreshTripID("r1"))
+	refresher.Schedule(refreshTripID("r2"))
+	assert.Equal(t, 1, refresher.Drain(context.Background()))
+	assert.Equal(t, map[string]int{"r2": 1}, store.refreshes)
+	assert.Zero(t, refresher.Pending(), "failed refreshes are left to the rebuild command")
+}
+
+func TestAggregateRefresher_CancelledDrainKeepsQueue(t *testing.T) {
+	store := newRefreshStore()
+	refresher := NewAggregateRefresher(store, log.NewNopLogger())
+	refresher.Schedule(refreshTripID("r1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Zero(t, refresher.Drain(ctx))
+	assert.Equal(t, 1, refresher.Pending())
+}
+
+func TestAggregateRefresher_Run(t *testing.T) {
+	store := newRefreshStore()
+	refresher := NewAggregateRefresher(store, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		refresher.Run(ctx)
+		close(done)
+	}()
+
+	refresher.Schedule(refreshTripID("r1"))
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.refreshes["r1"] == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestInsertDataBulk_RefreshesTripOnce(t *testing.T) {
+	store := newRefreshStore()
+	refresher := NewAggregateRefresher(store, log.NewNopLogger())
+	svc := NewSalesService(store, WithAggregateRefresher(refresher))
+
+	reports := make([]*models.CarriageReport, 20)
+	for i := range reports {
+		reports[i] = &models.CarriageReport{TripID: refreshTripID("r1"), CarriageID: int8(i + 1)}
+	}
+	for _, res := range svc.InsertDataBulk(context.Background(), reports) {
+		require.NoError(t, res.Err)
+	}
+	assert.Equal(t, 20, store.inserted)
+	assert.Zero(t, store.refreshes["r1"], "the refresh runs in the background")
+
+	assert.Equal(t, 1, refresher.Drain(context.Background()))
+	assert.Equal(t, 1, store.refreshes["r1"])
+}
+
+func TestInsertData_RefreshFailureIsNotReported(t *testing.T) {
+	store := newRefreshStore()
+	store.failing["r1"] = true
+	svc := NewSalesService(store)
+
+	_, err := svc.InsertData(context.Background(), &models.CarriageReport{TripID: refreshTripID("r1"), CarriageID: 1})
+	require.NoError(t, err, "the report is committed before its aggregates are refreshed")
+	assert.Equal(t, 1, store.inserted)
+}
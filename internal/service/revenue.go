@@ -0,0 +1,38 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxRevenueDays caps the range of a daily revenue query, every day is one partition read
+const maxRevenueDays = 366
+
+// GetTripRevenue Gets the stored revenue aggregate of a trip
+func (s *salesService) GetTripRevenue(ctx context.Context, tripID *models.TripID) (models.TripRevenue, error) {
+	return s.repo.GetTripRevenue(ctx, tripID)
+}
+
+// GetDailyRevenue Gets the daily revenue of a route, employee or product for the UTC days from..to inclusive
+func (s *salesService) GetDailyRevenue(ctx context.Context, scope models.RevenueScope, from, to time.Time) ([]models.DailyRevenue, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("revenue range ends before it starts")
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxRevenueDays {
+		return nil, fmt.Errorf("revenue range of %d days is longer than %d days", days, maxRevenueDays)
+	}
+	return s.repo.GetDailyRevenue(ctx, scope, from, to)
+}
+
+// refreshAggregates Recomputes the revenue aggregates of a trip after its operations changed. The write has
+// been committed by then, so the request never fails because of its aggregates: with a refresher the trip is
+// queued for the background, otherwise it is refreshed inline and a failure is left to `chaikactl rebuild aggregates`
+func (s *salesService) refreshAggregates(ctx context.Context, tripID *models.TripID) {
+	if s.refresher != nil {
+		s.refresher.Schedule(*tripID)
+		return
+	}
+	_ = s.repo.RefreshTripAggregates(ctx, tripID)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxSearchDays caps the range of trip start days a search covers
+	maxSearchDays = 31
+	// maxSearchTrips caps the trips read for one page, a page that reaches it may hold fewer
+	// operations than its limit, even none, and still have a next cursor
+	maxSearchTrips = 200
+	// maxSearchLimit caps the operations returned in a page
+	maxSearchLimit = 500
+)
+
+// searchQuery is the query a search cursor pages through
+type searchQuery struct {
+	filter models.OperationFilter
+	limit  int
+}
+
+// digest Returns a short hash identifying the query
+func (q searchQuery) digest() string {
+	f := q.filter
+	return digestKey(fmt.Sprintf("search|%q|%s|%s|%s|%q|%d|%s|%s|%s|%d",
+		f.RouteID, f.From.Format(time.DateOnly), f.To.Format(time.DateOnly), optional(f.CarriageID), f.EmployeeID,
+		f.OperationType, optional(f.ProductID), optional(f.MinAmount), optional(f.MaxAmount), q.limit))
+}
+
+func optional[T int8 | int | int64](v *T) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+// searchPosition is where a search resumes: after the operation Key of the trip of Route started at Start,
+// or after the whole trip when Key is nil
+type searchPosition struct {
+	Route string        `json:"r"`
+	Start time.Time     `json:"s"`
+	Key   *operationKey `json:"k,omitempty"`
+}
+
+// operationKey orders the operations of a trip the way models.Trip.Sort does
+type operationKey struct {
+	CarriageID    int8      `json:"c"`
+	OperationTime time.Time `json:"t"`
+	EmployeeID    string    `json:"e"`
+	ProductID     int       `json:"p"`
+}
+
+func keyOf(op models.Operation) operationKey {
+	return operationKey{
+		CarriageID:    op.CarriageID,
+		OperationTime: op.CartID.OperationTime,
+		EmployeeID:    op.CartID.EmployeeID,
+		ProductID:     op.Item.ProductID,
+	}
+}
+
+// after Reports whether k comes after other
+func (k operationKey) after(other operationKey) bool {
+	if k.CarriageID != other.CarriageID {
+		return k.CarriageID > other.CarriageID
+	}
+	if !k.OperationTime.Equal(other.OperationTime) {
+		return k.OperationTime.After(other.OperationTime)
+	}
+	if k.EmployeeID != other.EmployeeID {
+		return k.EmployeeID > other.EmployeeID
+	}
+	return k.ProductID > other.ProductID
+}
+
+// SearchOperations Finds the operations matching filter, scanning the trips of the selected routes and days
+// route by route and trip by trip in start order; cursors are signed and only accepted for the same filter and limit
+func (s *salesService) SearchOperations(ctx context.Context, filter models.OperationFilter, limit int, cursor string) (models.OperationPage, error) {
+	if filter.To.Before(filter.From) {
+		return models.OperationPage{}, fmt.Errorf("search range ends before it starts")
+	}
+	if days := int(filter.To.Sub(filter.From).Hours()/24) + 1; days > maxSearchDays {
+		return models.OperationPage{}, fmt.Errorf("search range of %d days is longer than %d days", days, maxSearchDays)
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		return models.OperationPage{}, fmt.Errorf("min_amount is greater than max_amount")
+	}
+	if limit <= 0 || limit > maxSearchLimit {
+		return models.OperationPage{}, fmt.Errorf("search limit must be between 1 and %d", maxSearchLimit)
+	}
+
+	query := searchQuery{filter: filter, limit: limit}
+	var pos *searchPosition
+	if cursor != "" {
+		raw, err := s.cursors.Open(cursor, query, s.now())
+		if err != nil {
+			return models.OperationPage{}, err
+		}
+		pos = new(searchPosition)
+		if err := json.Unmarshal([]byte(raw), pos); err != nil {
+			return models.OperationPage{}, fmt.Errorf("%w: malformed", models.ErrInvalidCursor)
+		}
+	}
+
+	routes := []string{filter.RouteID}
+	if filter.RouteID == "" {
+		var err error
+		if routes, err = s.repo.GetRoutes(ctx); err != nil {
+			return models.OperationPage{}, fmt.Errorf("failed to list routes: %w", err)
+		}
+		sort.Strings(routes)
+	}
+
+	from, to := filter.From, filter.To.AddDate(0, 0, 1)
+	page := models.OperationPage{Operations: []models.Operation{}}
+	scanned := 0
+	var lastScanned models.TripID
+	for _, routeID := range routes {
+		if pos != nil && routeID < pos.Route {
+			continue
+		}
+		trips, err := s.repo.GetRouteTrips(ctx, routeID, from, to)
+		if err != nil {
+			return models.OperationPage{}, fmt.Errorf("failed to list trips of route %s: %w", routeID, err)
+		}
+		sort.Slice(trips, func(i, j int) bool { return trips[i].StartTime.Before(trips[j].StartTime) })
+
+		for i := range trips {
+			var resumeAfter *operationKey
+			if pos != nil && routeID == pos.Route {
+				if trips[i].StartTime.Before(pos.Start) || trips[i].StartTime.Equal(pos.Start) && pos.Key == nil {
+					continue
+				}
+				if trips[i].StartTime.Equal(pos.Start) {
+					resumeAfter = pos.Key
+				}
+			}
+			if scanned == maxSearchTrips {
+				// Every match of the trips scanned so far has been returned
+				page.NextCursor = s.sealPosition(query, searchPosition{Route: lastScanned.RouteID, Start: lastScanned.StartTime})
+				return page, nil
+			}
+			scanned++
+			lastScanned = trips[i]
+
+			trip, err := s.repo.GetTrip(ctx, &trips[i])
+			if err != nil {
+				return models.OperationPage{}, fmt.Errorf("failed to read trip %s %v: %w", routeID, trips[i].StartTime, err)
+			}
+			for _, op := range matchingOperations(trips[i], trip, filter) {
+				if resumeAfter != nil && !keyOf(op).after(*resumeAfter) {
+					continue
+				}
+				if len(page.Operations) == limit {
+					// Another match exists, the next page starts after the last operation returned
+					last := page.Operations[limit-1]
+					key := keyOf(last)
+					page.NextCursor = s.sealPosition(query, searchPosition{Route: last.TripID.RouteID, Start: last.TripID.StartTime, Key: &key})
+					return page, nil
+				}
+				page.Operations = append(page.Operations, op)
+			}
+		}
+	}
+	return page, nil
+}
+
+func (s *salesService) sealPosition(query searchQuery, pos searchPosition) string {
+	raw, _ := json.Marshal(pos)
+	return s.cursors.Seal(string(raw), query, s.now())
+}
+
+// matchingOperations Returns the operations of trip matching filter in the order of models.Trip.Sort
+func matchingOperations(tripID models.TripID, trip models.Trip, filter models.OperationFilter) []models.Operation {
+	trip.Sort()
+	var ops []models.Operation
+	for _, report := range trip.Carriage {
+		if filter.CarriageID != nil && report.CarriageID != *filter.CarriageID {
+			continue
+		}
+		for _, cart := range report.Carts {
+			if filter.EmployeeID != "" && cart.CartID.EmployeeID != filter.EmployeeID ||
+				filter.OperationType != 0 && cart.OperationType != filter.OperationType {
+				continue
+			}
+			for _, item := range cart.Items {
+				amount := int64(item.Quantity) * item.Price
+				if filter.ProductID != nil && item.ProductID != *filter.ProductID ||
+					filter.MinAmount != nil && amount < *filter.MinAmount ||
+					filter.MaxAmount != nil && amount > *filter.MaxAmount {
+					continue
+				}
+				ops = append(ops, models.Operation{
+					TripID:        tripID,
+					CarriageID:    report.CarriageID,
+					CartID:        cart.CartID,
+					OperationType: cart.OperationType,
+					Item:          item,
+					Amount:        amount,
+				})
+			}
+		}
+	}
+	return ops
+}
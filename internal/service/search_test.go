@@ -0,0 +1,202 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"ChaikaReports/internal/repository"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tripStore serves routes and trips from memory and counts the trips read
+type tripStore struct {
+	repository.SalesRepository
+	trips     map[string][]models.Trip // by route, every trip has at least one carriage
+	tripReads int
+}
+
+func (s *tripStore) add(trip models.Trip) {
+	if s.trips == nil {
+		s.trips = make(map[string][]models.Trip)
+	}
+	routeID := trip.Carriage[0].TripID.RouteID
+	s.trips[routeID] = append(s.trips[routeID], trip)
+}
+
+func (s *tripStore) GetRoutes(_ context.Context) ([]string, error) {
+	var routes []string
+	for routeID := range s.trips {
+		routes = append(routes, routeID)
+	}
+	return routes, nil
+}
+
+func (s *tripStore) GetRouteTrips(_ context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	var trips []models.TripID
+	for _, trip := range s.trips[routeID] {
+		tripID := trip.Carriage[0].TripID
+		if !tripID.StartTime.Before(from) && tripID.StartTime.Before(to) {
+			trips = append(trips, tripID)
+		}
+	}
+	return trips, nil
+}
+
+func (s *tripStore) GetTrip(_ context.Context, tripID *models.TripID) (models.Trip, error) {
+	s.tripReads++
+	for _, trip := range s.trips[tripID.RouteID] {
+		if trip.Carriage[0].TripID.StartTime.Equal(tripID.StartTime) {
+			return trip, nil
+		}
+	}
+	return models.Trip{}, nil
+}
+
+var searchDay = time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+
+func searchTrip(routeID string, hour int, carts ...models.Cart) models.Trip {
+	start := searchDay.Add(time.Duration(hour) * time.Hour)
+	return models.Trip{Carriage: []models.CarriageReport{{
+		TripID:     models.TripID{RouteID: routeID, Year: "2024", StartTime: start},
+		CarriageID: 1,
+		Carts:      carts,
+	}}}
+}
+
+func searchCart(employeeID string, minute int, opType int8, items ...models.Item) models.Cart {
+	return models.Cart{
+		CartID:        models.CartID{EmployeeID: employeeID, OperationTime: searchDay.Add(time.Duration(minute) * time.Minute)},
+		OperationType: opType,
+		Items:         items,
+	}
+}
+
+// searchAll Pages through a search and returns the product IDs found and the number of pages
+func searchAll(t *testing.T, svc SalesService, filter models.OperationFilter, limit int) ([]int, int) {
+	t.Helper()
+	var products []int
+	cursor := ""
+	for pages := 1; ; pages++ {
+		require.Less(t, pages, 100, "paging does not end")
+		page, err := svc.SearchOperations(context.Background(), filter, limit, cursor)
+		require.NoError(t, err)
+		for _, op := range page.Operations {
+			products = append(products, op.Item.ProductID)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			return products, pages
+		}
+	}
+}
+
+func TestSearchOperations(t *testing.T) {
+	store := &tripStore{}
+	store.add(searchTrip("b", 10,
+		searchCart("e1", 610, models.OperationTypeSale, models.Item{ProductID: 42, Quantity: 1, Price: 100}),
+		searchCart("e2", 620, models.OperationTypeRefund, models.Item{ProductID: 42, Quantity: 2, Price: 100}, models.Item{ProductID: 7, Quantity: 1, Price: 30}),
+	))
+	store.add(searchTrip("a", 8,
+		searchCart("e1", 500, models.OperationTypeRefund, models.Item{ProductID: 42, Quantity: 1, Price: 100}),
+		searchCart("e1", 490, models.OperationTypeSale, models.Item{ProductID: 3, Quantity: 5, Price: 20}),
+	))
+	// Started the day after the range
+	store.add(searchTrip("a", 30, searchCart("e1", 1810, models.OperationTypeRefund, models.Item{ProductID: 42, Quantity: 1, Price: 100})))
+	svc := NewSalesService(store, WithCursorSigner(NewCursorSigner([]byte("search-test-secret"), time.Hour)))
+
+	day := searchDay
+	refund := models.OperationTypeRefund
+	product := 42
+	minAmount := int64(150)
+	carriage := int8(2)
+
+	tests := []struct {
+		name   string
+		filter models.OperationFilter
+		want   []int
+	}{
+		{name: "Everything, by route then time", filter: models.OperationFilter{From: day, To: day}, want: []int{3, 42, 42, 7, 42}},
+		{name: "Refunds of a product", filter: models.OperationFilter{From: day, To: day, OperationType: refund, ProductID: &product}, want: []int{42, 42}},
+		{name: "Route", filter: models.OperationFilter{RouteID: "b", From: day, To: day}, want: []int{42, 7, 42}},
+		{name: "Employee", filter: models.OperationFilter{From: day, To: day, EmployeeID: "e2"}, want: []int{7, 42}},
+		{name: "Minimum amount", filter: models.OperationFilter{From: day, To: day, MinAmount: &minAmount}, want: []int{42}},
+		{name: "Carriage", filter: models.OperationFilter{From: day, To: day, CarriageID: &carriage}, want: nil},
+		{name: "Two days", filter: models.OperationFilter{RouteID: "a", From: day, To: day.AddDate(0, 0, 1)}, want: []int{3, 42, 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all, pages := searchAll(t, svc, tt.filter, 10)
+			assert.Equal(t, tt.want, all)
+			assert.Equal(t, 1, pages)
+
+			// Paging one operation at a time finds the same operations
+			paged, _ := searchAll(t, svc, tt.filter, 1)
+			assert.Equal(t, tt.want, paged)
+		})
+	}
+}
+
+func TestSearchOperations_ScanLimit(t *testing.T) {
+	store := &tripStore{}
+	for i := 0; i < maxSearchTrips+5; i++ {
+		trip := searchTrip("r", 0, searchCart("e1", i, models.OperationTypeSale, models.Item{ProductID: i + 1, Quantity: 1, Price: 10}))
+		trip.Carriage[0].TripID.StartTime = searchDay.Add(time.Duration(i) * time.Minute)
+		store.add(trip)
+	}
+	svc := NewSalesService(store)
+	product := maxSearchTrips + 3
+	filter := models.OperationFilter{From: searchDay, To: searchDay, ProductID: &product}
+
+	page, err := svc.SearchOperations(context.Background(), filter, 10, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Operations)
+	assert.NotEmpty(t, page.NextCursor)
+	assert.Equal(t, maxSearchTrips, store.tripReads)
+
+	page, err = svc.SearchOperations(context.Background(), filter, 10, page.NextCursor)
+	require.NoError(t, err)
+	require.Len(t, page.Operations, 1)
+	assert.Equal(t, product, page.Operations[0].Item.ProductID)
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, maxSearchTrips+5, store.tripReads)
+}
+
+func TestSearchOperations_Rejected(t *testing.T) {
+	store := &tripStore{}
+	store.add(searchTrip("r", 0,
+		searchCart("e1", 0, models.OperationTypeSale, models.Item{ProductID: 1, Quantity: 1, Price: 10}, models.Item{ProductID: 2, Quantity: 1, Price: 10})))
+	svc := NewSalesService(store)
+	filter := models.OperationFilter{From: searchDay, To: searchDay}
+	page, err := svc.SearchOperations(context.Background(), filter, 1, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, page.NextCursor)
+
+	minAmount, maxAmount := int64(10), int64(5)
+	otherRoute := filter
+	otherRoute.RouteID = "x"
+	tests := []struct {
+		name    string
+		filter  models.OperationFilter
+		limit   int
+		cursor  string
+		wantErr string
+	}{
+		{name: "Reversed range", filter: models.OperationFilter{From: searchDay, To: searchDay.AddDate(0, 0, -1)}, limit: 1, wantErr: "search range ends before it starts"},
+		{name: "Long range", filter: models.OperationFilter{From: searchDay, To: searchDay.AddDate(0, 0, maxSearchDays)}, limit: 1,
+			wantErr: fmt.Sprintf("search range of %d days is longer than %d days", maxSearchDays+1, maxSearchDays)},
+		{name: "Amount bounds", filter: models.OperationFilter{From: searchDay, To: searchDay, MinAmount: &minAmount, MaxAmount: &maxAmount}, limit: 1,
+			wantErr: "min_amount is greater than max_amount"},
+		{name: "Limit", filter: filter, limit: maxSearchLimit + 1, wantErr: fmt.Sprintf("search limit must be between 1 and %d", maxSearchLimit)},
+		{name: "Cursor of another filter", filter: otherRoute, limit: 1, cursor: page.NextCursor, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+		{name: "Cursor of another limit", filter: filter, limit: 2, cursor: page.NextCursor, wantErr: "invalid cursor: issued for another trip, employee, limit or filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.SearchOperations(context.Background(), tt.filter, tt.limit, tt.cursor)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -4,34 +4,174 @@ import (
 	"ChaikaReports/internal/models"
 	"ChaikaReports/internal/repository"
 	"context"
+	"errors"
+	"sync"
 	"time"
 )
 
+// bulkInsertConcurrency limits how many carriage reports of a bulk upload are written in parallel
+const bulkInsertConcurrency = 8
+
+// DefaultMaxClockSkew is the terminal clock skew above which a carriage report is flagged
+const DefaultMaxClockSkew = 5 * time.Minute
+
 type SalesService interface {
-	InsertData(ctx context.Context, carriageReport *models.CarriageReport) error
+	InsertData(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, error)
+	InsertDataBulk(ctx context.Context, carriageReports []*models.CarriageReport) []models.InsertResult
+	ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, models.ReportDiff, error)
+	GetReceipt(ctx context.Context, reportID string) (models.Receipt, error)
 	GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error)
-	GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string) ([]models.Cart, error)
-	GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, cartLimit int, cursor string) ([]models.Cart, string, error)
+	GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string, filter models.CartFilter) ([]models.Cart, error)
+	GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, filter models.CartFilter, cartLimit int, cursor string) (models.CartPage, error)
 	GetEmployeeIDsByTrip(ctx context.Context, tripID *models.TripID) ([]string, error)
 	GetEmployeeTrips(ctx context.Context, employeeID string, year string) ([]models.EmployeeTrip, error)
 	GetUnsyncedTrips(ctx context.Context) ([]models.TripID, error)
 	UpdateItemQuantity(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int, newQuantity *int16) error
 	DeleteItemFromCart(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int) error
 	DeleteSyncedTrip(ctx context.Context, routeID string, startTime time.Time) error
+	RequeueTrip(ctx context.Context, tripID *models.TripID) error
+	GetArchivedTrips(ctx context.Context, routeID string) ([]models.ArchivedTrip, error)
+	GetTripSummary(ctx context.Context, tripID *models.TripID) (models.TripSummary, error)
+	GetTripRevenue(ctx context.Context, tripID *models.TripID) (models.TripRevenue, error)
+	GetDailyRevenue(ctx context.Context, scope models.RevenueScope, from, to time.Time) ([]models.DailyRevenue, error)
+	SearchOperations(ctx context.Context, filter models.OperationFilter, limit int, cursor string) (models.OperationPage, error)
+	GetEmployeeAnalytics(ctx context.Context, routeID, employeeID string, from, to time.Time) ([]models.EmployeeStats, error)
+	GetAnomalies(ctx context.Context, filter models.AnomalyFilter) ([]models.Anomaly, error)
+	GetProductConsumption(ctx context.Context, routeID string, from, to time.Time, byCarriage bool) ([]models.ProductConsumption, error)
 }
 
 type salesService struct {
-	repo repository.SalesRepository
+	repo        repository.SalesRepository
+	now         func() time.Time
+	newReportID func() (string, error)
+
+	maxClockSkew       time.Duration
+	normalizeClockSkew bool
+
+	cursors   *CursorSigner
+	refresher *AggregateRefresher
+}
+
+// Option configures optional behaviour of the salesService
+type Option func(*salesService)
+
+// WithClockSkewPolicy Sets the clock skew above which reports are flagged, 0 flags any skew, and whether
+// operation times of flagged reports are shifted to server time
+func WithClockSkewPolicy(maxSkew time.Duration, normalize bool) Option {
+	return func(s *salesService) {
+		s.maxClockSkew = maxSkew
+		s.normalizeClockSkew = normalize
+	}
+}
+
+// WithCursorSigner Sets the signer of paging cursors, which by default uses a random secret
+func WithCursorSigner(signer *CursorSigner) Option {
+	return func(s *salesService) {
+		s.cursors = signer
+	}
+}
+
+// WithAggregateRefresher Refreshes revenue aggregates in the background instead of inline after every write
+func WithAggregateRefresher(refresher *AggregateRefresher) Option {
+	return func(s *salesService) {
+		s.refresher = refresher
+	}
+}
+
+// WithClock Replaces the server clock, used by tests
+func WithClock(now func() time.Time) Option {
+	return func(s *salesService) {
+		s.now = now
+	}
+}
+
+// WithReportIDGenerator Replaces the generator of receipt report IDs, used by tests
+func WithReportIDGenerator(newReportID func() (string, error)) Option {
+	return func(s *salesService) {
+		s.newReportID = newReportID
+	}
 }
 
 // NewSalesService Creates new salesService
-func NewSalesService(repo repository.SalesRepository) SalesService {
-	return &salesService{repo: repo}
+func NewSalesService(repo repository.SalesRepository, opts ...Option) SalesService {
+	s := &salesService{
+		repo:         repo,
+		now:          time.Now,
+		newReportID:  newRandomReportID,
+		maxClockSkew: DefaultMaxClockSkew,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cursors == nil {
+		s.cursors = NewCursorSigner(nil, 0)
+	}
+	return s
+}
+
+// InsertData Inserts incoming carriageReport data, returns the receipt issued for it
+func (s *salesService) InsertData(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, error) {
+	receipt, err := s.newReceipt(carriageReport)
+	if err != nil {
+		return models.Receipt{}, err
+	}
+	if err := s.applyClockSkew(ctx, carriageReport); err != nil {
+		return models.Receipt{}, err
+	}
+	if err := s.repo.InsertData(ctx, carriageReport, &receipt); err != nil {
+		return models.Receipt{}, err
+	}
+	s.refreshAggregates(ctx, &carriageReport.TripID)
+	return receipt, nil
+}
+
+// InsertDataBulk Inserts several carriage reports with bounded concurrency,
+// returns a result for every report in the same order
+func (s *salesService) InsertDataBulk(ctx context.Context, carriageReports []*models.CarriageReport) []models.InsertResult {
+	results := make([]models.InsertResult, len(carriageReports))
+	sem := make(chan struct{}, bulkInsertConcurrency)
+	var wg sync.WaitGroup
+
+	for i, report := range carriageReports {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, report *models.CarriageReport) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				return
+			}
+			results[i].Receipt, results[i].Err = s.InsertData(ctx, report)
+		}(i, report)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// ReplaceCarriageReport Replaces a previously uploaded carriage report, returns its receipt and what changed
+func (s *salesService) ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport) (models.Receipt, models.ReportDiff, error) {
+	receipt, err := s.newReceipt(carriageReport)
+	if err != nil {
+		return models.Receipt{}, models.ReportDiff{}, err
+	}
+	if err := s.applyClockSkew(ctx, carriageReport); err != nil {
+		return models.Receipt{}, models.ReportDiff{}, err
+	}
+	diff, err := s.repo.ReplaceCarriageReport(ctx, carriageReport, &receipt)
+	if err != nil {
+		return models.Receipt{}, models.ReportDiff{}, err
+	}
+	s.refreshAggregates(ctx, &carriageReport.TripID)
+	return receipt, diff, nil
 }
 
-// InsertData Inserts incoming carriageReport data
-func (s *salesService) InsertData(ctx context.Context, carriageReport *models.CarriageReport) error {
-	return s.repo.InsertData(ctx, carriageReport)
+// GetReceipt Gets the receipt of a previously accepted carriage report
+func (s *salesService) GetReceipt(ctx context.Context, reportID string) (models.Receipt, error) {
+	return s.repo.GetReceipt(ctx, reportID)
 }
 
 // GetTrip Gets all reports from a single trip
@@ -39,14 +179,34 @@ func (s *salesService) GetTrip(ctx context.Context, tripID *models.TripID) (mode
 	return s.repo.GetTrip(ctx, tripID)
 }
 
-// GetEmployeeCartsInTrip Gets all carts an employee made during trip
-func (s *salesService) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string) ([]models.Cart, error) {
-	return s.repo.GetEmployeeCartsInTrip(ctx, tripID, employeeID)
+// GetEmployeeCartsInTrip Gets all carts an employee made during trip matching filter
+func (s *salesService) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string, filter models.CartFilter) ([]models.Cart, error) {
+	return s.repo.GetEmployeeCartsInTrip(ctx, tripID, employeeID, filter)
 }
 
-// GetEmployeeCartsInTripPaged Gets paged carts an employee has sold during trip, returns array of Carts and a cursor for paging
-func (s *salesService) GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, cartLimit int, cursor string) ([]models.Cart, string, error) {
-	return s.repo.GetEmployeeCartsInTripPaged(ctx, tripID, employeeID, cartLimit, cursor)
+// GetEmployeeCartsInTripPaged Gets a page of the carts an employee has sold during trip matching filter, with cursors
+// of the next and previous pages; cursors are signed and only accepted for the same trip, employee, limit and filter
+func (s *salesService) GetEmployeeCartsInTripPaged(ctx context.Context, tripID *models.TripID, employeeID string, filter models.CartFilter, cartLimit int, cursor string) (models.CartPage, error) {
+	query := CursorQuery{TripID: *tripID, EmployeeID: employeeID, Limit: cartLimit, Filter: filter}
+	var position string
+	if cursor != "" {
+		var err error
+		if position, err = s.cursors.Open(cursor, query, s.now()); err != nil {
+			return models.CartPage{}, err
+		}
+	}
+
+	page, err := s.repo.GetEmployeeCartsInTripPaged(ctx, tripID, employeeID, filter, cartLimit, position)
+	if err != nil {
+		return models.CartPage{}, err
+	}
+	if page.NextCursor != "" {
+		page.NextCursor = s.cursors.Seal(page.NextCursor, query, s.now())
+	}
+	if page.PrevCursor != "" {
+		page.PrevCursor = s.cursors.Seal(page.PrevCursor, query, s.now())
+	}
+	return page, nil
 }
 
 // GetEmployeeIDsByTrip Gets all employee ID's in trip
@@ -62,17 +222,74 @@ func (s *salesService) GetUnsyncedTrips(ctx context.Context) ([]models.TripID, e
 	return s.repo.GetUnsyncedTrips(ctx)
 }
 
-// UpdateItemQuantity Updates item quantity in cart
+// UpdateItemQuantity Updates item quantity in cart, the repository records the correction once it is applied
 func (s *salesService) UpdateItemQuantity(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int, newQuantity *int16) error {
-	return s.repo.UpdateItemQuantity(ctx, tripID, cartID, productID, newQuantity)
+	if err := s.repo.UpdateItemQuantity(ctx, tripID, cartID, productID, newQuantity); err != nil {
+		return err
+	}
+	s.refreshAggregates(ctx, tripID)
+	return nil
 }
 
-// DeleteItemFromCart Deletes item from cart
+// DeleteItemFromCart Deletes item from cart, the repository records the correction once it is applied
 func (s *salesService) DeleteItemFromCart(ctx context.Context, tripID *models.TripID, cartID *models.CartID, productID *int) error {
-	return s.repo.DeleteItemFromCart(ctx, tripID, cartID, productID)
+	if err := s.repo.DeleteItemFromCart(ctx, tripID, cartID, productID); err != nil {
+		return err
+	}
+	s.refreshAggregates(ctx, tripID)
+	return nil
 }
 
 // DeleteSyncedTrip Deletes an already synchronized trip
 func (s *salesService) DeleteSyncedTrip(ctx context.Context, routeID string, startTime time.Time) error {
 	return s.repo.DeleteSyncedTrip(ctx, routeID, startTime)
 }
+
+// RequeueTrip Marks a trip as unsynchronized again
+func (s *salesService) RequeueTrip(ctx context.Context, tripID *models.TripID) error {
+	return s.repo.RequeueTrip(ctx, tripID)
+}
+
+// GetArchivedTrips Gets the catalog of trips moved to archive files by the retention job
+func (s *salesService) GetArchivedTrips(ctx context.Context, routeID string) ([]models.ArchivedTrip, error) {
+	return s.repo.GetArchivedTrips(ctx, routeID)
+}
+
+// applyClockSkew Compares the terminal-reported device time with the server clock and flags the report
+// when the skew exceeds maxClockSkew. When normalization is enabled, operation times of flagged reports
+// are shifted by the skew and the terminal values are kept in Cart.RawOperationTime. Operation time is
+// part of the primary key, so the skew measured on the first upload of a carriage report is stored and
+// reused by retries and replacements, which then shift operation times to the same keys; whether they
+// are normalized also follows the first upload. The trip start time is left as reported since all
+// carriages share it.
+func (s *salesService) applyClockSkew(ctx context.Context, carriageReport *models.CarriageReport) error {
+	cs := carriageReport.ClockSkew
+	if cs == nil || cs.DeviceTime.IsZero() {
+		return nil
+	}
+
+	stored, err := s.repo.GetClockSkew(ctx, &carriageReport.TripID, carriageReport.CarriageID)
+	switch {
+	case err == nil:
+		*cs = stored
+	case errors.Is(err, models.ErrNotFound):
+		// Stored skews have millisecond precision, measure the first one the same way
+		cs.ServerTime = s.now().UTC().Truncate(time.Millisecond)
+		cs.Skew = cs.ServerTime.Sub(cs.DeviceTime).Truncate(time.Millisecond)
+		cs.Flagged = cs.Skew > s.maxClockSkew || cs.Skew < -s.maxClockSkew
+		cs.Corrected = cs.Flagged && s.normalizeClockSkew
+	default:
+		return err
+	}
+	if !cs.Corrected {
+		return nil
+	}
+
+	for i := range carriageReport.Carts {
+		cart := &carriageReport.Carts[i]
+		raw := cart.CartID.OperationTime
+		cart.RawOperationTime = &raw
+		cart.CartID.OperationTime = raw.Add(cs.Skew)
+	}
+	return nil
+}
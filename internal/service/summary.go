@@ -0,0 +1,52 @@
+package service
+
+import (
+	"ChaikaReports/internal/models"
+	"context"
+)
+
+// GetTripSummary Aggregates the carts of a trip into counts and totals, item price is per unit
+func (s *salesService) GetTripSummary(ctx context.Context, tripID *models.TripID) (models.TripSummary, error) {
+	trip, err := s.repo.GetTrip(ctx, tripID)
+	if err != nil {
+		return models.TripSummary{}, err
+	}
+	return summarizeTrip(*tripID, trip), nil
+}
+
+func summarizeTrip(tripID models.TripID, trip models.Trip) models.TripSummary {
+	summary := models.TripSummary{TripID: tripID}
+	carriages := make(map[int8]struct{})
+	employees := make(map[string]struct{})
+
+	for _, report := range trip.Carriage {
+		carriages[report.CarriageID] = struct{}{}
+		for _, cart := range report.Carts {
+			employees[cart.CartID.EmployeeID] = struct{}{}
+			summary.Carts++
+
+			var quantity int
+			var total int64
+			for _, item := range cart.Items {
+				quantity += int(item.Quantity)
+				total += int64(item.Quantity) * item.Price
+			}
+
+			switch cart.OperationType {
+			case models.OperationTypeSale:
+				summary.Sales++
+				summary.ItemsSold += quantity
+				summary.SalesTotal += total
+			case models.OperationTypeRefund:
+				summary.Refunds++
+				summary.ItemsRefunded += quantity
+				summary.RefundsTotal += total
+			}
+		}
+	}
+
+	summary.Carriages = len(carriages)
+	summary.Employees = len(employees)
+	summary.NetTotal = summary.SalesTotal - summary.RefundsTotal
+	return summary
+}
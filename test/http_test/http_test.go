@@ -9,11 +9,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-kit/log"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,11 +38,117 @@ func (m *MockSalesRepository) DeleteSyncedTrip(ctx context.Context, routeID stri
 	panic("implement me")
 }
 
-func (m *MockSalesRepository) InsertData(ctx context.Context, carriageReport *models.CarriageReport) error {
+func (m *MockSalesRepository) RequeueTrip(ctx context.Context, tripID *models.TripID) error {
+	args := m.Called(ctx, tripID)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) GetRoutes(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	routes, _ := args.Get(0).([]string)
+	return routes, args.Error(1)
+}
+
+func (m *MockSalesRepository) GetRouteTrips(ctx context.Context, routeID string, from, to time.Time) ([]models.TripID, error) {
+	args := m.Called(ctx, routeID, from, to)
+	trips, _ := args.Get(0).([]models.TripID)
+	return trips, args.Error(1)
+}
+
+func (m *MockSalesRepository) BackfillRouteTrips(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockSalesRepository) DeleteTrip(ctx context.Context, tripID *models.TripID, employeeIDs []string) error {
+	args := m.Called(ctx, tripID, employeeIDs)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) InsertArchivedTrip(ctx context.Context, archived *models.ArchivedTrip) error {
+	args := m.Called(ctx, archived)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) GetArchivedTrips(ctx context.Context, routeID string) ([]models.ArchivedTrip, error) {
+	args := m.Called(ctx, routeID)
+	trips, _ := args.Get(0).([]models.ArchivedTrip)
+	return trips, args.Error(1)
+}
+
+func (m *MockSalesRepository) BackfillTripEmployees(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockSalesRepository) RefreshTripAggregates(ctx context.Context, tripID *models.TripID) error {
+	args := m.Called(ctx, tripID)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) RebuildAggregates(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockSalesRepository) GetTripRevenue(ctx context.Context, tripID *models.TripID) (models.TripRevenue, error) {
+	args := m.Called(ctx, tripID)
+	return args.Get(0).(models.TripRevenue), args.Error(1)
+}
+
+func (m *MockSalesRepository) GetDailyRevenue(ctx context.Context, scope models.RevenueScope, from, to time.Time) ([]models.DailyRevenue, error) {
+	args := m.Called(ctx, scope, from, to)
+	return args.Get(0).([]models.DailyRevenue), args.Error(1)
+}
+
+func (m *MockSalesRepository) GetCorrections(ctx context.Context, day time.Time) ([]models.Correction, error) {
+	args := m.Called(ctx, day)
+	corrections, _ := args.Get(0).([]models.Correction)
+	return corrections, args.Error(1)
+}
+
+func (m *MockSalesRepository) InsertAnomaly(ctx context.Context, anomaly *models.Anomaly) error {
+	args := m.Called(ctx, anomaly)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) GetAnomalies(ctx context.Context, from, to time.Time) ([]models.Anomaly, error) {
+	args := m.Called(ctx, from, to)
+	anomalies, _ := args.Get(0).([]models.Anomaly)
+	return anomalies, args.Error(1)
+}
+
+func (m *MockSalesRepository) InsertData(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) error {
+	args := m.Called(ctx, carriageReport, receipt)
+	return args.Error(0)
+}
+
+func (m *MockSalesRepository) ImportData(ctx context.Context, carriageReport *models.CarriageReport) error {
 	args := m.Called(ctx, carriageReport)
 	return args.Error(0)
 }
 
+func (m *MockSalesRepository) ReplaceCarriageReport(ctx context.Context, carriageReport *models.CarriageReport, receipt *models.Receipt) (models.ReportDiff, error) {
+	args := m.Called(ctx, carriageReport, receipt)
+	return args.Get(0).(models.ReportDiff), args.Error(1)
+}
+
+func (m *MockSalesRepository) GetReceipt(ctx context.Context, reportID string) (models.Receipt, error) {
+	args := m.Called(ctx, reportID)
+	if receipt, ok := args.Get(0).(models.Receipt); ok {
+		return receipt, args.Error(1)
+	}
+	return models.Receipt{}, args.Error(1)
+}
+
+func (m *MockSalesRepository) GetClockSkew(ctx context.Context, tripID *models.TripID, carriageID int8) (models.ClockSkew, error) {
+	args := m.Called(ctx, tripID, carriageID)
+	if cs, ok := args.Get(0).(models.ClockSkew); ok {
+		return cs, args.Error(1)
+	}
+	return models.ClockSkew{}, args.Error(1)
+}
+
 func (m *MockSalesRepository) GetTrip(ctx context.Context, tripID *models.TripID) (models.Trip, error) {
 	args := m.Called(ctx, tripID)
 	// if the first argument isn't nil and can be asserted to models.Trip, return it
@@ -51,8 +159,8 @@ func (m *MockSalesRepository) GetTrip(ctx context.Context, tripID *models.TripID
 	return models.Trip{}, args.Error(1)
 }
 
-func (m *MockSalesRepository) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string) ([]models.Cart, error) {
-	args := m.Called(ctx, tripID, employeeID)
+func (m *MockSalesRepository) GetEmployeeCartsInTrip(ctx context.Context, tripID *models.TripID, employeeID *string, filter models.CartFilter) ([]models.Cart, error) {
+	args := m.Called(ctx, tripID, employeeID, filter)
 	if args.Get(0) != nil {
 		return args.Get(0).([]models.Cart), args.Error(1)
 	}
@@ -63,22 +171,17 @@ func (m *MockSalesRepository) GetEmployeeCartsInTripPaged(
 	ctx context.Context,
 	tripID *models.TripID,
 	employeeID string,
+	filter models.CartFilter,
 	cartLimit int,
 	cursorB64 string,
-) ([]models.Cart, string, error) {
-	args := m.Called(ctx, tripID, employeeID, cartLimit, cursorB64)
+) (models.CartPage, error) {
+	args := m.Called(ctx, tripID, employeeID, filter, cartLimit, cursorB64)
 
-	var carts []models.Cart
+	var page models.CartPage
 	if v := args.Get(0); v != nil {
-		carts = v.([]models.Cart)
-	}
-
-	var next string
-	if v := args.Get(1); v != nil {
-		next = v.(string)
+		page = v.(models.CartPage)
 	}
-
-	return carts, next, args.Error(2)
+	return page, args.Error(1)
 }
 
 func (m *MockSalesRepository) GetEmployeeIDsByTrip(ctx context.Context, tripID *models.TripID) ([]string, error) {
@@ -107,6 +210,16 @@ func (m *MockSalesRepository) DeleteItemFromCart(ctx context.Context, tripID *mo
 	return args.Error(0)
 }
 
+// receiptTime and receiptOptions make receipts issued by the service deterministic in tests
+var receiptTime = time.Date(2023, 1, 15, 13, 0, 0, 0, time.UTC)
+
+func receiptOptions() []service.Option {
+	return []service.Option{
+		service.WithClock(func() time.Time { return receiptTime }),
+		service.WithReportIDGenerator(func() (string, error) { return "report-1", nil }),
+	}
+}
+
 func TestInsertSalesEndpoint(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -140,11 +253,21 @@ func TestInsertSalesEndpoint(t *testing.T) {
    		  ]
    		}`,
 			mockSetup: func(m *MockSalesRepository) {
-				m.On("InsertData", mock.Anything, mock.AnythingOfType("*models.CarriageReport")).Return(nil)
+				m.On("InsertData", mock.Anything, mock.AnythingOfType("*models.CarriageReport"), mock.AnythingOfType("*models.Receipt")).Return(nil)
+				m.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: schemas.InsertSalesResponse{
 				Message: "Data inserted successfully",
+				Receipt: schemas.Receipt{
+					ReportID:    "report-1",
+					TripID:      schemas.TripID{RouteID: "route_test", Year: "2023", StartTime: "2023-01-15T10:00:01Z"},
+					CarriageID:  10,
+					ContentHash: "sha256:2e244043e93a951d406dd41d18bcd1bad329762785411dd6c7bcb0ed89a0b90e",
+					Carts:       1,
+					Items:       3,
+					ReceivedAt:  "2023-01-15T13:00:00Z",
+				},
 			},
 		},
 		{
@@ -160,118 +283,1141 @@ func TestInsertSalesEndpoint(t *testing.T) {
    		}`, // Missing comma between route_id and start_time
 			mockSetup:      func(m *MockSalesRepository) {},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody: schemas.ErrorResponse{
-				Error: "invalid request body",
-			},
+			expectedBody: schemas.ErrorResponse{
+				Error: "invalid request body",
+			},
+		},
+		{
+			name: "Incorrect quantity",
+			rawJSON: `{
+   		  "trip_id": {
+   		    "route_id": "route_test",
+   		    "start_time": "2023-01-15T10:00:01Z"
+   		  },
+   		  "end_time": "2023-01-15T11:00:01Z",
+   		  "carriage_id": 10,
+   		  "carts": [
+   		    {
+   		      "cart_id": {
+   		        "employee_id": "67890",
+   		        "operation_time": "2023-01-15T12:30:00Z"
+   		      },
+   		      "operation_type": 1,
+   		      "items": [
+   		        {"product_id": 1, "quantity": 0, "price": 100},
+   		        {"product_id": 2, "quantity": 5, "price": 200},
+   		        {"product_id": 3, "quantity": 2, "price": 250}
+   		      ]
+   		    }
+   		  ]
+   		}`, // Missing comma between route_id and start_time
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: schemas.ErrorResponse{
+				Error: "invalid item quantity",
+			},
+		},
+		{
+			name: "Missing RouteID",
+			rawJSON: `{
+   		  "trip_id": {
+   		    "start_time": "2023-01-15T10:00:01Z"
+   		  },
+   		  "end_time": "2023-01-15T11:00:01Z",
+   		  "carriage_id": 10,
+   		  "carts": []
+   		}`, // Missing trip_id
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: schemas.ErrorResponse{
+				Error: "validation failed: Key: 'InsertSalesRequest.TripID.RouteID' Error:Field validation for 'RouteID' failed on the 'required' tag",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Initialize mock repository
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+
+			// Initialize service with mock repository
+			svc := service.NewSalesService(mockRepo, receiptOptions()...)
+
+			// Initialize HTTP handler with the service
+			handler := httphandler.NewHTTPHandler(svc, log.NewNopLogger())
+
+			// Create HTTP request
+			req, err := http.NewRequest("POST", "/api/v1/report/sale", bytes.NewBufferString(tt.rawJSON))
+			assert.NoError(t, err, "Failed to create new request")
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create ResponseRecorder
+			rr := httptest.NewRecorder()
+
+			// Serve the request
+			handler.ServeHTTP(rr, req)
+
+			// Check status code
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+
+			// Decode and check response body
+			body, err := io.ReadAll(rr.Body)
+			assert.NoError(t, err, "Failed to read response body")
+
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), string(body), "Response body does not match")
+
+			// Assert that InsertData was called if expected
+			if tt.expectedStatus == http.StatusOK {
+				mockRepo.AssertCalled(t, "InsertData", mock.Anything, mock.AnythingOfType("*models.CarriageReport"), mock.AnythingOfType("*models.Receipt"))
+			} else {
+				mockRepo.AssertNotCalled(t, "InsertData", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestInsertSalesEndpoint_InvalidRequestType(t *testing.T) {
+	// Create a mock repository and a service based on it.
+	mockRepo := &MockSalesRepository{}
+	svc := service.NewSalesService(mockRepo)
+
+	// Build the InsertSales endpoint.
+	endpoint := httphandler.MakeInsertSalesEndpoint(svc)
+
+	// Call the endpoint with an invalid request type (a string instead of *models.CarriageReport).
+	resp, err := endpoint(context.Background(), "this is not a carriage")
+
+	// Expect a nil response and an error indicating "invalid request type".
+	assert.Nil(t, resp)
+	assert.EqualError(t, err, "invalid request type")
+
+	// Assert that InsertData was never called.
+	mockRepo.AssertNotCalled(t, "InsertData", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestInsertSalesBulkEndpoint(t *testing.T) {
+	line := func(routeID string) string {
+		return `{"trip_id":{"route_id":"` + routeID + `","start_time":"2023-01-15T10:00:01Z"},` +
+			`"end_time":"2023-01-15T11:00:01Z","carriage_id":10,` +
+			`"carts":[{"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},` +
+			`"operation_type":1,"items":[{"product_id":1,"quantity":1,"price":100}]}]}`
+	}
+	// The receipt hash depends on the route, which differs from the single insert test
+	bulkHash := service.ContentHash(&models.CarriageReport{
+		TripID:     models.TripID{RouteID: "route_ok", StartTime: time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)},
+		EndTime:    time.Date(2023, 1, 15, 11, 0, 1, 0, time.UTC),
+		CarriageID: 10,
+		Carts: []models.Cart{{
+			CartID:        models.CartID{EmployeeID: "67890", OperationTime: time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)},
+			OperationType: 1,
+			Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}},
+		}},
+	})
+
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(*MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name: "Mixed accepted and rejected lines",
+			body: line("route_ok") + "\n" +
+				"{not json}\n" +
+				"\n" +
+				line("route_fail") + "\n",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("InsertData", mock.Anything, mock.MatchedBy(func(c *models.CarriageReport) bool {
+					return c.TripID.RouteID == "route_ok"
+				}), mock.AnythingOfType("*models.Receipt")).Return(nil)
+				m.On("InsertData", mock.Anything, mock.MatchedBy(func(c *models.CarriageReport) bool {
+					return c.TripID.RouteID == "route_fail"
+				}), mock.AnythingOfType("*models.Receipt")).Return(errors.New("failed to execute batch: timeout"))
+				m.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.InsertSalesBulkResponse{
+				Accepted: 1,
+				Rejected: 2,
+				Results: []schemas.InsertSalesBulkResult{
+					{Line: 1, Status: "accepted", Receipt: &schemas.Receipt{
+						ReportID:    "report-1",
+						TripID:      schemas.TripID{RouteID: "route_ok", Year: "2023", StartTime: "2023-01-15T10:00:01Z"},
+						CarriageID:  10,
+						ContentHash: bulkHash,
+						Carts:       1,
+						Items:       1,
+						ReceivedAt:  "2023-01-15T13:00:00Z",
+					}},
+					{Line: 2, Status: "rejected", Error: "invalid request body"},
+					// Repository errors are not reported to the client
+					{Line: 4, Status: "rejected", Error: "Internal Server Error"},
+				},
+			},
+		},
+		{
+			name:           "Validation error is reported per line",
+			body:           `{"trip_id":{"start_time":"2023-01-15T10:00:01Z"},"end_time":"2023-01-15T11:00:01Z","carriage_id":10,"carts":[]}`,
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.InsertSalesBulkResponse{
+				Accepted: 0,
+				Rejected: 1,
+				Results: []schemas.InsertSalesBulkResult{
+					{Line: 1, Status: "rejected", Error: "validation failed: Key: 'InsertSalesRequest.TripID.RouteID' Error:Field validation for 'RouteID' failed on the 'required' tag"},
+				},
+			},
+		},
+		{
+			name:           "Empty body",
+			body:           "\n\n",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "empty bulk request"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+
+			svc := service.NewSalesService(mockRepo, receiptOptions()...)
+			handler := httphandler.NewHTTPHandler(svc, log.NewNopLogger())
+
+			req, err := http.NewRequest("POST", "/api/v1/report/sale/bulk", bytes.NewBufferString(tt.body))
+			assert.NoError(t, err, "Failed to create new request")
+			req.Header.Set("Content-Type", "application/x-ndjson")
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+
+			body, err := io.ReadAll(rr.Body)
+			assert.NoError(t, err, "Failed to read response body")
+
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), string(body), "Response body does not match")
+
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestInsertSalesEndpoint_ClockSkew(t *testing.T) {
+	serverNow := time.Date(2023, 1, 15, 13, 0, 0, 0, time.UTC)
+	body := func(deviceTime string) string {
+		return `{"trip_id":{"route_id":"route_test","start_time":"2023-01-15T10:00:01Z"},` +
+			`"end_time":"2023-01-15T11:00:01Z","carriage_id":10,"device_time":"` + deviceTime + `",` +
+			`"carts":[{"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},` +
+			`"operation_type":1,"items":[{"product_id":1,"quantity":1,"price":100}]}]}`
+	}
+	operationTime := time.Date(2023, 1, 15, 12, 30, 0, 0, time.UTC)
+	// Receipts hash the report as sent, before operation times are corrected
+	sentHash := service.ContentHash(&models.CarriageReport{
+		TripID:     models.TripID{RouteID: "route_test", StartTime: time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)},
+		EndTime:    time.Date(2023, 1, 15, 11, 0, 1, 0, time.UTC),
+		CarriageID: 10,
+		Carts: []models.Cart{{
+			CartID:        models.CartID{EmployeeID: "67890", OperationTime: operationTime},
+			OperationType: 1,
+			Items:         []models.Item{{ProductID: 1, Quantity: 1, Price: 100}},
+		}},
+	})
+
+	tests := []struct {
+		name              string
+		deviceTime        string
+		normalize         bool
+		stored            *models.ClockSkew // skew stored by an earlier upload of the report
+		expectedStatus    int
+		expectedBody      string
+		expectedOperation time.Time
+	}{
+		{
+			name:              "Skew within threshold is reported only",
+			deviceTime:        "2023-01-15T12:59:00Z",
+			normalize:         true,
+			expectedStatus:    http.StatusOK,
+			expectedBody:      `{"message":"Data inserted successfully","clock_skew_ms":60000}`,
+			expectedOperation: operationTime,
+		},
+		{
+			name:              "Flagged skew without normalization",
+			deviceTime:        "2023-01-15T12:00:00Z",
+			normalize:         false,
+			expectedStatus:    http.StatusOK,
+			expectedBody:      `{"message":"Data inserted successfully","clock_skew_ms":3600000,"clock_skew_flagged":true}`,
+			expectedOperation: operationTime,
+		},
+		{
+			name:              "Flagged skew is corrected",
+			deviceTime:        "2023-01-15T12:00:00Z",
+			normalize:         true,
+			expectedStatus:    http.StatusOK,
+			expectedBody:      `{"message":"Data inserted successfully","clock_skew_ms":3600000,"clock_skew_flagged":true,"clock_corrected":true}`,
+			expectedOperation: operationTime.Add(time.Hour),
+		},
+		{
+			name:       "Retry reuses the stored skew",
+			deviceTime: "2023-01-15T12:00:05Z",
+			normalize:  true,
+			stored: &models.ClockSkew{
+				DeviceTime: time.Date(2023, 1, 15, 11, 0, 0, 0, time.UTC),
+				ServerTime: time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC),
+				Skew:       time.Hour,
+				Flagged:    true,
+				Corrected:  true,
+			},
+			expectedStatus:    http.StatusOK,
+			expectedBody:      `{"message":"Data inserted successfully","clock_skew_ms":3600000,"clock_skew_flagged":true,"clock_corrected":true}`,
+			expectedOperation: operationTime.Add(time.Hour),
+		},
+		{
+			name:       "Report first stored without normalization stays uncorrected",
+			deviceTime: "2023-01-15T12:00:00Z",
+			normalize:  true,
+			stored: &models.ClockSkew{
+				DeviceTime: time.Date(2023, 1, 15, 11, 0, 0, 0, time.UTC),
+				ServerTime: time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC),
+				Skew:       time.Hour,
+				Flagged:    true,
+			},
+			expectedStatus:    http.StatusOK,
+			expectedBody:      `{"message":"Data inserted successfully","clock_skew_ms":3600000,"clock_skew_flagged":true}`,
+			expectedOperation: operationTime,
+		},
+		{
+			name:           "Invalid device time",
+			deviceTime:     "yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"validation failed: Key: 'InsertSalesRequest.DeviceTime' Error:Field validation for 'DeviceTime' failed on the 'datetime' tag"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			if tt.stored != nil {
+				mockRepo.On("GetClockSkew", mock.Anything, mock.AnythingOfType("*models.TripID"), int8(10)).Return(*tt.stored, nil)
+			} else {
+				mockRepo.On("GetClockSkew", mock.Anything, mock.AnythingOfType("*models.TripID"), int8(10)).Return(nil, models.ErrNotFound)
+			}
+			mockRepo.On("InsertData", mock.Anything, mock.MatchedBy(func(c *models.CarriageReport) bool {
+				cart := c.Carts[0]
+				if !cart.CartID.OperationTime.Equal(tt.expectedOperation) {
+					return false
+				}
+				// The terminal value is kept only when the operation time was corrected
+				return (cart.RawOperationTime == nil) == tt.expectedOperation.Equal(operationTime)
+			}), mock.MatchedBy(func(r *models.Receipt) bool {
+				return r.ContentHash == sentHash
+			})).Return(nil)
+			mockRepo.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
+
+			svc := service.NewSalesService(mockRepo,
+				service.WithClockSkewPolicy(5*time.Minute, tt.normalize),
+				service.WithClock(func() time.Time { return serverNow }),
+			)
+			handler := httphandler.NewHTTPHandler(svc, log.NewNopLogger())
+
+			req, err := http.NewRequest("POST", "/api/v1/report/sale", bytes.NewBufferString(body(tt.deviceTime)))
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+
+			// The receipt is covered by TestInsertSalesEndpoint; only compare the clock skew fields here
+			var got map[string]interface{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			delete(got, "receipt")
+			gotJSON, _ := json.Marshal(got)
+			assert.JSONEq(t, tt.expectedBody, string(gotJSON), "Response body does not match")
+			if tt.expectedStatus == http.StatusOK {
+				mockRepo.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestReplaceSalesEndpoint(t *testing.T) {
+	body := `{"trip_id":{"route_id":"route_test","start_time":"2023-01-15T10:00:01Z"},` +
+		`"end_time":"2023-01-15T11:00:01Z","carriage_id":10,` +
+		`"carts":[{"cart_id":{"employee_id":"67890","operation_time":"2023-01-15T12:30:00Z"},` +
+		`"operation_type":1,"items":[{"product_id":1,"quantity":3,"price":100}]}]}`
+
+	mockRepo := &MockSalesRepository{}
+	mockRepo.On("ReplaceCarriageReport", mock.Anything, mock.AnythingOfType("*models.CarriageReport"), mock.AnythingOfType("*models.Receipt")).
+		Return(models.ReportDiff{Inserted: 1, Updated: 2, Deleted: 3, Unchanged: 4}, nil)
+	mockRepo.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
+
+	handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo, receiptOptions()...), log.NewNopLogger())
+
+	req, err := http.NewRequest("PUT", "/api/v1/report/sale", bytes.NewBufferString(body))
+	assert.NoError(t, err, "Failed to create new request")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Unexpected status code")
+	expected, _ := json.Marshal(schemas.ReplaceSalesResponse{
+		Message: "Carriage report replaced successfully",
+		Receipt: schemas.Receipt{
+			ReportID:    "report-1",
+			TripID:      schemas.TripID{RouteID: "route_test", Year: "2023", StartTime: "2023-01-15T10:00:01Z"},
+			CarriageID:  10,
+			ContentHash: "sha256:b3e5bee35f989de07cd76fcc990bd6700ce5615c99621e945aec4d7ecae937de",
+			Carts:       1,
+			Items:       1,
+			ReceivedAt:  "2023-01-15T13:00:00Z",
+		},
+		Inserted:  1,
+		Updated:   2,
+		Deleted:   3,
+		Unchanged: 4,
+	})
+	assert.JSONEq(t, string(expected), rr.Body.String(), "Response body does not match")
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "InsertData", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetReceiptEndpoint(t *testing.T) {
+	receipt := models.Receipt{
+		ReportID:    "report-1",
+		TripID:      models.TripID{RouteID: "route_test", Year: "2023", StartTime: time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)},
+		CarriageID:  10,
+		ContentHash: "sha256:abc",
+		Carts:       2,
+		Items:       5,
+		ReceivedAt:  receiptTime,
+	}
+
+	tests := []struct {
+		name           string
+		reportID       string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:     "Found",
+			reportID: "report-1",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetReceipt", mock.Anything, "report-1").Return(receipt, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.GetReceiptResponse{Receipt: schemas.Receipt{
+				ReportID:    "report-1",
+				TripID:      schemas.TripID{RouteID: "route_test", Year: "2023", StartTime: "2023-01-15T10:00:01Z"},
+				CarriageID:  10,
+				ContentHash: "sha256:abc",
+				Carts:       2,
+				Items:       5,
+				ReceivedAt:  "2023-01-15T13:00:00Z",
+			}},
+		},
+		{
+			name:     "Not found",
+			reportID: "missing",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetReceipt", mock.Anything, "missing").Return(nil, fmt.Errorf("receipt missing: %w", models.ErrNotFound))
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   schemas.ErrorResponse{Error: "receipt missing: not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/sale/"+tt.reportID, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// TestGetArchivedTripsEndpoint tests the GET /api/v1/report/trip/archived endpoint.
+func TestGetArchivedTripsEndpoint(t *testing.T) {
+	archived := models.ArchivedTrip{
+		TripID:      models.TripID{RouteID: "route_test", Year: "2021", StartTime: time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC)},
+		ArchiveFile: "route_test_2021_20240101T030000Z.jsonl.gz",
+		Reports:     3,
+		Operations:  42,
+		ArchivedAt:  time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC),
+	}
+	expected := schemas.ArchivedTrip{
+		TripID:      schemas.TripID{RouteID: "route_test", Year: "2021", StartTime: "2021-05-01T08:00:00Z"},
+		ArchiveFile: "route_test_2021_20240101T030000Z.jsonl.gz",
+		Reports:     3,
+		Operations:  42,
+		ArchivedAt:  "2024-01-01T03:00:00Z",
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:  "By route",
+			query: "?route_id=route_test",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetArchivedTrips", mock.Anything, "route_test").Return([]models.ArchivedTrip{archived}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetArchivedTripsResponse{ArchivedTrips: []schemas.ArchivedTrip{expected}},
+		},
+		{
+			name:  "All routes, empty catalog",
+			query: "",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetArchivedTrips", mock.Anything, "").Return(nil, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetArchivedTripsResponse{ArchivedTrips: []schemas.ArchivedTrip{}},
+		},
+		{
+			name:  "Repository error",
+			query: "?route_id=route_test",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetArchivedTrips", mock.Anything, "route_test").Return(nil, errors.New("database error"))
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "database error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/trip/archived"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetTripRevenueEndpoint(t *testing.T) {
+	start := time.Date(2023, 1, 15, 10, 0, 1, 0, time.UTC)
+	tripID := &models.TripID{RouteID: "route_test", Year: "2023", StartTime: start}
+	revenue := models.TripRevenue{
+		TripID:    *tripID,
+		Revenue:   models.Revenue{Sales: 3, Refunds: 1, ItemsSold: 5, ItemsRefunded: 1, SalesTotal: 1500, RefundsTotal: 200, NetTotal: 1300},
+		UpdatedAt: time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:  "Found",
+			query: "?route_id=route_test&year=2023&start_time=2023-01-15T10:00:01Z",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetTripRevenue", mock.Anything, tripID).Return(revenue, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.GetTripRevenueResponse{
+				TripID:    schemas.TripID{RouteID: "route_test", Year: "2023", StartTime: "2023-01-15T10:00:01Z"},
+				Revenue:   schemas.Revenue{Sales: 3, Refunds: 1, ItemsSold: 5, ItemsRefunded: 1, SalesTotal: 1500, RefundsTotal: 200, NetTotal: 1300},
+				UpdatedAt: "2023-01-15T12:00:00Z",
+			},
+		},
+		{
+			name:  "No aggregate",
+			query: "?route_id=route_test&year=2023&start_time=2023-01-15T10:00:01Z",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetTripRevenue", mock.Anything, tripID).Return(models.TripRevenue{}, fmt.Errorf("revenue of trip: %w", models.ErrNotFound))
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   schemas.ErrorResponse{Error: "revenue of trip: not found"},
+		},
+		{
+			name:           "Missing start time",
+			query:          "?route_id=route_test&year=2023",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "missing required query parameters: route_id, year or start_time"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/trip/revenue"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetDailyRevenueEndpoint(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC)
+	productID := 42
+	days := []models.DailyRevenue{
+		{Day: "2024-03-02", Revenue: models.Revenue{Sales: 2, ItemsSold: 4, SalesTotal: 800, NetTotal: 800}},
+	}
+	expectedDays := schemas.GetDailyRevenueResponse{Days: []schemas.DailyRevenue{
+		{Day: "2024-03-02", Revenue: schemas.Revenue{Sales: 2, ItemsSold: 4, SalesTotal: 800, NetTotal: 800}},
+	}}
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:  "By route",
+			query: "?route_id=route_test&from=2024-03-01&to=2024-03-07",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetDailyRevenue", mock.Anything, models.RevenueScope{RouteID: "route_test"}, from, to).Return(days, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   expectedDays,
+		},
+		{
+			name:  "By product, no operations",
+			query: "?product_id=42&from=2024-03-01&to=2024-03-07",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetDailyRevenue", mock.Anything, models.RevenueScope{ProductID: &productID}, from, to).Return([]models.DailyRevenue(nil), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetDailyRevenueResponse{Days: []schemas.DailyRevenue{}},
+		},
+		{
+			name:           "Two scopes",
+			query:          "?route_id=route_test&employee_id=emp1&from=2024-03-01&to=2024-03-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "exactly one of route_id, employee_id or product_id is required"},
+		},
+		{
+			name:           "Invalid date",
+			query:          "?employee_id=emp1&from=01.03.2024&to=2024-03-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid from or to format; must be YYYY-MM-DD"},
+		},
+		{
+			name:           "Range too long",
+			query:          "?employee_id=emp1&from=2023-01-01&to=2024-03-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "revenue range of 432 days is longer than 366 days"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/revenue/daily"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSearchOperationsEndpoint(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tripID := models.TripID{RouteID: "route_test", Year: "2024", StartTime: day.Add(8 * time.Hour)}
+	opTime := day.Add(9 * time.Hour)
+	trip := models.Trip{Carriage: []models.CarriageReport{{
+		TripID:     tripID,
+		CarriageID: 3,
+		Carts: []models.Cart{
+			{CartID: models.CartID{EmployeeID: "emp1", OperationTime: opTime}, OperationType: models.OperationTypeRefund,
+				Items: []models.Item{{ProductID: 42, Quantity: 2, Price: 150}, {ProductID: 7, Quantity: 1, Price: 30}}},
+			{CartID: models.CartID{EmployeeID: "emp2", OperationTime: opTime}, OperationType: models.OperationTypeSale,
+				Items: []models.Item{{ProductID: 42, Quantity: 1, Price: 150}}},
+		},
+	}}}
+	withTrip := func(m *MockSalesRepository) {
+		m.On("GetRouteTrips", mock.Anything, "route_test", day, day.AddDate(0, 0, 7)).Return([]models.TripID{tripID}, nil)
+		m.On("GetTrip", mock.Anything, &tripID).Return(trip, nil)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:           "Refunds of a product on a route",
+			query:          "?route_id=route_test&from=2024-03-01&to=2024-03-07&operation_type=2&product_id=42&min_amount=300",
+			mockSetup:      withTrip,
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.SearchOperationsResponse{Operations: []schemas.Operation{{
+				TripID:        schemas.TripID{RouteID: "route_test", Year: "2024", StartTime: "2024-03-01T08:00:00Z"},
+				CarriageID:    3,
+				CartID:        schemas.CartID{EmployeeID: "emp1", OperationTime: "2024-03-01T09:00:00Z"},
+				OperationType: models.OperationTypeRefund,
+				ProductID:     42,
+				Quantity:      2,
+				Price:         150,
+				Amount:        300,
+			}}},
+		},
+		{
+			name:  "Every route, nothing found",
+			query: "?from=2024-03-01&to=2024-03-07&employee_id=emp3",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetRoutes", mock.Anything).Return([]string{"route_test"}, nil)
+				withTrip(m)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.SearchOperationsResponse{Operations: []schemas.Operation{}},
+		},
+		{
+			name:           "Missing range",
+			query:          "?route_id=route_test&from=2024-03-01",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "missing required query parameters: from or to"},
+		},
+		{
+			name:           "Invalid operation type",
+			query:          "?from=2024-03-01&to=2024-03-07&operation_type=3",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid operation_type (must be 1 for sales or 2 for refunds)"},
+		},
+		{
+			name:           "Invalid amount",
+			query:          "?from=2024-03-01&to=2024-03-07&max_amount=1.5",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid max_amount (must be an integer number of kopecks)"},
+		},
+		{
+			name:           "Range too long",
+			query:          "?from=2024-03-01&to=2024-04-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "search range of 38 days is longer than 31 days"},
+		},
+		{
+			name:           "Forged cursor",
+			query:          "?from=2024-03-01&to=2024-03-07&cursor=eyJyIjoiYSJ9",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: malformed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/operation/search"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetEmployeeAnalyticsEndpoint(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tripID := models.TripID{RouteID: "route_test", Year: "2024", StartTime: day.Add(8 * time.Hour)}
+	opTime := day.Add(9 * time.Hour)
+	trip := models.Trip{Carriage: []models.CarriageReport{{
+		TripID:     tripID,
+		CarriageID: 3,
+		Carts: []models.Cart{
+			{CartID: models.CartID{EmployeeID: "emp1", OperationTime: opTime}, OperationType: models.OperationTypeSale,
+				Items: []models.Item{{ProductID: 42, Quantity: 2, Price: 150}, {ProductID: 7, Quantity: 1, Price: 30}}},
+			{CartID: models.CartID{EmployeeID: "emp1", OperationTime: opTime.Add(time.Minute)}, OperationType: models.OperationTypeRefund,
+				Items: []models.Item{{ProductID: 7, Quantity: 1, Price: 30}}},
+			{CartID: models.CartID{EmployeeID: "emp2", OperationTime: opTime}, OperationType: models.OperationTypeSale,
+				Items: []models.Item{{ProductID: 42, Quantity: 4, Price: 150}}},
+		},
+	}}}
+	emp1 := schemas.EmployeeStats{
+		EmployeeID: "emp1",
+		Rank:       2,
+		Trips:      1,
+		Carts:      2,
+		Revenue: schemas.Revenue{Sales: 1, Refunds: 1, ItemsSold: 3, ItemsRefunded: 1,
+			SalesTotal: 330, RefundsTotal: 30, NetTotal: 300},
+		RefundRatio: 30.0 / 330,
+		AverageCart: 330,
+		TopProducts: []schemas.ProductStats{{ProductID: 42, Quantity: 2, NetTotal: 300}, {ProductID: 7, Quantity: 0, NetTotal: 0}},
+	}
+	emp2 := schemas.EmployeeStats{
+		EmployeeID:  "emp2",
+		Rank:        1,
+		Trips:       1,
+		Carts:       1,
+		Revenue:     schemas.Revenue{Sales: 1, ItemsSold: 4, SalesTotal: 600, NetTotal: 600},
+		AverageCart: 600,
+		TopProducts: []schemas.ProductStats{{ProductID: 42, Quantity: 4, NetTotal: 600}},
+	}
+	withTrip := func(m *MockSalesRepository) {
+		m.On("GetRouteTrips", mock.Anything, "route_test", day, day.AddDate(0, 0, 7)).Return([]models.TripID{tripID}, nil)
+		m.On("GetTrip", mock.Anything, &tripID).Return(trip, nil)
+	}
+	unranked := emp1
+	unranked.Rank = 0
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:           "Route ranking",
+			query:          "?route_id=route_test&from=2024-03-01&to=2024-03-07",
+			mockSetup:      withTrip,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetEmployeeAnalyticsResponse{Employees: []schemas.EmployeeStats{emp2, emp1}},
+		},
+		{
+			name:           "Employee of a route keeps the rank",
+			query:          "?route_id=route_test&employee_id=emp1&from=2024-03-01&to=2024-03-07",
+			mockSetup:      withTrip,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetEmployeeAnalyticsResponse{Employees: []schemas.EmployeeStats{emp1}},
+		},
+		{
+			name:  "Employee on every route",
+			query: "?employee_id=emp1&from=2024-03-01&to=2024-03-07",
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetEmployeeTrips", mock.Anything, "emp1", "2024").Return([]models.EmployeeTrip{
+					{EmployeeID: "emp1", TripID: tripID},
+					{EmployeeID: "emp1", TripID: models.TripID{RouteID: "route_test", Year: "2024", StartTime: day.AddDate(0, 0, 7)}},
+				}, nil)
+				m.On("GetTrip", mock.Anything, &tripID).Return(trip, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetEmployeeAnalyticsResponse{Employees: []schemas.EmployeeStats{unranked}},
+		},
+		{
+			name:           "Missing route and employee",
+			query:          "?from=2024-03-01&to=2024-03-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "route_id or employee_id is required"},
+		},
+		{
+			name:           "Invalid date",
+			query:          "?route_id=route_test&from=2024-03-01&to=03/07/2024",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid from or to format; must be YYYY-MM-DD"},
+		},
+		{
+			name:           "Range too long",
+			query:          "?route_id=route_test&from=2024-01-01&to=2024-06-30",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "analytics range of 182 days is longer than 93 days"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+			req, err := http.NewRequest("GET", "/api/v1/report/analytics/employee"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetProductConsumptionEndpoint(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tripID := models.TripID{RouteID: "route_test", Year: "2024", StartTime: day.Add(8 * time.Hour)}
+	opTime := day.Add(9 * time.Hour)
+	trip := models.Trip{Carriage: []models.CarriageReport{
+		{
+			TripID:     tripID,
+			CarriageID: 3,
+			Carts: []models.Cart{
+				{CartID: models.CartID{EmployeeID: "emp1", OperationTime: opTime}, OperationType: models.OperationTypeSale,
+					Items: []models.Item{{ProductID: 42, Quantity: 2, Price: 150}, {ProductID: 7, Quantity: 5, Price: 30}}},
+				{CartID: models.CartID{EmployeeID: "emp1", OperationTime: opTime.Add(time.Minute)}, OperationType: models.OperationTypeRefund,
+					Items: []models.Item{{ProductID: 7, Quantity: 1, Price: 30}}},
+			},
+		},
+		{
+			TripID:     tripID,
+			CarriageID: 4,
+			Carts: []models.Cart{
+				{CartID: models.CartID{EmployeeID: "emp2", OperationTime: opTime}, OperationType: models.OperationTypeSale,
+					Items: []models.Item{{ProductID: 42, Quantity: 4, Price: 150}}},
+			},
+		},
+	}}
+	withTrip := func(m *MockSalesRepository) {
+		m.On("GetRouteTrips", mock.Anything, "route_test", day, day.AddDate(0, 0, 7)).Return([]models.TripID{tripID}, nil)
+		m.On("GetTrip", mock.Anything, &tripID).Return(trip, nil)
+	}
+	carriage := func(id int8) *int8 { return &id }
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:           "Per day",
+			query:          "?route_id=route_test&from=2024-03-01&to=2024-03-07",
+			mockSetup:      withTrip,
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.GetProductConsumptionResponse{RouteID: "route_test", From: "2024-03-01", To: "2024-03-07",
+				Products: []schemas.ProductConsumption{
+					{Day: "2024-03-01", ProductID: 42, Rank: 1, Sold: 6, Quantity: 6, NetTotal: 900},
+					{Day: "2024-03-01", ProductID: 7, Rank: 2, Sold: 5, Refunded: 1, Quantity: 4, NetTotal: 120},
+				}},
+		},
+		{
+			name:           "Per carriage",
+			query:          "?route_id=route_test&from=2024-03-01&to=2024-03-07&by_carriage=true",
+			mockSetup:      withTrip,
+			expectedStatus: http.StatusOK,
+			expectedBody: schemas.GetProductConsumptionResponse{RouteID: "route_test", From: "2024-03-01", To: "2024-03-07",
+				Products: []schemas.ProductConsumption{
+					{Day: "2024-03-01", CarriageID: carriage(3), ProductID: 7, Rank: 1, Sold: 5, Refunded: 1, Quantity: 4, NetTotal: 120},
+					{Day: "2024-03-01", CarriageID: carriage(3), ProductID: 42, Rank: 2, Sold: 2, Quantity: 2, NetTotal: 300},
+					{Day: "2024-03-01", CarriageID: carriage(4), ProductID: 42, Rank: 1, Sold: 4, Quantity: 4, NetTotal: 600},
+				}},
+		},
+		{
+			name:           "Missing route",
+			query:          "?from=2024-03-01&to=2024-03-07",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "missing required query parameters: route_id, from or to"},
 		},
 		{
-			name: "Incorrect quantity",
-			rawJSON: `{
-   		  "trip_id": {
-   		    "route_id": "route_test",
-   		    "start_time": "2023-01-15T10:00:01Z"
-   		  },
-   		  "end_time": "2023-01-15T11:00:01Z",
-   		  "carriage_id": 10,
-   		  "carts": [
-   		    {
-   		      "cart_id": {
-   		        "employee_id": "67890",
-   		        "operation_time": "2023-01-15T12:30:00Z"
-   		      },
-   		      "operation_type": 1,
-   		      "items": [
-   		        {"product_id": 1, "quantity": 0, "price": 100},
-   		        {"product_id": 2, "quantity": 5, "price": 200},
-   		        {"product_id": 3, "quantity": 2, "price": 250}
-   		      ]
-   		    }
-   		  ]
-   		}`, // Missing comma between route_id and start_time
+			name:           "Invalid format",
+			query:          "?route_id=route_test&from=2024-03-01&to=2024-03-07&format=xlsx",
 			mockSetup:      func(m *MockSalesRepository) {},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody: schemas.ErrorResponse{
-				Error: "invalid item quantity",
-			},
+			expectedBody:   schemas.ErrorResponse{Error: "invalid format (must be json or csv)"},
 		},
 		{
-			name: "Missing RouteID",
-			rawJSON: `{
-   		  "trip_id": {
-   		    "start_time": "2023-01-15T10:00:01Z"
-   		  },
-   		  "end_time": "2023-01-15T11:00:01Z",
-   		  "carriage_id": 10,
-   		  "carts": []
-   		}`, // Missing trip_id
+			name:           "Range too long",
+			query:          "?route_id=route_test&from=2024-01-01&to=2024-06-30",
 			mockSetup:      func(m *MockSalesRepository) {},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody: schemas.ErrorResponse{
-				Error: "validation failed: Key: 'InsertSalesRequest.TripID.RouteID' Error:Field validation for 'RouteID' failed on the 'required' tag",
-			},
+			expectedBody:   schemas.ErrorResponse{Error: "consumption range of 182 days is longer than 93 days"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Initialize mock repository
 			mockRepo := &MockSalesRepository{}
 			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
 
-			// Initialize service with mock repository
-			svc := service.NewSalesService(mockRepo)
-
-			// Initialize HTTP handler with the service
-			handler := httphandler.NewHTTPHandler(svc, log.NewNopLogger())
-
-			// Create HTTP request
-			req, err := http.NewRequest("POST", "/api/v1/report/sale", bytes.NewBufferString(tt.rawJSON))
+			req, err := http.NewRequest("GET", "/api/v1/report/analytics/product"+tt.query, nil)
 			assert.NoError(t, err, "Failed to create new request")
-			req.Header.Set("Content-Type", "application/json")
-
-			// Create ResponseRecorder
 			rr := httptest.NewRecorder()
-
-			// Serve the request
 			handler.ServeHTTP(rr, req)
 
-			// Check status code
 			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
-
-			// Decode and check response body
-			body, err := io.ReadAll(rr.Body)
-			assert.NoError(t, err, "Failed to read response body")
-
 			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
-			assert.JSONEq(t, string(expectedBodyJSON), string(body), "Response body does not match")
-
-			// Assert that InsertData was called if expected
-			if tt.expectedStatus == http.StatusOK {
-				mockRepo.AssertCalled(t, "InsertData", mock.Anything, mock.AnythingOfType("*models.CarriageReport"))
-			} else {
-				mockRepo.AssertNotCalled(t, "InsertData", mock.Anything, mock.Anything)
-			}
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
 		})
 	}
+
+	t.Run("CSV", func(t *testing.T) {
+		mockRepo := &MockSalesRepository{}
+		withTrip(mockRepo)
+		handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
+
+		req, err := http.NewRequest("GET", "/api/v1/report/analytics/product?route_id=route_test&from=2024-03-01&to=2024-03-07&by_carriage=true&format=csv", nil)
+		assert.NoError(t, err, "Failed to create new request")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code, "Unexpected status code")
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Equal(t, "attachment; filename=consumption_route_test_2024-03-01_2024-03-07.csv", rr.Header().Get("Content-Disposition"))
+		assert.Equal(t, "day,carriage_id,product_id,rank,sold,refunded,quantity,net_total\n"+
+			"2024-03-01,3,7,1,5,1,4,120\n"+
+			"2024-03-01,3,42,2,2,0,2,300\n"+
+			"2024-03-01,4,42,1,4,0,4,600\n", rr.Body.String())
+		mockRepo.AssertExpectations(t)
+	})
 }
 
-func TestInsertSalesEndpoint_InvalidRequestType(t *testing.T) {
-	// Create a mock repository and a service based on it.
-	mockRepo := &MockSalesRepository{}
-	svc := service.NewSalesService(mockRepo)
+func TestGetAnomaliesEndpoint(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC)
+	start := from.Add(8 * time.Hour)
+	detectedAt := time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC)
+	stored := []models.Anomaly{
+		{ID: "route_test|1709280000000|emp1|1709283600000", Day: "2024-03-01", Kind: models.AnomalyOutlierCart,
+			TripID: models.TripID{RouteID: "route_test", Year: "2024", StartTime: start}, EmployeeID: "emp1",
+			OperationTime: start.Add(time.Hour), Value: 12.5, Threshold: 10, DetectedAt: detectedAt},
+		{ID: "emp2", Day: "2024-03-02", Kind: models.AnomalyCorrections, EmployeeID: "emp2", Value: 11, Threshold: 10, DetectedAt: detectedAt},
+	}
+	outlier := schemas.Anomaly{
+		ID:            "route_test|1709280000000|emp1|1709283600000",
+		Day:           "2024-03-01",
+		Kind:          "outlier_cart",
+		TripID:        &schemas.TripID{RouteID: "route_test", Year: "2024", StartTime: "2024-03-01T08:00:00Z"},
+		EmployeeID:    "emp1",
+		OperationTime: "2024-03-01T09:00:00Z",
+		Value:         12.5,
+		Threshold:     10,
+		DetectedAt:    "2024-03-02T06:00:00Z",
+	}
+	corrections := schemas.Anomaly{ID: "emp2", Day: "2024-03-02", Kind: "corrections", EmployeeID: "emp2", Value: 11, Threshold: 10,
+		DetectedAt: "2024-03-02T06:00:00Z"}
+	withStored := func(m *MockSalesRepository) {
+		m.On("GetAnomalies", mock.Anything, from, to).Return(stored, nil)
+	}
 
-	// Build the InsertSales endpoint.
-	endpoint := httphandler.MakeInsertSalesEndpoint(svc)
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(m *MockSalesRepository)
+		expectedStatus int
+		expectedBody   interface{}
+	}{
+		{
+			name:           "Every anomaly",
+			query:          "?from=2024-03-01&to=2024-03-07",
+			mockSetup:      withStored,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetAnomaliesResponse{Anomalies: []schemas.Anomaly{outlier, corrections}},
+		},
+		{
+			name:           "Kind",
+			query:          "?from=2024-03-01&to=2024-03-07&kind=corrections",
+			mockSetup:      withStored,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetAnomaliesResponse{Anomalies: []schemas.Anomaly{corrections}},
+		},
+		{
+			name:           "Route",
+			query:          "?from=2024-03-01&to=2024-03-07&route_id=route_test",
+			mockSetup:      withStored,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetAnomaliesResponse{Anomalies: []schemas.Anomaly{outlier}},
+		},
+		{
+			name:           "Employee without anomalies",
+			query:          "?from=2024-03-01&to=2024-03-07&employee_id=emp3",
+			mockSetup:      withStored,
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetAnomaliesResponse{Anomalies: []schemas.Anomaly{}},
+		},
+		{
+			name:           "Invalid kind",
+			query:          "?from=2024-03-01&to=2024-03-07&kind=fraud",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid kind (must be refund_ratio, outlier_cart, outside_trip or corrections)"},
+		},
+		{
+			name:           "Reversed range",
+			query:          "?from=2024-03-07&to=2024-03-01",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "anomaly range ends before it starts"},
+		},
+		{
+			name:           "Range too long",
+			query:          "?from=2024-03-01&to=2024-04-01",
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "anomaly range of 32 days is longer than 31 days"},
+		},
+	}
 
-	// Call the endpoint with an invalid request type (a string instead of *models.CarriageReport).
-	resp, err := endpoint(context.Background(), "this is not a carriage")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockSalesRepository{}
+			tt.mockSetup(mockRepo)
+			handler := httphandler.NewHTTPHandler(service.NewSalesService(mockRepo), log.NewNopLogger())
 
-	// Expect a nil response and an error indicating "invalid request type".
-	assert.Nil(t, resp)
-	assert.EqualError(t, err, "invalid request type")
+			req, err := http.NewRequest("GET", "/api/v1/report/anomaly"+tt.query, nil)
+			assert.NoError(t, err, "Failed to create new request")
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
 
-	// Assert that InsertData was never called.
-	mockRepo.AssertNotCalled(t, "InsertData", mock.Anything, mock.Anything)
+			assert.Equal(t, tt.expectedStatus, rr.Code, "Unexpected status code")
+			expectedBodyJSON, _ := json.Marshal(tt.expectedBody)
+			assert.JSONEq(t, string(expectedBodyJSON), rr.Body.String(), "Response body does not match")
+			mockRepo.AssertExpectations(t)
+		})
+	}
 }
 
 // TestGetEmployeeCartsInTripEndpoint tests the GET /api/v1/report/sale/trip/cart/employee endpoint.
@@ -282,6 +1428,7 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 		mockSetup      func(m *MockSalesRepository)
 		expectedStatus int
 		expectedBody   interface{}
+		rejected       bool // the order or window is invalid
 	}{
 		{
 			name: "Successful Get",
@@ -303,7 +1450,7 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 						{ProductID: 2, Quantity: 5, Price: 200},
 					},
 				}
-				m.On("GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string")).
+				m.On("GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string"), models.CartFilter{Order: models.SortDesc}).
 					Return([]models.Cart{sampleCart}, nil)
 			},
 			expectedStatus: http.StatusOK,
@@ -323,6 +1470,31 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Ascending Window",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2023",
+				"start_time":  "2023-01-15T10:00:01Z",
+				"employee_id": "emp1",
+				"order":       "asc",
+				"from":        "2023-01-15T11:00:00Z",
+				"to":          "2023-01-15T13:00:00+01:00",
+			},
+			mockSetup: func(m *MockSalesRepository) {
+				filter := models.CartFilter{
+					Order: models.SortAsc,
+					From:  time.Date(2023, 1, 15, 11, 0, 0, 0, time.UTC),
+					To:    time.Date(2023, 1, 15, 12, 0, 0, 0, time.UTC),
+				}
+				m.On("GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string"),
+					mock.MatchedBy(func(f models.CartFilter) bool {
+						return f.Order == filter.Order && f.From.Equal(filter.From) && f.To.Equal(filter.To)
+					})).Return([]models.Cart{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetEmployeeCartsInTripResponse{},
+		},
 		{
 			name: "Missing Query Parameters",
 			queryParams: map[string]string{
@@ -351,6 +1523,55 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 				Error: "invalid start_time format; must be RFC3339",
 			},
 		},
+		{
+			name: "Invalid Order",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2023",
+				"start_time":  "2023-01-15T10:00:01Z",
+				"employee_id": "emp1",
+				"order":       "newest",
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: schemas.ErrorResponse{
+				Error: "invalid order (must be asc or desc)",
+			},
+			rejected: true,
+		},
+		{
+			name: "Invalid Window Format",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2023",
+				"start_time":  "2023-01-15T10:00:01Z",
+				"employee_id": "emp1",
+				"from":        "2023-01-15",
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: schemas.ErrorResponse{
+				Error: "invalid from or to format; must be RFC3339",
+			},
+			rejected: true,
+		},
+		{
+			name: "Empty Window",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2023",
+				"start_time":  "2023-01-15T10:00:01Z",
+				"employee_id": "emp1",
+				"from":        "2023-01-15T13:00:00Z",
+				"to":          "2023-01-15T12:00:00Z",
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: schemas.ErrorResponse{
+				Error: "invalid window; from must be before to",
+			},
+			rejected: true,
+		},
 		{
 			name: "Repository Error",
 			queryParams: map[string]string{
@@ -360,7 +1581,7 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 				"employee_id": "emp1",
 			},
 			mockSetup: func(m *MockSalesRepository) {
-				m.On("GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string")).
+				m.On("GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string"), models.CartFilter{Order: models.SortDesc}).
 					Return(nil, errors.New("database error"))
 			},
 			// Even though repository returns an error, the endpoint still calls the repository because the query is valid.
@@ -417,10 +1638,10 @@ func TestGetEmployeeCartsInTripEndpoint(t *testing.T) {
 			startTimeStr, hasStartTime := tt.queryParams["start_time"]
 			_, validStartTime := time.Parse(time.RFC3339, startTimeStr)
 
-			if hasEmployeeID && employeeID != "" && hasStartTime && validStartTime == nil {
-				mockRepo.AssertCalled(t, "GetEmployeeCartsInTrip", mock.Anything, mock.AnythingOfType("*models.TripID"), mock.AnythingOfType("*string"))
+			if hasEmployeeID && employeeID != "" && hasStartTime && validStartTime == nil && !tt.rejected {
+				mockRepo.AssertExpectations(t)
 			} else {
-				mockRepo.AssertNotCalled(t, "GetEmployeeCartsInTrip", mock.Anything, mock.Anything, mock.Anything)
+				mockRepo.AssertNotCalled(t, "GetEmployeeCartsInTrip", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 			}
 		})
 	}
@@ -442,7 +1663,7 @@ func TestGetEmployeeCartsInTripEndpoint_InvalidRequestType(t *testing.T) {
 	assert.EqualError(t, err, "invalid request type")
 
 	// Since the type assertion fails, the service method should never be called.
-	mockRepo.AssertNotCalled(t, "GetEmployeeCartsInTrip", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "GetEmployeeCartsInTrip", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
@@ -460,6 +1681,20 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 	firstOp := time.Date(2025, 8, 20, 10, 0, 0, 0, time.UTC)
 	secondOp := time.Date(2025, 8, 20, 9, 0, 0, 0, time.UTC)
 
+	// Cursors are signed by the service and bound to the trip, employee, limit and filter
+	now := time.Date(2025, 8, 21, 12, 0, 0, 0, time.UTC)
+	signer := service.NewCursorSigner([]byte("paging-test-secret"), time.Hour)
+	cursorQuery := func(employeeID string, limit int) service.CursorQuery {
+		return service.CursorQuery{
+			TripID:     models.TripID{RouteID: "route_test", Year: "2025", StartTime: time.Date(2025, 8, 20, 8, 0, 0, 0, time.UTC)},
+			EmployeeID: employeeID,
+			Limit:      limit,
+			Filter:     models.CartFilter{Order: models.SortDesc},
+		}
+	}
+	nextCursor := signer.Seal("CURSOR_NEXT", cursorQuery("emp1", 2), now)
+	prevCursor := signer.Seal("CURSOR_PREV", cursorQuery("emp1", 2), now)
+
 	tests := []struct {
 		name           string
 		queryParams    map[string]string
@@ -489,9 +1724,10 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 					mock.Anything,
 					mock.AnythingOfType("*models.TripID"),
 					"emp1",
+					models.CartFilter{Order: models.SortDesc},
 					2,
 					"",
-				).Return([]models.Cart{c1, c2}, "CURSOR_NEXT", nil)
+				).Return(models.CartPage{Carts: []models.Cart{c1, c2}, NextCursor: "CURSOR_NEXT"}, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: schemas.GetEmployeeCartsInTripPagedResponse{
@@ -518,7 +1754,7 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 						},
 					},
 				},
-				NextCursor: "CURSOR_NEXT",
+				NextCursor: nextCursor,
 			},
 		},
 		{
@@ -529,7 +1765,7 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 				"start_time":  "2025-08-20T08:00:00Z",
 				"employee_id": "emp1",
 				"limit":       "2",
-				"cursor":      "CURSOR_NEXT",
+				"cursor":      nextCursor,
 			},
 			mockSetup: func(m *MockSalesRepository) {
 				// Return the last page (single cart) and empty cursor
@@ -541,9 +1777,10 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 					mock.Anything,
 					mock.AnythingOfType("*models.TripID"),
 					"emp1",
+					models.CartFilter{Order: models.SortDesc},
 					2,
 					"CURSOR_NEXT",
-				).Return([]models.Cart{c3}, "", nil)
+				).Return(models.CartPage{Carts: []models.Cart{c3}, PrevCursor: "CURSOR_PREV"}, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: schemas.GetEmployeeCartsInTripPagedResponse{
@@ -560,7 +1797,103 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 					},
 				},
 				NextCursor: "",
+				PrevCursor: prevCursor,
+			},
+		},
+		{
+			name: "Ascending window",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp1",
+				"limit":       "2",
+				"order":       "asc",
+				"from":        "2025-08-20T09:00:00Z",
+			},
+			mockSetup: func(m *MockSalesRepository) {
+				m.On("GetEmployeeCartsInTripPaged",
+					mock.Anything,
+					mock.AnythingOfType("*models.TripID"),
+					"emp1",
+					models.CartFilter{Order: models.SortAsc, From: secondOp},
+					2,
+					"",
+				).Return(models.CartPage{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   schemas.GetEmployeeCartsInTripPagedResponse{},
+		},
+		{
+			name: "Cursor of another order",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp1",
+				"limit":       "2",
+				"order":       "asc",
+				"cursor":      nextCursor,
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: issued for another trip, employee, limit or filter"},
+		},
+		{
+			name: "Forged cursor",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp1",
+				"limit":       "2",
+				"cursor":      "eyJ0IjoiMjAyNS0wOC0yMFQxMDowMDowMFoifQ==",
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: malformed"},
+		},
+		{
+			name: "Tampered cursor",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp1",
+				"limit":       "2",
+				"cursor":      nextCursor[:len(nextCursor)-2] + "AA",
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: bad signature"},
+		},
+		{
+			name: "Cursor of another employee",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp2",
+				"limit":       "2",
+				"cursor":      nextCursor,
+			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: issued for another trip, employee, limit or filter"},
+		},
+		{
+			name: "Expired cursor",
+			queryParams: map[string]string{
+				"route_id":    "route_test",
+				"year":        "2025",
+				"start_time":  "2025-08-20T08:00:00Z",
+				"employee_id": "emp1",
+				"limit":       "2",
+				"cursor":      signer.Seal("CURSOR_NEXT", cursorQuery("emp1", 2), now.Add(-2*time.Hour)),
 			},
+			mockSetup:      func(m *MockSalesRepository) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   schemas.ErrorResponse{Error: "invalid cursor: expired"},
 		},
 		{
 			name: "Missing required params",
@@ -621,9 +1954,10 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 					mock.Anything,
 					mock.AnythingOfType("*models.TripID"),
 					"emp1",
+					models.CartFilter{Order: models.SortDesc},
 					2,
 					"",
-				).Return(nil, "", errors.New("database error"))
+				).Return(nil, errors.New("database error"))
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: schemas.ErrorResponse{
@@ -637,7 +1971,10 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 			mockRepo := &MockSalesRepository{}
 			tt.mockSetup(mockRepo)
 
-			svc := service.NewSalesService(mockRepo)
+			svc := service.NewSalesService(mockRepo,
+				service.WithClock(func() time.Time { return now }),
+				service.WithCursorSigner(signer),
+			)
 			handler := httphandler.NewHTTPHandler(svc, log.NewNopLogger())
 
 			req, err := http.NewRequest("GET", "/api/v1/report/trip/cart/employee/paged", nil)
@@ -675,12 +2012,16 @@ func TestGetEmployeeCartsInTripPagedEndpoint(t *testing.T) {
 				}
 			}
 
-			if hasRoute && routeID != "" && hasYear && year != "" && hasStart && startErr == nil && hasEmp && emp != "" && limitOK {
+			// Rejected cursors never reach the repository
+			errBody, isErr := tt.expectedBody.(schemas.ErrorResponse)
+			cursorRejected := isErr && strings.HasPrefix(errBody.Error, models.ErrInvalidCursor.Error())
+
+			if hasRoute && routeID != "" && hasYear && year != "" && hasStart && startErr == nil && hasEmp && emp != "" && limitOK && !cursorRejected {
 				mockRepo.AssertCalled(t, "GetEmployeeCartsInTripPaged",
-					mock.Anything, mock.AnythingOfType("*models.TripID"), emp, mock.AnythingOfType("int"), mock.AnythingOfType("string"))
+					mock.Anything, mock.AnythingOfType("*models.TripID"), emp, mock.AnythingOfType("models.CartFilter"), mock.AnythingOfType("int"), mock.AnythingOfType("string"))
 			} else {
 				mockRepo.AssertNotCalled(t, "GetEmployeeCartsInTripPaged",
-					mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+					mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 			}
 		})
 	}
@@ -995,6 +2336,7 @@ func TestUpdateItemQuantityEndpoint(t *testing.T) {
 				m.On("UpdateItemQuantity", mock.Anything, mock.AnythingOfType("*models.TripID"),
 					mock.AnythingOfType("*models.CartID"), mock.AnythingOfType("*int"), mock.AnythingOfType("*int16")).
 					Return(nil)
+				m.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: schemas.UpdateItemQuantityResponse{
@@ -1180,6 +2522,7 @@ func TestDeleteItemFromCartEndpoint(t *testing.T) {
 				m.On("DeleteItemFromCart", mock.Anything, mock.AnythingOfType("*models.TripID"),
 					mock.AnythingOfType("*models.CartID"), mock.AnythingOfType("*int")).
 					Return(nil)
+				m.On("RefreshTripAggregates", mock.Anything, mock.AnythingOfType("*models.TripID")).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: schemas.DeleteItemFromCartResponse{
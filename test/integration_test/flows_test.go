@@ -0,0 +1,318 @@
+package integration_test
+
+import (
+	"ChaikaReports/internal/handler/http/schemas"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/Chaika-Team/chaika-proto/gen/rprts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var tripStart = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+// testTrip Returns a trip on a route of its own, so tests sharing the keyspace do not see each other's data
+func testTrip(t *testing.T) schemas.TripID {
+	return schemas.TripID{
+		RouteID:   strings.ToLower(t.Name()),
+		Year:      "2024",
+		StartTime: tripStart.Format(time.RFC3339),
+	}
+}
+
+func testCart(employeeID string, minute int, items ...schemas.Item) schemas.Cart {
+	return schemas.Cart{
+		CartID: schemas.CartID{
+			EmployeeID:    employeeID,
+			OperationTime: tripStart.Add(time.Hour + time.Duration(minute)*time.Minute).Format(time.RFC3339),
+		},
+		OperationType: 1,
+		Items:         items,
+	}
+}
+
+func testReport(tripID schemas.TripID, carriageID int8, carts ...schemas.Cart) schemas.InsertSalesRequest {
+	return schemas.InsertSalesRequest{
+		TripID:     tripID,
+		EndTime:    tripStart.Add(4 * time.Hour).Format(time.RFC3339),
+		CarriageID: carriageID,
+		Carts:      carts,
+	}
+}
+
+func tripQuery(tripID schemas.TripID) url.Values {
+	return url.Values{
+		"route_id":   {tripID.RouteID},
+		"year":       {tripID.Year},
+		"start_time": {tripID.StartTime},
+	}
+}
+
+func (h *harness) insert(t *testing.T, report schemas.InsertSalesRequest) schemas.Receipt {
+	t.Helper()
+	var resp schemas.InsertSalesResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodPost, apiPrefix+"/sale", report, &resp))
+	return resp.Receipt
+}
+
+func (h *harness) employeeCarts(t *testing.T, tripID schemas.TripID, employeeID string) []schemas.Cart {
+	t.Helper()
+	query := tripQuery(tripID)
+	query.Set("employee_id", employeeID)
+	var resp schemas.GetEmployeeCartsInTripResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee?"+query.Encode(), nil, &resp))
+	return resp.Carts
+}
+
+func (h *harness) revenue(t *testing.T, tripID schemas.TripID) schemas.Revenue {
+	t.Helper()
+	var resp schemas.GetTripRevenueResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/revenue?"+tripQuery(tripID).Encode(), nil, &resp))
+	return resp.Revenue
+}
+
+func (h *harness) unsyncedTrips(t *testing.T) map[string]bool {
+	t.Helper()
+	reply, err := h.grpc.GetUnsyncedTrips(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	trips := make(map[string]bool, len(reply.Trips))
+	for _, trip := range reply.Trips {
+		trips[fmt.Sprintf("%s/%s", trip.RouteId, trip.StartTime.AsTime().Format(time.RFC3339))] = true
+	}
+	return trips
+}
+
+func TestHealth(t *testing.T) {
+	h := requireCluster(t)
+	h.checker.Check(context.Background())
+
+	assert.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/healthz", nil, nil))
+	assert.Equal(t, http.StatusOK, h.do(t, http.MethodGet, "/readyz", nil, nil))
+
+	resp, err := h.health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestIngestion(t *testing.T) {
+	h := requireCluster(t)
+	tripID := testTrip(t)
+	report := testReport(tripID, 3,
+		testCart("e1", 0, schemas.Item{ProductID: 1, Quantity: 2, Price: 100}, schemas.Item{ProductID: 2, Quantity: 1, Price: 50}),
+		testCart("e2", 5, schemas.Item{ProductID: 1, Quantity: 1, Price: 100}),
+	)
+
+	receipt := h.insert(t, report)
+	assert.NotEmpty(t, receipt.ReportID)
+	assert.Equal(t, 2, receipt.Carts)
+	assert.Equal(t, 3, receipt.Items)
+
+	var stored schemas.GetReceiptResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/sale/"+receipt.ReportID, nil, &stored))
+	assert.Equal(t, receipt.ContentHash, stored.Receipt.ContentHash)
+
+	// Uploading the same report again is idempotent
+	assert.Equal(t, receipt.ContentHash, h.insert(t, report).ContentHash)
+
+	var employees schemas.GetEmployeeIDsByTripResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/employee_id?"+tripQuery(tripID).Encode(), nil, &employees))
+	assert.Equal(t, []string{"e1", "e2"}, employees.EmployeeIDs)
+
+	assert.Equal(t, []schemas.Cart{report.Carts[0]}, h.employeeCarts(t, tripID, "e1"))
+
+	revenue := h.revenue(t, tripID)
+	assert.Equal(t, int64(350), revenue.SalesTotal)
+	assert.Equal(t, int64(350), revenue.NetTotal)
+
+	reply, err := h.grpc.GetTrip(context.Background(), &pb.GetTripRequest{
+		RouteId:   tripID.RouteID,
+		Year:      tripID.Year,
+		StartTime: timestamppb.New(tripStart),
+	})
+	require.NoError(t, err)
+	require.Len(t, reply.Trip.Carriage, 1)
+	carriage := reply.Trip.Carriage[0]
+	assert.Equal(t, int32(3), carriage.CarriageId)
+	assert.True(t, tripStart.Add(4*time.Hour).Equal(carriage.EndTime.AsTime()))
+	assert.Len(t, carriage.Carts, 2)
+
+	var invalid schemas.InsertSalesRequest
+	assert.Equal(t, http.StatusBadRequest, h.do(t, http.MethodPost, apiPrefix+"/sale", invalid, nil))
+}
+
+func TestPaging(t *testing.T) {
+	h := requireCluster(t)
+	tripID := testTrip(t)
+	var carts []schemas.Cart
+	for minute := 0; minute < 5; minute++ {
+		carts = append(carts, testCart("e1", minute, schemas.Item{ProductID: minute + 1, Quantity: 1, Price: 10}))
+	}
+	carts = append(carts, testCart("e2", 30, schemas.Item{ProductID: 1, Quantity: 1, Price: 10}))
+	h.insert(t, testReport(tripID, 1, carts...))
+
+	query := tripQuery(tripID)
+	query.Set("employee_id", "e1")
+	query.Set("limit", "2")
+
+	var pages [][]schemas.Cart
+	var resp schemas.GetEmployeeCartsInTripPagedResponse
+	for {
+		resp = schemas.GetEmployeeCartsInTripPagedResponse{}
+		require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee/paged?"+query.Encode(), nil, &resp))
+		pages = append(pages, resp.Carts)
+		if resp.NextCursor == "" {
+			break
+		}
+		require.Less(t, len(pages), 5, "paging does not end")
+		query.Set("cursor", resp.NextCursor)
+	}
+
+	// Carts come newest first
+	require.Len(t, pages, 3)
+	assert.Equal(t, []schemas.Cart{carts[4], carts[3]}, pages[0])
+	assert.Equal(t, []schemas.Cart{carts[2], carts[1]}, pages[1])
+	assert.Equal(t, []schemas.Cart{carts[0]}, pages[2])
+
+	// Walking back from the last page returns the same pages
+	for page := len(pages) - 2; page >= 0; page-- {
+		require.NotEmpty(t, resp.PrevCursor)
+		query.Set("cursor", resp.PrevCursor)
+		resp = schemas.GetEmployeeCartsInTripPagedResponse{}
+		require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee/paged?"+query.Encode(), nil, &resp))
+		assert.Equal(t, pages[page], resp.Carts)
+	}
+	assert.Empty(t, resp.PrevCursor)
+
+	query.Set("cursor", "not-a-cursor")
+	assert.NotEqual(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee/paged?"+query.Encode(), nil, nil))
+
+	// Oldest first, limited to the window from minute 1 up to minute 4
+	query = tripQuery(tripID)
+	query.Set("employee_id", "e1")
+	query.Set("order", "asc")
+	query.Set("from", carts[1].CartID.OperationTime)
+	query.Set("to", carts[4].CartID.OperationTime)
+	resp = schemas.GetEmployeeCartsInTripPagedResponse{}
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee/paged?"+query.Encode(), nil, &resp))
+	assert.Equal(t, []schemas.Cart{carts[1], carts[2], carts[3]}, resp.Carts)
+	assert.Empty(t, resp.NextCursor)
+
+	var all schemas.GetEmployeeCartsInTripResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/trip/cart/employee?"+query.Encode(), nil, &all))
+	assert.Equal(t, resp.Carts, all.Carts)
+}
+
+func TestSearch(t *testing.T) {
+	h := requireCluster(t)
+	tripID := testTrip(t)
+	refund := testCart("e2", 5, schemas.Item{ProductID: 42, Quantity: 1, Price: 100})
+	refund.OperationType = 2
+	h.insert(t, testReport(tripID, 1,
+		testCart("e1", 0, schemas.Item{ProductID: 42, Quantity: 2, Price: 100}, schemas.Item{ProductID: 7, Quantity: 1, Price: 50}),
+		refund,
+	))
+
+	query := url.Values{
+		"route_id":       {tripID.RouteID},
+		"from":           {tripStart.Format(time.DateOnly)},
+		"to":             {tripStart.Format(time.DateOnly)},
+		"operation_type": {"2"},
+		"product_id":     {"42"},
+	}
+	var resp schemas.SearchOperationsResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/operation/search?"+query.Encode(), nil, &resp))
+	require.Len(t, resp.Operations, 1)
+	assert.Equal(t, refund.CartID, resp.Operations[0].CartID)
+	assert.Equal(t, int64(100), resp.Operations[0].Amount)
+	assert.Empty(t, resp.NextCursor)
+
+	// One operation per page
+	query.Del("operation_type")
+	query.Set("limit", "1")
+	var found []schemas.CartID
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5, "paging does not end")
+		resp = schemas.SearchOperationsResponse{}
+		require.Equal(t, http.StatusOK, h.do(t, http.MethodGet, apiPrefix+"/operation/search?"+query.Encode(), nil, &resp))
+		for _, op := range resp.Operations {
+			found = append(found, op.CartID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+	assert.Equal(t, []schemas.CartID{testCart("e1", 0).CartID, refund.CartID}, found)
+}
+
+func TestCorrections(t *testing.T) {
+	h := requireCluster(t)
+	tripID := testTrip(t)
+	report := testReport(tripID, 1,
+		testCart("e1", 0, schemas.Item{ProductID: 1, Quantity: 2, Price: 100}, schemas.Item{ProductID: 2, Quantity: 1, Price: 50}),
+		testCart("e2", 5, schemas.Item{ProductID: 1, Quantity: 1, Price: 100}),
+	)
+	h.insert(t, report)
+	cartID := report.Carts[0].CartID
+
+	var updated schemas.UpdateItemQuantityResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodPut, apiPrefix+"/trip/cart/item/quantity",
+		schemas.UpdateItemQuantityRequest{TripID: tripID, CartID: cartID, ProductID: 1, NewQuantity: 5}, &updated))
+	assert.Equal(t, "Item quantity updated successfully", updated.Message)
+	assert.NotEqual(t, http.StatusOK, h.do(t, http.MethodPut, apiPrefix+"/trip/cart/item/quantity",
+		schemas.UpdateItemQuantityRequest{TripID: tripID, CartID: cartID, ProductID: 9, NewQuantity: 5}, nil))
+
+	var deleted schemas.DeleteItemFromCartResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodDelete, apiPrefix+"/trip/cart/item",
+		schemas.DeleteItemFromCartRequest{TripID: tripID, CartID: cartID, ProductID: 2}, &deleted))
+	assert.Equal(t, "Item deleted successfully", deleted.Message)
+
+	carts := h.employeeCarts(t, tripID, "e1")
+	require.Len(t, carts, 1)
+	assert.Equal(t, []schemas.Item{{ProductID: 1, Quantity: 5, Price: 100}}, carts[0].Items)
+	assert.Equal(t, int64(600), h.revenue(t, tripID).SalesTotal)
+
+	// Replacing the report drops e2's cart, keeps the first one and adds a new one
+	replacement := testReport(tripID, 1,
+		testCart("e1", 0, schemas.Item{ProductID: 1, Quantity: 5, Price: 100}),
+		testCart("e1", 10, schemas.Item{ProductID: 4, Quantity: 1, Price: 70}),
+	)
+	var replaced schemas.ReplaceSalesResponse
+	require.Equal(t, http.StatusOK, h.do(t, http.MethodPut, apiPrefix+"/sale", replacement, &replaced))
+	assert.Equal(t, 1, replaced.Inserted)
+	assert.Equal(t, 1, replaced.Deleted)
+	assert.Equal(t, 1, replaced.Unchanged)
+	assert.Empty(t, h.employeeCarts(t, tripID, "e2"))
+	assert.Len(t, h.employeeCarts(t, tripID, "e1"), 2)
+	assert.Equal(t, int64(570), h.revenue(t, tripID).SalesTotal)
+}
+
+func TestSync(t *testing.T) {
+	h := requireCluster(t)
+	tripID := testTrip(t)
+	h.insert(t, testReport(tripID, 1, testCart("e1", 0, schemas.Item{ProductID: 1, Quantity: 1, Price: 100})))
+	key := fmt.Sprintf("%s/%s", tripID.RouteID, tripID.StartTime)
+
+	assert.True(t, h.unsyncedTrips(t)[key])
+
+	ctx := context.Background()
+	req := &pb.DeleteSyncedTripRequest{RouteId: tripID.RouteID, StartTime: timestamppb.New(tripStart)}
+	ack, err := h.grpc.DeleteSyncedTrip(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "deleted", ack.Message)
+	assert.False(t, h.unsyncedTrips(t)[key])
+
+	// A synced trip cannot be deleted twice, its data stays readable
+	_, err = h.grpc.DeleteSyncedTrip(ctx, req)
+	assert.Error(t, err)
+	assert.Len(t, h.employeeCarts(t, tripID, "e1"), 1)
+}
@@ -0,0 +1,260 @@
+package integration_test
+
+// The integration suite runs HTTP → service → Cassandra end to end against a real Cassandra or ScyllaDB.
+//
+// It connects to CHAIKA_IT_CASSANDRA_HOSTS (comma separated, default 127.0.0.1:9042) and is skipped when
+// nothing listens there. Every run creates its own keyspace, applies the embedded migrations and drops the
+// keyspace afterwards unless CHAIKA_IT_KEEP_KEYSPACE is set.
+//
+//	CHAIKA_IT_CASSANDRA_HOSTS=db1:9042,db2:9042 \
+//	CHAIKA_IT_CASSANDRA_USER=cassandra CHAIKA_IT_CASSANDRA_PASSWORD=cassandra \
+//	go test ./test/integration_test/
+
+import (
+	"ChaikaReports/internal/config"
+	grpcHandler "ChaikaReports/internal/handler/grpc"
+	httpHandler "ChaikaReports/internal/handler/http"
+	"ChaikaReports/internal/health"
+	"ChaikaReports/internal/repository/cassandra"
+	"ChaikaReports/internal/service"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/Chaika-Team/chaika-proto/gen/rprts"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultHosts       = "127.0.0.1:9042"
+	defaultReplication = "{'class': 'SimpleStrategy', 'replication_factor': 1}"
+	apiPrefix          = "/api/v1/report"
+)
+
+// harness is the running system shared by every test of the suite
+type harness struct {
+	baseURL string
+	grpc    pb.SalesServiceClient
+	health  healthpb.HealthClient
+	checker *health.Checker
+}
+
+var (
+	suite *harness
+	// skipReason is set when no cluster is reachable
+	skipReason string
+)
+
+func TestMain(m *testing.M) {
+	hosts := os.Getenv("CHAIKA_IT_CASSANDRA_HOSTS")
+	explicit := hosts != ""
+	if !explicit {
+		hosts = defaultHosts
+	}
+	storage := config.StorageConfig{
+		Hosts:         strings.Split(hosts, ","),
+		Keyspace:      fmt.Sprintf("chaika_it_%d", time.Now().UnixNano()),
+		User:          os.Getenv("CHAIKA_IT_CASSANDRA_USER"),
+		Password:      os.Getenv("CHAIKA_IT_CASSANDRA_PASSWORD"),
+		Timeout:       10 * time.Second,
+		RetryDelay:    time.Second,
+		RetryAttempts: 3,
+	}
+
+	if !reachable(storage.Hosts) {
+		if explicit {
+			_, _ = fmt.Fprintf(os.Stderr, "integration: cassandra is not reachable at %s\n", hosts)
+			os.Exit(1)
+		}
+		skipReason = fmt.Sprintf("no cassandra reachable at %s, set CHAIKA_IT_CASSANDRA_HOSTS", hosts)
+		os.Exit(m.Run())
+	}
+
+	h, teardown, err := start(storage)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "integration:", err)
+		if teardown != nil {
+			teardown()
+		}
+		os.Exit(1)
+	}
+	suite = h
+	code := m.Run()
+	teardown()
+	os.Exit(code)
+}
+
+// requireCluster Returns the running harness or skips the test when no cluster is reachable
+func requireCluster(t *testing.T) *harness {
+	t.Helper()
+	if suite == nil {
+		t.Skip(skipReason)
+	}
+	return suite
+}
+
+// reachable Reports whether any of hosts accepts TCP connections
+func reachable(hosts []string) bool {
+	for _, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "9042")
+		}
+		conn, err := net.DialTimeout("tcp", host, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// start Creates and migrates the keyspace, then boots the HTTP and gRPC servers on random ports;
+// the returned teardown stops them and drops the keyspace
+func start(storage config.StorageConfig) (*harness, func(), error) {
+	logger := log.NewNopLogger()
+	if os.Getenv("CHAIKA_IT_VERBOSE") != "" {
+		logger = log.With(log.NewLogfmtLogger(os.Stderr), "ts", log.DefaultTimestampUTC)
+	}
+	var cleanups []func()
+	teardown := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	if err := createKeyspace(logger, storage); err != nil {
+		return nil, nil, err
+	}
+	if os.Getenv("CHAIKA_IT_KEEP_KEYSPACE") == "" {
+		cleanups = append(cleanups, func() { _ = dropKeyspace(logger, storage) })
+	}
+
+	session, err := cassandra.InitCassandra(logger, storage)
+	if err != nil {
+		return nil, teardown, fmt.Errorf("failed to connect to keyspace %s: %w", storage.Keyspace, err)
+	}
+	cleanups = append(cleanups, func() { cassandra.CloseCassandra(session) })
+
+	ctx := context.Background()
+	migrator, err := cassandra.NewMigrator(session, logger)
+	if err != nil {
+		return nil, teardown, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, teardown, fmt.Errorf("failed to migrate keyspace %s: %w", storage.Keyspace, err)
+	}
+	if err := cassandra.PrepareStatements(ctx, session); err != nil {
+		return nil, teardown, err
+	}
+
+	repo := cassandra.NewSalesRepository(session, logger)
+	svc := service.NewSalesService(repo)
+	checker := health.NewChecker(func(ctx context.Context) error { return cassandra.Ping(ctx, session) },
+		logger, 0, 0)
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(checker))
+	mux.Handle("/", httpHandler.NewHTTPHandler(svc, logger))
+	httpLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, teardown, err
+	}
+	httpSrv := &http.Server{Handler: mux}
+	go func() { _ = httpSrv.Serve(httpLis) }()
+	cleanups = append(cleanups, func() { _ = httpSrv.Close() })
+
+	grpcLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, teardown, err
+	}
+	grpcSrv := grpc.NewServer()
+	grpcHandler.RegisterGRPCServer(grpcSrv, grpcHandler.NewRouter(svc, logger))
+	grpcHealthSrv := grpcHealth.NewServer()
+	healthpb.RegisterHealthServer(grpcSrv, grpcHealthSrv)
+	updateGRPCHealth := health.GRPCStatusUpdater(grpcHealthSrv)
+	updateGRPCHealth(false)
+	checker.OnChange(updateGRPCHealth)
+	go func() { _ = grpcSrv.Serve(grpcLis) }()
+	cleanups = append(cleanups, grpcSrv.Stop)
+
+	conn, err := grpc.NewClient(grpcLis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, teardown, err
+	}
+	cleanups = append(cleanups, func() { _ = conn.Close() })
+
+	return &harness{
+		baseURL: "http://" + httpLis.Addr().String(),
+		grpc:    pb.NewSalesServiceClient(conn),
+		health:  healthpb.NewHealthClient(conn),
+		checker: checker,
+	}, teardown, nil
+}
+
+// createKeyspace Connects without a keyspace and creates the one of storage
+func createKeyspace(logger log.Logger, storage config.StorageConfig) error {
+	replication := os.Getenv("CHAIKA_IT_REPLICATION")
+	if replication == "" {
+		replication = defaultReplication
+	}
+	return withoutKeyspace(logger, storage, fmt.Sprintf("CREATE KEYSPACE %s WITH replication = %s", storage.Keyspace, replication))
+}
+
+// dropKeyspace Drops the keyspace of storage
+func dropKeyspace(logger log.Logger, storage config.StorageConfig) error {
+	return withoutKeyspace(logger, storage, fmt.Sprintf("DROP KEYSPACE IF EXISTS %s", storage.Keyspace))
+}
+
+func withoutKeyspace(logger log.Logger, storage config.StorageConfig, stmt string) error {
+	noKeyspace := storage
+	noKeyspace.Keyspace = ""
+	session, err := cassandra.InitCassandra(logger, noKeyspace)
+	if err != nil {
+		return fmt.Errorf("failed to connect to cassandra: %w", err)
+	}
+	defer cassandra.CloseCassandra(session)
+
+	if err := session.Query(stmt).Exec(); err != nil {
+		return fmt.Errorf("failed to run %q: %w", stmt, err)
+	}
+	return nil
+}
+
+// do Sends a request with body encoded as JSON and decodes the JSON response into out, returns the status code
+func (h *harness) do(t *testing.T, method, path string, body, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.baseURL+path, reader)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if out != nil && resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.Unmarshal(data, out), string(data))
+	}
+	return resp.StatusCode
+}